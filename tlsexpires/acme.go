@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	acmeARI       = flag.Bool("acme-ari", false, "For Let's Encrypt certificates, also ask the CA's ACME renewalInfo (ARI) endpoint when it suggests the certificate be renewed, and report that window instead of the usual one")
+	acmeDirectory = flag.String("acme-directory", "https://acme-v02.api.letsencrypt.org/directory", "The ACME directory -acme-ari finds the renewalInfo endpoint in")
+)
+
+// acmeClient is used to talk to the ACME server.
+var acmeClient = &http.Client{Timeout: 10 * time.Second, Transport: countedTransport(lookupACME)}
+
+// acmeRenewal is when a certificate from an ACME CA, like Let's Encrypt, should be renewed.
+type acmeRenewal struct {
+	// CertID is the ARI identifier of the certificate, see ariCertID().
+	CertID string `json:"certId,omitempty"`
+	// RenewAfter is when ACME clients typically renew the certificate, with a third of its
+	// lifetime left. That is 30 days before a 90 day certificate expires.
+	RenewAfter time.Time `json:"renewAfter"`
+	// SuggestedStart and SuggestedEnd are the window the CA suggests renewing in, which it
+	// moves up when it has to revoke certificates. Only set with -acme-ari.
+	SuggestedStart time.Time `json:"suggestedStart,omitzero"`
+	SuggestedEnd   time.Time `json:"suggestedEnd,omitzero"`
+	// ExplanationURL is where the CA explains why it suggests the window, if it does.
+	ExplanationURL string `json:"explanationUrl,omitempty"`
+	// Overdue is set if the certificate should have been renewed by now, going by the
+	// suggested window if we have one and RenewAfter if we don't, but it is still served.
+	Overdue bool `json:"overdue"`
+	// Error is why we couldn't ask ARI for the suggested window.
+	Error string `json:"error,omitempty"`
+}
+
+// acmeRenewalFor returns when leaf should be renewed, or nil if it isn't from Let's Encrypt.
+func acmeRenewalFor(leaf *x509.Certificate) *acmeRenewal {
+	if !slices.Contains(leaf.Issuer.Organization, "Let's Encrypt") {
+		return nil
+	}
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	return &acmeRenewal{CertID: ariCertID(leaf), RenewAfter: leaf.NotAfter.Add(-lifetime / 3)}
+}
+
+// ariCertID returns the identifier ARI (RFC 9773) knows leaf by, the key identifier of its
+// issuer and its serial number. It is empty if leaf has no authority key identifier.
+func ariCertID(leaf *x509.Certificate) string {
+	if len(leaf.AuthorityKeyId) == 0 {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(leaf.AuthorityKeyId) + "." + base64.RawURLEncoding.EncodeToString(serialDER(leaf))
+}
+
+// serialDER returns the serial number of cert as the bytes of a DER INTEGER, which has a leading
+// 0 when the high bit of the number is set.
+func serialDER(cert *x509.Certificate) []byte {
+	b := cert.SerialNumber.Bytes()
+	if len(b) == 0 || b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+// checkACME fills in whether the certificate of v, if it is from an ACME CA, is overdue for
+// renewal and records a finding if it is. With -acme-ari it asks the CA first.
+func checkACME(ctx context.Context, v *result) {
+	a := v.ACME
+	if a == nil {
+		return
+	}
+	if *acmeARI && a.CertID != "" {
+		w, err := renewalInfo(ctx, a.CertID)
+		integrationUsed(integrationACME, err)
+		if err != nil {
+			a.Error = err.Error()
+		} else {
+			a.SuggestedStart, a.SuggestedEnd, a.ExplanationURL = w.Window.Start, w.Window.End, w.ExplanationURL
+		}
+	}
+	renewAt := a.RenewAfter
+	if !a.SuggestedStart.IsZero() {
+		renewAt = a.SuggestedStart
+	}
+	if a.Overdue = until(renewAt) < 0; a.Overdue {
+		v.find(findingACMEOverdue)
+	}
+}
+
+// ariResponse is the body of a renewalInfo response.
+type ariResponse struct {
+	Window struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+	} `json:"suggestedWindow"`
+	ExplanationURL string `json:"explanationURL"`
+}
+
+// ariEndpoint is the renewalInfo URL in -acme-directory, which we only look up once.
+var ariEndpoint = struct {
+	once sync.Once
+	url  string
+	err  error
+}{}
+
+// renewalInfo asks the renewalInfo endpoint of -acme-directory for the renewal window of the
+// certificate with certID.
+func renewalInfo(ctx context.Context, certID string) (ariResponse, error) {
+	ariEndpoint.once.Do(func() {
+		var dir struct {
+			RenewalInfo string `json:"renewalInfo"`
+		}
+		ariEndpoint.err = acmeGet(ctx, *acmeDirectory, &dir)
+		switch {
+		case ariEndpoint.err != nil:
+		case dir.RenewalInfo == "":
+			ariEndpoint.err = fmt.Errorf("ACME directory %s has no renewalInfo endpoint", *acmeDirectory)
+		default:
+			ariEndpoint.url = strings.TrimSuffix(dir.RenewalInfo, "/")
+		}
+	})
+	if ariEndpoint.err != nil {
+		return ariResponse{}, ariEndpoint.err
+	}
+
+	var resp ariResponse
+	if err := acmeGet(ctx, ariEndpoint.url+"/"+certID, &resp); err != nil {
+		return ariResponse{}, err
+	}
+	if resp.Window.Start.IsZero() || resp.Window.End.Before(resp.Window.Start) {
+		return ariResponse{}, fmt.Errorf("renewalInfo for %s has no valid suggestedWindow", certID)
+	}
+	return resp, nil
+}
+
+// acmeGet GETs the json at url from the ACME server into v.
+func acmeGet(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := acmeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("GET %s: %s", url, err)
+	}
+	return nil
+}
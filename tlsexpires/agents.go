@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	agentListen  = flag.String("agent-listen", "", "Coordinate agents, instances of us run with -agent-of in other regions or networks, on this address, like :9221. The hosts of a zone in -zones with an agent are checked by that agent, from where it runs, so split-horizon and geo-fenced hosts are checked from the right place and still end up in our results. Agents must send the token in $TLSEXPIRES_AGENT_TOKEN")
+	agentTLSCert = flag.String("agent-tls-cert", "", "The PEM certificate to serve -agent-listen over TLS with. Requires -agent-tls-key")
+	agentTLSKey  = flag.String("agent-tls-key", "", "The PEM private key for -agent-tls-cert")
+	agentTimeout = flag.Duration("agent-timeout", 5*time.Minute, "How long a host waits for its agent to check it before it is reported as failed, like when the agent isn't running")
+	agentOf      = flag.String("agent-of", "", "Run as an agent of the coordinator at this URL, like https://coordinator:9221, checking the hosts it sends us with our own flags and -zones and sending it the results, instead of scanning. The token in $TLSEXPIRES_AGENT_TOKEN must match the coordinator's. Requests to an https:// coordinator are verified against -ca-file")
+	agentName    = flag.String("agent-name", "", "The name we check hosts under with -agent-of, which the zones in the coordinator's -zones refer to, like eu-west. Defaults to our hostname")
+)
+
+// agentTokenEnv is the environment variable with the token agents and their coordinator share.
+// The coordinator only takes work requests and results from agents that send it.
+const agentTokenEnv = "TLSEXPIRES_AGENT_TOKEN"
+
+const (
+	// agentPollWait is how long a coordinator holds an agent's request for work when it has none,
+	// so new work reaches agents right away without them asking all the time.
+	agentPollWait = 30 * time.Second
+	// maxAgentBatch is the most hosts a coordinator sends an agent at a time.
+	maxAgentBatch = 100
+	// agentRetryWait is how long an agent waits before asking a coordinator it couldn't reach again.
+	agentRetryWait = 5 * time.Second
+	// agentJobTimeout is how long an agent has to check a host and send the results. Once it is
+	// interrupted, it still finishes the hosts it has, within this.
+	agentJobTimeout = 2 * time.Minute
+)
+
+// agentWork is a host a coordinator sends an agent to check.
+type agentWork struct {
+	// ID is what the agent sends the result back with.
+	ID string `json:"id"`
+	// Host is the host:port to check.
+	Host string `json:"host"`
+	// Options are the host's options in the coordinator's -config, as an agent doesn't have it.
+	// The files they name, like ClientCert, are read on the agent.
+	Options *targetConfig `json:"options,omitempty"`
+}
+
+// agentWorkRequest is the body of POST /agent/work, which an agent asks for work with.
+type agentWorkRequest struct {
+	Agent string `json:"agent"`
+}
+
+// agentResult is the body of POST /agent/result, which an agent sends its results back with.
+type agentResult struct {
+	Agent string `json:"agent"`
+	ID    string `json:"id"`
+	// Results are the results of checking the host, one for each of its addresses if the agent
+	// runs with -all-ips.
+	Results []result `json:"results"`
+}
+
+// agentJob is a host waiting for an agent to check it.
+type agentJob struct {
+	work agentWork
+	done chan []result
+}
+
+// agentHub hands the hosts of zones with an agent to the agents that ask it for work, and
+// returns their results to the checks waiting for them.
+type agentHub struct {
+	token string
+
+	mu sync.Mutex
+	// queues are the jobs waiting for each agent to ask for them, keyed by the agent's name.
+	queues map[string]chan *agentJob
+	// jobs are the jobs waiting for their result, keyed by their ID.
+	jobs map[string]*agentJob
+	// lastSeen is when each agent last asked for work.
+	lastSeen map[string]time.Time
+}
+
+// agents is the agentHub of -agent-listen, nil without it.
+var agents *agentHub
+
+var (
+	agentHubOnce sync.Once
+	agentHubErr  error
+)
+
+// startAgentHub serves -agent-listen, if it is set, and sets agents to the hub it serves. It only
+// starts it once, so everything that checks zones can call it. A failure to serve after it starts
+// is fatal, since the checks of zones with agents would only time out.
+func startAgentHub() error {
+	agentHubOnce.Do(func() { agentHubErr = serveAgentHub() })
+	return agentHubErr
+}
+
+// serveAgentHub does the work of startAgentHub().
+func serveAgentHub() error {
+	if *agentListen == "" {
+		return nil
+	}
+	token := os.Getenv(agentTokenEnv)
+	if token == "" {
+		return fmt.Errorf("-agent-listen requires $%s, the token agents must send", agentTokenEnv)
+	}
+	if (*agentTLSCert == "") != (*agentTLSKey == "") {
+		return fmt.Errorf("-agent-tls-cert and -agent-tls-key must be given together")
+	}
+	l, err := net.Listen("tcp", *agentListen)
+	if err != nil {
+		return fmt.Errorf("-agent-listen: %s", err)
+	}
+	h := &agentHub{token: token, queues: map[string]chan *agentJob{}, jobs: map[string]*agentJob{}, lastSeen: map[string]time.Time{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /agent/work", h.serveWork)
+	mux.HandleFunc("POST /agent/result", h.serveResult)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		var err error
+		if *agentTLSCert != "" {
+			err = srv.ServeTLS(l, *agentTLSCert, *agentTLSKey)
+		} else {
+			err = srv.Serve(l)
+		}
+		log.Fatalf("-agent-listen: %s", err)
+	}()
+	agents = h
+	log.Printf("coordinating agents at %s", *agentListen)
+	return nil
+}
+
+// queue returns the queue of jobs for the agent called name.
+func (h *agentHub) queue(name string) chan *agentJob {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	q, ok := h.queues[name]
+	if !ok {
+		q = make(chan *agentJob, maxAgentBatch)
+		h.queues[name] = q
+	}
+	return q
+}
+
+// check has the agent called name check hostPort and returns its results. The agent resolves
+// the host itself, so it finds the addresses it can reach, and with its own -all-ips it checks
+// each of them. If the agent doesn't send them within -agent-timeout, the result is a failure
+// that says so.
+func (h *agentHub) check(ctx context.Context, name, hostPort string) []result {
+	j := &agentJob{
+		work: agentWork{ID: newULID(time.Now()), Host: hostPort},
+		done: make(chan []result, 1),
+	}
+	if opts, ok := targetConfigs[hostPort]; ok {
+		j.work.Options = opts
+	}
+	h.mu.Lock()
+	h.jobs[j.work.ID] = j
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.jobs, j.work.ID)
+		h.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, *agentTimeout)
+	defer cancel()
+	select {
+	case h.queue(name) <- j:
+		select {
+		case vs := <-j.done:
+			if len(vs) > 0 {
+				for i := range vs {
+					vs[i].HostPort, vs[i].Agent = hostPort, name
+				}
+				return vs
+			}
+		case <-ctx.Done():
+		}
+	case <-ctx.Done():
+	}
+
+	host, port, _ := net.SplitHostPort(hostPort)
+	v := result{HostPort: hostPort, Server: host, Port: port, Agent: name, Status: statusOK}
+	if ctx.Err() == nil {
+		v.Err = fmt.Sprintf("agent %s sent no results for the host", name)
+		v.find(findingHandshake)
+		return []result{v}
+	}
+	v.Err = fmt.Sprintf("agent %s didn't check the host within -agent-timeout", name)
+	if seen, ok := h.seen(name); ok {
+		v.Err += fmt.Sprintf(", it last asked for work at %s", seen.Format(time.RFC3339))
+	} else {
+		v.Err += ", it never asked for work"
+	}
+	v.find(findingHandshake)
+	return []result{v}
+}
+
+// pending reports if the check of j still waits for its result. Jobs whose check timed out are
+// left in their agent's queue, and aren't worth the agent's time.
+func (h *agentHub) pending(j *agentJob) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.jobs[j.work.ID]
+	return ok
+}
+
+// seen returns when the agent called name last asked for work.
+func (h *agentHub) seen(name string) (time.Time, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t, ok := h.lastSeen[name]
+	return t, ok
+}
+
+// authorized reports if r has our token. If it doesn't, it responds with a 401.
+func (h *agentHub) authorized(w http.ResponseWriter, r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		http.Error(w, "bad or missing token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// serveWork serves POST /agent/work. It responds with the jobs waiting for the agent, waiting up
+// to agentPollWait for one if there are none. Jobs that timed out are skipped.
+func (h *agentHub) serveWork(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(w, r) {
+		return
+	}
+	var req agentWorkRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCheckRequest)).Decode(&req); err != nil || req.Agent == "" {
+		http.Error(w, "the body must be {\"agent\": name}", http.StatusBadRequest)
+		return
+	}
+	h.mu.Lock()
+	h.lastSeen[req.Agent] = time.Now()
+	h.mu.Unlock()
+
+	q := h.queue(req.Agent)
+	work := []agentWork{}
+	timeout := time.After(agentPollWait)
+wait:
+	for len(work) == 0 {
+		select {
+		case j := <-q:
+			if h.pending(j) {
+				work = append(work, j.work)
+			}
+		case <-timeout:
+			break wait
+		case <-r.Context().Done():
+			return
+		}
+	}
+drain:
+	for len(work) > 0 && len(work) < maxAgentBatch {
+		select {
+		case j := <-q:
+			if h.pending(j) {
+				work = append(work, j.work)
+			}
+		default:
+			break drain
+		}
+	}
+	writeJSON(w, work)
+}
+
+// serveResult serves POST /agent/result, handing the result to the check waiting for it. A result
+// nothing waits for anymore, because its check timed out, is dropped.
+func (h *agentHub) serveResult(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(w, r) {
+		return
+	}
+	var res agentResult
+	// Results with an enumeration or long chains are bigger than a check request.
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<20)).Decode(&res); err != nil {
+		http.Error(w, fmt.Sprintf("bad request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	h.mu.Lock()
+	j, ok := h.jobs[res.ID]
+	h.mu.Unlock()
+	if ok {
+		select {
+		case j.done <- res.Results:
+		default:
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// agentClient talks to our coordinator as the agent called name.
+type agentClient struct {
+	url   string
+	name  string
+	token string
+	http  *http.Client
+}
+
+// runAgent runs us as an agent of -agent-of. We ask the coordinator for hosts to check, check
+// them like POST /check does, with our own flags and -zones, and send it the results. It returns
+// once ctx is done and the hosts we have are checked and sent, which can take agentJobTimeout,
+// or if we can't start.
+func runAgent(ctx context.Context) error {
+	token := os.Getenv(agentTokenEnv)
+	if token == "" {
+		return fmt.Errorf("-agent-of requires $%s, the token of the coordinator", agentTokenEnv)
+	}
+	name := *agentName
+	if name == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("-agent-name isn't set and we don't know our hostname: %s", err)
+		}
+		name = h
+	}
+	api, err := newCheckAPI()
+	if err != nil {
+		return err
+	}
+	c := &agentClient{
+		url:   strings.TrimSuffix(*agentOf, "/"),
+		name:  name,
+		token: token,
+		http:  &http.Client{Timeout: agentPollWait + dialTimeout, Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: rootCAs}}},
+	}
+	log.Printf("checking hosts for the coordinator at %s as agent %s", c.url, c.name)
+
+	sem := make(chan struct{}, defaultConcurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for ctx.Err() == nil {
+		work, err := c.work(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("could not get work from the coordinator: %s", err)
+			sleep(ctx, agentRetryWait)
+			continue
+		}
+		for _, w := range work {
+			sem <- struct{}{}
+			wg.Go(func() {
+				defer func() { <-sem }()
+				// The coordinator waits for the host until -agent-timeout, so we finish it even
+				// if we are interrupted.
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), agentJobTimeout)
+				defer cancel()
+				c.send(ctx, w.ID, c.check(ctx, api, w))
+			})
+		}
+	}
+	return nil
+}
+
+// work asks the coordinator for hosts to check.
+func (c *agentClient) work(ctx context.Context) ([]agentWork, error) {
+	var work []agentWork
+	if err := c.post(ctx, "/agent/work", agentWorkRequest{Agent: c.name}, &work); err != nil {
+		return nil, err
+	}
+	return work, nil
+}
+
+// check checks the host of w with api. With -all-ips, each of the addresses we resolve it to is
+// checked on its own, like a scan does.
+func (c *agentClient) check(ctx context.Context, api *checkAPI, w agentWork) []result {
+	opts, err := agentOptions(w)
+	if err != nil {
+		host, port, _ := net.SplitHostPort(w.Host)
+		v := result{HostPort: w.Host, Server: host, Port: port, Status: statusOK, Err: fmt.Sprintf("agent %s can't check it: %s", c.name, err)}
+		v.find(findingHandshake)
+		return []result{v}
+	}
+	var addrs []string
+	if *allIPs {
+		// If this fails, we check the host without an address so the failure is reported.
+		addrs, _ = resolveAddrs(ctx, w.Host)
+	}
+	if len(addrs) == 0 {
+		return []result{api.check(ctx, opts)}
+	}
+	results := make([]result, 0, len(addrs))
+	for _, addr := range addrs {
+		results = append(results, api.checkAt(ctx, opts, addr))
+	}
+	return results
+}
+
+// agentOptions returns the options to check the host of w with, after making sure they are valid.
+func agentOptions(w agentWork) (*targetConfig, error) {
+	opts := &targetConfig{}
+	if w.Options != nil {
+		o := *w.Options
+		opts = &o
+	}
+	opts.Host = w.Host
+	// Like POST /check, the coordinator only sends us hosts to connect to.
+	for prefix := range storedProbers {
+		if strings.HasPrefix(opts.Host, prefix) {
+			return nil, fmt.Errorf("host must be a host:port")
+		}
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// send sends results, the results of the work with id, to the coordinator. It tries a few times,
+// since the coordinator reports the host as failed if it never gets them.
+func (c *agentClient) send(ctx context.Context, id string, results []result) {
+	var err error
+	for range 3 {
+		if err = c.post(ctx, "/agent/result", agentResult{Agent: c.name, ID: id, Results: results}, nil); err == nil {
+			return
+		}
+		if !sleep(ctx, agentRetryWait) {
+			break
+		}
+	}
+	log.Printf("could not send the results for %s to the coordinator: %s", results[0].HostPort, err)
+}
+
+// post POSTs body as json to path on the coordinator and decodes the json response into resp,
+// if it isn't nil.
+func (c *agentClient) post(ctx context.Context, path string, body, resp any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	r, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", path, r.Status)
+	}
+	if resp == nil {
+		return nil
+	}
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return fmt.Errorf("the coordinator sent a response we don't understand: %s", err)
+	}
+	return nil
+}
+
+// sleep waits for d, or until ctx is done. It reports if it waited all of d.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
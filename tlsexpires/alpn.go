@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"strings"
+)
+
+var alpnProtocols = flag.String("alpn", "", "The ALPN protocols to offer in the TLS handshake, separated by commas like h2,http/1.1, for servers that pick their certificate by ALPN. With more than one, each is also checked on its own, and a host that serves a different certificate for one of them, or fails its handshake, gets a warning. alpn in -config does this for a single target")
+
+// alpnPath is the result of a handshake offering only one of the ALPN protocols of a target.
+type alpnPath struct {
+	// Protocol is the protocol we offered.
+	Protocol string `json:"protocol"`
+	// Negotiated is the protocol the server chose, which is empty if it ignored ALPN.
+	Negotiated string `json:"negotiated,omitempty"`
+	// Fingerprint is the SHA-256 fingerprint of the certificate the server presented.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Error is why the handshake failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// alpn returns the ALPN protocols to offer t, from -config or -alpn.
+func (t *targetConfig) alpn() []string {
+	if len(t.ALPN) > 0 {
+		return t.ALPN
+	}
+	var protos []string
+	for _, p := range strings.Split(*alpnProtocols, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			protos = append(protos, p)
+		}
+	}
+	return protos
+}
+
+// checkALPNPaths connects to dialAddr once for each of protos, offering only that protocol, so
+// each path a client can take to the server is checked.
+func checkALPNPaths(ctx context.Context, d contextDialer, dialAddr, host string, opts *targetConfig, protos []string) []alpnPath {
+	paths := make([]alpnPath, 0, len(protos))
+	for _, p := range protos {
+		path := alpnPath{Protocol: p}
+		conf := opts.tlsConfig(host)
+		conf.NextProtos = []string{p}
+		conn, err := dialTLS(ctx, d, dialAddr, conf, opts.STARTTLS)
+		if err != nil {
+			path.Error = err.Error()
+			paths = append(paths, path)
+			continue
+		}
+		cs := conn.ConnectionState()
+		conn.Close()
+		path.Negotiated = cs.NegotiatedProtocol
+		path.Fingerprint = fingerprint(cs.PeerCertificates[0])
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// alpnMismatch reports if any of paths failed or served a certificate other than the one with
+// fingerprint.
+func alpnMismatch(paths []alpnPath, fingerprint string) bool {
+	for _, p := range paths {
+		if p.Error != "" || p.Fingerprint != fingerprint {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	acmtypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	elb "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+)
+
+var (
+	awsTargets  = flag.Bool("aws", false, "Check the certificates and TLS endpoints in the AWS account our credentials are for instead of -file. Credentials come from the usual places: the environment, ~/.aws or the instance role. See -aws-discover")
+	awsRegions  = flag.String("aws-regions", "", "A comma separated list of the AWS regions to look in with -aws. Defaults to the region in the environment or ~/.aws/config")
+	awsDiscover = flag.String("aws-discover", "acm,elb,cloudfront", "A comma separated list of what -aws checks: 'acm' (the certificates in ACM), 'elb' (HTTPS and TLS listeners of application and network load balancers) and 'cloudfront' (the domain and aliases of CloudFront distributions)")
+)
+
+// awsACMPrefix starts the target for a certificate in ACM, which is followed by its ARN. We
+// check these by fetching the certificate instead of connecting to anything.
+const awsACMPrefix = "aws-acm:"
+
+// awsARNLabel is the label we put the ARN of the AWS resource a target came from in.
+const awsARNLabel = "aws_arn"
+
+var (
+	awsConfigOnce sync.Once
+	awsCfg        aws.Config
+	awsCfgErr     error
+	// acmClients are our ACM clients, keyed by region.
+	acmClients sync.Map
+)
+
+// loadAWSConfig returns the AWS configuration from the environment, ~/.aws and the instance role.
+func loadAWSConfig(ctx context.Context) (aws.Config, error) {
+	awsConfigOnce.Do(func() {
+		awsCfg, awsCfgErr = awsconfig.LoadDefaultConfig(ctx)
+		if awsCfgErr != nil {
+			awsCfgErr = fmt.Errorf("-aws could not load the AWS configuration: %s", awsCfgErr)
+		}
+	})
+	return awsCfg, awsCfgErr
+}
+
+// acmClient returns the ACM client for region.
+func acmClient(cfg aws.Config, region string) *acm.Client {
+	if c, ok := acmClients.Load(region); ok {
+		return c.(*acm.Client)
+	}
+	c, _ := acmClients.LoadOrStore(region, acm.NewFromConfig(cfg, func(o *acm.Options) { o.Region = region }))
+	return c.(*acm.Client)
+}
+
+// awsListers returns the cloudListers for everything -aws-discover asks for in the regions we
+// look in. regions is the value of -aws-regions and discover the value of -aws-discover.
+func awsListers(ctx context.Context, regions, discover string) ([]cloudLister, error) {
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rs []string
+	for _, r := range strings.Split(regions, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			rs = append(rs, r)
+		}
+	}
+	if len(rs) == 0 {
+		if cfg.Region == "" {
+			return nil, fmt.Errorf("-aws needs -aws-regions, or a region in AWS_REGION or ~/.aws/config")
+		}
+		rs = []string{cfg.Region}
+	}
+
+	var listers []cloudLister
+	for _, kind := range strings.Split(discover, ",") {
+		switch strings.TrimSpace(kind) {
+		case "acm":
+			for _, r := range rs {
+				listers = append(listers, acmLister(acmClient(cfg, r), "acm in "+r))
+			}
+		case "elb":
+			for _, r := range rs {
+				listers = append(listers, elbLister(elb.NewFromConfig(cfg, func(o *elb.Options) { o.Region = r }), "elb in "+r))
+			}
+		case "cloudfront":
+			// CloudFront is global, so it doesn't matter which region we ask.
+			listers = append(listers, cloudFrontLister(cloudfront.NewFromConfig(cfg, func(o *cloudfront.Options) { o.Region = "us-east-1" })))
+		default:
+			return nil, fmt.Errorf("-aws-discover had %q, which is not 'acm', 'elb' or 'cloudfront'", kind)
+		}
+	}
+	return listers, nil
+}
+
+// awsTarget returns the target for hostPort, labelled with the ARN of the resource it is for.
+func awsTarget(hostPort string, resource *string) target {
+	return target{HostPort: hostPort, Labels: map[string]string{awsARNLabel: aws.ToString(resource)}}
+}
+
+// acmLister lists the issued and expired certificates in ACM. ACM only lists RSA 2048
+// certificates unless asked for other key types.
+func acmLister(c *acm.Client, name string) cloudLister {
+	p := acm.NewListCertificatesPaginator(c, &acm.ListCertificatesInput{
+		CertificateStatuses: []acmtypes.CertificateStatus{acmtypes.CertificateStatusIssued, acmtypes.CertificateStatusExpired},
+		Includes:            &acmtypes.Filters{KeyTypes: acmtypes.KeyAlgorithm("").Values()},
+	})
+	return cloudLister{
+		integration: integrationAWS,
+		name:        name,
+		more:        p.HasMorePages,
+		next: func(ctx context.Context) ([]target, error) {
+			page, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			var ts []target
+			for _, s := range page.CertificateSummaryList {
+				ts = append(ts, awsTarget(awsACMPrefix+aws.ToString(s.CertificateArn), s.CertificateArn))
+			}
+			return ts, nil
+		},
+	}
+}
+
+// elbLister lists the HTTPS and TLS listeners of application and network load balancers. Each
+// is checked at the load balancer's DNS name.
+func elbLister(c *elb.Client, name string) cloudLister {
+	p := elb.NewDescribeLoadBalancersPaginator(c, &elb.DescribeLoadBalancersInput{})
+	return cloudLister{
+		integration: integrationAWS,
+		name:        name,
+		more:        p.HasMorePages,
+		next: func(ctx context.Context) ([]target, error) {
+			page, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			var ts []target
+			for _, lb := range page.LoadBalancers {
+				in := &elb.DescribeListenersInput{LoadBalancerArn: lb.LoadBalancerArn}
+				for {
+					out, err := c.DescribeListeners(ctx, in)
+					if err != nil {
+						return nil, err
+					}
+					for _, l := range out.Listeners {
+						if l.Protocol != "HTTPS" && l.Protocol != "TLS" {
+							continue
+						}
+						hostPort := net.JoinHostPort(aws.ToString(lb.DNSName), strconv.Itoa(int(aws.ToInt32(l.Port))))
+						ts = append(ts, awsTarget(hostPort, l.ListenerArn))
+					}
+					if out.NextMarker == nil {
+						break
+					}
+					in.Marker = out.NextMarker
+				}
+			}
+			return ts, nil
+		},
+	}
+}
+
+// cloudFrontLister lists the domain name and aliases of each CloudFront distribution. Wildcard
+// aliases are skipped because there is no single name to connect to.
+func cloudFrontLister(c *cloudfront.Client) cloudLister {
+	p := cloudfront.NewListDistributionsPaginator(c, &cloudfront.ListDistributionsInput{})
+	return cloudLister{
+		integration: integrationAWS,
+		name:        "cloudfront",
+		more:        p.HasMorePages,
+		next: func(ctx context.Context) ([]target, error) {
+			page, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			var ts []target
+			if page.DistributionList == nil {
+				return nil, nil
+			}
+			for _, d := range page.DistributionList.Items {
+				ts = append(ts, awsTarget(net.JoinHostPort(aws.ToString(d.DomainName), "443"), d.ARN))
+				if d.Aliases == nil {
+					continue
+				}
+				for _, alias := range d.Aliases.Items {
+					if !strings.HasPrefix(alias, "*") {
+						ts = append(ts, awsTarget(net.JoinHostPort(alias, "443"), d.ARN))
+					}
+				}
+			}
+			return ts, nil
+		},
+	}
+}
+
+// checkACMCert checks a certificate in ACM, whose target is awsACMPrefix + its ARN. This
+// catches certificates that expire before anything serves them, and ACM certificates that
+// aren't attached to anything we can reach. ACM renews the certificates it issued itself, so
+// an expiring one usually means renewal is failing or it was imported.
+func checkACMCert(hostPort string) result {
+	certARN := strings.TrimPrefix(hostPort, awsACMPrefix)
+	v := result{HostPort: hostPort, Server: certARN, Status: statusOK}
+	fail := func(err error) result {
+		v.Err = err.Error()
+		v.find(findingStoredCert)
+		return v
+	}
+
+	a, err := arn.Parse(certARN)
+	if err != nil {
+		return fail(fmt.Errorf("%q is not an ACM certificate ARN: %s", certARN, err))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	cfg, err := loadAWSConfig(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	out, err := acmClient(cfg, a.Region).GetCertificate(ctx, &acm.GetCertificateInput{CertificateArn: aws.String(certARN)})
+	if err != nil {
+		return fail(fmt.Errorf("could not get %s from ACM: %s", certARN, err))
+	}
+
+	chain, err := pemCertificates([]byte(aws.ToString(out.Certificate) + "\n" + aws.ToString(out.CertificateChain)))
+	if err != nil {
+		return fail(fmt.Errorf("ACM returned a bad certificate for %s: %s", certARN, err))
+	}
+	if len(chain) == 0 {
+		return fail(fmt.Errorf("ACM returned no certificate for %s", certARN))
+	}
+	v.describe(chain, optionsFor(hostPort).warnDays())
+	return v
+}
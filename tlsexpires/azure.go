@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+var (
+	azureTargets       = flag.Bool("azure", false, "Check the certificates and TLS endpoints in Azure instead of -file. Credentials come from AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET, then the az CLI's login, then the VM's managed identity. See -azure-discover")
+	azureSubscriptions = flag.String("azure-subscriptions", "", "A comma separated list of the Azure subscription IDs to look in with -azure. Defaults to AZURE_SUBSCRIPTION_ID, or every subscription our credentials can see")
+	azureDiscover      = flag.String("azure-discover", "certificates,appservice,appgateway", "A comma separated list of what -azure checks: 'certificates' (App Service certificates, including managed ones), 'appservice' (the custom domains of web apps with TLS bindings) and 'appgateway' (the host names of Application Gateway HTTPS listeners)")
+)
+
+// azureCertPrefix starts the target for a certificate stored in Azure, which is followed by its
+// resource ID. We check these by fetching the certificate instead of connecting to anything.
+const azureCertPrefix = "azure-cert:"
+
+// azureIDLabel is the label we put the resource ID of the Azure resource a target came from in.
+const azureIDLabel = "azure_id"
+
+// azureManagementAPI is the Azure Resource Manager API, and azureWebAPIVersion the version of its
+// Microsoft.Web resources we use.
+const (
+	azureManagementAPI = "https://management.azure.com"
+	azureWebAPIVersion = "2022-03-01"
+)
+
+var (
+	azureClientOnce sync.Once
+	azureHTTP       *http.Client
+)
+
+// azureClient returns an http.Client that authenticates to Azure Resource Manager with the first
+// credentials we find. Missing credentials are only noticed when the first request needs a token.
+func azureClient() *http.Client {
+	azureClientOnce.Do(func() {
+		var ts oauth2.TokenSource
+		tenant, id, secret := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET")
+		switch {
+		case tenant != "" && id != "" && secret != "":
+			cc := &clientcredentials.Config{
+				ClientID:     id,
+				ClientSecret: secret,
+				TokenURL:     "https://login.microsoftonline.com/" + url.PathEscape(tenant) + "/oauth2/v2.0/token",
+				Scopes:       []string{azureManagementAPI + "/.default"},
+			}
+			ts = cc.TokenSource(context.Background())
+		default:
+			if _, err := exec.LookPath("az"); err == nil {
+				ts = oauth2.ReuseTokenSource(nil, azureCLIToken{})
+			} else {
+				ts = oauth2.ReuseTokenSource(nil, azureManagedIdentityToken{clientID: id})
+			}
+		}
+		azureHTTP = oauth2.NewClient(context.Background(), ts)
+		azureHTTP.Timeout = time.Minute
+	})
+	return azureHTTP
+}
+
+// azureCLIToken gets tokens from the az CLI, for when we are run by someone who is logged in with it.
+type azureCLIToken struct{}
+
+// Token implements oauth2.TokenSource.Token().
+func (azureCLIToken) Token() (*oauth2.Token, error) {
+	out, err := exec.Command("az", "account", "get-access-token", "--resource", azureManagementAPI+"/", "--output", "json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("-azure could not get a token from the az CLI, is it logged in?: %s", err)
+	}
+	tok := struct {
+		AccessToken string `json:"accessToken"`
+		ExpiresOn   int64  `json:"expires_on"`
+	}{}
+	if err := json.Unmarshal(out, &tok); err != nil {
+		return nil, fmt.Errorf("-azure could not decode the token from the az CLI: %s", err)
+	}
+	// Old versions of the CLI don't say when the token expires in a way we can use. Their tokens
+	// last at least this long.
+	expiry := time.Now().Add(5 * time.Minute)
+	if tok.ExpiresOn > 0 {
+		expiry = time.Unix(tok.ExpiresOn, 0)
+	}
+	return &oauth2.Token{AccessToken: tok.AccessToken, TokenType: "Bearer", Expiry: expiry}, nil
+}
+
+// azureManagedIdentityToken gets tokens for the managed identity of the VM we are running on.
+// clientID picks a user assigned identity, empty is the system assigned one.
+type azureManagedIdentityToken struct {
+	clientID string
+}
+
+// Token implements oauth2.TokenSource.Token().
+func (m azureManagedIdentityToken) Token() (*oauth2.Token, error) {
+	q := url.Values{"api-version": {"2018-02-01"}, "resource": {azureManagementAPI + "/"}}
+	if m.clientID != "" {
+		q.Set("client_id", m.clientID)
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://169.254.169.254/metadata/identity/oauth2/token?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := (&http.Client{Timeout: dialTimeout}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("-azure found no credentials: no AZURE_CLIENT_SECRET, no az CLI and no managed identity (%s)", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("-azure could not get a managed identity token: %s", resp.Status)
+	}
+	tok := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("-azure could not decode the managed identity token: %s", err)
+	}
+	secs, err := strconv.ParseInt(tok.ExpiresOn, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("-azure: the managed identity token has a bad expires_on %q", tok.ExpiresOn)
+	}
+	return &oauth2.Token{AccessToken: tok.AccessToken, TokenType: "Bearer", Expiry: time.Unix(secs, 0)}, nil
+}
+
+// azureList is the part of an Azure Resource Manager list we use.
+type azureList struct {
+	Value    []json.RawMessage `json:"value"`
+	NextLink string            `json:"nextLink"`
+}
+
+// azureListers returns the cloudListers for everything -azure-discover asks for in the
+// subscriptions we look in. subscriptions is the value of -azure-subscriptions and discover the
+// value of -azure-discover.
+func azureListers(ctx context.Context, subscriptions, discover string) ([]cloudLister, error) {
+	c := azureClient()
+	var subs []string
+	for _, s := range strings.Split(subscriptions, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			subs = append(subs, s)
+		}
+	}
+	if len(subs) == 0 {
+		var err error
+		if subs, err = azureSubscriptionIDs(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+
+	var listers []cloudLister
+	for _, kind := range strings.Split(discover, ",") {
+		for _, s := range subs {
+			var path string
+			var targets func(item json.RawMessage) ([]target, error)
+			switch strings.TrimSpace(kind) {
+			case "certificates":
+				path, targets = "/providers/Microsoft.Web/certificates?api-version="+azureWebAPIVersion, azureCertificateTargets
+			case "appservice":
+				path, targets = "/providers/Microsoft.Web/sites?api-version="+azureWebAPIVersion, appServiceTargets
+			case "appgateway":
+				path, targets = "/providers/Microsoft.Network/applicationGateways?api-version=2023-09-01", appGatewayTargets
+			default:
+				return nil, fmt.Errorf("-azure-discover had %q, which is not 'certificates', 'appservice' or 'appgateway'", kind)
+			}
+			u := azureManagementAPI + "/subscriptions/" + url.PathEscape(s) + path
+			listers = append(listers, restLister(integrationAzure, strings.TrimSpace(kind)+" in "+s, c, u, azurePage(targets)))
+		}
+	}
+	return listers, nil
+}
+
+// azureSubscriptionIDs returns AZURE_SUBSCRIPTION_ID, or if it isn't set the IDs of every enabled
+// subscription c can see.
+func azureSubscriptionIDs(ctx context.Context, c *http.Client) ([]string, error) {
+	if s := os.Getenv("AZURE_SUBSCRIPTION_ID"); s != "" {
+		return []string{s}, nil
+	}
+	var ids []string
+	for u := azureManagementAPI + "/subscriptions?api-version=2022-12-01"; u != ""; {
+		body, err := restGet(ctx, c, u)
+		if err != nil {
+			return nil, fmt.Errorf("-azure could not list subscriptions: %s", err)
+		}
+		list := struct {
+			Value []struct {
+				SubscriptionID string `json:"subscriptionId"`
+				State          string `json:"state"`
+			} `json:"value"`
+			NextLink string `json:"nextLink"`
+		}{}
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, fmt.Errorf("-azure could not decode the subscriptions: %s", err)
+		}
+		for _, s := range list.Value {
+			if s.State == "Enabled" {
+				ids = append(ids, s.SubscriptionID)
+			}
+		}
+		u = list.NextLink
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("-azure: our credentials can't see any enabled subscriptions, set -azure-subscriptions")
+	}
+	return ids, nil
+}
+
+// azurePage returns the parser for pages of an Azure list, which gets the targets in each
+// resource in the page with targets.
+func azurePage(targets func(item json.RawMessage) ([]target, error)) func([]byte) ([]target, string, error) {
+	return func(page []byte) ([]target, string, error) {
+		var list azureList
+		if err := json.Unmarshal(page, &list); err != nil {
+			return nil, "", err
+		}
+		var ts []target
+		for _, item := range list.Value {
+			t, err := targets(item)
+			if err != nil {
+				return nil, "", err
+			}
+			ts = append(ts, t...)
+		}
+		return ts, list.NextLink, nil
+	}
+}
+
+// azureTarget returns the target for hostPort, labelled with the ID of the resource it is for.
+func azureTarget(hostPort, id string) target {
+	return target{HostPort: hostPort, Labels: map[string]string{azureIDLabel: id}}
+}
+
+// azureCertificateTargets returns the target for an App Service certificate.
+func azureCertificateTargets(item json.RawMessage) ([]target, error) {
+	cert := struct {
+		ID string `json:"id"`
+	}{}
+	if err := json.Unmarshal(item, &cert); err != nil {
+		return nil, err
+	}
+	return []target{azureTarget(azureCertPrefix+cert.ID, cert.ID)}, nil
+}
+
+// appServiceTargets returns the targets for the host names of a web app that have a TLS
+// binding. The default *.azurewebsites.net name serves Microsoft's certificate, so it is skipped.
+func appServiceTargets(item json.RawMessage) ([]target, error) {
+	site := struct {
+		ID         string `json:"id"`
+		Properties struct {
+			HostNameSslStates []struct {
+				Name     string `json:"name"`
+				SSLState string `json:"sslState"`
+			} `json:"hostNameSslStates"`
+		} `json:"properties"`
+	}{}
+	if err := json.Unmarshal(item, &site); err != nil {
+		return nil, err
+	}
+	var ts []target
+	for _, h := range site.Properties.HostNameSslStates {
+		if h.SSLState == "" || h.SSLState == "Disabled" {
+			continue
+		}
+		ts = append(ts, azureTarget(net.JoinHostPort(h.Name, "443"), site.ID))
+	}
+	return ts, nil
+}
+
+// appGatewayTargets returns the targets for the HTTPS listeners of an Application Gateway.
+// Listeners without a host name are skipped, they answer on the gateway's frontend IP, which is
+// a separate resource.
+func appGatewayTargets(item json.RawMessage) ([]target, error) {
+	gw := struct {
+		ID         string `json:"id"`
+		Properties struct {
+			FrontendPorts []struct {
+				ID         string `json:"id"`
+				Properties struct {
+					Port int `json:"port"`
+				} `json:"properties"`
+			} `json:"frontendPorts"`
+			HTTPListeners []struct {
+				Properties struct {
+					Protocol     string   `json:"protocol"`
+					HostName     string   `json:"hostName"`
+					HostNames    []string `json:"hostNames"`
+					FrontendPort struct {
+						ID string `json:"id"`
+					} `json:"frontendPort"`
+				} `json:"properties"`
+			} `json:"httpListeners"`
+		} `json:"properties"`
+	}{}
+	if err := json.Unmarshal(item, &gw); err != nil {
+		return nil, err
+	}
+	ports := map[string]int{}
+	for _, p := range gw.Properties.FrontendPorts {
+		ports[strings.ToLower(p.ID)] = p.Properties.Port
+	}
+	var ts []target
+	for _, l := range gw.Properties.HTTPListeners {
+		if !strings.EqualFold(l.Properties.Protocol, "Https") {
+			continue
+		}
+		port, ok := ports[strings.ToLower(l.Properties.FrontendPort.ID)]
+		if !ok {
+			port = 443
+		}
+		hosts := l.Properties.HostNames
+		if l.Properties.HostName != "" {
+			hosts = append(hosts, l.Properties.HostName)
+		}
+		for _, h := range hosts {
+			// A wildcard host name has no single name to connect to.
+			if strings.HasPrefix(h, "*") {
+				continue
+			}
+			ts = append(ts, azureTarget(net.JoinHostPort(h, strconv.Itoa(port)), gw.ID))
+		}
+	}
+	return ts, nil
+}
+
+// checkAzureCert checks an App Service certificate, whose target is azureCertPrefix + its
+// resource ID. Like checkACMCert, this catches certificates that expire before anything serves them.
+func checkAzureCert(hostPort string) result {
+	id := strings.TrimPrefix(hostPort, azureCertPrefix)
+	v := result{HostPort: hostPort, Server: id, Status: statusOK}
+	fail := func(err error) result {
+		v.Err = err.Error()
+		v.find(findingStoredCert)
+		return v
+	}
+
+	if !strings.HasPrefix(id, "/subscriptions/") || !strings.Contains(strings.ToLower(id), "/providers/microsoft.web/certificates/") || strings.ContainsAny(id, "?#") {
+		return fail(fmt.Errorf("%q is not the ID of an App Service certificate", id))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	body, err := restGet(ctx, azureClient(), azureManagementAPI+id+"?api-version="+azureWebAPIVersion)
+	if err != nil {
+		return fail(fmt.Errorf("could not get %s from Azure: %s", id, err))
+	}
+	cert := struct {
+		Properties struct {
+			// CerBlob is the DER encoded certificate.
+			CerBlob []byte `json:"cerBlob"`
+		} `json:"properties"`
+	}{}
+	if err := json.Unmarshal(body, &cert); err != nil {
+		return fail(fmt.Errorf("could not decode %s: %s", id, err))
+	}
+	chain, err := x509.ParseCertificates(cert.Properties.CerBlob)
+	if err != nil {
+		return fail(fmt.Errorf("Azure returned a bad certificate for %s: %s", id, err))
+	}
+	if len(chain) == 0 {
+		return fail(fmt.Errorf("Azure has no certificate for %s", id))
+	}
+	v.describe(chain, optionsFor(hostPort).warnDays())
+	return v
+}
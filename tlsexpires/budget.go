@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	budgetLookups = flag.Int64("budget-lookups", 0, "Warn when a run makes more than this many external lookups: DNS queries and OCSP, CRL, CT and crt.sh requests. 0 is no budget")
+	budgetBytes   = flag.Int64("budget-bytes", 0, "Warn when a run sends and receives more than this many bytes, counting connections to hosts and to the services we look things up with. 0 is no budget")
+)
+
+// These are the kinds of external lookups we count.
+const (
+	lookupDNS  = "dns"
+	lookupOCSP = "ocsp"
+	lookupCRL  = "crl"
+	lookupCT   = "ct"
+	lookupACME = "acme"
+)
+
+// usage is what a run cost in external lookups and traffic, so that large scheduled scans don't
+// quietly run up egress bills or get us rate limited by third parties.
+type usage struct {
+	// Lookups is the number of external lookups we made, keyed by kind. DNS answers from our
+	// cache aren't lookups.
+	Lookups map[string]int64 `json:"lookups,omitempty"`
+	// TotalLookups is the sum of Lookups.
+	TotalLookups int64 `json:"totalLookups"`
+	// BytesSent and BytesReceived are what went over the connections we made. Queries to a
+	// plain DNS -resolver aren't included.
+	BytesSent     int64 `json:"bytesSent"`
+	BytesReceived int64 `json:"bytesReceived"`
+	// OverBudget says which of -budget-lookups and -budget-bytes the run went over.
+	OverBudget []string `json:"overBudget,omitempty"`
+}
+
+var (
+	lookupsMu     sync.Mutex
+	lookups       = map[string]int64{}
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+)
+
+// countLookup records an external lookup of kind.
+func countLookup(kind string) {
+	lookupsMu.Lock()
+	defer lookupsMu.Unlock()
+	lookups[kind]++
+}
+
+// resetUsage forgets what we have used, for the start of a run.
+func resetUsage() {
+	lookupsMu.Lock()
+	defer lookupsMu.Unlock()
+	lookups = map[string]int64{}
+	bytesSent.Store(0)
+	bytesReceived.Store(0)
+}
+
+// usageReport returns what we have used since the start of the run, checked against our budgets.
+func usageReport() *usage {
+	lookupsMu.Lock()
+	defer lookupsMu.Unlock()
+
+	u := &usage{Lookups: map[string]int64{}, BytesSent: bytesSent.Load(), BytesReceived: bytesReceived.Load()}
+	for kind, n := range lookups {
+		u.Lookups[kind] = n
+		u.TotalLookups += n
+	}
+	if *budgetLookups > 0 && u.TotalLookups > *budgetLookups {
+		u.OverBudget = append(u.OverBudget, fmt.Sprintf("made %d lookups, the budget is %d", u.TotalLookups, *budgetLookups))
+	}
+	if total := u.BytesSent + u.BytesReceived; *budgetBytes > 0 && total > *budgetBytes {
+		u.OverBudget = append(u.OverBudget, fmt.Sprintf("transferred %d bytes, the budget is %d", total, *budgetBytes))
+	}
+	return u
+}
+
+// warnBudget logs a warning for each budget u went over.
+func warnBudget(u *usage) {
+	for _, over := range u.OverBudget {
+		log.Printf("warning: over budget, this run %s", over)
+	}
+}
+
+// LookupList returns u.Lookups as "kind n" pairs, for the text summary.
+func (u *usage) LookupList() string {
+	var kinds []string
+	for kind := range u.Lookups {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for i, kind := range kinds {
+		kinds[i] = fmt.Sprintf("%s %d", kind, u.Lookups[kind])
+	}
+	return strings.Join(kinds, ", ")
+}
+
+// countingConn counts the bytes that go over a connection.
+type countingConn struct {
+	net.Conn
+}
+
+// Read implements net.Conn.Read().
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	bytesReceived.Add(int64(n))
+	return n, err
+}
+
+// Write implements net.Conn.Write().
+func (c countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	bytesSent.Add(int64(n))
+	return n, err
+}
+
+// countedTransport returns an http.RoundTripper that counts the bytes of its connections and, if
+// kind isn't empty, each request as a lookup of kind.
+func countedTransport(kind string) http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	d := &net.Dialer{Timeout: dialTimeout}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return countingConn{conn}, nil
+	}
+	if kind == "" {
+		return t
+	}
+	return lookupCounter{kind: kind, next: t}
+}
+
+// lookupCounter counts each request it makes as a lookup of kind.
+type lookupCounter struct {
+	kind string
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.RoundTrip().
+func (l lookupCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	countLookup(l.kind)
+	return l.next.RoundTrip(req)
+}
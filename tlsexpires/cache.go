@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	cacheDir = flag.String("cache", "", "A directory to cache the result of checking each server in, so runs within -cache-ttl of each other, like several pipelines scanning the same hosts, reuse it instead of connecting again. A result is only reused by a run that checks the server the same way, with the same flags and -config options. Certificate files and stored certificates aren't cached")
+	cacheTTL = flag.Duration("cache-ttl", time.Hour, "How long a result in -cache is reused for")
+)
+
+// cacheIgnoredFlags are flags that don't change the result of checking a server, so runs that
+// only differ in them share the results in -cache. They pick the targets, decide what we do with
+// the results, or are applied to the results after they come out of the cache, like -expect-issuer.
+var cacheIgnoredFlags = []string{
+	"acme-ari", "acme-directory", "agent-listen", "agent-name", "agent-of", "agent-timeout",
+	"agent-tls-cert", "agent-tls-key", "aws", "aws-discover", "aws-regions", "azure", "azure-discover",
+	"azure-subscriptions", "budget-bytes", "budget-lookups", "cache", "cache-ttl", "cert-file",
+	"cert-stdin", "checkpoint", "clock-skew", "config", "confirm-expand", "consul", "consul-dc",
+	"consul-tag", "digest-window", "expect-issuer", "file", "forecast", "format", "gcp",
+	"gcp-discover", "gcp-projects", "group-by-cert", "grpc-listen", "history", "ics-alarms",
+	"incident-days", "interval", "jira-issue-type", "jira-project", "jira-url", "jira-user", "k8s",
+	"k8s-discover", "k8s-namespace", "lang", "listen", "max-expand", "max-validity-days",
+	"no-progress", "notify-max-age", "notify-queue", "notify-retry-for", "notify-slack",
+	"notify-teams", "notify-templates", "notify-webhook", "on-expiring-exec",
+	"only-expiring-within", "opsgenie-api-key", "opsgenie-url", "otlp-endpoint", "output",
+	"pagerduty-routing-key", "preserve-order", "profile", "prometheus-url", "resume", "scan-path",
+	"servicenow-table", "servicenow-url", "servicenow-user", "smtp-days", "smtp-digest",
+	"smtp-from", "smtp-server", "smtp-to", "smtp-user", "stage-buffer", "stage-metrics", "store",
+	"stream", "strict", "targets-url", "webhook", "webhook-breaches", "webhook-header",
+	"windows-store",
+}
+
+// cacheEntry is a result in -cache.
+type cacheEntry struct {
+	// Checked is when the server was checked.
+	Checked time.Time `json:"checked"`
+	Result  result    `json:"result"`
+}
+
+var (
+	cacheSettingsOnce sync.Once
+	cacheSettings     string
+)
+
+// cacheFlags returns the flags that were set and change the result of checking a server, with
+// their values, which are part of the key of every result in -cache.
+func cacheFlags() string {
+	cacheSettingsOnce.Do(func() {
+		var set []string
+		flag.Visit(func(f *flag.Flag) {
+			if !slices.Contains(cacheIgnoredFlags, f.Name) {
+				set = append(set, f.Name+"="+f.Value.String())
+			}
+		})
+		cacheSettings = strings.Join(set, "\n")
+	})
+	return cacheSettings
+}
+
+// cachePath returns the path in -cache of the result of checking hostPort, at addr if it is set,
+// with opts.
+func cachePath(hostPort, addr string, opts *targetConfig) string {
+	// The client certificate isn't in the json of opts, the files it came from are.
+	o, _ := json.Marshal(opts)
+	sum := sha256.Sum256([]byte(strings.Join([]string{hostPort, addr, string(o), cacheFlags()}, "\n")))
+	return filepath.Join(*cacheDir, hex.EncodeToString(sum[:])+".json")
+}
+
+// cachedResult returns the result of checking hostPort, at addr if it is set, with opts from
+// -cache, if it is there and younger than -cache-ttl.
+func cachedResult(hostPort, addr string, opts *targetConfig) (result, bool) {
+	if *cacheDir == "" {
+		return result{}, false
+	}
+	b, err := os.ReadFile(cachePath(hostPort, addr, opts))
+	if err != nil {
+		return result{}, false
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(b, &e); err != nil || time.Since(e.Checked) >= *cacheTTL {
+		return result{}, false
+	}
+	e.Result.CachedAt = e.Checked
+	return e.Result, true
+}
+
+// cacheResult writes v, the result of checking hostPort with opts, to -cache. Like the
+// checkpoint, a failure to write it is logged instead of failing the check. It is written to a
+// temporary file and renamed, so runs sharing the cache never read a partial result.
+func cacheResult(hostPort, addr string, opts *targetConfig, v result) {
+	if *cacheDir == "" {
+		return
+	}
+	if err := writeCacheEntry(cachePath(hostPort, addr, opts), cacheEntry{Checked: time.Now(), Result: v}); err != nil {
+		log.Printf("could not write %s to -cache: %s", hostPort, err)
+	}
+}
+
+// writeCacheEntry writes e to path.
+func writeCacheEntry(path string, e cacheEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cache-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not rename %s: %s", tmp.Name(), err)
+	}
+	return nil
+}
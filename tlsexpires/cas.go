@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/template"
+	"time"
+)
+
+// chainCert is a CA certificate in the chain of a result, above the leaf.
+type chainCert struct {
+	// Subject is the distinguished name of the CA.
+	Subject string `json:"subject"`
+	// Fingerprint is the SHA-256 fingerprint of the CA's certificate.
+	Fingerprint string `json:"fingerprint"`
+	// ExpiresOn is when the CA's certificate expires.
+	ExpiresOn time.Time `json:"expiresOn"`
+	// Root is if the certificate is self-signed, which makes it the root of the chain.
+	Root bool `json:"root,omitempty"`
+}
+
+// chainCerts returns certs, the CA certificates of a chain, as chainCerts.
+func chainCerts(certs []*x509.Certificate) []chainCert {
+	var out []chainCert
+	for _, c := range certs {
+		out = append(out, chainCert{
+			Subject:     c.Subject.String(),
+			Fingerprint: fingerprint(c),
+			ExpiresOn:   c.NotAfter,
+			Root:        bytes.Equal(c.RawIssuer, c.RawSubject),
+		})
+	}
+	return out
+}
+
+// caUsage is a CA and the leaves of a run that chain to it.
+type caUsage struct {
+	chainCert
+	// Leaves is the number of distinct leaf certificates that chain to the CA.
+	Leaves int `json:"leaves"`
+	// Hosts is the number of results whose certificate chains to the CA.
+	Hosts int `json:"hosts"`
+	// OutlivedBy is the number of Leaves that expire after the CA does. They stop working when
+	// it expires, unless they are reissued from another CA first.
+	OutlivedBy int `json:"outlivedBy"`
+}
+
+// ExpireInDays is result.ExpireInDays() for the CA.
+func (c caUsage) ExpireInDays() int {
+	return result{ExpiresOn: c.ExpiresOn}.ExpireInDays()
+}
+
+// caReport is every CA seen in a run, soonest to expire first, so CA transitions can be
+// planned for the whole fleet.
+type caReport struct {
+	// RunID is the ID of the run.
+	RunID string `json:"runId,omitempty"`
+	// Started is when the run started.
+	Started time.Time `json:"started"`
+	// CAs are the CAs, soonest to expire first.
+	CAs []*caUsage `json:"cas"`
+}
+
+var caTmpl = template.Must(template.New("").Parse(`CAs in {{ with .RunID }}run {{ . }}, {{ end }}started {{ .Started.Format "2006-01-02 15:04 MST" }}, soonest to expire first:
+{{- range .CAs }}
+
+  {{ .Subject }}{{ if .Root }} (root){{ end }}
+    Expires {{ .ExpiresOn.Format "2006-01-02" }}, in {{ .ExpireInDays }} days
+    {{ .Leaves }} leaves on {{ .Hosts }} hosts{{ with .OutlivedBy }}, {{ . }} of them expire after it{{ end }}
+    SHA-256 Fingerprint: {{ .Fingerprint }}
+{{- else }}
+  No results have CAs, they are in the json output of runs since the chain was recorded.
+{{- end }}
+`))
+
+// newCAReport aggregates the results of r by the CAs their certificates chain to.
+func newCAReport(r run) caReport {
+	byFP := map[string]*caUsage{}
+	leaves := map[string]map[string]bool{}
+	for _, v := range r.Results {
+		for _, c := range v.CAs {
+			u, ok := byFP[c.Fingerprint]
+			if !ok {
+				u = &caUsage{chainCert: c}
+				byFP[c.Fingerprint] = u
+				leaves[c.Fingerprint] = map[string]bool{}
+			}
+			u.Hosts++
+			if leaves[c.Fingerprint][v.Fingerprint] {
+				continue
+			}
+			leaves[c.Fingerprint][v.Fingerprint] = true
+			u.Leaves++
+			if v.ExpiresOn.After(c.ExpiresOn) {
+				u.OutlivedBy++
+			}
+		}
+	}
+
+	rep := caReport{RunID: r.ID, Started: r.Started, CAs: []*caUsage{}}
+	for _, u := range byFP {
+		rep.CAs = append(rep.CAs, u)
+	}
+	sort.Slice(rep.CAs, func(i, j int) bool {
+		if !rep.CAs[i].ExpiresOn.Equal(rep.CAs[j].ExpiresOn) {
+			return rep.CAs[i].ExpiresOn.Before(rep.CAs[j].ExpiresOn)
+		}
+		return rep.CAs[i].Subject < rep.CAs[j].Subject
+	})
+	return rep
+}
+
+// casCmd implements the "cas" subcommand. It reports every intermediate and root CA the
+// certificates in the json output of a previous run chain to, with how many leaves chain to
+// each and when it expires.
+func casCmd(args []string) error {
+	fs := subcommandFlags("cas")
+	from := fs.String("from", "", "The path to the json output (-format=json) of a previous run")
+	fs.Parse(args)
+	if err := applyProfile(fs); err != nil {
+		return err
+	}
+
+	if *from == "" {
+		return fmt.Errorf("cas requires -from")
+	}
+	r, err := readRun(*from)
+	if err != nil {
+		return err
+	}
+
+	rep := newCAReport(r)
+	switch *format {
+	case "text":
+		return caTmpl.Execute(os.Stdout, rep)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	}
+	return fmt.Errorf("-format=%s is not supported", *format)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	scanPath  = flag.String("scan-path", "", "A comma separated list of directories or files to look for certificates in, like /etc/ssl/private. PEM, DER, PKCS#12 and Java keystore files are checked alongside the other targets, or on their own if no other targets are given. See -keystore-pass")
+	certFile  = flag.String("cert-file", "", "Check the certificate in this PEM, DER, PKCS#12 or Java keystore file instead of connecting to anything, like one attached to a ticket")
+	certStdin = flag.Bool("cert-stdin", false, "Check the PEM or DER certificate read from stdin instead of connecting to anything, like one pasted from a ticket")
+)
+
+// certFilePrefix starts the target for a certificate file found with -scan-path, which is
+// followed by its path. A keystore with more than one entry has a target for each, whose path is
+// followed by # and the entry's alias. We check these by reading the file instead of connecting
+// to anything.
+const certFilePrefix = "file:"
+
+// certStdinTarget is the target for the certificate read with -cert-stdin, which is kept in
+// certStdinData.
+const certStdinTarget = "stdin:"
+
+var certStdinData []byte
+
+// maxCertFile is the biggest file we look for certificates in. Certificates and bundles of them
+// are far smaller, anything bigger is something else.
+const maxCertFile = 4 << 20
+
+// pkcs12Exts and derExts are the extensions of files that hold PKCS#12 and DER certificates. We
+// find PEM files by their contents, because they are named all sorts of things.
+var (
+	pkcs12Exts = map[string]bool{".p12": true, ".pfx": true}
+	derExts    = map[string]bool{".der": true, ".cer": true, ".crt": true}
+)
+
+// fileProvider provides a target for every certificate file under -scan-path, and for every
+// entry of the keystores. A file reached by more than one path, like through the hash links in
+// /etc/ssl/certs, is only provided once.
+type fileProvider struct {
+	targets []target
+}
+
+// newFileProvider walks the comma separated list of roots and returns a fileProvider for the
+// certificate files in them. A root we can't read is an error, anything under it we can't read
+// is logged and skipped.
+func newFileProvider(roots string) (*fileProvider, error) {
+	p := &fileProvider{}
+	seen := map[string]bool{}
+	for _, root := range strings.Split(roots, ",") {
+		root = strings.TrimSpace(root)
+		if root == "" {
+			continue
+		}
+		if _, err := os.Stat(root); err != nil {
+			return nil, fmt.Errorf("-scan-path: %s", err)
+		}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				log.Printf("warning: -scan-path is skipping %s: %s", path, err)
+				if d != nil && d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			real, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				// A dangling link.
+				return nil
+			}
+			if seen[real] || !isCertFile(real) {
+				return nil
+			}
+			seen[real] = true
+			p.targets = append(p.targets, fileTargets(path)...)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("-scan-path: %s", err)
+		}
+	}
+	return p, nil
+}
+
+// isCertFile reports if the file at path looks like it holds certificates.
+func isCertFile(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxCertFile {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if pkcs12Exts[ext] || derExts[ext] || keystoreExts[ext] {
+		return true
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	// A PEM file with only a key, like most of /etc/ssl/private, has nothing for us.
+	return bytes.Contains(b, []byte("-----BEGIN CERTIFICATE-----"))
+}
+
+// Next implements targetProvider.Next().
+func (p *fileProvider) Next(ctx context.Context) (target, error) {
+	if err := ctx.Err(); err != nil {
+		return target{}, err
+	}
+	if len(p.targets) == 0 {
+		return target{}, io.EOF
+	}
+	t := p.targets[0]
+	p.targets = p.targets[1:]
+	return t, nil
+}
+
+// fileTargets returns the targets for the certificate file at path. A keystore we can't read
+// gets a single target, so its check reports why.
+func fileTargets(path string) []target {
+	if !isKeystore(path) {
+		return []target{{HostPort: certFilePrefix + path}}
+	}
+	entries, err := keystoreEntries(path)
+	if err != nil || len(entries) == 1 {
+		return []target{{HostPort: certFilePrefix + path}}
+	}
+	var ts []target
+	for _, e := range entries {
+		ts = append(ts, target{HostPort: certFilePrefix + path + "#" + e.alias, Labels: map[string]string{keystoreAliasLabel: e.alias}})
+	}
+	return ts
+}
+
+// multiProvider provides the targets of each of its providers in turn.
+type multiProvider struct {
+	providers []targetProvider
+}
+
+// Next implements targetProvider.Next().
+func (m *multiProvider) Next(ctx context.Context) (target, error) {
+	for len(m.providers) > 0 {
+		t, err := m.providers[0].Next(ctx)
+		if err == io.EOF {
+			m.providers = m.providers[1:]
+			continue
+		}
+		return t, err
+	}
+	return target{}, io.EOF
+}
+
+// newCertInputProvider returns a provider of the target for -cert-file or -cert-stdin. The first
+// call reads stdin for -cert-stdin, so every scan with -listen checks the same certificate.
+func newCertInputProvider() (*fileProvider, error) {
+	if *certFile != "" && *certStdin {
+		return nil, fmt.Errorf("-cert-file and -cert-stdin can't be used together")
+	}
+	if sourceFlagSet() || *scanPath != "" || *windowsStores != "" {
+		return nil, fmt.Errorf("-cert-file and -cert-stdin can't be used with other targets")
+	}
+	if *certFile != "" {
+		if _, err := os.Stat(*certFile); err != nil {
+			return nil, fmt.Errorf("-cert-file: %s", err)
+		}
+		return &fileProvider{targets: fileTargets(*certFile)}, nil
+	}
+	if certStdinData == nil {
+		b, err := io.ReadAll(io.LimitReader(os.Stdin, maxCertFile+1))
+		if err != nil {
+			return nil, fmt.Errorf("-cert-stdin: %s", err)
+		}
+		if len(b) > maxCertFile {
+			return nil, fmt.Errorf("-cert-stdin: more than %d bytes is not a certificate", maxCertFile)
+		}
+		certStdinData = b
+	}
+	return &fileProvider{targets: []target{{HostPort: certStdinTarget}}}, nil
+}
+
+// checkCertStdin checks the certificate read with -cert-stdin.
+func checkCertStdin(hostPort string) result {
+	v := result{HostPort: hostPort, Server: "stdin", Status: statusOK}
+	chain, err := parseCerts(certStdinData, "stdin")
+	if err == nil && len(chain) == 0 {
+		err = fmt.Errorf("stdin has no certificates")
+	}
+	if err != nil {
+		v.Err = err.Error()
+		v.find(findingCertFile)
+		return v
+	}
+	v.describe(chain, optionsFor(hostPort).warnDays())
+	return v
+}
+
+// checkCertFile checks a certificate file, whose target is certFilePrefix + its path. The first
+// certificate in the file is treated as the leaf, which is how servers like haproxy and nginx
+// want their bundles.
+func checkCertFile(hostPort string) result {
+	path, alias := strings.TrimPrefix(hostPort, certFilePrefix), ""
+	// A # ends the path of a file unless the file's name has one.
+	if i := strings.LastIndex(path, "#"); i >= 0 {
+		if _, err := os.Stat(path); err != nil {
+			path, alias = path[:i], path[i+1:]
+		}
+	}
+	v := result{HostPort: hostPort, Server: strings.TrimPrefix(hostPort, certFilePrefix), Status: statusOK}
+	fail := func(err error) result {
+		v.Err = err.Error()
+		v.find(findingCertFile)
+		return v
+	}
+
+	chain, err := certsInFile(path, alias)
+	if err != nil {
+		return fail(err)
+	}
+	if len(chain) == 0 {
+		return fail(fmt.Errorf("%s has no certificates", path))
+	}
+	v.describe(chain, optionsFor(hostPort).warnDays())
+	return v
+}
+
+// certsInFile returns the certificates in the PEM or DER file at path. If path is a keystore, it
+// returns the certificates of the entry called alias, or of its only entry if alias is empty.
+func certsInFile(path, alias string) ([]*x509.Certificate, error) {
+	if isKeystore(path) {
+		entries, err := keystoreEntries(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.alias == alias || (alias == "" && len(entries) == 1) {
+				return e.chain, nil
+			}
+		}
+		if alias == "" {
+			return nil, fmt.Errorf("%s has %d entries, expected 1", path, len(entries))
+		}
+		return nil, fmt.Errorf("%s has no entry %q", path, alias)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCerts(b, path)
+}
+
+// parseCerts returns the certificates in b, which is PEM or DER. name is where b came from, for
+// errors.
+func parseCerts(b []byte, name string) ([]*x509.Certificate, error) {
+	if bytes.Contains(b, []byte("-----BEGIN")) {
+		certs, err := pemCertificates(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s has a bad certificate: %s", name, err)
+		}
+		return certs, nil
+	}
+	certs, err := x509.ParseCertificates(bytes.TrimSpace(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a PEM or DER certificate: %s", name, err)
+	}
+	return certs, nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/ocsp"
+)
+
+// oidSCTList is the x509 extension that holds Signed Certificate Timestamps embedded in a certificate.
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// extensionNames are human readable names for the x509 extensions we are likely to see.
+var extensionNames = map[string]string{
+	"2.5.29.14":               "Subject Key Identifier",
+	"2.5.29.15":               "Key Usage",
+	"2.5.29.17":               "Subject Alternative Name",
+	"2.5.29.19":               "Basic Constraints",
+	"2.5.29.30":               "Name Constraints",
+	"2.5.29.31":               "CRL Distribution Points",
+	"2.5.29.32":               "Certificate Policies",
+	"2.5.29.35":               "Authority Key Identifier",
+	"2.5.29.37":               "Extended Key Usage",
+	"1.3.6.1.5.5.7.1.1":       "Authority Information Access",
+	"1.3.6.1.5.5.7.1.24":      "TLS Feature (OCSP Must-Staple)",
+	"1.3.6.1.4.1.11129.2.4.2": "Signed Certificate Timestamps",
+	"1.3.6.1.4.1.11129.2.4.3": "CT Precertificate Poison",
+}
+
+// extensionName returns the human readable name of an x509 extension, or the OID if we don't know it.
+func extensionName(oid asn1.ObjectIdentifier) string {
+	if n, ok := extensionNames[oid.String()]; ok {
+		return n
+	}
+	return oid.String()
+}
+
+// fingerprint returns the SHA-256 fingerprint of cert as colon separated hex.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return colonHex(sum[:])
+}
+
+// colonHex returns b as upper case hex bytes separated by colons, the way openssl prints them.
+func colonHex(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = strings.ToUpper(hex.EncodeToString([]byte{c}))
+	}
+	return strings.Join(parts, ":")
+}
+
+// pemCertificates parses the CERTIFICATE blocks in data, in order. Other blocks are skipped.
+func pemCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// keyDescription returns the public key algorithm and size of cert, like "RSA 2048" or "ECDSA P-256".
+func keyDescription(cert *x509.Certificate) string {
+	switch k := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA %s", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
+
+// subjectAltNames returns the DNS names and IP addresses cert is valid for.
+func subjectAltNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// keyUsages returns the names of the key usages set in cert.
+func keyUsages(cert *x509.Certificate) []string {
+	names := []struct {
+		usage x509.KeyUsage
+		name  string
+	}{
+		{x509.KeyUsageDigitalSignature, "Digital Signature"},
+		{x509.KeyUsageContentCommitment, "Content Commitment"},
+		{x509.KeyUsageKeyEncipherment, "Key Encipherment"},
+		{x509.KeyUsageDataEncipherment, "Data Encipherment"},
+		{x509.KeyUsageKeyAgreement, "Key Agreement"},
+		{x509.KeyUsageCertSign, "Certificate Sign"},
+		{x509.KeyUsageCRLSign, "CRL Sign"},
+		{x509.KeyUsageEncipherOnly, "Encipher Only"},
+		{x509.KeyUsageDecipherOnly, "Decipher Only"},
+	}
+	var out []string
+	for _, n := range names {
+		if cert.KeyUsage&n.usage != 0 {
+			out = append(out, n.name)
+		}
+	}
+	return out
+}
+
+// extKeyUsages returns the names of the extended key usages in cert.
+func extKeyUsages(cert *x509.Certificate) []string {
+	var out []string
+	for _, u := range cert.ExtKeyUsage {
+		switch u {
+		case x509.ExtKeyUsageServerAuth:
+			out = append(out, "TLS Web Server Authentication")
+		case x509.ExtKeyUsageClientAuth:
+			out = append(out, "TLS Web Client Authentication")
+		case x509.ExtKeyUsageCodeSigning:
+			out = append(out, "Code Signing")
+		case x509.ExtKeyUsageEmailProtection:
+			out = append(out, "E-mail Protection")
+		case x509.ExtKeyUsageOCSPSigning:
+			out = append(out, "OCSP Signing")
+		case x509.ExtKeyUsageTimeStamping:
+			out = append(out, "Time Stamping")
+		case x509.ExtKeyUsageAny:
+			out = append(out, "Any")
+		default:
+			out = append(out, fmt.Sprintf("unknown(%d)", u))
+		}
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		out = append(out, oid.String())
+	}
+	return out
+}
+
+// sct is a Signed Certificate Timestamp, the promise from a Certificate Transparency log that it
+// will publish a certificate. See RFC 6962 section 3.2.
+type sct struct {
+	// Source is where we found the SCT, "embedded" in the certificate or delivered in the "handshake".
+	Source string
+	// Version is the SCT version, 0 is v1.
+	Version uint8
+	// LogID is the SHA-256 hash of the log's public key.
+	LogID [32]byte
+	// Timestamp is when the log promised to publish the certificate.
+	Timestamp time.Time
+	// Extensions are the SCT extensions, which are currently always empty.
+	Extensions []byte
+	// HashAlg and SigAlg are the TLS HashAlgorithm and SignatureAlgorithm of Signature.
+	HashAlg, SigAlg uint8
+	// Signature is the log's signature over the certificate and the fields above.
+	Signature []byte
+}
+
+// parseSCT parses a single TLS encoded SCT.
+func parseSCT(b []byte, source string) (sct, error) {
+	s := sct{Source: source}
+	in := cryptobyte.String(b)
+
+	var ts uint64
+	var logID []byte
+	var exts, sig cryptobyte.String
+	if !in.ReadUint8(&s.Version) || !in.ReadBytes(&logID, 32) || !in.ReadUint64(&ts) ||
+		!in.ReadUint16LengthPrefixed(&exts) || !in.ReadUint8(&s.HashAlg) || !in.ReadUint8(&s.SigAlg) ||
+		!in.ReadUint16LengthPrefixed(&sig) || !in.Empty() {
+		return sct{}, fmt.Errorf("malformed SCT")
+	}
+	copy(s.LogID[:], logID)
+	s.Timestamp = time.UnixMilli(int64(ts)).UTC()
+	s.Extensions = exts
+	s.Signature = sig
+	return s, nil
+}
+
+// embeddedSCTs returns the SCTs embedded in cert's SCT list extension.
+func embeddedSCTs(cert *x509.Certificate) ([]sct, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSCTList) {
+			continue
+		}
+		// The extension value is an OCTET STRING holding a TLS encoded SignedCertificateTimestampList.
+		var list []byte
+		if _, err := asn1.Unmarshal(ext.Value, &list); err != nil {
+			return nil, fmt.Errorf("malformed SCT list extension: %s", err)
+		}
+		in := cryptobyte.String(list)
+		var scts cryptobyte.String
+		if !in.ReadUint16LengthPrefixed(&scts) || !in.Empty() {
+			return nil, fmt.Errorf("malformed SCT list")
+		}
+		var out []sct
+		for !scts.Empty() {
+			var raw cryptobyte.String
+			if !scts.ReadUint16LengthPrefixed(&raw) {
+				return nil, fmt.Errorf("malformed SCT list")
+			}
+			s, err := parseSCT(raw, "embedded")
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	}
+	return nil, nil
+}
+
+// handshakeSCTs parses the SCTs a server sent in the TLS handshake.
+func handshakeSCTs(raw [][]byte) ([]sct, error) {
+	var out []sct
+	for _, b := range raw {
+		s, err := parseSCT(b, "handshake")
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// ocspClient is used for any OCSP requests we make.
+var ocspClient = &http.Client{Timeout: 10 * time.Second, Transport: countedTransport(lookupOCSP)}
+
+// ocspStatus returns the OCSP response for leaf. If the server stapled a response we use that,
+// otherwise we ask the OCSP responder listed in the certificate. source says which we used.
+func ocspStatus(leaf, issuer *x509.Certificate, stapled []byte) (resp *ocsp.Response, source string, err error) {
+	if len(stapled) > 0 {
+		resp, err := ocsp.ParseResponseForCert(stapled, leaf, issuer)
+		if err != nil {
+			return nil, "stapled", fmt.Errorf("could not parse stapled OCSP response: %s", err)
+		}
+		return resp, "stapled", nil
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil, "", fmt.Errorf("certificate has no OCSP responder and none was stapled")
+	}
+
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, leaf.OCSPServer[0], err
+	}
+	hresp, err := ocspClient.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, leaf.OCSPServer[0], err
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode != http.StatusOK {
+		return nil, leaf.OCSPServer[0], fmt.Errorf("OCSP responder returned %s", hresp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(hresp.Body, 1<<20))
+	if err != nil {
+		return nil, leaf.OCSPServer[0], err
+	}
+	resp, err = ocsp.ParseResponseForCert(b, leaf, issuer)
+	if err != nil {
+		return nil, leaf.OCSPServer[0], fmt.Errorf("could not parse OCSP response: %s", err)
+	}
+	return resp, leaf.OCSPServer[0], nil
+}
+
+// ocspStatusName returns the human readable name of an OCSP status.
+func ocspStatusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	case ocsp.Unknown:
+		return "unknown"
+	}
+	return fmt.Sprintf("invalid(%d)", status)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxAdHocChecks is how many POST /check requests we check at a time. More wait their turn.
+const maxAdHocChecks = 16
+
+// maxCheckRequest is the largest POST /check body we read.
+const maxCheckRequest = 64 << 10
+
+// checkRequest is the json body of POST /check. It has the options of a target in -config that
+// are safe to take from a caller, the rest use the flags.
+//
+//	{"host": "mail.example.com:25", "starttls": "smtp", "warnDays": 14}
+type checkRequest struct {
+	// Host is the host:port to check.
+	Host string `json:"host"`
+	// SNI is the server name we ask for and verify the certificate against. Defaults to the host in Host.
+	SNI string `json:"sni"`
+	// Protocol is "tls", the default, "ssh" or another protocol we have a prober for, see targetConfig.
+	Protocol string `json:"protocol"`
+	// STARTTLS is the plain text protocol to upgrade to TLS, see starttlsProtocols.
+	STARTTLS string `json:"starttls"`
+	// WarnDays overrides -warn-days.
+	WarnDays *int `json:"warnDays"`
+}
+
+// checkAPI serves POST /check, which checks a single host on demand and returns its result,
+// so other tools can check certificates through us instead of doing TLS themselves.
+type checkAPI struct {
+	zones *zoneConfig
+	sem   chan struct{}
+}
+
+// newCheckAPI returns a checkAPI that checks hosts in the zones of -zones.
+func newCheckAPI() (*checkAPI, error) {
+	if err := loadRootCAs(); err != nil {
+		return nil, err
+	}
+	if err := loadTrustStores(); err != nil {
+		return nil, err
+	}
+	if err := loadConfig(); err != nil {
+		return nil, err
+	}
+	zc, err := loadZones()
+	if err != nil {
+		return nil, err
+	}
+	return &checkAPI{zones: zc, sem: make(chan struct{}, maxAdHocChecks)}, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (c *checkAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req checkRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCheckRequest))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	opts, err := adHocOptions(req.Host, req.SNI, req.Protocol, req.STARTTLS, req.WarnDays)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case c.sem <- struct{}{}:
+		defer func() { <-c.sem }()
+	case <-r.Context().Done():
+		return
+	}
+	writeJSON(w, c.check(r.Context(), opts))
+}
+
+// adHocOptions returns the options to check a host we were asked to check on demand with, after
+// making sure they are valid.
+func adHocOptions(host, sni, protocol, starttls string, warnDays *int) (*targetConfig, error) {
+	// Stored certificates are read from places a caller shouldn't be able to point us at, like
+	// our own files.
+	for prefix := range storedProbers {
+		if strings.HasPrefix(host, prefix) {
+			return nil, fmt.Errorf("host must be a host:port")
+		}
+	}
+	opts := &targetConfig{Host: host, SNI: sni, Protocol: protocol, STARTTLS: starttls, WarnDays: warnDays}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// check checks opts.Host in its zone. It gives up if ctx is done.
+func (c *checkAPI) check(ctx context.Context, opts *targetConfig) result {
+	return c.checkAt(ctx, opts, "")
+}
+
+// checkAt checks opts.Host in its zone at addr, or wherever it resolves to if addr is empty.
+func (c *checkAPI) checkAt(ctx context.Context, opts *targetConfig, addr string) result {
+	z := c.zones.zoneFor(ctx, opts.Host)
+	z.wait(ctx)
+	v := checkServer(ctx, z.dialer, opts.Host, addr, opts)
+	v.Zone = z.Name
+	v.Labels = labelsFor(opts.Host, nil)
+	return v
+}
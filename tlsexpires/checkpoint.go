@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	checkpointFile = flag.String("checkpoint", "", "A file to record each finished check in, so a scan that is interrupted can be finished with -resume instead of starting over. It is removed once the scan finishes")
+	resume         = flag.Bool("resume", false, "Finish the scan recorded in -checkpoint, only checking the targets it didn't get to")
+)
+
+// checkpointHeader is the first line of a -checkpoint file. Every line after it is the json of
+// a finished check.
+type checkpointHeader struct {
+	// RunID and Started are those of the scan, which a resumed scan keeps.
+	RunID   string    `json:"runId"`
+	Started time.Time `json:"started"`
+}
+
+// checkpoint records each finished check of a scan in -checkpoint. A nil *checkpoint records
+// nothing, so callers don't need to check if -checkpoint is set.
+type checkpoint struct {
+	header checkpointHeader
+	// done are the results of the scan we are resuming, keyed by resultKey().
+	done map[string]result
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// scanCheckpoint is the checkpoint of the scan output() is doing, if -checkpoint is set. The
+// check workers of checkAll() use it.
+var scanCheckpoint *checkpoint
+
+// openCheckpoint opens -checkpoint for a scan with runID that started at started. With -resume,
+// it returns the checkpoint of the interrupted scan, with its run ID and start time, and new
+// checks are added to it. Otherwise it starts a new one. It returns nil if -checkpoint isn't set.
+func openCheckpoint(runID string, started time.Time) (*checkpoint, error) {
+	if *checkpointFile == "" {
+		if *resume {
+			return nil, fmt.Errorf("-resume requires -checkpoint")
+		}
+		return nil, nil
+	}
+	c := &checkpoint{header: checkpointHeader{RunID: runID, Started: started}, done: map[string]result{}}
+
+	if *resume {
+		err := c.read()
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			log.Printf("-checkpoint=%s doesn't exist, starting the scan from the beginning", *checkpointFile)
+		case err != nil:
+			return nil, err
+		default:
+			log.Printf("resuming scan %s from -checkpoint, %d checks are already done", c.header.RunID, len(c.done))
+			f, err := os.OpenFile(*checkpointFile, os.O_WRONLY|os.O_APPEND, 0o600)
+			if err != nil {
+				return nil, fmt.Errorf("could not open -checkpoint: %s", err)
+			}
+			c.f = f
+			return c, nil
+		}
+	}
+
+	f, err := os.OpenFile(*checkpointFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not create -checkpoint: %s", err)
+	}
+	c.f = f
+	b, err := json.Marshal(c.header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not write -checkpoint: %s", err)
+	}
+	return c, nil
+}
+
+// read reads the header and finished checks of -checkpoint into c.
+func (c *checkpoint) read() error {
+	f, err := os.Open(*checkpointFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	// Results with an enumeration or a long chain of SANs make for long lines.
+	s.Buffer(nil, 16<<20)
+	if !s.Scan() {
+		return fmt.Errorf("-checkpoint=%s is empty", *checkpointFile)
+	}
+	if err := json.Unmarshal(s.Bytes(), &c.header); err != nil || c.header.RunID == "" {
+		return fmt.Errorf("-checkpoint=%s is not a checkpoint", *checkpointFile)
+	}
+	for s.Scan() {
+		var v result
+		// The last line is cut short if we were killed while writing it, that check is done again.
+		if err := json.Unmarshal(s.Bytes(), &v); err != nil {
+			continue
+		}
+		c.done[v.resultKey()] = v
+	}
+	return s.Err()
+}
+
+// lookup returns the result of checking hostPort, at addr if it is set, from the scan we are
+// resuming.
+func (c *checkpoint) lookup(hostPort, addr string) (result, bool) {
+	if c == nil {
+		return result{}, false
+	}
+	v, ok := c.done[result{HostPort: hostPort, Address: addr}.resultKey()]
+	return v, ok
+}
+
+// record adds the result of a check to the checkpoint. A failure to write it is logged instead
+// of stopping the scan, as the scan is worth more than being able to resume it.
+func (c *checkpoint) record(v result) {
+	if c == nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("could not record %s in -checkpoint: %s", v.HostPort, err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.f.Write(append(b, '\n')); err != nil {
+		log.Printf("could not record %s in -checkpoint: %s", v.HostPort, err)
+	}
+}
+
+// close closes the checkpoint. If the scan finished, the checkpoint is removed, there is nothing
+// left to resume.
+func (c *checkpoint) close(finished bool) {
+	if c == nil {
+		return
+	}
+	c.f.Close()
+	if !finished {
+		log.Printf("the checks done so far are in -checkpoint=%s, finish the scan with -resume", *checkpointFile)
+		return
+	}
+	if err := os.Remove(*checkpointFile); err != nil {
+		log.Printf("could not remove -checkpoint: %s", err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	setClock(t, testNow)
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	setFlag(t, "checkpoint", path)
+	setFlag(t, "resume", "false")
+
+	// The scan that is interrupted.
+	c, err := openCheckpoint("first", clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := []result{
+		{HostPort: "a:443", Status: statusOK, ExpiresOn: testNow.Add(90 * day)},
+		{HostPort: "b:443", Address: "10.0.0.1", Status: statusWarning, ExpiresOn: testNow.Add(5 * day)},
+	}
+	for _, v := range done {
+		c.record(v)
+	}
+	c.close(false)
+
+	// A check that was being written when we were killed is done again.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"hostPort":"c:443","stat`)
+	f.Close()
+
+	// The scan that finishes it keeps its ID and start.
+	setFlag(t, "resume", "true")
+	c, err = openCheckpoint("second", testNow.Add(day))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.header.RunID != "first" || !c.header.Started.Equal(testNow) {
+		t.Errorf("TestCheckpointResume: got run %s started %s, want run first started %s", c.header.RunID, c.header.Started, testNow)
+	}
+
+	tests := []struct {
+		hostPort, addr string
+		// want is the expiry of the recorded check, zero if it wasn't done.
+		want time.Time
+	}{
+		{hostPort: "a:443", want: done[0].ExpiresOn},
+		{hostPort: "b:443", addr: "10.0.0.1", want: done[1].ExpiresOn},
+		{hostPort: "b:443", addr: "10.0.0.2"},
+		{hostPort: "b:443"},
+		{hostPort: "c:443"},
+	}
+	for _, test := range tests {
+		v, ok := c.lookup(test.hostPort, test.addr)
+		if ok != !test.want.IsZero() || !v.ExpiresOn.Equal(test.want) {
+			t.Errorf("TestCheckpointResume(%s %s): got done == %t expiring %s, want done == %t expiring %s", test.hostPort, test.addr, ok, v.ExpiresOn, !test.want.IsZero(), test.want)
+		}
+	}
+
+	// A scan that finishes has nothing left to resume.
+	c.close(true)
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("TestCheckpointResume: -checkpoint is still there after the scan finished: %v", err)
+	}
+}
+
+func TestCheckpointResumeMissing(t *testing.T) {
+	setFlag(t, "checkpoint", filepath.Join(t.TempDir(), "checkpoint"))
+	setFlag(t, "resume", "true")
+
+	// Resuming a scan that never wrote a checkpoint starts it from the beginning.
+	c, err := openCheckpoint("new", testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close(true)
+	if c.header.RunID != "new" || len(c.done) != 0 {
+		t.Errorf("TestCheckpointResumeMissing: got run %s with %d checks done, want run new with none", c.header.RunID, len(c.done))
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	clientCertFile = flag.String("client-cert", "", "A PEM client certificate to present to servers that require mutual TLS. Requires -client-key. On Windows it can instead be winstore:, a store and the SHA-1 thumbprint of a certificate in it, like winstore:LocalMachine/My/0123ABCD..., whose private key is used where it is, so a key in a TPM or on a smart card works and is never exported")
+	clientKeyFile  = flag.String("client-key", "", "The PEM private key for -client-cert")
+
+	hostClientCerts = clientCerts{}
+)
+
+func init() {
+	flag.Var(hostClientCerts, "host-client-cert", "host:port=cert.pem,key.pem to present a client certificate to just that host, overriding -client-cert. The certificate can be in a Windows store like with -client-cert, host:port=winstore:LocalMachine/My/0123ABCD... Can be repeated")
+}
+
+// clientCerts is a flag.Value for the repeatable -host-client-cert flag. It maps a host:port to
+// the client certificate we present to it.
+type clientCerts map[string]*tls.Certificate
+
+// String implements flag.Value.String().
+func (c clientCerts) String() string {
+	var out []string
+	for hostPort := range c {
+		out = append(out, hostPort)
+	}
+	return strings.Join(out, ",")
+}
+
+// Set implements flag.Value.Set().
+func (c clientCerts) Set(s string) error {
+	hostPort, files, ok := strings.Cut(s, "=")
+	certFile, keyFile, ok2 := strings.Cut(files, ",")
+	if !ok || (!ok2 && !strings.HasPrefix(certFile, winStorePrefix)) {
+		return fmt.Errorf("-host-client-cert must be host:port=cert.pem,key.pem or host:port=winstore:store/thumbprint, was %q", s)
+	}
+	cert, err := loadClientCert(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("-host-client-cert %q: %s", s, err)
+	}
+	c[strings.TrimSpace(hostPort)] = cert
+	return nil
+}
+
+// loadClientCert loads the client certificate in certFile, with the private key in keyFile.
+// certFile can instead be winStorePrefix, a store and the thumbprint of a certificate in it,
+// like a -windows-store target, with keyFile empty. Its private key stays in the store.
+func loadClientCert(certFile, keyFile string) (*tls.Certificate, error) {
+	ref, ok := strings.CutPrefix(certFile, winStorePrefix)
+	if !ok {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}
+	if keyFile != "" {
+		return nil, fmt.Errorf("the private key of %s is in its store, it can't have a key file", certFile)
+	}
+	i := strings.LastIndex(ref, "/")
+	if i < 0 {
+		return nil, fmt.Errorf("%s must be %sstore/thumbprint", certFile, winStorePrefix)
+	}
+	location, name, err := splitWinStore(ref[:i])
+	if err != nil {
+		return nil, err
+	}
+	thumbprint, err := hex.DecodeString(strings.ReplaceAll(ref[i+1:], ":", ""))
+	if err != nil || len(thumbprint) != sha1.Size {
+		return nil, fmt.Errorf("%s doesn't end in a SHA-1 thumbprint", certFile)
+	}
+	return windowsStoreClientCert(location, name, thumbprint)
+}
+
+var (
+	defaultClientCertOnce sync.Once
+	defaultClientCert     *tls.Certificate
+	defaultClientCertErr  error
+)
+
+// clientCertFor returns the client certificate we present to hostPort, or nil if we don't
+// present one.
+func clientCertFor(hostPort string) (*tls.Certificate, error) {
+	if c, ok := hostClientCerts[hostPort]; ok {
+		return c, nil
+	}
+	defaultClientCertOnce.Do(func() {
+		if *clientCertFile == "" && *clientKeyFile == "" {
+			return
+		}
+		if (*clientCertFile == "" || *clientKeyFile == "") && !strings.HasPrefix(*clientCertFile, winStorePrefix) {
+			defaultClientCertErr = fmt.Errorf("-client-cert and -client-key must be used together")
+			return
+		}
+		cert, err := loadClientCert(*clientCertFile, *clientKeyFile)
+		if err != nil {
+			defaultClientCertErr = fmt.Errorf("could not load -client-cert: %s", err)
+			return
+		}
+		defaultClientCert = cert
+	})
+	return defaultClientCert, defaultClientCertErr
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// Clock tells us what time it is. Every expiration calculation asks the Clock instead of calling
+// time.Now(), which lets -now and tests decide what "now" is.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// realClock is a Clock that returns the actual time.
+type realClock struct{}
+
+// Now implements Clock.Now().
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// fixedClock is a Clock that always returns the same time.
+type fixedClock struct {
+	t time.Time
+}
+
+// Now implements Clock.Now().
+func (f fixedClock) Now() time.Time {
+	return f.t
+}
+
+// clock is the Clock everything uses. It is changed by -now.
+var clock Clock = realClock{}
+
+// until is time.Until() for our clock.
+func until(t time.Time) time.Duration {
+	return t.Sub(clock.Now())
+}
+
+// nowFlag is the flag.Value for -now, which replaces clock with a fixedClock.
+type nowFlag struct{}
+
+// String implements flag.Value.String().
+func (nowFlag) String() string {
+	if f, ok := clock.(fixedClock); ok {
+		return f.t.Format(time.RFC3339)
+	}
+	return ""
+}
+
+// Set implements flag.Value.Set().
+func (nowFlag) Set(s string) error {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			clock = fixedClock{t: t}
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not a date (2006-01-02) or RFC3339 time", s)
+}
+
+// hiddenFlags are flags that work, but that we don't show in -help.
+var hiddenFlags = map[string]bool{}
+
+func init() {
+	// -now lets us ask questions like "what will have expired by the December change freeze?"
+	// while still scanning live servers. It is hidden because it's easy to confuse yourself with.
+	flag.Var(nowFlag{}, "now", "Pretend the current time is this date (2006-01-02) or RFC3339 time when calculating expirations")
+	hiddenFlags["now"] = true
+}
+
+// printDefaults is flag.PrintDefaults() for fs, but it leaves out hiddenFlags.
+func printDefaults(fs *flag.FlagSet) {
+	visible := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	visible.SetOutput(fs.Output())
+	fs.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	visible.PrintDefaults()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// cloudLister lists one kind of cloud resource a page at a time.
+type cloudLister struct {
+	// integration is the integration the lister belongs to, like integrationAWS. It is also the
+	// name of the flag that turns it on.
+	integration string
+	// name says what is being listed, like "acm in us-east-1".
+	name string
+	// more reports if there are more pages.
+	more func() bool
+	// next returns the targets in the next page.
+	next func(ctx context.Context) ([]target, error)
+}
+
+// cloudProvider provides a target for everything -aws, -gcp and -azure ask for, so a single run
+// covers every cloud we are in. Like k8sProvider, it lists a page at a time and only provides a
+// host once.
+type cloudProvider struct {
+	listers []cloudLister
+	pending []target
+	seen    map[string]bool
+}
+
+// newCloudProvider returns the cloudProvider for the clouds our flags turn on.
+func newCloudProvider(ctx context.Context) (*cloudProvider, error) {
+	c := &cloudProvider{seen: map[string]bool{}}
+	if *awsTargets {
+		ls, err := awsListers(ctx, *awsRegions, *awsDiscover)
+		if err != nil {
+			return nil, err
+		}
+		c.listers = append(c.listers, ls...)
+	}
+	if *gcpTargets {
+		ls, err := gcpListers(ctx, *gcpProjects, *gcpDiscover)
+		if err != nil {
+			return nil, err
+		}
+		c.listers = append(c.listers, ls...)
+	}
+	if *azureTargets {
+		ls, err := azureListers(ctx, *azureSubscriptions, *azureDiscover)
+		if err != nil {
+			return nil, err
+		}
+		c.listers = append(c.listers, ls...)
+	}
+	return c, nil
+}
+
+// Next implements targetProvider.Next().
+func (c *cloudProvider) Next(ctx context.Context) (target, error) {
+	for {
+		for len(c.pending) > 0 {
+			t := c.pending[0]
+			c.pending = c.pending[1:]
+			if c.seen[t.HostPort] {
+				continue
+			}
+			c.seen[t.HostPort] = true
+			return t, nil
+		}
+		if len(c.listers) == 0 {
+			return target{}, io.EOF
+		}
+		l := c.listers[0]
+		if !l.more() {
+			c.listers = c.listers[1:]
+			continue
+		}
+		ts, err := l.next(ctx)
+		integrationUsed(l.integration, err)
+		if err != nil {
+			// Like all our integrations, we carry on without what we couldn't list.
+			log.Printf("warning: -%s is skipping %s: %s", l.integration, l.name, err)
+			c.listers = c.listers[1:]
+			continue
+		}
+		c.pending = append(c.pending, ts...)
+	}
+}
+
+// restLister returns a cloudLister for a REST API that pages by returning the URL of the next
+// page. It GETs url with client, and parse returns the targets in a page and the URL of the next
+// one, which is empty after the last page.
+func restLister(integration, name string, client *http.Client, url string, parse func(page []byte) ([]target, string, error)) cloudLister {
+	return cloudLister{
+		integration: integration,
+		name:        name,
+		more:        func() bool { return url != "" },
+		next: func(ctx context.Context) ([]target, error) {
+			page, err := restGet(ctx, client, url)
+			if err != nil {
+				return nil, err
+			}
+			ts, next, err := parse(page)
+			if err != nil {
+				return nil, fmt.Errorf("could not decode GET %s: %s", url, err)
+			}
+			url = next
+			return ts, nil
+		},
+	}
+}
+
+// restGet fetches url with client, which adds our credentials, and returns the body.
+func restGet(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRESTBody))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned %s: %s", url, resp.Status, restErrorMessage(body))
+	}
+	return body, nil
+}
+
+// maxRESTBody is the most we read of a cloud API response. Pages are far smaller than this.
+const maxRESTBody = 64 << 20
+
+// restErrorMessage returns the message in the json error body of a GCP or Azure API, or the body
+// itself if it doesn't have one.
+func restErrorMessage(body []byte) string {
+	e := struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+	if json.Unmarshal(body, &e) == nil && e.Error.Message != "" {
+		return e.Error.Message
+	}
+	if len(body) > 200 {
+		body = body[:200]
+	}
+	return string(body)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// These are our exit codes. Nothing else should be passed to os.Exit(), and log.Fatal() exits
+// with exitFailure.
+const (
+	// exitOK means we did what we were asked. A scan exits with this even when hosts have
+	// warnings or errors, the results say what we found.
+	exitOK = 0
+	// exitFailure means we couldn't do what we were asked, verify found hosts that deviate
+	// from the manifest, or an integration failed with -strict.
+	exitFailure = 1
+	// exitUsage means the command line was wrong.
+	exitUsage = 2
+	// exitInterrupted means a scan was stopped by SIGINT or SIGTERM before it finished. It is
+	// the status a shell gives a command SIGINT stopped.
+	exitInterrupted = 130
+)
+
+// exitCodes describes each of our exit codes. It is the source of the reference in -help and
+// the "codes" subcommand.
+var exitCodes = []codeInfo{
+	{Code: fmt.Sprint(exitOK), Description: "Success. A scan exits with this even when hosts have warnings or errors, check the statuses in the output"},
+	{Code: fmt.Sprint(exitFailure), Description: "We couldn't do what was asked (bad input, unreadable files, failed notifications), verify found hosts that deviate from the manifest, or an optional integration failed with -strict"},
+	{Code: fmt.Sprint(exitUsage), Description: "The command line was wrong"},
+	{Code: fmt.Sprint(exitInterrupted), Description: "A scan was interrupted by SIGINT (Ctrl-C) or SIGTERM before it finished, the output only has the hosts checked until then"},
+}
+
+// statuses describes each status a result can have, from best to worst.
+var statuses = []codeInfo{
+	{Code: string(statusOK), Description: "We got a certificate and nothing is wrong with it"},
+	{Code: string(statusWarning), Description: "We got a certificate, but have findings that need attention soon"},
+	{Code: string(statusError), Description: "We couldn't get a certificate, or clients will reject the one we got"},
+}
+
+// finding is a machine readable reason a result isn't statusOK. The human readable details are
+// in the field of the result that goes with it.
+type finding string
+
+const (
+	findingExpiring         finding = "expiring"
+	findingHandshake        finding = "handshake-failed"
+	findingPinMismatch      finding = "pin-mismatch"
+	findingRevokedOCSP      finding = "revoked-ocsp"
+	findingRevokedCRL       finding = "revoked-crl"
+	findingNoValidSCTs      finding = "no-valid-scts"
+	findingKeyWeakness      finding = "key-weakness"
+	findingProtocolWeakness finding = "protocol-weakness"
+	findingChanged          finding = "changed"
+	findingBackendMismatch  finding = "backend-mismatch"
+	findingBadSecret        finding = "bad-secret"
+	findingStoredCert       finding = "cloud-cert-unreadable"
+	findingCertFile         finding = "cert-file-unreadable"
+	findingSSHNoCert        finding = "ssh-no-certificate"
+	findingALPNMismatch     finding = "alpn-mismatch"
+	findingRenegotiation    finding = "insecure-renegotiation"
+	findingUnexpectedIssuer finding = "unexpected-issuer"
+	findingLongValidity     finding = "validity-too-long"
+	findingNotYetValid      finding = "not-yet-valid"
+	findingClockSkew        finding = "clock-skew"
+	findingACMEOverdue      finding = "acme-renewal-overdue"
+	findingNoPostQuantum    finding = "no-post-quantum"
+	findingUntrustedByStore finding = "untrusted-by-store"
+	findingDANEMismatch     finding = "dane-mismatch"
+	findingDANEInsecure     finding = "dane-insecure"
+	findingDANELookup       finding = "dane-lookup-failed"
+	findingNoHSTS           finding = "no-hsts"
+	findingNoRedirect       finding = "http-not-redirected"
+)
+
+// findingInfo is what a finding means.
+type findingInfo struct {
+	// Status is the status a result with this finding has at best.
+	Status status
+	// Field is the json field of a result with the details.
+	Field string
+	// Description says what the finding means.
+	Description string
+}
+
+// findings describes every finding we can report. result.find() uses it to set the status of a
+// result, so it can't disagree with what we do.
+var findings = map[finding]findingInfo{
+	findingExpiring:         {statusWarning, "expiresOn", "The certificate expires within -warn-days, or has expired"},
+	findingHandshake:        {statusError, "error", "We couldn't connect, or the TLS or SSH handshake or certificate verification failed"},
+	findingPinMismatch:      {statusError, "error", "The certificate doesn't match its -pin-check fingerprint"},
+	findingRevokedOCSP:      {statusError, "error", "The stapled OCSP response says the certificate was revoked"},
+	findingRevokedCRL:       {statusError, "error", "A certificate in the chain was revoked according to its CRL. Only with -crl"},
+	findingNoValidSCTs:      {statusError, "error", "The certificate has no valid SCTs. Only with -ct"},
+	findingKeyWeakness:      {statusWarning, "keyWeaknesses", "The key is too small or the signature uses a weak hash"},
+	findingProtocolWeakness: {statusWarning, "enumeration.weaknesses", "The server accepts an old TLS version or weak cipher suite. Only with -enumerate"},
+	findingChanged:          {statusWarning, "changes", "The issuer, key algorithm, chain length or TLS version changed since the last run. Only with -history"},
+	findingBackendMismatch:  {statusWarning, "mismatch", "The addresses of the host serve different certificates. Only with -all-ips"},
+	findingBadSecret:        {statusError, "error", "A kubernetes.io/tls secret couldn't be read, has no certificate, or its key doesn't go with it. Only with -k8s"},
+	findingStoredCert:       {statusError, "error", "A certificate stored with a cloud provider, like in ACM, couldn't be fetched or parsed. Only with -aws, -gcp or -azure"},
+	findingCertFile:         {statusError, "error", "A file found with -scan-path or given with -cert-file or -cert-stdin, or a certificate in a -windows-store store, couldn't be read or parsed, or is a keystore we don't have the right password for"},
+	findingSSHNoCert:        {statusError, "fingerprint", "The SSH server has a plain host key instead of a host certificate. Only with -ssh"},
+	findingALPNMismatch:     {statusWarning, "alpnPaths", "The host serves a different certificate, or fails the handshake, when offered only one of the -alpn protocols. Only with more than one -alpn protocol"},
+	findingRenegotiation:    {statusWarning, "tlsHealth.secureRenegotiation", "The server speaks TLS 1.2 or older without RFC 5746 secure renegotiation, which leaves it open to renegotiation attacks. Only with -tls-health"},
+	findingUnexpectedIssuer: {statusError, "error", "The certificate was issued by a CA other than the ones -expect-issuer, or the issuerRules in -config, expect"},
+	findingLongValidity:     {statusWarning, "issuedOn", "The certificate is valid for more days in total, from issuedOn to expiresOn, than -max-validity-days allows. Only with -max-validity-days"},
+	findingNotYetValid:      {statusError, "error", "The certificate isn't valid until more than -clock-skew from now, so clients will reject it"},
+	findingClockSkew:        {statusWarning, "issuedOn", "The certificate becomes valid within -clock-skew, was issued before 2001 or expires before it was issued, which suggest the clock of our host, the server or its CA is off"},
+	findingACMEOverdue:      {statusWarning, "acme.overdue", "A Let's Encrypt certificate is still served after ACME clients typically renew it, with a third of its lifetime left, or after the start of the window the CA suggests with -acme-ari. Its ACME client probably isn't renewing it"},
+	findingNoPostQuantum:    {statusWarning, "postQuantum", "The server turns down every post-quantum hybrid key exchange group, like X25519MLKEM768, so its traffic can be recorded now and decrypted once there are quantum computers. Only with -pqc"},
+	findingUntrustedByStore: {statusWarning, "trustStores", "The chain doesn't verify against one of the -trust-store bundles, so clients that only trust that bundle reject it. Only with -trust-store"},
+	findingDANEMismatch:     {statusError, "error", "The certificates the server presents match none of its DANE TLSA records, so DANE clients refuse to connect. Only with -check-dane"},
+	findingDANEInsecure:     {statusWarning, "dane.secure", "The server has DANE TLSA records that the resolver didn't validate with DNSSEC, so DANE clients ignore them. Only with -check-dane"},
+	findingDANELookup:       {statusWarning, "dane.error", "We couldn't look up the server's DANE TLSA records, so we don't know if DANE clients can connect. Only with -check-dane"},
+	findingNoHSTS:           {statusWarning, "http.hsts", "The server doesn't send HSTS, or sends it with a max-age of 0, so browsers can be downgraded to plain HTTP. Only with -http-checks"},
+	findingNoRedirect:       {statusWarning, "http.redirectsToHttps", "Plain HTTP on port 80 of the host answers without redirecting to HTTPS. Only with -http-checks"},
+}
+
+// findingOrder is the order we list findings in.
+var findingOrder = []finding{
+	findingExpiring,
+	findingHandshake,
+	findingPinMismatch,
+	findingRevokedOCSP,
+	findingRevokedCRL,
+	findingNoValidSCTs,
+	findingKeyWeakness,
+	findingProtocolWeakness,
+	findingChanged,
+	findingBackendMismatch,
+	findingBadSecret,
+	findingStoredCert,
+	findingCertFile,
+	findingSSHNoCert,
+	findingALPNMismatch,
+	findingRenegotiation,
+	findingUnexpectedIssuer,
+	findingLongValidity,
+	findingNotYetValid,
+	findingClockSkew,
+	findingACMEOverdue,
+	findingNoPostQuantum,
+	findingUntrustedByStore,
+	findingDANEMismatch,
+	findingDANEInsecure,
+	findingDANELookup,
+	findingNoHSTS,
+	findingNoRedirect,
+}
+
+// severity ranks s so statuses can be compared, higher is worse.
+func severity(s status) int {
+	for i, info := range statuses {
+		if info.Code == string(s) {
+			return i
+		}
+	}
+	return 0
+}
+
+// find records f in v.Findings and makes v.Status at least as bad as f requires.
+func (v *result) find(f finding) {
+	for _, got := range v.Findings {
+		if got == f {
+			return
+		}
+	}
+	v.Findings = append(v.Findings, f)
+	if s := findings[f].Status; severity(s) > severity(v.Status) {
+		v.Status = s
+	}
+}
+
+// findingNames returns v.Findings as strings.
+func (v result) findingNames() []string {
+	names := make([]string, len(v.Findings))
+	for i, f := range v.Findings {
+		names[i] = string(f)
+	}
+	return names
+}
+
+// codeInfo describes an exit code or status.
+type codeInfo struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// codeReference is everything "codes" outputs.
+type codeReference struct {
+	ExitCodes []codeInfo        `json:"exitCodes"`
+	Statuses  []codeInfo        `json:"statuses"`
+	Findings  []findingCodeInfo `json:"findings"`
+}
+
+// findingCodeInfo describes a finding in the codeReference.
+type findingCodeInfo struct {
+	Code        finding `json:"code"`
+	Status      status  `json:"status"`
+	Field       string  `json:"field"`
+	Description string  `json:"description"`
+}
+
+// newCodeReference returns the codeReference built from our tables.
+func newCodeReference() codeReference {
+	ref := codeReference{ExitCodes: exitCodes, Statuses: statuses}
+	for _, f := range findingOrder {
+		info := findings[f]
+		ref.Findings = append(ref.Findings, findingCodeInfo{Code: f, Status: info.Status, Field: info.Field, Description: info.Description})
+	}
+	return ref
+}
+
+// writeCodes writes the human readable version of the codeReference to w, for -help.
+func writeCodes(w io.Writer) error {
+	ref := newCodeReference()
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nExit codes:")
+	for _, c := range ref.ExitCodes {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.Code, c.Description)
+	}
+	fmt.Fprintln(tw, "\nStatuses:")
+	for _, c := range ref.Statuses {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.Code, c.Description)
+	}
+	fmt.Fprintln(tw, "\nFindings (the \"findings\" of a json result):")
+	for _, f := range ref.Findings {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", f.Code, f.Status, f.Description)
+	}
+	return tw.Flush()
+}
+
+// codes implements the "codes" subcommand. It writes every exit code, status and finding we can
+// produce as json, so automation can discover them instead of copying them out of our docs.
+func codes(args []string) error {
+	fs := subcommandFlags("codes")
+	fs.Parse(args)
+	if err := applyProfile(fs); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(newCodeReference())
+}
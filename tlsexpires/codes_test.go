@@ -0,0 +1,81 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		desc         string
+		status       status
+		find         []finding
+		wantStatus   status
+		wantFindings []finding
+	}{
+		{
+			desc:       "nothing found",
+			status:     statusOK,
+			wantStatus: statusOK,
+		},
+		{
+			desc:         "a warning",
+			status:       statusOK,
+			find:         []finding{findingExpiring},
+			wantStatus:   statusWarning,
+			wantFindings: []finding{findingExpiring},
+		},
+		{
+			desc:         "an error after a warning",
+			status:       statusOK,
+			find:         []finding{findingKeyWeakness, findingRevokedOCSP},
+			wantStatus:   statusError,
+			wantFindings: []finding{findingKeyWeakness, findingRevokedOCSP},
+		},
+		{
+			desc:         "a warning doesn't make an error better",
+			status:       statusOK,
+			find:         []finding{findingPinMismatch, findingExpiring},
+			wantStatus:   statusError,
+			wantFindings: []finding{findingPinMismatch, findingExpiring},
+		},
+		{
+			desc:         "a finding is only recorded once",
+			status:       statusOK,
+			find:         []finding{findingChanged, findingChanged},
+			wantStatus:   statusWarning,
+			wantFindings: []finding{findingChanged},
+		},
+	}
+	for _, test := range tests {
+		v := result{Status: test.status}
+		for _, f := range test.find {
+			v.find(f)
+		}
+		if v.Status != test.wantStatus {
+			t.Errorf("TestFind(%s): got status %s, want %s", test.desc, v.Status, test.wantStatus)
+		}
+		if !slices.Equal(v.Findings, test.wantFindings) {
+			t.Errorf("TestFind(%s): got findings %v, want %v", test.desc, v.Findings, test.wantFindings)
+		}
+	}
+}
+
+// TestFindingsDescribed checks that every finding we can report is described and listed, so
+// the "codes" subcommand covers them all.
+func TestFindingsDescribed(t *testing.T) {
+	for f, info := range findings {
+		if info.Description == "" || info.Field == "" {
+			t.Errorf("TestFindingsDescribed: %s has no description or field", f)
+		}
+		if !slices.ContainsFunc(statuses, func(c codeInfo) bool { return c.Code == string(info.Status) }) {
+			t.Errorf("TestFindingsDescribed: %s has status %q, which isn't one of our statuses", f, info.Status)
+		}
+		if !slices.Contains(findingOrder, f) {
+			t.Errorf("TestFindingsDescribed: %s isn't in findingOrder", f)
+		}
+	}
+	if len(findingOrder) != len(findings) {
+		t.Errorf("TestFindingsDescribed: findingOrder has %d findings, findings has %d", len(findingOrder), len(findings))
+	}
+}
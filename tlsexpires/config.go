@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var configFile = flag.String("config", "", "The path to a YAML (.yaml, .yml) or TOML (.toml) file of targets to check, each with its own SNI, STARTTLS, ALPN, -warn-days, -max-validity-days, client certificate, pin and labels, instead of -file. It can also have issuerRules, the CAs expected to issue the certificates of hosts with certain labels, and profiles, named sets of flags, see -profile. Without targets, the targets come from the other flags")
+
+// targetConfig is a target in -config and the options to check it with. Options that aren't
+// set use the flags.
+//
+// In YAML:
+//
+//	targets:
+//	  - host: mail.example.com:25
+//	    starttls: smtp
+//	    warnDays: 14
+//	    labels: {team: messaging}
+//	  - host: 10.0.0.5:443
+//	    sni: api.example.com
+//	    clientCert: client.pem
+//	    clientKey: client.key
+//	    alpn: [h2, http/1.1]
+type targetConfig struct {
+	// Host is the host:port to connect to. CIDRs and port ranges aren't allowed here.
+	Host string `yaml:"host" toml:"host"`
+	// SNI is the server name we ask for and verify the certificate against. Defaults to the host in Host.
+	SNI string `yaml:"sni" toml:"sni"`
+	// Protocol is what we check, "tls", the default, "ssh" for an OpenSSH host certificate like -ssh,
+	// or any other protocol we have a prober for, see registerProber().
+	Protocol string `yaml:"protocol" toml:"protocol"`
+	// STARTTLS is the plain text protocol to upgrade to TLS, see starttlsProtocols.
+	STARTTLS string `yaml:"starttls" toml:"starttls"`
+	// ALPN are the ALPN protocols to offer, overriding -alpn.
+	ALPN []string `yaml:"alpn" toml:"alpn"`
+	// WarnDays overrides -warn-days.
+	WarnDays *int `yaml:"warnDays" toml:"warnDays"`
+	// MaxValidityDays overrides -max-validity-days.
+	MaxValidityDays *int `yaml:"maxValidityDays" toml:"maxValidityDays"`
+	// ClientCert and ClientKey are the PEM client certificate and key to present, overriding -client-cert.
+	// ClientCert can be in a Windows store like with -client-cert, without a ClientKey.
+	ClientCert string `yaml:"clientCert" toml:"clientCert"`
+	ClientKey  string `yaml:"clientKey" toml:"clientKey"`
+	// Pin is a sha256:<fingerprint> the certificate must match, like -pin-check.
+	Pin string `yaml:"pin" toml:"pin"`
+	// Labels are carried into the results for this target.
+	Labels map[string]string `yaml:"labels" toml:"labels"`
+
+	// clientCert is ClientCert and ClientKey, loaded.
+	clientCert *tls.Certificate
+}
+
+// configFileTargets is the layout of -config.
+type configFileTargets struct {
+	Targets     []*targetConfig    `yaml:"targets" toml:"targets"`
+	Keystores   []*keystoreConfig  `yaml:"keystores" toml:"keystores"`
+	IssuerRules []*issuerRule      `yaml:"issuerRules" toml:"issuerRules"`
+	Profiles    map[string]profile `yaml:"profiles" toml:"profiles"`
+}
+
+var (
+	configOnce sync.Once
+	configErr  error
+	// targetConfigs are the targets in -config, keyed by Host.
+	targetConfigs map[string]*targetConfig
+	// configTargets are the targets in -config in the order they were listed.
+	configTargets []*targetConfig
+	// keystoreConfigs are the keystores in -config, keyed by their absolute Path.
+	keystoreConfigs map[string]*keystoreConfig
+	// issuerRules are the issuer rules in -config, in the order they were listed.
+	issuerRules []*issuerRule
+)
+
+// loadConfig reads -config, if it is set. It only reads the file once, so it is safe to call
+// from everything that needs the options.
+func loadConfig() error {
+	configOnce.Do(func() {
+		if *configFile == "" {
+			return
+		}
+		cf, err := readConfig(*configFile)
+		if err != nil {
+			configErr = fmt.Errorf("-config: %s", err)
+			return
+		}
+		configTargets = cf.Targets
+		targetConfigs = map[string]*targetConfig{}
+		for _, t := range configTargets {
+			targetConfigs[t.Host] = t
+		}
+		keystoreConfigs = map[string]*keystoreConfig{}
+		for _, k := range cf.Keystores {
+			keystoreConfigs[k.Path] = k
+		}
+		issuerRules = cf.IssuerRules
+	})
+	return configErr
+}
+
+// readConfig reads and validates the config file at path.
+func readConfig(path string) (*configFileTargets, error) {
+	cf, err := decodeConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for i, t := range cf.Targets {
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("target %d (%s): %s", i+1, t.Host, err)
+		}
+		if seen[t.Host] {
+			return nil, fmt.Errorf("target %d: %s is listed more than once", i+1, t.Host)
+		}
+		seen[t.Host] = true
+	}
+	for i, k := range cf.Keystores {
+		if err := k.validate(); err != nil {
+			return nil, fmt.Errorf("keystore %d (%s): %s", i+1, k.Path, err)
+		}
+	}
+	for i, r := range cf.IssuerRules {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("issuer rule %d: %s", i+1, err)
+		}
+	}
+	for name, p := range cf.Profiles {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("profile %s: %s", name, err)
+		}
+	}
+	return cf, nil
+}
+
+// decodeConfig reads the config file at path without validating it. The extension of path says
+// if it is YAML or TOML.
+func decodeConfig(path string) (*configFileTargets, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cf := configFileTargets{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cf); err != nil && err != io.EOF {
+			return nil, err
+		}
+	case ".toml":
+		md, err := toml.Decode(string(b), &cf)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown option %q", undecoded[0].String())
+		}
+	default:
+		return nil, fmt.Errorf("%s must end in .yaml, .yml or .toml", path)
+	}
+	return &cf, nil
+}
+
+// protocol returns the protocol t is checked with, which picks its prober. -ssh makes every
+// target ssh.
+func (t *targetConfig) protocol() string {
+	switch {
+	case *sshProbe:
+		return "ssh"
+	case t.Protocol != "":
+		return t.Protocol
+	}
+	return "tls"
+}
+
+// validate checks t's options and loads its client certificate.
+func (t *targetConfig) validate() error {
+	t.Host = strings.TrimSpace(t.Host)
+	host, port, err := net.SplitHostPort(t.Host)
+	if err != nil {
+		return fmt.Errorf("host must be the DNS hostname or IP address + ':' + port")
+	}
+	if strings.Contains(host, "/") || strings.Contains(port, "-") {
+		return fmt.Errorf("CIDRs and port ranges can't have options, list them in -file instead")
+	}
+	if _, ok := probers[t.protocol()]; !ok {
+		return fmt.Errorf("protocol %q is not supported, use one of %s", t.Protocol, strings.Join(proberNames(), ", "))
+	}
+	if t.Protocol != "" && t.Protocol != "tls" && (t.STARTTLS != "" || len(t.ALPN) > 0) {
+		return fmt.Errorf("starttls and alpn can't be used with protocol %s", t.Protocol)
+	}
+	for _, p := range t.ALPN {
+		if p == "" || len(p) > 255 {
+			return fmt.Errorf("alpn protocols must be 1 to 255 bytes, had %q", p)
+		}
+	}
+	if t.STARTTLS != "" {
+		if _, ok := starttlsProtocols[t.STARTTLS]; !ok {
+			return fmt.Errorf("starttls %q is not supported, use one of %s", t.STARTTLS, strings.Join(starttlsNames(), ", "))
+		}
+	}
+	if t.WarnDays != nil && *t.WarnDays < 0 {
+		return fmt.Errorf("warnDays can't be negative")
+	}
+	if t.MaxValidityDays != nil && *t.MaxValidityDays < 0 {
+		return fmt.Errorf("maxValidityDays can't be negative")
+	}
+	if (t.ClientCert == "") != (t.ClientKey == "") && !strings.HasPrefix(t.ClientCert, winStorePrefix) {
+		return fmt.Errorf("clientCert and clientKey must be used together")
+	}
+	if t.ClientCert != "" {
+		cert, err := loadClientCert(t.ClientCert, t.ClientKey)
+		if err != nil {
+			return err
+		}
+		t.clientCert = cert
+	}
+	if t.Pin != "" {
+		if err := pinChecks.Set(t.Host + "=" + t.Pin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// optionsFor returns the options for hostPort from -config. If it has none, the zero
+// targetConfig is returned, whose options all use the flags.
+func optionsFor(hostPort string) *targetConfig {
+	if t, ok := targetConfigs[hostPort]; ok {
+		return t
+	}
+	return &targetConfig{}
+}
+
+// tlsConfig returns the tls.Config to check a host named host with.
+func (t *targetConfig) tlsConfig(host string) *tls.Config {
+	conf := &tls.Config{ServerName: host, RootCAs: rootCAs}
+	if t.SNI != "" {
+		conf.ServerName = t.SNI
+	}
+	if t.clientCert != nil {
+		conf.Certificates = []tls.Certificate{*t.clientCert}
+	}
+	conf.NextProtos = t.alpn()
+	return conf
+}
+
+// warnDays returns the number of days before expiration we warn at.
+func (t *targetConfig) warnDays() int {
+	if t.WarnDays != nil {
+		return *t.WarnDays
+	}
+	return *warnDays
+}
+
+// maxValidityDays returns the most days a certificate may be valid for, 0 for no limit.
+func (t *targetConfig) maxValidityDays() int {
+	if t.MaxValidityDays != nil {
+		return *t.MaxValidityDays
+	}
+	return *maxValidityDays
+}
+
+// configProvider provides the targets in -config.
+type configProvider struct {
+	targets []*targetConfig
+}
+
+// Next implements targetProvider.Next().
+func (c *configProvider) Next(ctx context.Context) (target, error) {
+	if err := ctx.Err(); err != nil {
+		return target{}, err
+	}
+	if len(c.targets) == 0 {
+		return target{}, io.EOF
+	}
+	t := c.targets[0]
+	c.targets = c.targets[1:]
+	return target{HostPort: t.Host}, nil
+}
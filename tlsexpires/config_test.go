@@ -0,0 +1,188 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useConfig writes content to a -config named name and makes its targets the ones optionsFor()
+// finds for the rest of the test.
+func useConfig(t *testing.T, name, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cf, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := targetConfigs
+	targetConfigs = map[string]*targetConfig{}
+	for _, tc := range cf.Targets {
+		targetConfigs[tc.Host] = tc
+	}
+	t.Cleanup(func() { targetConfigs = old })
+}
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		desc    string
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			desc:    "yaml",
+			name:    "config.yaml",
+			content: "targets:\n  - host: mail.example.com:25\n    starttls: smtp\n    warnDays: 14\n",
+		},
+		{
+			desc:    "toml",
+			name:    "config.toml",
+			content: "[[targets]]\nhost = \"mail.example.com:25\"\nstarttls = \"smtp\"\nwarnDays = 14\n",
+		},
+		{
+			desc:    "an option we don't know",
+			name:    "config.yaml",
+			content: "targets:\n  - host: example.com:443\n    warndays: 14\n",
+			wantErr: true,
+		},
+		{
+			desc:    "a host listed twice",
+			name:    "config.yaml",
+			content: "targets:\n  - host: example.com:443\n  - host: example.com:443\n",
+			wantErr: true,
+		},
+		{
+			desc:    "a CIDR",
+			name:    "config.yaml",
+			content: "targets:\n  - host: 10.0.0.0/24:443\n",
+			wantErr: true,
+		},
+		{
+			desc:    "a negative warnDays",
+			name:    "config.yaml",
+			content: "targets:\n  - host: example.com:443\n    warnDays: -1\n",
+			wantErr: true,
+		},
+		{
+			desc:    "starttls we don't support",
+			name:    "config.yaml",
+			content: "targets:\n  - host: example.com:443\n    starttls: gopher\n",
+			wantErr: true,
+		},
+		{
+			desc:    "a file that isn't yaml or toml",
+			name:    "config.json",
+			content: "{}",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), test.name)
+		if err := os.WriteFile(path, []byte(test.content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := readConfig(path)
+		switch {
+		case err == nil && test.wantErr:
+			t.Errorf("TestReadConfig(%s): got err == nil, want err != nil", test.desc)
+		case err != nil && !test.wantErr:
+			t.Errorf("TestReadConfig(%s): got err == %s, want err == nil", test.desc, err)
+		}
+	}
+}
+
+func TestOptionsFor(t *testing.T) {
+	setFlag(t, "warn-days", "30")
+	setFlag(t, "max-validity-days", "398")
+	useConfig(t, "config.yaml", `
+targets:
+  - host: mail.example.com:25
+    warnDays: 14
+    maxValidityDays: 0
+  - host: 10.0.0.5:443
+    sni: api.example.com
+`)
+
+	tests := []struct {
+		hostPort        string
+		host            string
+		wantWarnDays    int
+		wantMaxValidity int
+		wantServerName  string
+	}{
+		{hostPort: "mail.example.com:25", host: "mail.example.com", wantWarnDays: 14, wantMaxValidity: 0, wantServerName: "mail.example.com"},
+		{hostPort: "10.0.0.5:443", host: "10.0.0.5", wantWarnDays: 30, wantMaxValidity: 398, wantServerName: "api.example.com"},
+		{hostPort: "www.example.com:443", host: "www.example.com", wantWarnDays: 30, wantMaxValidity: 398, wantServerName: "www.example.com"},
+	}
+	for _, test := range tests {
+		opts := optionsFor(test.hostPort)
+		if got := opts.warnDays(); got != test.wantWarnDays {
+			t.Errorf("TestOptionsFor(%s): got warnDays %d, want %d", test.hostPort, got, test.wantWarnDays)
+		}
+		if got := opts.maxValidityDays(); got != test.wantMaxValidity {
+			t.Errorf("TestOptionsFor(%s): got maxValidityDays %d, want %d", test.hostPort, got, test.wantMaxValidity)
+		}
+		if got := opts.tlsConfig(test.host).ServerName; got != test.wantServerName {
+			t.Errorf("TestOptionsFor(%s): got server name %s, want %s", test.hostPort, got, test.wantServerName)
+		}
+	}
+}
+
+func TestLabelsFor(t *testing.T) {
+	useConfig(t, "config.yaml", `
+targets:
+  - host: a.example.com:443
+    labels: {team: payments, tier: "1"}
+  - host: b.example.com:443
+`)
+
+	tests := []struct {
+		desc     string
+		hostPort string
+		provided map[string]string
+		want     map[string]string
+	}{
+		{
+			desc:     "only -config",
+			hostPort: "a.example.com:443",
+			want:     map[string]string{"team": "payments", "tier": "1"},
+		},
+		{
+			desc:     "only the provider",
+			hostPort: "b.example.com:443",
+			provided: map[string]string{"arn": "arn:aws:acm:1"},
+			want:     map[string]string{"arn": "arn:aws:acm:1"},
+		},
+		{
+			desc:     "-config wins over the provider",
+			hostPort: "a.example.com:443",
+			provided: map[string]string{"team": "web", "arn": "arn:aws:acm:1"},
+			want:     map[string]string{"team": "payments", "tier": "1", "arn": "arn:aws:acm:1"},
+		},
+		{
+			desc:     "neither",
+			hostPort: "c.example.com:443",
+		},
+	}
+	for _, test := range tests {
+		var provided map[string]string
+		if test.provided != nil {
+			provided = maps.Clone(test.provided)
+		}
+		got := labelsFor(test.hostPort, provided)
+		if !maps.Equal(got, test.want) {
+			t.Errorf("TestLabelsFor(%s): got %v, want %v", test.desc, got, test.want)
+		}
+		if !maps.Equal(provided, test.provided) {
+			t.Errorf("TestLabelsFor(%s): the provider's labels were changed to %v", test.desc, provided)
+		}
+	}
+	if got := optionsFor("a.example.com:443").Labels; !maps.Equal(got, map[string]string{"team": "payments", "tier": "1"}) {
+		t.Errorf("TestLabelsFor: the labels in -config were changed to %v", got)
+	}
+}
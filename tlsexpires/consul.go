@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	consulAddr = flag.String("consul", "", "Check the services in the Consul catalog at this address, like http://127.0.0.1:8500, instead of -file. Only services with -consul-tag are checked, at their registered addresses. With -listen, every scan gets the catalog again. The ACL token is read from CONSUL_HTTP_TOKEN")
+	consulTag  = flag.String("consul-tag", "https", "The tag of the Consul services -consul checks")
+	consulDC   = flag.String("consul-dc", "", "The Consul datacenter to look in with -consul. Defaults to the agent's")
+)
+
+// consulClient is used to query -consul.
+var consulClient = &http.Client{Timeout: time.Minute}
+
+// consulProvider provides a target for every instance of the services in a Consul catalog that
+// have our tag. Like k8sProvider, it asks for one service at a time and only provides an address
+// once.
+type consulProvider struct {
+	addr, tag, dc string
+	services      []string
+	pending       []target
+	seen          map[string]bool
+}
+
+// newConsulProvider returns a consulProvider for the catalog at addr, listing the services that
+// have tag in the datacenter dc, or the agent's if it is empty.
+func newConsulProvider(ctx context.Context, addr, tag, dc string) (*consulProvider, error) {
+	c := &consulProvider{addr: strings.TrimSuffix(addr, "/"), tag: tag, dc: dc, seen: map[string]bool{}}
+	services := map[string][]string{}
+	if err := c.get(ctx, "/v1/catalog/services", &services); err != nil {
+		return nil, err
+	}
+	for name, tags := range services {
+		if slices.Contains(tags, tag) {
+			c.services = append(c.services, name)
+		}
+	}
+	sort.Strings(c.services)
+	return c, nil
+}
+
+// get fetches path from the Consul HTTP API and decodes the json response into out.
+func (c *consulProvider) get(ctx context.Context, path string, out any) error {
+	u, err := url.Parse(c.addr + path)
+	if err != nil {
+		return fmt.Errorf("-consul=%s is not a URL: %s", c.addr, err)
+	}
+	if c.dc != "" {
+		q := u.Query()
+		q.Set("dc", c.dc)
+		u.RawQuery = q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	resp, err := consulClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("-consul: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("-consul: GET %s returned %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("-consul: could not decode GET %s: %s", path, err)
+	}
+	return nil
+}
+
+// Next implements targetProvider.Next().
+func (c *consulProvider) Next(ctx context.Context) (target, error) {
+	for {
+		for len(c.pending) > 0 {
+			t := c.pending[0]
+			c.pending = c.pending[1:]
+			if c.seen[t.HostPort] {
+				continue
+			}
+			c.seen[t.HostPort] = true
+			return t, nil
+		}
+		if len(c.services) == 0 {
+			return target{}, io.EOF
+		}
+		name := c.services[0]
+		c.services = c.services[1:]
+
+		var instances []struct {
+			Node           string
+			Address        string
+			Datacenter     string
+			ServiceAddress string
+			ServicePort    int
+		}
+		path := "/v1/catalog/service/" + url.PathEscape(name) + "?tag=" + url.QueryEscape(c.tag)
+		if err := c.get(ctx, path, &instances); err != nil {
+			return target{}, err
+		}
+		for _, in := range instances {
+			// Services registered without an address are at the address of their node.
+			host := in.ServiceAddress
+			if host == "" {
+				host = in.Address
+			}
+			c.pending = append(c.pending, target{
+				HostPort: net.JoinHostPort(host, strconv.Itoa(in.ServicePort)),
+				Labels:   map[string]string{"consul_service": name, "consul_node": in.Node, "consul_dc": in.Datacenter},
+			})
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	checkCRL    = flag.Bool("crl", false, "Check the CRL distribution points of every certificate in the chain and report certificates that were revoked")
+	crlCacheDir = flag.String("crl-cache-dir", "", "A directory to cache downloaded CRLs in between runs. CRLs are used until their NextUpdate time")
+)
+
+// crlClient is used to download CRLs. CRLs can be many megabytes, so this has a longer timeout than ocspClient.
+var crlClient = &http.Client{Timeout: 30 * time.Second, Transport: countedTransport(lookupCRL)}
+
+// crlResult is the result of checking one certificate against one of its CRLs.
+type crlResult struct {
+	// Cert is the certificate we checked.
+	Cert *x509.Certificate
+	// URL is the CRL distribution point we checked.
+	URL string
+	// Revoked is true if Cert is on the CRL.
+	Revoked bool
+	// RevokedAt is when Cert was revoked.
+	RevokedAt time.Time
+	// Err is set if we couldn't check the CRL.
+	Err error
+}
+
+// crlEntry is a CRL we've downloaded, or are downloading, in this process.
+type crlEntry struct {
+	// ready is closed once crl and err are set.
+	ready chan struct{}
+	crl   *x509.RevocationList
+	err   error
+}
+
+// stale reports if e was downloaded and shouldn't be used anymore, because downloading it failed
+// or it is past its NextUpdate time. A CRL without a NextUpdate, which RFC 5280 requires, is
+// always stale. Like -crl-cache-dir, this goes by the real time, not -now, as it is about when
+// the CRL was published.
+func (e *crlEntry) stale() bool {
+	select {
+	case <-e.ready:
+	default:
+		return false
+	}
+	return e.err != nil || !time.Now().Before(e.crl.NextUpdate)
+}
+
+// crls caches CRLs in memory until their NextUpdate time. Many hosts share the same issuer,
+// so without this a scan would download the same CRL over and over. Failed downloads aren't
+// cached, so with -listen a CRL that couldn't be downloaded is tried again by the next check.
+var crls = struct {
+	mu      sync.Mutex
+	entries map[string]*crlEntry
+}{entries: map[string]*crlEntry{}}
+
+// getCRL returns the CRL at url. It uses our in memory cache, then the -crl-cache-dir cache and
+// finally downloads it. Checks that want the same CRL while it is downloading wait for it.
+func getCRL(url string) (*x509.RevocationList, error) {
+	crls.mu.Lock()
+	e, ok := crls.entries[url]
+	if !ok || e.stale() {
+		e = &crlEntry{ready: make(chan struct{})}
+		crls.entries[url] = e
+		ok = false
+	}
+	crls.mu.Unlock()
+
+	if !ok {
+		e.crl, e.err = loadCRL(url)
+		close(e.ready)
+	}
+	<-e.ready
+	return e.crl, e.err
+}
+
+// crlCachePath returns the path in -crl-cache-dir for the CRL at url.
+func crlCachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(*crlCacheDir, hex.EncodeToString(sum[:])+".crl")
+}
+
+// loadCRL gets the CRL at url from -crl-cache-dir if it is still fresh, otherwise it downloads it.
+func loadCRL(url string) (*x509.RevocationList, error) {
+	if *crlCacheDir != "" {
+		if b, err := os.ReadFile(crlCachePath(url)); err == nil {
+			crl, err := x509.ParseRevocationList(b)
+			if err == nil && time.Now().Before(crl.NextUpdate) {
+				return crl, nil
+			}
+		}
+	}
+
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("CRL distribution point %q is not http, which we don't support", url)
+	}
+	resp, err := crlClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CRL distribution point %q returned %s", url, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 100<<20))
+	if err != nil {
+		return nil, err
+	}
+	crl, err := x509.ParseRevocationList(b)
+	if err != nil {
+		return nil, fmt.Errorf("CRL at %q could not be parsed: %s", url, err)
+	}
+
+	if *crlCacheDir != "" {
+		if err := os.MkdirAll(*crlCacheDir, 0o700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(crlCachePath(url), b, 0o600); err != nil {
+			return nil, err
+		}
+	}
+	return crl, nil
+}
+
+// checkCRLs checks every certificate in chain against its CRLs. chain must be in order, with
+// each certificate followed by the one that issued it. The last certificate in chain is only
+// used as an issuer, because nothing publishes a CRL for a root.
+func checkCRLs(chain []*x509.Certificate) []crlResult {
+	var results []crlResult
+	for i := 0; i < len(chain)-1; i++ {
+		cert, issuer := chain[i], chain[i+1]
+		for _, url := range cert.CRLDistributionPoints {
+			r := crlResult{Cert: cert, URL: url}
+			crl, err := getCRL(url)
+			if err != nil {
+				r.Err = err
+				results = append(results, r)
+				continue
+			}
+			if err := crl.CheckSignatureFrom(issuer); err != nil {
+				r.Err = fmt.Errorf("CRL at %q is not signed by %s: %s", url, issuer.Subject, err)
+				results = append(results, r)
+				continue
+			}
+			for _, rc := range crl.RevokedCertificateEntries {
+				if rc.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					r.Revoked = true
+					r.RevokedAt = rc.RevocationTime
+					break
+				}
+			}
+			results = append(results, r)
+		}
+	}
+	return results
+}
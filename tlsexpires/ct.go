@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	checkCT   = flag.Bool("ct", false, "Verify the Signed Certificate Timestamps embedded in the certificate or sent in the handshake against the logs in -ct-log-list")
+	ctLogList = flag.String("ct-log-list", "https://www.gstatic.com/ct/log_list/v3/log_list.json", "The URL or file path of a Certificate Transparency log list in Google's v3 format")
+	ctQuery   = flag.Bool("ct-query", false, "With -ct, also ask crt.sh if the certificate has been logged")
+)
+
+// ctClient is used for talking to log lists and crt.sh.
+var ctClient = &http.Client{Timeout: 30 * time.Second, Transport: countedTransport(lookupCT)}
+
+// ctLog is a Certificate Transparency log we know the key of.
+type ctLog struct {
+	// Description is the log's human readable name.
+	Description string
+	// Key is the log's public key, which signs its SCTs.
+	Key crypto.PublicKey
+}
+
+// logListJSON is the part of Google's v3 log list (https://www.gstatic.com/ct/log_list/v3/log_list_schema.json) we need.
+type logListJSON struct {
+	Operators []struct {
+		Logs      []logJSON `json:"logs"`
+		TiledLogs []logJSON `json:"tiled_logs"`
+	} `json:"operators"`
+}
+
+// logJSON is a single log in a logListJSON.
+type logJSON struct {
+	Description string `json:"description"`
+	LogID       string `json:"log_id"`
+	Key         string `json:"key"`
+}
+
+// ctLogs holds the logs from -ct-log-list, keyed by log ID. It is loaded once per process.
+var ctLogs struct {
+	mu   sync.Mutex
+	logs map[[32]byte]ctLog
+}
+
+// loadCTLogs returns the logs in -ct-log-list keyed by log ID. If they can't be loaded, the next
+// check tries again, so with -listen a list that was briefly unavailable doesn't fail every check.
+func loadCTLogs() (map[[32]byte]ctLog, error) {
+	ctLogs.mu.Lock()
+	defer ctLogs.mu.Unlock()
+	if ctLogs.logs != nil {
+		return ctLogs.logs, nil
+	}
+	logs, err := readCTLogs(*ctLogList)
+	if err != nil {
+		return nil, err
+	}
+	ctLogs.logs = logs
+	return logs, nil
+}
+
+// readCTLogs reads the log list at loc, which is a URL or a file path.
+func readCTLogs(loc string) (map[[32]byte]ctLog, error) {
+	var b []byte
+	if strings.HasPrefix(loc, "http://") || strings.HasPrefix(loc, "https://") {
+		resp, err := ctClient.Get(loc)
+		if err != nil {
+			return nil, fmt.Errorf("could not get CT log list: %s", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("could not get CT log list: %s returned %s", loc, resp.Status)
+		}
+		b, err = io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+		if err != nil {
+			return nil, fmt.Errorf("could not get CT log list: %s", err)
+		}
+	} else {
+		var err error
+		b, err = os.ReadFile(loc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	list := logListJSON{}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("CT log list %s is not valid: %s", loc, err)
+	}
+
+	logs := map[[32]byte]ctLog{}
+	for _, op := range list.Operators {
+		for _, l := range append(op.Logs, op.TiledLogs...) {
+			id, err := base64.StdEncoding.DecodeString(l.LogID)
+			if err != nil || len(id) != 32 {
+				continue
+			}
+			der, err := base64.StdEncoding.DecodeString(l.Key)
+			if err != nil {
+				continue
+			}
+			key, err := x509.ParsePKIXPublicKey(der)
+			if err != nil {
+				continue
+			}
+			var logID [32]byte
+			copy(logID[:], id)
+			logs[logID] = ctLog{Description: l.Description, Key: key}
+		}
+	}
+	return logs, nil
+}
+
+// ctResult is the result of verifying a single SCT.
+type ctResult struct {
+	// SCT is the SCT that was verified.
+	SCT sct
+	// Log is the description of the log that issued the SCT. Empty if the log isn't in our list.
+	Log string
+	// Err is set if the SCT didn't verify.
+	Err error
+}
+
+// verifySCTs verifies every SCT for leaf, which was issued by issuer. An error is only returned if
+// we couldn't load the log list.
+func verifySCTs(leaf, issuer *x509.Certificate, handshake [][]byte) ([]ctResult, error) {
+	logs, err := loadCTLogs()
+	if err != nil {
+		return nil, err
+	}
+
+	var scts []sct
+	embedded, err := embeddedSCTs(leaf)
+	if err != nil {
+		return nil, err
+	}
+	scts = append(scts, embedded...)
+	sent, err := handshakeSCTs(handshake)
+	if err != nil {
+		return nil, err
+	}
+	scts = append(scts, sent...)
+
+	var results []ctResult
+	for _, s := range scts {
+		r := ctResult{SCT: s}
+		l, ok := logs[s.LogID]
+		switch {
+		case !ok:
+			r.Err = fmt.Errorf("SCT is from log %s, which isn't in the log list", hex.EncodeToString(s.LogID[:]))
+		case s.Source == "embedded" && issuer == nil:
+			r.Log = l.Description
+			r.Err = fmt.Errorf("can't verify an embedded SCT without the issuing certificate")
+		default:
+			r.Log = l.Description
+			r.Err = verifySCT(s, leaf, issuer, l.Key)
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// verifySCT verifies the signature on s with the log's key. See RFC 6962 section 3.2.
+func verifySCT(s sct, leaf, issuer *x509.Certificate, key crypto.PublicKey) error {
+	if s.Version != 0 {
+		return fmt.Errorf("SCT version %d is not supported", s.Version)
+	}
+
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint8(s.Version)
+	b.AddUint8(0) // signature_type: certificate_timestamp
+	b.AddUint64(uint64(s.Timestamp.UnixMilli()))
+	if s.Source == "embedded" {
+		// An embedded SCT signs the precertificate, which is the TBSCertificate without the SCTs
+		// plus a hash of the issuer's key.
+		tbs, err := precertTBS(leaf)
+		if err != nil {
+			return err
+		}
+		b.AddUint16(1) // entry_type: precert_entry
+		keyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+		b.AddBytes(keyHash[:])
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(tbs) })
+	} else {
+		b.AddUint16(0) // entry_type: x509_entry
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(leaf.Raw) })
+	}
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(s.Extensions) })
+	signed, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+
+	// 4 is sha256, the only hash RFC 6962 allows.
+	if s.HashAlg != 4 {
+		return fmt.Errorf("SCT uses hash algorithm %d, which isn't sha256", s.HashAlg)
+	}
+	digest := sha256.Sum256(signed)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest[:], s.Signature) {
+			return fmt.Errorf("SCT signature is not valid")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], s.Signature); err != nil {
+			return fmt.Errorf("SCT signature is not valid: %s", err)
+		}
+	default:
+		return fmt.Errorf("log key type %T is not supported", key)
+	}
+	return nil
+}
+
+// precertTBS returns cert's TBSCertificate with the SCT list extension removed, which is what a
+// log signed when it issued the SCTs embedded in cert.
+func precertTBS(cert *x509.Certificate) ([]byte, error) {
+	in := cryptobyte.String(cert.RawTBSCertificate)
+	var tbs cryptobyte.String
+	if !in.ReadASN1(&tbs, cbasn1.SEQUENCE) {
+		return nil, fmt.Errorf("malformed TBSCertificate")
+	}
+
+	extsTag := cbasn1.Tag(3).Constructed().ContextSpecific()
+	b := cryptobyte.NewBuilder(nil)
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		for !tbs.Empty() {
+			var elem cryptobyte.String
+			var tag cbasn1.Tag
+			if !tbs.ReadAnyASN1Element(&elem, &tag) {
+				b.SetError(fmt.Errorf("malformed TBSCertificate"))
+				return
+			}
+			if tag != extsTag {
+				b.AddBytes(elem)
+				continue
+			}
+
+			var wrapper, exts cryptobyte.String
+			if !elem.ReadASN1(&wrapper, extsTag) || !wrapper.ReadASN1(&exts, cbasn1.SEQUENCE) {
+				b.SetError(fmt.Errorf("malformed TBSCertificate extensions"))
+				return
+			}
+			b.AddASN1(extsTag, func(b *cryptobyte.Builder) {
+				b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					for !exts.Empty() {
+						var ext cryptobyte.String
+						if !exts.ReadASN1Element(&ext, cbasn1.SEQUENCE) {
+							b.SetError(fmt.Errorf("malformed extension"))
+							return
+						}
+						body := ext
+						var inner cryptobyte.String
+						var oid asn1.ObjectIdentifier
+						if !body.ReadASN1(&inner, cbasn1.SEQUENCE) || !inner.ReadASN1ObjectIdentifier(&oid) {
+							b.SetError(fmt.Errorf("malformed extension"))
+							return
+						}
+						if oid.Equal(oidSCTList) {
+							continue
+						}
+						b.AddBytes(ext)
+					}
+				})
+			})
+		}
+	})
+	return b.Bytes()
+}
+
+// crtshLogged asks crt.sh if cert has been logged to any of the CT logs it monitors.
+func crtshLogged(cert *x509.Certificate) (bool, error) {
+	sum := sha256.Sum256(cert.Raw)
+	resp, err := ctClient.Get("https://crt.sh/?output=json&q=" + hex.EncodeToString(sum[:]))
+	if err != nil {
+		return false, fmt.Errorf("could not query crt.sh: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("crt.sh returned %s", resp.Status)
+	}
+	var entries []json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return false, fmt.Errorf("crt.sh returned something that wasn't json: %s", err)
+	}
+	return len(entries) > 0, nil
+}
+
+// addCT verifies the SCTs for the connection and records the results in v.
+func addCT(v *result, cs tls.ConnectionState) {
+	chain := cs.VerifiedChains[0]
+	leaf := chain[0]
+	var issuer *x509.Certificate
+	if len(chain) > 1 {
+		issuer = chain[1]
+	}
+
+	results, err := verifySCTs(leaf, issuer, cs.SignedCertificateTimestamps)
+	integrationUsed(integrationCT, err)
+	if err != nil {
+		v.CTError = err.Error()
+	} else {
+		for _, r := range results {
+			switch {
+			case r.Err == nil:
+				v.ValidSCTs++
+			case v.CTError == "":
+				v.CTError = r.Err.Error()
+			}
+		}
+		if v.ValidSCTs == 0 {
+			if v.Status != statusError {
+				v.Err = "certificate has no valid SCTs, so clients that enforce Certificate Transparency will reject it"
+			}
+			v.find(findingNoValidSCTs)
+		}
+	}
+
+	if *ctQuery {
+		logged, err := crtshLogged(leaf)
+		integrationUsed(integrationCrtSh, err)
+		if err != nil {
+			if v.CTError == "" {
+				v.CTError = err.Error()
+			}
+			return
+		}
+		v.CTLogged = &logged
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var checkDANE = flag.Bool("check-dane", false, "Also look up the DANE TLSA records of each server, _port._tcp.host, and check the certificates it presents match them. A server that matches none of them gets an error, since DANE clients, like mail servers delivering to it, refuse to connect, and TLSA records that aren't signed with DNSSEC get a warning, since DANE clients ignore them. We trust the resolver to validate DNSSEC, so use one you trust, like a -resolver over tls:// or https://")
+
+// typeTLSA is the DNS type of TLSA records, which dnsmessage doesn't have.
+const typeTLSA dnsmessage.Type = 52
+
+// tlsaRecord is a DANE TLSA record, see RFC 6698.
+type tlsaRecord struct {
+	// Usage is what the record pins: 0 (PKIX-TA) a CA, 1 (PKIX-EE) the leaf, both of which must
+	// also verify against the usual roots, 2 (DANE-TA) a CA and 3 (DANE-EE) the leaf.
+	Usage uint8 `json:"usage"`
+	// Selector is what of the certificate is matched, 0 the whole certificate and 1 its public key.
+	Selector uint8 `json:"selector"`
+	// MatchingType is how it is matched, 0 exactly, 1 by its SHA-256 and 2 by its SHA-512.
+	MatchingType uint8 `json:"matchingType"`
+	// Data is what is matched against, hex encoded.
+	Data string `json:"data"`
+	// Matched is if a certificate the server presented matches it.
+	Matched bool `json:"matched"`
+
+	data []byte
+}
+
+// String returns r in the presentation format of a zone file, like "3 1 1 8f...".
+func (r tlsaRecord) String() string {
+	return fmt.Sprintf("%d %d %d %s", r.Usage, r.Selector, r.MatchingType, r.Data)
+}
+
+// daneResult is what we found checking a server against its TLSA records.
+type daneResult struct {
+	// Name is the name the TLSA records are at, like _25._tcp.mail.example.com.
+	Name string `json:"name"`
+	// Records are the TLSA records. A server without any doesn't use DANE.
+	Records []tlsaRecord `json:"records,omitempty"`
+	// Secure is if the resolver validated the records with DNSSEC.
+	Secure bool `json:"secure"`
+	// Matched is if the server presented a certificate that matches one of the records.
+	Matched bool `json:"matched"`
+	// Error is why we couldn't look the records up.
+	Error string `json:"error,omitempty"`
+}
+
+var (
+	daneExchangeOnce sync.Once
+	daneExchange     dnsExchange
+	daneExchangeErr  error
+)
+
+// tlsaExchange returns the dnsExchange we look TLSA records up with, -resolver or the nameservers
+// in /etc/resolv.conf. The system resolver can't look up TLSA records.
+func tlsaExchange() (dnsExchange, error) {
+	daneExchangeOnce.Do(func() {
+		if *resolverFlag != "" {
+			daneExchange, daneExchangeErr = resolverExchange(*resolverFlag)
+			return
+		}
+		servers := resolvConfServers("/etc/resolv.conf")
+		if len(servers) == 0 {
+			daneExchangeErr = errors.New("-check-dane needs -resolver, /etc/resolv.conf has no nameservers")
+			return
+		}
+		daneExchange = udpExchange(servers)
+	})
+	return daneExchange, daneExchangeErr
+}
+
+// checkDANERecords looks up the TLSA records of host and port and checks them against chain, the
+// certificates the server presented with the leaf first, and verified, the chain we verified it
+// with, which ends at a root. verified is nil if chain didn't verify, then only DANE-TA and
+// DANE-EE records can match. It returns nil for hosts that are IP addresses, which can't have
+// TLSA records.
+func checkDANERecords(ctx context.Context, host, port string, chain, verified []*x509.Certificate) *daneResult {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	d := &daneResult{Name: fmt.Sprintf("_%s._tcp.%s", port, strings.TrimSuffix(host, "."))}
+	records, secure, err := lookupTLSA(ctx, d.Name)
+	integrationUsed(integrationDANE, err)
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	d.Secure = secure
+
+	for _, r := range records {
+		var candidates []*x509.Certificate
+		switch {
+		case r.Usage == 0 && verified != nil:
+			candidates = verified[1:]
+		case r.Usage == 1 && verified != nil, r.Usage == 3:
+			candidates = chain[:1]
+		case r.Usage == 2:
+			candidates = chain[1:]
+			if verified != nil {
+				candidates = append(candidates[:len(candidates):len(candidates)], verified[1:]...)
+			}
+		}
+		for _, c := range candidates {
+			if tlsaMatches(r, c) {
+				r.Matched = true
+				d.Matched = true
+				break
+			}
+		}
+		d.Records = append(d.Records, r)
+	}
+	return d
+}
+
+// daneOnlyChain returns the chain of err, from a handshake with host and port, if the handshake
+// failed because the chain doesn't verify against our roots, but it matches a DANE-TA or DANE-EE
+// TLSA record signed with DNSSEC, which is all DANE clients need. Otherwise it returns nil. This
+// is how mail servers with self-signed certificates and DANE are checked.
+func daneOnlyChain(ctx context.Context, err error, host, port string) ([]*x509.Certificate, *daneResult) {
+	var verr *tls.CertificateVerificationError
+	if !errors.As(err, &verr) || len(verr.UnverifiedCertificates) == 0 {
+		return nil, nil
+	}
+	chain := verr.UnverifiedCertificates
+	d := checkDANERecords(ctx, host, port, chain, nil)
+	if d == nil || !d.Matched || !d.Secure {
+		return nil, nil
+	}
+	return chain, d
+}
+
+// lookupTLSA returns the TLSA records at name, and if the resolver validated them with DNSSEC.
+// A name without any isn't an error.
+func lookupTLSA(ctx context.Context, name string) ([]tlsaRecord, bool, error) {
+	exchange, err := tlsaExchange()
+	if err != nil {
+		return nil, false, err
+	}
+	countLookup(lookupDNS)
+	resp, err := exchangeQuery(ctx, exchange, name, typeTLSA, true)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	var records []tlsaRecord
+	for _, a := range resp.Answers {
+		u, ok := a.Body.(*dnsmessage.UnknownResource)
+		if !ok || a.Header.Type != typeTLSA {
+			continue
+		}
+		if len(u.Data) < 4 {
+			return nil, false, fmt.Errorf("TLSA record at %s is malformed", name)
+		}
+		records = append(records, tlsaRecord{
+			Usage:        u.Data[0],
+			Selector:     u.Data[1],
+			MatchingType: u.Data[2],
+			Data:         hex.EncodeToString(u.Data[3:]),
+			data:         u.Data[3:],
+		})
+	}
+	return records, resp.AuthenticData, nil
+}
+
+// tlsaMatches reports if cert matches r, for the Selector and MatchingType of r. Its Usage is up
+// to the caller.
+func tlsaMatches(r tlsaRecord, cert *x509.Certificate) bool {
+	var b []byte
+	switch r.Selector {
+	case 0:
+		b = cert.Raw
+	case 1:
+		b = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+	switch r.MatchingType {
+	case 0:
+	case 1:
+		sum := sha256.Sum256(b)
+		b = sum[:]
+	case 2:
+		sum := sha512.Sum512(b)
+		b = sum[:]
+	default:
+		return false
+	}
+	return bytes.Equal(b, r.data)
+}
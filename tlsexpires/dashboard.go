@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// dashboardScans is how many scans the dashboard keeps the days remaining of, for its trends.
+const dashboardScans = 90
+
+// trendPoint is how many days a host's certificate had left at a scan.
+type trendPoint struct {
+	Scan          time.Time `json:"scan"`
+	DaysRemaining int       `json:"daysRemaining"`
+}
+
+// dashboardStatus is what /api/status returns.
+type dashboardStatus struct {
+	// Scanned is if a scan has finished. The other fields about the last scan are only set if it has.
+	Scanned bool `json:"scanned"`
+	// LastScanID is the ID of the last scan that finished.
+	LastScanID string `json:"lastScanId,omitempty"`
+	// LastScan is when the last scan that finished started.
+	LastScan time.Time `json:"lastScan,omitzero"`
+	// DurationSeconds is how long the last scan that finished took.
+	DurationSeconds float64 `json:"durationSeconds,omitempty"`
+	// Interval is how often we scan, like "1h0m0s".
+	Interval string `json:"interval"`
+	// Scans and Failures are the scans started since we started, and how many couldn't read their targets.
+	Scans    int `json:"scans"`
+	Failures int `json:"failures"`
+	// Summary is the summary of the last scan that finished.
+	Summary *summary `json:"summary,omitempty"`
+}
+
+// dashboardResult is a result of the last scan with its trend, for /api/results.
+type dashboardResult struct {
+	result
+	// Trend is the days remaining at each of the last dashboardScans scans the host succeeded
+	// in, oldest first.
+	Trend []trendPoint `json:"trend,omitempty"`
+}
+
+// recordTrends adds the days remaining of each result in r to e.trends. Hosts that aren't in r
+// any more are forgotten. e.mu must be held.
+func (e *exporter) recordTrends(r run) {
+	trends := map[string][]trendPoint{}
+	for _, v := range r.Results {
+		key := v.resultKey()
+		t := e.trends[key]
+		if v.Status != statusError {
+			t = append(t, trendPoint{Scan: r.Started, DaysRemaining: v.ExpireInDays()})
+			if len(t) > dashboardScans {
+				t = t[len(t)-dashboardScans:]
+			}
+		}
+		trends[key] = t
+	}
+	e.trends = trends
+}
+
+// registerDashboard adds the dashboard at /ui and the json API behind it to mux.
+func (e *exporter) registerDashboard(mux *http.ServeMux) {
+	mux.HandleFunc("/ui", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		w.Write([]byte(dashboardHTML))
+	})
+	mux.HandleFunc("/api/status", e.serveStatus)
+	mux.HandleFunc("/api/results", e.serveResults)
+}
+
+// serveStatus serves the dashboardStatus.
+func (e *exporter) serveStatus(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	s := dashboardStatus{Scanned: e.scanned, Interval: interval.String(), Scans: e.scans, Failures: e.failures}
+	if e.scanned {
+		s.LastScanID, s.LastScan, s.DurationSeconds, s.Summary = e.last.ID, e.last.Started, e.duration.Seconds(), e.last.Summary
+	}
+	e.mu.Unlock()
+	writeJSON(w, s)
+}
+
+// serveResults serves the results of the last scan with their trends, failures first and then
+// the soonest to expire. ?status=error, warning or ok only returns results with that status.
+func (e *exporter) serveResults(w http.ResponseWriter, r *http.Request) {
+	want := status(r.URL.Query().Get("status"))
+	e.mu.Lock()
+	results := []dashboardResult{}
+	for _, v := range e.last.Results {
+		if want != "" && v.Status != want {
+			continue
+		}
+		results = append(results, dashboardResult{result: v, Trend: e.trends[v.resultKey()]})
+	}
+	e.mu.Unlock()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if (a.Status == statusError) != (b.Status == statusError) {
+			return a.Status == statusError
+		}
+		return a.ExpiresOn.Before(b.ExpiresOn)
+	})
+	writeJSON(w, results)
+}
+
+// writeJSON writes v to w as json.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// dashboardHTML is the page at /ui. It gets everything it shows from /api/status and
+// /api/results, and refreshes every minute.
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>tlsexpires</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #ddd; vertical-align: top; }
+th { background: #f4f4f4; }
+.error { background: #fdd; }
+.warning { background: #ffc; }
+.stats span { display: inline-block; margin-right: 2em; }
+.muted { color: #777; }
+svg { display: block; }
+</style>
+</head>
+<body>
+<h1>tlsexpires</h1>
+<p id="scan" class="muted">Waiting for the first scan to finish.</p>
+<p class="stats" id="stats"></p>
+<h2>Failing hosts</h2>
+<table><thead><tr><th>Host</th><th>Address</th><th>Findings</th><th>Error</th></tr></thead><tbody id="failing"></tbody></table>
+<h2>Certificates</h2>
+<table><thead><tr><th>Host</th><th>Address</th><th>Status</th><th>Days left</th><th>Expires on</th><th>Issuer</th><th>Trend</th></tr></thead><tbody id="certs"></tbody></table>
+<script>
+function cell(row, text) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  row.appendChild(td);
+  return td;
+}
+
+// sparkline draws the days remaining at each scan.
+function sparkline(trend) {
+  const ns = "http://www.w3.org/2000/svg";
+  const svg = document.createElementNS(ns, "svg");
+  svg.setAttribute("width", 120);
+  svg.setAttribute("height", 24);
+  if (!trend || trend.length < 2) {
+    return svg;
+  }
+  const days = trend.map(p => p.daysRemaining);
+  const max = Math.max(...days), min = Math.min(...days);
+  const points = days.map((d, i) => {
+    const x = i * 118 / (days.length - 1) + 1;
+    const y = max == min ? 12 : 22 - (d - min) * 20 / (max - min);
+    return x.toFixed(1) + "," + y.toFixed(1);
+  });
+  const line = document.createElementNS(ns, "polyline");
+  line.setAttribute("points", points.join(" "));
+  line.setAttribute("fill", "none");
+  line.setAttribute("stroke", "#36c");
+  svg.appendChild(line);
+  const title = document.createElementNS(ns, "title");
+  title.textContent = days[0] + " to " + days[days.length - 1] + " days over " + days.length + " scans";
+  svg.appendChild(title);
+  return svg;
+}
+
+function daysLeft(expiresOn) {
+  return Math.max(0, Math.floor((new Date(expiresOn) - Date.now()) / 86400000));
+}
+
+async function refresh() {
+  const status = await (await fetch("/api/status")).json();
+  if (!status.scanned) {
+    return;
+  }
+  document.getElementById("scan").textContent = "Last scan " + status.lastScanId + " started " +
+    new Date(status.lastScan).toLocaleString() + " and took " + status.durationSeconds.toFixed(1) +
+    "s. We scan every " + status.interval + ".";
+  const s = status.summary;
+  const stats = document.getElementById("stats");
+  stats.replaceChildren();
+  for (const [name, n] of [["Hosts", s.total], ["Succeeded", s.succeeded], ["Failed", s.failed],
+      ["Within 7 days", s.within7Days], ["Within 30 days", s.within30Days], ["Within 90 days", s.within90Days]]) {
+    const span = document.createElement("span");
+    span.textContent = name + ": " + n;
+    stats.appendChild(span);
+  }
+
+  const results = await (await fetch("/api/results")).json();
+  const failing = document.getElementById("failing");
+  const certs = document.getElementById("certs");
+  failing.replaceChildren();
+  certs.replaceChildren();
+  for (const r of results) {
+    const row = document.createElement("tr");
+    row.className = r.status;
+    cell(row, r.hostPort);
+    cell(row, r.address || "");
+    if (r.status == "error") {
+      cell(row, (r.findings || []).join(", "));
+      cell(row, r.error || "");
+      failing.appendChild(row);
+      continue;
+    }
+    cell(row, r.status);
+    cell(row, daysLeft(r.expiresOn));
+    cell(row, new Date(r.expiresOn).toLocaleDateString());
+    cell(row, r.issuer || "");
+    cell(row, "").appendChild(sparkline(r.trend));
+    certs.appendChild(row);
+  }
+  if (!failing.children.length) {
+    const row = document.createElement("tr");
+    cell(row, "None").className = "muted";
+    failing.appendChild(row);
+  }
+}
+
+refresh();
+setInterval(refresh, 60000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// certChange is a host that served a different certificate than in the older run.
+type certChange struct {
+	// Was is the result for the host in the older run.
+	Was result `json:"was"`
+	// Is is the result for the host in the newer run.
+	Is result `json:"is"`
+}
+
+// ExpiryMoved is how far the expiry moved, like "+90d" for a renewal. It is empty if it didn't.
+func (c certChange) ExpiryMoved() string {
+	d := c.Is.ExpiresOn.Sub(c.Was.ExpiresOn)
+	if d == 0 {
+		return ""
+	}
+	days := int(d.Hours() / 24)
+	if days >= 0 {
+		return fmt.Sprintf("+%dd", days)
+	}
+	return fmt.Sprintf("%dd", days)
+}
+
+// runDiff is what changed between two runs, which is what people review each morning.
+type runDiff struct {
+	// From and To are the older and newer runs' IDs, which runs from before we had IDs don't have.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// FromStarted and ToStarted are when the runs started.
+	FromStarted time.Time `json:"fromStarted"`
+	ToStarted   time.Time `json:"toStarted"`
+	// Added are the hosts only in the newer run.
+	Added []result `json:"added,omitempty"`
+	// Removed are the hosts only in the older run.
+	Removed []result `json:"removed,omitempty"`
+	// NewCerts are the hosts that serve a different certificate, like after a renewal.
+	NewCerts []certChange `json:"newCerts,omitempty"`
+	// StartedFailing are the hosts we could check in the older run but not the newer one.
+	StartedFailing []result `json:"startedFailing,omitempty"`
+	// Recovered are the hosts we couldn't check in the older run but could in the newer one.
+	Recovered []result `json:"recovered,omitempty"`
+	// NewWarnings are the hosts that were ok and now have a warning, like a certificate that
+	// is now within -warn-days of expiring.
+	NewWarnings []result `json:"newWarnings,omitempty"`
+}
+
+// Empty reports if nothing changed.
+func (d runDiff) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.NewCerts)+len(d.StartedFailing)+len(d.Recovered)+len(d.NewWarnings) == 0
+}
+
+// diffRuns returns what changed from the run older to the run newer. Hosts are matched by
+// resultKey(), and each list is sorted by it.
+func diffRuns(older, newer run) runDiff {
+	d := runDiff{From: older.ID, To: newer.ID, FromStarted: older.Started, ToStarted: newer.Started}
+	was := map[string]result{}
+	for _, v := range older.Results {
+		was[v.resultKey()] = v
+	}
+	is := map[string]bool{}
+	for _, v := range newer.Results {
+		is[v.resultKey()] = true
+		last, ok := was[v.resultKey()]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, v)
+		case last.Status != statusError && v.Status == statusError:
+			d.StartedFailing = append(d.StartedFailing, v)
+		case last.Status == statusError && v.Status != statusError:
+			d.Recovered = append(d.Recovered, v)
+		case v.Status == statusError:
+			// Still failing, which the run itself reports.
+		case last.Fingerprint != v.Fingerprint:
+			d.NewCerts = append(d.NewCerts, certChange{Was: last, Is: v})
+		case last.Status == statusOK && v.Status == statusWarning:
+			d.NewWarnings = append(d.NewWarnings, v)
+		}
+	}
+	for _, v := range older.Results {
+		if !is[v.resultKey()] {
+			d.Removed = append(d.Removed, v)
+		}
+	}
+
+	for _, l := range [][]result{d.Added, d.Removed, d.StartedFailing, d.Recovered, d.NewWarnings} {
+		sort.Slice(l, func(i, j int) bool { return l[i].resultKey() < l[j].resultKey() })
+	}
+	sort.Slice(d.NewCerts, func(i, j int) bool { return d.NewCerts[i].Is.resultKey() < d.NewCerts[j].Is.resultKey() })
+	return d
+}
+
+// joinFindings joins findings with commas.
+func joinFindings(findings []finding) string {
+	s := make([]string, len(findings))
+	for i, f := range findings {
+		s[i] = string(f)
+	}
+	return strings.Join(s, ", ")
+}
+
+// diffTmpl renders a runDiff as text.
+var diffTmpl = template.Must(template.New("").Funcs(template.FuncMap{"findings": joinFindings}).Parse(`Changes from {{ with .From }}run {{ . }}, {{ end }}started {{ .FromStarted.Format "2006-01-02 15:04 MST" }}, to {{ with .To }}run {{ . }}, {{ end }}started {{ .ToStarted.Format "2006-01-02 15:04 MST" }}:
+{{- if .Empty }}
+  Nothing changed.
+{{- end }}
+{{- with .NewCerts }}
+
+New certificates ({{ len . }}):
+{{- range . }}
+  {{ .Is.HostPort }}{{ with .Is.Address }} ({{ . }}){{ end }}: expires {{ .Is.ExpiresOn.Format "2006-01-02" }}{{ with .ExpiryMoved }} ({{ . }}){{ end }}, issuer {{ .Is.Issuer }}
+    was {{ .Was.Fingerprint }}
+    now {{ .Is.Fingerprint }}
+{{- end }}
+{{- end }}
+{{- with .StartedFailing }}
+
+Started failing ({{ len . }}):
+{{- range . }}
+  {{ .HostPort }}{{ with .Address }} ({{ . }}){{ end }}: {{ findings .Findings }}: {{ .Err }}
+{{- end }}
+{{- end }}
+{{- with .NewWarnings }}
+
+New warnings ({{ len . }}):
+{{- range . }}
+  {{ .HostPort }}{{ with .Address }} ({{ . }}){{ end }}: {{ findings .Findings }}, expires {{ .ExpiresOn.Format "2006-01-02" }} in {{ .ExpireInDays }} days
+{{- end }}
+{{- end }}
+{{- with .Recovered }}
+
+Recovered ({{ len . }}):
+{{- range . }}
+  {{ .HostPort }}{{ with .Address }} ({{ . }}){{ end }}: expires {{ .ExpiresOn.Format "2006-01-02" }}
+{{- end }}
+{{- end }}
+{{- with .Added }}
+
+Added ({{ len . }}):
+{{- range . }}
+  {{ .HostPort }}{{ with .Address }} ({{ . }}){{ end }}: {{ .Status }}
+{{- end }}
+{{- end }}
+{{- with .Removed }}
+
+Removed ({{ len . }}):
+{{- range . }}
+  {{ .HostPort }}{{ with .Address }} ({{ . }}){{ end }}
+{{- end }}
+{{- end }}
+`))
+
+// diffCmd implements the "diff" subcommand. It reports what changed between two runs, either
+// the json output (-format=json) of each, or the last two runs in -store.
+func diffCmd(args []string) error {
+	fs := subcommandFlags("diff")
+	from := fs.String("from", "", "The path to the json output (-format=json) of the older run")
+	to := fs.String("to", "", "The path to the json output (-format=json) of the newer run")
+	fs.Parse(args)
+	if err := applyProfile(fs); err != nil {
+		return err
+	}
+
+	var older, newer run
+	switch {
+	case *from != "" && *to != "":
+		var err error
+		if older, err = readRun(*from); err != nil {
+			return err
+		}
+		if newer, err = readRun(*to); err != nil {
+			return err
+		}
+	case *from == "" && *to == "" && *storeURL != "":
+		db, err := openStore()
+		if err != nil {
+			return err
+		}
+		runs, err := lastStoredRuns(db, 2)
+		if err != nil {
+			return err
+		}
+		if len(runs) < 2 {
+			return fmt.Errorf("-store has %d runs, diff needs 2", len(runs))
+		}
+		newer, older = runs[0], runs[1]
+	default:
+		return fmt.Errorf("diff requires -from and -to, or -store")
+	}
+
+	d := diffRuns(older, newer)
+	switch *format {
+	case "text":
+		return diffTmpl.Execute(os.Stdout, d)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	}
+	return fmt.Errorf("-format=%s is not supported", *format)
+}
+
+// lastStoredRuns returns the last n runs in -store with their results, newest first.
+func lastStoredRuns(db *sql.DB, n int) ([]run, error) {
+	rows, err := db.Query(`SELECT id, started FROM runs ORDER BY started DESC LIMIT $1`, n)
+	if err != nil {
+		return nil, fmt.Errorf("-store: %s", err)
+	}
+	var runs []run
+	for rows.Next() {
+		var r run
+		if err := rows.Scan(&r.ID, &r.Started); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("-store: %s", err)
+		}
+		runs = append(runs, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("-store: %s", err)
+	}
+
+	for i := range runs {
+		if runs[i].Results, err = storedResults(db, runs[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return runs, nil
+}
+
+// storedResults returns the results of the run with id in -store.
+func storedResults(db *sql.DB, id string) ([]result, error) {
+	rows, err := db.Query(`SELECT result FROM results WHERE run_id = $1`, id)
+	if err != nil {
+		return nil, fmt.Errorf("-store: %s", err)
+	}
+	defer rows.Close()
+
+	var results []result
+	for rows.Next() {
+		var b string
+		if err := rows.Scan(&b); err != nil {
+			return nil, fmt.Errorf("-store: %s", err)
+		}
+		var v result
+		if err := json.Unmarshal([]byte(b), &v); err != nil {
+			return nil, fmt.Errorf("-store: run %s has a bad result: %s", id, err)
+		}
+		results = append(results, v)
+	}
+	return results, rows.Err()
+}
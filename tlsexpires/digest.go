@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var digestWindow = dayDuration(30 * 24 * time.Hour)
+
+func init() {
+	flag.Var(&digestWindow, "digest-window", "Digests list the certificates that expire within this window (like 30d or 2w)")
+}
+
+// digestGroup is the part of a digest for a single zone.
+type digestGroup struct {
+	// Zone is the -zones zone the hosts were checked in.
+	Zone string `json:"zone"`
+	// Summary is the aggregate statistics for the zone.
+	Summary summary `json:"summary"`
+	// Expiring are the certificates in the zone that expire within the digest window, soonest first.
+	Expiring []result `json:"expiring,omitempty"`
+	// Failed are the hosts in the zone we couldn't check.
+	Failed []result `json:"failed,omitempty"`
+}
+
+// digest is a summary of the certificate posture of every host in a run. Where notifications
+// only go out when something needs attention, a digest is a heartbeat that is sent on a schedule,
+// so people who don't want every alert still know how things stand.
+type digest struct {
+	// RunID is the ID of the run.
+	RunID string `json:"runId,omitempty"`
+	// Started is when the run started.
+	Started time.Time `json:"started"`
+	// Window is how far ahead we list expiring certificates, like "30d".
+	Window string `json:"window"`
+	// Summary is the aggregate statistics for every host.
+	Summary summary `json:"summary"`
+	// Expiring is the number of certificates that expire within Window.
+	Expiring int `json:"expiring"`
+	// Groups break the run down by zone, sorted by zone name.
+	Groups []digestGroup `json:"groups"`
+}
+
+// newDigest turns a run into the data our digest templates receive. Certificates that expire
+// within window are listed individually.
+func newDigest(r run, window time.Duration) digest {
+	dw := dayDuration(window)
+	d := digest{RunID: r.ID, Started: r.Started, Window: dw.String(), Summary: summarize(r.Results)}
+
+	byZone := map[string][]result{}
+	for _, v := range r.Results {
+		zone := v.Zone
+		if zone == "" {
+			zone = "default"
+		}
+		byZone[zone] = append(byZone[zone], v)
+	}
+
+	for zone, results := range byZone {
+		g := digestGroup{Zone: zone, Summary: summarize(results)}
+		for _, v := range results {
+			switch {
+			case v.Status == statusError:
+				g.Failed = append(g.Failed, v)
+			case until(v.ExpiresOn) < window:
+				g.Expiring = append(g.Expiring, v)
+			}
+		}
+		sort.SliceStable(g.Expiring, func(i, j int) bool {
+			return g.Expiring[i].ExpiresOn.Before(g.Expiring[j].ExpiresOn)
+		})
+		d.Expiring += len(g.Expiring)
+		d.Groups = append(d.Groups, g)
+	}
+	sort.Slice(d.Groups, func(i, j int) bool {
+		return d.Groups[i].Zone < d.Groups[j].Zone
+	})
+	return d
+}
+
+// digestCmd implements the "digest" subcommand. It renders a digest of the json output of a
+// previous run to stdout and, if -notify-webhook is set, queues it for delivery there. Run it
+// from cron daily or weekly after a scan, separately from the alerts every scan sends.
+func digestCmd(args []string) error {
+	fs := subcommandFlags("digest")
+	from := fs.String("from", "", "The path to the json output (-format=json) of a previous run")
+	fs.Parse(args)
+	if err := applyProfile(fs); err != nil {
+		return err
+	}
+
+	if *from == "" {
+		return fmt.Errorf("digest requires -from")
+	}
+	r, err := readRun(*from)
+	if err != nil {
+		return err
+	}
+	t, err := loadNotifyTemplates()
+	if err != nil {
+		return err
+	}
+
+	d := newDigest(r, time.Duration(digestWindow))
+	s, err := renderNotification(t, "digest", d)
+	if err != nil {
+		return err
+	}
+	fmt.Println(s)
+
+	if *notifyWebhook == "" {
+		return nil
+	}
+	body, err := renderNotification(t, "digest.webhook", d)
+	if err != nil {
+		return err
+	}
+	dl := delivery{Channel: "digest", URL: *notifyWebhook, Body: []byte(body)}
+	// Runs from before we had run IDs can't be told apart, so we can't stop them being sent twice.
+	if r.ID != "" {
+		dl.Key = "digest/" + r.ID
+	}
+	return deliver([]delivery{dl})
+}
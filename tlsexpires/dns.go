@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var (
+	dnsNegativeTTL = flag.Duration("dns-negative-ttl", 30*time.Second, "How long we remember that a name doesn't exist before asking again")
+	dnsMaxTTL      = flag.Duration("dns-max-ttl", time.Hour, "The longest we cache a DNS answer, whatever its TTL says")
+	resolverFlag   = flag.String("resolver", "", "The DNS server to resolve every name with instead of the system resolver, like 10.0.0.53, 10.0.0.53:5353, tls://1.1.1.1 (DNS over TLS) or https://dns.google/dns-query (DNS over HTTPS)")
+	dnsSystemTTL   = flag.Duration("dns-system-ttl", 5*time.Minute, "How long we cache answers from the system resolver, which doesn't tell us the TTL. It is used for names in /etc/hosts and names without a dot")
+)
+
+// dnsLookuper looks up the IP addresses of a host and how long the answer can be cached.
+// network is "ip", "ip4" or "ip6", like net.Resolver.LookupIP().
+type dnsLookuper interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, time.Duration, error)
+}
+
+// dnsCache caches the answers of a dnsLookuper for as long as their TTL says, and caches names
+// that don't exist for -dns-negative-ttl. Concurrent lookups of the same name share one query.
+type dnsCache struct {
+	lookuper dnsLookuper
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+// dnsEntry is a cached answer. ready is closed once the answer is in.
+type dnsEntry struct {
+	ready   chan struct{}
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+// newDNSCache returns a dnsCache in front of l.
+func newDNSCache(l dnsLookuper) *dnsCache {
+	return &dnsCache{lookuper: l, entries: map[string]*dnsEntry{}}
+}
+
+// LookupIP returns the IP addresses of host, from the cache if we have a fresh answer.
+func (c *dnsCache) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	key := network + " " + strings.ToLower(host)
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		select {
+		case <-e.ready:
+			if time.Now().After(e.expires) {
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		e = &dnsEntry{ready: make(chan struct{})}
+		c.entries[key] = e
+		c.mu.Unlock()
+		c.fill(ctx, e, network, host)
+	} else {
+		c.mu.Unlock()
+	}
+
+	select {
+	case <-e.ready:
+		return e.ips, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fill does the lookup for e and closes e.ready. Errors other than the name not existing
+// aren't cached, so a timeout is retried on the next lookup.
+func (c *dnsCache) fill(ctx context.Context, e *dnsEntry, network, host string) {
+	defer close(e.ready)
+
+	countLookup(lookupDNS)
+	ips, ttl, err := c.lookuper.LookupIP(ctx, network, host)
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil:
+		if ttl > *dnsMaxTTL {
+			ttl = *dnsMaxTTL
+		}
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		ttl = *dnsNegativeTTL
+	default:
+		ttl = 0
+	}
+	e.ips, e.err, e.expires = ips, err, time.Now().Add(ttl)
+}
+
+var (
+	resolverOnce sync.Once
+	resolver     *dnsCache
+)
+
+// dnsResolver returns the process wide dnsCache every lookup we do goes through. With -resolver,
+// every name is looked up with that server. A different source of answers, like a service
+// registry, is a dnsLookuper in this package that dnsResolver() puts the cache in front of.
+func dnsResolver() *dnsCache {
+	resolverOnce.Do(func() {
+		if *resolverFlag == "" {
+			resolver = newDNSCache(newDefaultLookuper())
+			return
+		}
+		exchange, err := resolverExchange(*resolverFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		resolver = newDNSCache(&serverLookuper{exchange: exchange})
+	})
+	return resolver
+}
+
+// systemLookuper looks up names with the system resolver. It can't know the TTL, so every
+// answer is good for ttl.
+type systemLookuper struct {
+	ttl time.Duration
+}
+
+// LookupIP implements dnsLookuper.LookupIP().
+func (s systemLookuper) LookupIP(ctx context.Context, network, host string) ([]net.IP, time.Duration, error) {
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, host)
+	return ips, s.ttl, err
+}
+
+// defaultLookuper asks the nameservers in /etc/resolv.conf ourselves, so we know the TTL of
+// each answer. Names in /etc/hosts and names without a dot, which rely on search domains, are
+// left to the system resolver.
+type defaultLookuper struct {
+	hosts  map[string]bool
+	system systemLookuper
+	server *serverLookuper
+}
+
+// newDefaultLookuper returns a defaultLookuper. If we can't read /etc/resolv.conf, everything
+// goes to the system resolver.
+func newDefaultLookuper() *defaultLookuper {
+	d := &defaultLookuper{hosts: map[string]bool{}, system: systemLookuper{ttl: *dnsSystemTTL}}
+
+	if f, err := os.Open("/etc/hosts"); err == nil {
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			line, _, _ := strings.Cut(s.Text(), "#")
+			fields := strings.Fields(line)
+			for _, name := range fields[min(1, len(fields)):] {
+				d.hosts[strings.ToLower(name)] = true
+			}
+		}
+		f.Close()
+	}
+
+	if servers := resolvConfServers("/etc/resolv.conf"); len(servers) > 0 {
+		d.server = &serverLookuper{exchange: udpExchange(servers)}
+	}
+	return d
+}
+
+// LookupIP implements dnsLookuper.LookupIP().
+func (d *defaultLookuper) LookupIP(ctx context.Context, network, host string) ([]net.IP, time.Duration, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if d.server == nil || d.hosts[host] || !strings.Contains(host, ".") {
+		return d.system.LookupIP(ctx, network, host)
+	}
+	return d.server.LookupIP(ctx, network, host)
+}
+
+// resolvConfServers returns the nameservers in the resolv.conf file at path.
+func resolvConfServers(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var servers []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	return servers
+}
+
+// serverLookuper looks up names by sending queries with exchange, which sends a DNS message to a
+// nameserver and returns its response.
+type serverLookuper struct {
+	exchange dnsExchange
+}
+
+// LookupIP implements dnsLookuper.LookupIP(). The TTL is the smallest TTL of any record we used.
+func (s *serverLookuper) LookupIP(ctx context.Context, network, host string) ([]net.IP, time.Duration, error) {
+	var types []dnsmessage.Type
+	switch network {
+	case "ip4":
+		types = []dnsmessage.Type{dnsmessage.TypeA}
+	case "ip6":
+		types = []dnsmessage.Type{dnsmessage.TypeAAAA}
+	default:
+		types = []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	}
+
+	var ips []net.IP
+	var lastErr error
+	ttl := time.Duration(-1)
+	for _, t := range types {
+		got, gotTTL, err := s.query(ctx, host, t)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ips = append(ips, got...)
+		if len(got) > 0 && (ttl < 0 || gotTTL < ttl) {
+			ttl = gotTTL
+		}
+	}
+	switch {
+	case len(ips) > 0:
+		return ips, ttl, nil
+	case lastErr != nil:
+		return nil, 0, lastErr
+	}
+	return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// query asks for the records of type t for host.
+func (s *serverLookuper) query(ctx context.Context, host string, t dnsmessage.Type) ([]net.IP, time.Duration, error) {
+	resp, err := exchangeQuery(ctx, s.exchange, host, t, false)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var ips []net.IP
+	ttl := time.Duration(-1)
+	for _, a := range resp.Answers {
+		switch r := a.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IP(r.A[:]))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IP(r.AAAA[:]))
+		default:
+			continue
+		}
+		if d := time.Duration(a.Header.TTL) * time.Second; ttl < 0 || d < ttl {
+			ttl = d
+		}
+	}
+	return ips, ttl, nil
+}
+
+// exchangeQuery asks for the records of type t for host with exchange and returns the response.
+// With authenticData, the AD bit is set to ask the server to say if it validated the answer with
+// DNSSEC, as RFC 6840 describes.
+func exchangeQuery(ctx context.Context, exchange dnsExchange, host string, t dnsmessage.Type, authenticData bool) (*dnsmessage.Message, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: host}
+	}
+	var id [2]byte
+	rand.Read(id[:])
+	q := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true, AuthenticData: authenticData},
+		Questions: []dnsmessage.Question{{Name: name, Type: t, Class: dnsmessage.ClassINET}},
+	}
+	b, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	rb, err := exchange(ctx, b)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: host, IsTemporary: true}
+	}
+	resp := &dnsmessage.Message{}
+	if err := resp.Unpack(rb); err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: host}
+	}
+	if resp.ID != q.ID {
+		return nil, &net.DNSError{Err: "response had the wrong ID", Name: host, IsTemporary: true}
+	}
+	switch resp.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	default:
+		return nil, &net.DNSError{Err: fmt.Sprintf("server returned %s", resp.RCode), Name: host, IsTemporary: true}
+	}
+	return resp, nil
+}
+
+// udpExchange returns an exchange function that sends queries to servers over UDP, trying each
+// in turn until one answers.
+func udpExchange(servers []string) dnsExchange {
+	return func(ctx context.Context, query []byte) ([]byte, error) {
+		var lastErr error
+		for _, server := range servers {
+			resp, err := udpQuery(ctx, server, query)
+			if err == nil {
+				return resp, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+// udpQuery sends query to server over UDP and returns the response. If the response was too big
+// for UDP, we ask again over TCP.
+func udpQuery(ctx context.Context, server string, query []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	// The TC (truncated) bit is in the third byte of the header.
+	if n > 2 && buf[2]&0x02 != 0 {
+		return tcpQuery(ctx, server, query)
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// dnsExchange sends a DNS query message and returns the response message.
+type dnsExchange func(ctx context.Context, query []byte) ([]byte, error)
+
+// resolverExchange returns the dnsExchange for a -resolver value. That is an IP address with
+// an optional port for plain DNS, tls://host[:port] for DNS over TLS or an https:// URL for
+// DNS over HTTPS.
+func resolverExchange(resolver string) (dnsExchange, error) {
+	switch {
+	case strings.HasPrefix(resolver, "https://"):
+		if _, err := url.Parse(resolver); err != nil {
+			return nil, fmt.Errorf("-resolver=%s is not a valid URL: %s", resolver, err)
+		}
+		return dohExchange(resolver), nil
+	case strings.HasPrefix(resolver, "tls://"):
+		return dotExchange(withDefaultPort(strings.TrimPrefix(resolver, "tls://"), "853")), nil
+	case strings.Contains(resolver, "://"):
+		return nil, fmt.Errorf("-resolver=%s must be host[:port], tls://host[:port] or https://url", resolver)
+	}
+	return udpExchange([]string{withDefaultPort(resolver, "53")}), nil
+}
+
+// withDefaultPort adds port to hostPort if it doesn't have one.
+func withDefaultPort(hostPort, port string) string {
+	if _, _, err := net.SplitHostPort(hostPort); err == nil {
+		return hostPort
+	}
+	return net.JoinHostPort(strings.Trim(hostPort, "[]"), port)
+}
+
+// dohClient is used for DNS over HTTPS queries.
+var dohClient = &http.Client{Timeout: 10 * time.Second, Transport: countedTransport("")}
+
+// dohExchange returns a dnsExchange that POSTs queries to the DNS over HTTPS server at u, as
+// RFC 8484 describes.
+func dohExchange(u string) dnsExchange {
+	return func(ctx context.Context, query []byte) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(query))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/dns-message")
+		req.Header.Set("Accept", "application/dns-message")
+		resp, err := dohClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("DNS over HTTPS server returned %s", resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, 65535))
+	}
+}
+
+// dotExchange returns a dnsExchange that sends queries to server over TLS, as RFC 7858 describes.
+func dotExchange(server string) dnsExchange {
+	return func(ctx context.Context, query []byte) ([]byte, error) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		host, _, _ := net.SplitHostPort(server)
+		d := tls.Dialer{Config: &tls.Config{ServerName: host}}
+		conn, err := d.DialContext(ctx, "tcp", server)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return streamQuery(ctx, conn, query)
+	}
+}
+
+// tcpQuery sends query to server over TCP. We use it when a UDP response was truncated.
+func tcpQuery(ctx context.Context, server string, query []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return streamQuery(ctx, conn, query)
+}
+
+// streamQuery sends query on a stream connection, where every message has a two byte length
+// prefix, and returns the response.
+func streamQuery(ctx context.Context, conn net.Conn, query []byte) ([]byte, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	msg := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := conn.Write(append(msg, query...)); err != nil {
+		return nil, err
+	}
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var dumpCerts = flag.String("dump-certs", "", "A directory to write each host's presented certificate chain to, as a PEM file named by host, port and the leaf's SHA-256 fingerprint")
+
+// dumpChain writes chain to a PEM file in -dump-certs, leaf first, in the order the server sent it.
+// The file is named host_port_fingerprint.pem, so a certificate that changes between runs gets
+// a new file instead of replacing the old one.
+func dumpChain(host, port string, chain []*x509.Certificate) error {
+	if err := os.MkdirAll(*dumpCerts, 0o755); err != nil {
+		return err
+	}
+
+	buf := bytes.Buffer{}
+	for _, c := range chain {
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}); err != nil {
+			return err
+		}
+	}
+
+	sum := sha256.Sum256(chain[0].Raw)
+	// IPv6 addresses have colons, which some filesystems don't allow.
+	host = strings.ReplaceAll(host, ":", "_")
+	name := fmt.Sprintf("%s_%s_%s.pem", host, port, hex.EncodeToString(sum[:]))
+	return os.WriteFile(filepath.Join(*dumpCerts, name), buf.Bytes(), 0o644)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	smtpServer = flag.String("smtp-server", "", "The host:port of an SMTP server to email the email.subject and email.body notification templates through when any host needs attention. The password for -smtp-user is read from $TLSEXPIRES_SMTP_PASSWORD")
+	smtpFrom   = flag.String("smtp-from", "", "The address emails are sent from")
+	smtpTo     = flag.String("smtp-to", "", "The addresses emails are sent to, separated by commas")
+	smtpUser   = flag.String("smtp-user", "", "The user to log in to -smtp-server as, if it requires it")
+	smtpDays   = flag.Int("smtp-days", 0, "Only email about hosts we couldn't check and certificates that expire in fewer than this many days, for people who only want email when it is urgent. 0 emails about every host that needs attention")
+	smtpDigest = flag.Bool("smtp-digest", false, "Email the digest.subject and digest notification templates after every run instead, one email summarizing every certificate that expires within -digest-window, whether or not any host needs attention")
+)
+
+// smtpPasswordEnv is the environment variable with the password for -smtp-user. It isn't a flag
+// so it doesn't show up in ps.
+const smtpPasswordEnv = "TLSEXPIRES_SMTP_PASSWORD"
+
+// emailDelivery returns the email about the hosts in rr to its addresses, if -smtp-server is set
+// and there is something to say.
+func emailDelivery(t *template.Template, rr routedRun) (delivery, bool, error) {
+	if *smtpServer == "" {
+		return delivery{}, false, nil
+	}
+	if *smtpFrom == "" || (*smtpTo == "" && *routesFile == "") {
+		return delivery{}, false, fmt.Errorf("-smtp-server requires -smtp-from and -smtp-to")
+	}
+	if len(rr.Email) == 0 {
+		return delivery{}, false, nil
+	}
+
+	var data any
+	subjectTmpl, bodyTmpl, key := "email.subject", "email.body", rr.key("email")
+	if *smtpDigest {
+		data = newDigest(rr.run, time.Duration(digestWindow))
+		subjectTmpl, bodyTmpl, key = "digest.subject", "digest", rr.key("email-digest")
+	} else {
+		n := newNotification(urgent(rr.run, *smtpDays))
+		if len(n.Results) == 0 {
+			return delivery{}, false, nil
+		}
+		n.Total = len(rr.Results)
+		data = n
+	}
+
+	subject, err := renderNotification(t, subjectTmpl, data)
+	if err != nil {
+		return delivery{}, false, err
+	}
+	body, err := renderNotification(t, bodyTmpl, data)
+	if err != nil {
+		return delivery{}, false, err
+	}
+	msg, err := emailMessage(*smtpFrom, rr.Email, subject, body, key)
+	if err != nil {
+		return delivery{}, false, err
+	}
+	return delivery{Key: key, Channel: "email", URL: "smtp://" + *smtpServer, From: *smtpFrom, To: rr.Email, Body: msg}, true, nil
+}
+
+// urgent returns r with only the results we couldn't check and the certificates that expire in
+// fewer than days. It returns r if days is 0.
+func urgent(r run, days int) run {
+	if days <= 0 {
+		return r
+	}
+	u := r
+	u.Results = nil
+	for _, v := range r.Results {
+		if v.Status == statusError || (!v.ExpiresOn.IsZero() && v.ExpireInDays() < days) {
+			u.Results = append(u.Results, v)
+		}
+	}
+	return u
+}
+
+// emailMessage returns a plain text email. key is used for the Message-ID, so mail clients
+// show an email we sent twice, because we didn't hear back the first time, only once.
+func emailMessage(from string, to []string, subject, body, key string) ([]byte, error) {
+	b := bytes.Buffer{}
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@tlsexpires>\r\n", strings.ReplaceAll(key, "/", "."))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	w := quotedprintable.NewWriter(&b)
+	if _, err := w.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// sendMail makes a single attempt to send d, which is an email, through the SMTP server in its URL.
+func (d delivery) sendMail() error {
+	addr := strings.TrimPrefix(d.URL, "smtp://")
+	var auth smtp.Auth
+	if *smtpUser != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return permanentError{err}
+		}
+		auth = smtp.PlainAuth("", *smtpUser, os.Getenv(smtpPasswordEnv), host)
+	}
+
+	err := smtp.SendMail(addr, auth, d.From, d.To, d.Body)
+	// 5xx replies, like an unknown recipient, will be the same next time.
+	var te *textproto.Error
+	if errors.As(err, &te) && te.Code >= 500 {
+		return permanentError{fmt.Errorf("%s: %s", addr, err)}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", addr, err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var enumerate = flag.Bool("enumerate", false, "Try a handshake with every TLS version and cipher suite to report what each server accepts and flag deprecated protocols and weak ciphers. This makes dozens of connections per host")
+
+// enumeration is what a server accepted when we tried every TLS version and cipher suite.
+type enumeration struct {
+	// Protocols are the TLS versions the server accepted, like "1.2".
+	Protocols []string `json:"protocols"`
+	// CipherSuites are the cipher suites the server accepted, keyed by TLS version. We can't choose
+	// the cipher suites for TLS 1.3, so that only has the one the server picked.
+	CipherSuites map[string][]string `json:"cipherSuites"`
+	// Weaknesses are the deprecated protocols and weak cipher suites the server accepted.
+	Weaknesses []string `json:"weaknesses,omitempty"`
+}
+
+// enumerateTLS tries a handshake with hostPort for every TLS version and cipher suite we
+// implement and returns what the server accepted. d is used to make the connections, and
+// opts says how to reach the server.
+func enumerateTLS(ctx context.Context, d contextDialer, hostPort, host string, opts *targetConfig) enumeration {
+	e := enumeration{CipherSuites: map[string][]string{}}
+
+	suites := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+	for _, version := range []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12} {
+		name := tlsVersionName(version)
+		for _, suite := range suites {
+			if !supportsVersion(suite, version) {
+				continue
+			}
+			conf := opts.tlsConfig(host)
+			conf.InsecureSkipVerify = true
+			conf.MinVersion, conf.MaxVersion = version, version
+			conf.CipherSuites = []uint16{suite.ID}
+			cs, err := tryHandshake(ctx, d, hostPort, conf, opts.STARTTLS)
+			if err != nil {
+				continue
+			}
+			e.CipherSuites[name] = append(e.CipherSuites[name], suite.Name)
+
+			switch {
+			case strings.Contains(suite.Name, "RC4"):
+				e.Weaknesses = append(e.Weaknesses, fmt.Sprintf("TLS %s accepts RC4 cipher suite %s", name, suite.Name))
+			case strings.Contains(suite.Name, "3DES"):
+				e.Weaknesses = append(e.Weaknesses, fmt.Sprintf("TLS %s accepts 3DES cipher suite %s", name, suite.Name))
+			case strings.Contains(suite.Name, "CBC") && !usedEMS(cs):
+				e.Weaknesses = append(e.Weaknesses, fmt.Sprintf("TLS %s accepts CBC cipher suite %s without the extended master secret extension", name, suite.Name))
+			}
+		}
+	}
+
+	// Go doesn't let us choose TLS 1.3 cipher suites, so all we can do is see if it works.
+	conf := opts.tlsConfig(host)
+	conf.InsecureSkipVerify = true
+	conf.MinVersion, conf.MaxVersion = tls.VersionTLS13, tls.VersionTLS13
+	if cs, err := tryHandshake(ctx, d, hostPort, conf, opts.STARTTLS); err == nil {
+		e.CipherSuites["1.3"] = []string{tls.CipherSuiteName(cs.CipherSuite)}
+	}
+
+	for _, version := range []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13} {
+		name := tlsVersionName(version)
+		if len(e.CipherSuites[name]) == 0 {
+			continue
+		}
+		e.Protocols = append(e.Protocols, name)
+		if version < tls.VersionTLS12 {
+			e.Weaknesses = append(e.Weaknesses, fmt.Sprintf("accepts deprecated protocol TLS %s", name))
+		}
+	}
+	return e
+}
+
+// supportsVersion reports if suite can be used with TLS version.
+func supportsVersion(suite *tls.CipherSuite, version uint16) bool {
+	for _, v := range suite.SupportedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// usedEMS reports if the connection used the extended master secret extension (RFC 7627). Go
+// refuses to export keying material from TLS 1.2 and below connections that don't use it, so
+// that is how we find out.
+func usedEMS(cs tls.ConnectionState) bool {
+	_, err := cs.ExportKeyingMaterial("tlsexpires ems probe", nil, 1)
+	return err == nil
+}
+
+// tryHandshake connects to hostPort with d and does a TLS handshake with conf, after upgrading
+// the connection with STARTTLS if upgrade is set.
+func tryHandshake(ctx context.Context, d contextDialer, hostPort string, conf *tls.Config, upgrade string) (tls.ConnectionState, error) {
+	conn, err := dialTLS(ctx, d, hostPort, conf, upgrade)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState(), nil
+}
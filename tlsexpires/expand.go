@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+var (
+	maxExpand     = flag.Int("max-expand", 1024, "The most targets one input line with a CIDR (10.0.0.0/24:443) or port range (host:8000-8010) can expand into without -confirm-expand")
+	confirmExpand = flag.Bool("confirm-expand", false, "Allow input lines to expand into more than -max-expand targets")
+)
+
+// expandProvider expands the CIDRs and port ranges in the targets of another targetProvider into
+// a target for every address and port. Expansions are generated as they are needed, so a large
+// subnet doesn't have to fit in memory.
+type expandProvider struct {
+	inner targetProvider
+	next  func() (target, bool)
+	// labels are the labels of the target next expands, which each of its targets gets.
+	labels map[string]string
+}
+
+// Next implements targetProvider.Next().
+func (e *expandProvider) Next(ctx context.Context) (target, error) {
+	for {
+		if e.next != nil {
+			if t, ok := e.next(); ok {
+				t.Labels = e.labels
+				return t, nil
+			}
+			e.next = nil
+		}
+		t, err := e.inner.Next(ctx)
+		if err != nil {
+			return target{}, err
+		}
+		next, err := expandTarget(t.HostPort)
+		if err != nil {
+			return target{}, err
+		}
+		if next == nil {
+			return t, nil
+		}
+		e.next, e.labels = next, t.Labels
+	}
+}
+
+// expandTarget returns a generator of the targets hostPort expands into, or nil if it is an
+// ordinary host:port. It returns an error if the expansion is bigger than -max-expand and
+// -confirm-expand isn't set.
+func expandTarget(hostPort string) (func() (target, bool), error) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		// Let the check report the badly formed host:port.
+		return nil, nil
+	}
+	isCIDR := strings.Contains(host, "/")
+	isRange := strings.Contains(port, "-")
+	if !isCIDR && !isRange {
+		return nil, nil
+	}
+
+	first, last := netip.Addr{}, netip.Addr{}
+	addrs := big.NewInt(1)
+	if isCIDR {
+		prefix, err := netip.ParsePrefix(host)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s is not a valid CIDR: %s", hostPort, host, err)
+		}
+		prefix = prefix.Masked()
+		first, last = prefix.Addr(), lastAddr(prefix)
+		hostBits := prefix.Addr().BitLen() - prefix.Bits()
+		// The network and broadcast addresses of IPv4 subnets don't have hosts on them.
+		if prefix.Addr().Is4() && hostBits >= 2 {
+			first, last = first.Next(), last.Prev()
+			addrs.SetInt64(int64(1)<<(32-prefix.Bits()) - 2)
+		} else {
+			addrs.Lsh(addrs, uint(hostBits))
+		}
+	}
+
+	lowPort, highPort := 0, 0
+	if isRange {
+		lo, hi, _ := strings.Cut(port, "-")
+		l, err1 := strconv.Atoi(lo)
+		h, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || l < 1 || h > 65535 || l > h {
+			return nil, fmt.Errorf("%q: %s is not a valid port range", hostPort, port)
+		}
+		lowPort, highPort = l, h
+	} else {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s is not a valid port", hostPort, port)
+		}
+		lowPort, highPort = p, p
+	}
+
+	total := new(big.Int).Mul(addrs, big.NewInt(int64(highPort-lowPort+1)))
+	if !*confirmExpand && total.Cmp(big.NewInt(int64(*maxExpand))) > 0 {
+		return nil, fmt.Errorf("%q expands to %s targets, which is more than -max-expand=%d. Use -confirm-expand if that is what you want", hostPort, total, *maxExpand)
+	}
+
+	// Walk every port of an address before moving on to the next address.
+	addr, p := first, lowPort
+	done := false
+	return func() (target, bool) {
+		if done {
+			return target{}, false
+		}
+		h := host
+		if isCIDR {
+			h = addr.String()
+		}
+		t := target{HostPort: net.JoinHostPort(h, strconv.Itoa(p))}
+
+		p++
+		if p > highPort {
+			p = lowPort
+			if !isCIDR || addr == last {
+				done = true
+			}
+			addr = addr.Next()
+		}
+		return t, true
+	}, nil
+}
+
+// lastAddr returns the last address in prefix.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
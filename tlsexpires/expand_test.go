@@ -0,0 +1,120 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExpandTarget(t *testing.T) {
+	keepFlags(t, "max-expand", "confirm-expand")
+
+	tests := []struct {
+		desc          string
+		hostPort      string
+		maxExpand     string
+		confirmExpand string
+		// want is nil for a host:port that isn't expanded.
+		want    []string
+		wantErr bool
+	}{
+		{
+			desc:     "an ordinary host:port",
+			hostPort: "example.com:443",
+		},
+		{
+			desc:     "a badly formed host:port is left to the check",
+			hostPort: "example.com",
+		},
+		{
+			desc:     "an IPv4 subnet leaves out the network and broadcast addresses",
+			hostPort: "10.0.0.0/30:443",
+			want:     []string{"10.0.0.1:443", "10.0.0.2:443"},
+		},
+		{
+			desc:     "a /31 has no network or broadcast address",
+			hostPort: "10.0.0.4/31:443",
+			want:     []string{"10.0.0.4:443", "10.0.0.5:443"},
+		},
+		{
+			desc:     "an unmasked CIDR",
+			hostPort: "10.0.0.7/30:443",
+			want:     []string{"10.0.0.5:443", "10.0.0.6:443"},
+		},
+		{
+			desc:     "an IPv6 subnet",
+			hostPort: "[2001:db8::/127]:443",
+			want:     []string{"[2001:db8::]:443", "[2001:db8::1]:443"},
+		},
+		{
+			desc:     "a port range",
+			hostPort: "example.com:8000-8002",
+			want:     []string{"example.com:8000", "example.com:8001", "example.com:8002"},
+		},
+		{
+			desc:     "every port of an address before the next address",
+			hostPort: "10.0.0.0/30:1-2",
+			want:     []string{"10.0.0.1:1", "10.0.0.1:2", "10.0.0.2:1", "10.0.0.2:2"},
+		},
+		{
+			desc:     "a CIDR that doesn't parse",
+			hostPort: "10.0.0.0/33:443",
+			wantErr:  true,
+		},
+		{
+			desc:     "a backwards port range",
+			hostPort: "example.com:10-5",
+			wantErr:  true,
+		},
+		{
+			desc:     "a port range past 65535",
+			hostPort: "example.com:65530-65536",
+			wantErr:  true,
+		},
+		{
+			desc:      "more than -max-expand",
+			hostPort:  "10.0.0.0/29:443",
+			maxExpand: "5",
+			wantErr:   true,
+		},
+		{
+			desc:          "more than -max-expand with -confirm-expand",
+			hostPort:      "10.0.0.0/29:443",
+			maxExpand:     "5",
+			confirmExpand: "true",
+			want:          []string{"10.0.0.1:443", "10.0.0.2:443", "10.0.0.3:443", "10.0.0.4:443", "10.0.0.5:443", "10.0.0.6:443"},
+		},
+	}
+	for _, test := range tests {
+		maxExpand, confirmExpand := "1024", "false"
+		if test.maxExpand != "" {
+			maxExpand = test.maxExpand
+		}
+		if test.confirmExpand != "" {
+			confirmExpand = test.confirmExpand
+		}
+		setFlag(t, "max-expand", maxExpand)
+		setFlag(t, "confirm-expand", confirmExpand)
+
+		next, err := expandTarget(test.hostPort)
+		switch {
+		case err == nil && test.wantErr:
+			t.Errorf("TestExpandTarget(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.wantErr:
+			t.Errorf("TestExpandTarget(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+
+		var got []string
+		if next != nil {
+			for tgt, ok := next(); ok; tgt, ok = next() {
+				got = append(got, tgt.HostPort)
+			}
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("TestExpandTarget(%s): got %q, want %q", test.desc, got, test.want)
+		}
+	}
+}
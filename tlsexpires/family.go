@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"time"
+)
+
+var (
+	ipv4Only      = flag.Bool("4", false, "Only connect to hosts over IPv4")
+	ipv6Only      = flag.Bool("6", false, "Only connect to hosts over IPv6")
+	fallbackDelay = flag.Duration("fallback-delay", 300*time.Millisecond, "For hosts with both IPv4 and IPv6 addresses, how long we give the addresses of the first family to connect before racing the other family too (Happy Eyeballs, RFC 8305), so a host with a broken AAAA record doesn't use up the whole timeout before we try IPv4. Negative tries the addresses one at a time")
+	allIPs        = flag.Bool("all-ips", false, "Resolve every A and AAAA record for each hostname and check each address separately, using the hostname for SNI. Hosts whose addresses serve different certificates get a warning. -4 and -6 limit which records we use")
+)
+
+// checkFamilyFlags returns an error if the address family flags, and the source address flags
+// that have to agree with them, don't make sense together.
+func checkFamilyFlags() error {
+	if *ipv4Only && *ipv6Only {
+		return fmt.Errorf("-4 and -6 can't be used together")
+	}
+	if *sourceIP != "" && *interfaceFlag != "" {
+		return fmt.Errorf("-source-ip and -interface can't be used together")
+	}
+	if *sourceIP != "" {
+		ip := net.ParseIP(*sourceIP)
+		switch {
+		case ip == nil:
+			return fmt.Errorf("-source-ip %q is not an IP address", *sourceIP)
+		case *ipv6Only && ip.To4() != nil:
+			return fmt.Errorf("-source-ip %s is an IPv4 address, which can't connect over IPv6 with -6", *sourceIP)
+		case *ipv4Only && ip.To4() == nil:
+			return fmt.Errorf("-source-ip %s is an IPv6 address, which can't connect over IPv4 with -4", *sourceIP)
+		}
+	}
+	return nil
+}
+
+// dialNetwork returns the network we pass to Dial(), which is how -4 and -6 are enforced.
+func dialNetwork() string {
+	switch {
+	case *ipv4Only:
+		return "tcp4"
+	case *ipv6Only:
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// ipNetwork returns the network we pass to LookupIP(), so we only get addresses -4 and -6 allow.
+func ipNetwork() string {
+	switch {
+	case *ipv4Only:
+		return "ip4"
+	case *ipv6Only:
+		return "ip6"
+	}
+	return "ip"
+}
+
+// resolveAddrs returns the IP addresses of the host in hostPort that -4 and -6 allow us to use.
+// If the host is already an IP address, that is all we return.
+func resolveAddrs(ctx context.Context, hostPort string) ([]string, error) {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := dnsResolver().LookupIP(ctx, ipNetwork(), host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, ip.String())
+	}
+	return addrs, nil
+}
+
+// backendGroups holds -all-ips results until the results for every address of a host are in,
+// so they can be compared with each other. They are keyed by the host's position in the input.
+type backendGroups map[int][]result
+
+// add adds v and returns the results for its host once all of them are in. Hosts we only
+// checked one address of are returned right away.
+func (b backendGroups) add(v result) []result {
+	if v.addrs <= 1 {
+		return []result{v}
+	}
+	g := append(b[v.order], v)
+	if len(g) < v.addrs {
+		b[v.order] = g
+		return nil
+	}
+	delete(b, v.order)
+	compareBackends(g)
+	return g
+}
+
+// compareBackends sets Mismatch and a warning status on every result in g if the addresses
+// didn't all serve the same certificate. Load balancers and anycast often have backends that
+// didn't get the last certificate rotation, which checking one address at random can miss.
+func compareBackends(g []result) {
+	fingerprints := map[string]bool{}
+	for _, v := range g {
+		if v.Fingerprint != "" {
+			fingerprints[v.Fingerprint] = true
+		}
+	}
+	if len(fingerprints) <= 1 {
+		return
+	}
+	for i := range g {
+		if g[i].Fingerprint == "" {
+			continue
+		}
+		g[i].Mismatch = fmt.Sprintf("the %d addresses of %s serve %d different certificates", len(g), g[i].HostPort, len(fingerprints))
+		g[i].find(findingBackendMismatch)
+	}
+}
+
+// dialAddrs connects to port on the first of ips that answers. ips are tried one at a time, in
+// order, but if they have both IPv4 and IPv6 addresses, the family of the first address gets
+// -fallback-delay before we start on the other family at the same time, like net.Dialer does
+// for names it resolves.
+func dialAddrs(ctx context.Context, d contextDialer, ips []net.IP, port string) (net.Conn, error) {
+	primaries, fallbacks := splitFamilies(ips)
+	if len(fallbacks) == 0 || *fallbackDelay < 0 {
+		return dialSerial(ctx, d, ips, port)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type dialResult struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+	results := make(chan dialResult)
+	returned := make(chan struct{})
+	defer close(returned)
+	race := func(ips []net.IP, primary bool) {
+		conn, err := dialSerial(ctx, d, ips, port)
+		select {
+		case results <- dialResult{conn: conn, err: err, primary: primary}:
+		case <-returned:
+			// The other family won.
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+	go race(primaries, true)
+	fallback := time.NewTimer(*fallbackDelay)
+	defer fallback.Stop()
+
+	var primaryErr error
+	racing, started := 1, false
+	start := func() {
+		if !started {
+			started = true
+			racing++
+			go race(fallbacks, false)
+		}
+	}
+	for {
+		select {
+		case <-fallback.C:
+			start()
+		case r := <-results:
+			if r.err == nil {
+				return r.conn, nil
+			}
+			racing--
+			if r.primary {
+				primaryErr = r.err
+				start()
+			}
+			if racing == 0 {
+				// The error for the family we preferred is the one to report.
+				return nil, primaryErr
+			}
+		}
+	}
+}
+
+// dialSerial connects to port on the first of ips that answers, trying them in order. Each
+// address gets an equal share of the time left, so one that doesn't answer can't use up the time
+// of the ones after it.
+func dialSerial(ctx context.Context, d contextDialer, ips []net.IP, port string) (net.Conn, error) {
+	var lastErr error
+	for i, ip := range ips {
+		actx, cancel := attemptContext(ctx, len(ips)-i)
+		cctx, end := phase(actx, "connect")
+		conn, err := d.DialContext(cctx, dialNetwork(), net.JoinHostPort(ip.String(), port))
+		end(err)
+		cancel()
+		if err == nil {
+			return countingConn{conn}, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// minAttemptTime is the least time attemptContext gives an address, even if it leaves less for
+// the ones after it. It is what net.Dialer uses.
+const minAttemptTime = 2 * time.Second
+
+// attemptContext returns a context for dialing the first of left addresses that has an equal
+// share of the time ctx has left.
+func attemptContext(ctx context.Context, left int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || left <= 1 {
+		return context.WithCancel(ctx)
+	}
+	share := time.Until(deadline) / time.Duration(left)
+	if share < minAttemptTime {
+		share = minAttemptTime
+	}
+	return context.WithTimeout(ctx, share)
+}
+
+// splitFamilies splits ips into the addresses of the same family as the first, in order, and
+// the rest.
+func splitFamilies(ips []net.IP) (primaries, fallbacks []net.IP) {
+	if len(ips) == 0 {
+		return nil, nil
+	}
+	v4 := ips[0].To4() != nil
+	for _, ip := range ips {
+		if (ip.To4() != nil) == v4 {
+			primaries = append(primaries, ip)
+		} else {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	return primaries, fallbacks
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dayDuration is a flag.Value for a time.Duration that also understands days ("30d") and
+// weeks ("2w"), which is what people think in when talking about certificate expiration.
+type dayDuration time.Duration
+
+// String implements flag.Value.String().
+func (d *dayDuration) String() string {
+	if d == nil || *d == 0 {
+		return "0"
+	}
+	td := time.Duration(*d)
+	if td%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", td/(24*time.Hour))
+	}
+	return td.String()
+}
+
+// Set implements flag.Value.Set().
+func (d *dayDuration) Set(s string) error {
+	td, err := parseDayDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = dayDuration(td)
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler, so files can use "30d" just like the flags.
+func (d *dayDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations must be a string like \"30d\": %s", err)
+	}
+	return d.Set(s)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d dayDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// parseDayDuration is time.ParseDuration, but also accepts a whole number of days ("30d") or weeks ("2w").
+func parseDayDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if !strings.HasSuffix(s, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a valid duration", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+	td, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a valid duration, use something like 30d, 2w or 12h", s)
+	}
+	return td, nil
+}
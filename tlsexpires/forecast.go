@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var forecastPeriod = flag.String("forecast", "", "Add a renewal forecast to the summary, how many certificates expire in each week or month of the next year, so the renewal work can be planned instead of reacted to. week or month")
+
+// forecastWidth is the most #s in a bar of the text forecast.
+const forecastWidth = 40
+
+// forecastBucket is a week or month of a forecast.
+type forecastBucket struct {
+	// Start is when the week, which starts on Monday, or the month starts, in UTC.
+	Start time.Time `json:"start"`
+	// Certificates is the number of certificates that expire in it. A certificate served by
+	// several hosts counts once, since it is renewed once.
+	Certificates int `json:"certificates"`
+	// Hosts is the number of hosts that serve those certificates.
+	Hosts int `json:"hosts"`
+}
+
+// forecast is how many certificates expire in each week or month of the next year.
+type forecast struct {
+	// Period is "week" or "month".
+	Period string `json:"period"`
+	// Expired is the number of certificates that have already expired.
+	Expired int `json:"expired"`
+	// Buckets are the weeks or months of the next year, starting with the current one.
+	Buckets []forecastBucket `json:"buckets"`
+	// Later is the number of certificates that expire after the last bucket.
+	Later int `json:"later"`
+}
+
+// checkForecastFlag returns an error if -forecast isn't a period we forecast by.
+func checkForecastFlag() error {
+	switch *forecastPeriod {
+	case "", "week", "month":
+		return nil
+	}
+	return fmt.Errorf("-forecast must be week or month, was %q", *forecastPeriod)
+}
+
+// newForecast returns the forecast by period, "week" or "month", of the certificates of results
+// from now. It returns nil if period is empty.
+func newForecast(results []result, period string, now time.Time) *forecast {
+	if period == "" {
+		return nil
+	}
+	now = now.UTC()
+	start, next := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	n := 12
+	if period == "week" {
+		// Weeks start on Monday, like ISO 8601 says.
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		start, next = day.AddDate(0, 0, -(int(day.Weekday())+6)%7), func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }
+		n = 52
+	}
+
+	f := &forecast{Period: period}
+	for t := start; len(f.Buckets) < n; t = next(t) {
+		f.Buckets = append(f.Buckets, forecastBucket{Start: t})
+	}
+	end := next(f.Buckets[n-1].Start)
+
+	groups, _ := groupByFingerprint(results)
+	for _, g := range groups {
+		exp := g.Certificate.ExpiresOn
+		switch {
+		case exp.IsZero() || exp.Equal(sshCertForever):
+			continue
+		case exp.Before(now):
+			f.Expired++
+			continue
+		case !exp.Before(end):
+			f.Later++
+			continue
+		}
+		for i := len(f.Buckets) - 1; i >= 0; i-- {
+			if !exp.Before(f.Buckets[i].Start) {
+				f.Buckets[i].Certificates++
+				f.Buckets[i].Hosts += len(g.Hosts)
+				break
+			}
+		}
+	}
+	return f
+}
+
+// writeForecast writes f as a calendar with a bar for each week or month.
+func writeForecast(w io.Writer, f *forecast) error {
+	most := 0
+	for _, b := range f.Buckets {
+		most = max(most, b.Certificates)
+	}
+	layout := "2006-01"
+	if f.Period == "week" {
+		layout = "2006-01-02"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "\n%s:\n", msg("forecast"))
+	fmt.Fprintf(&sb, "  %-10s  %s\n", msg("expired"), msg("forecastCerts", f.Expired))
+	for _, b := range f.Buckets {
+		if b.Certificates == 0 {
+			fmt.Fprintf(&sb, "  %-10s  %4d\n", b.Start.Format(layout), 0)
+			continue
+		}
+		// Any certificates at all get at least one #, so they can't be missed.
+		bar := strings.Repeat("#", max(1, b.Certificates*forecastWidth/most))
+		fmt.Fprintf(&sb, "  %-10s  %4d %-*s %s\n", b.Start.Format(layout), b.Certificates, forecastWidth, bar, msg("hostCount", b.Hosts))
+	}
+	fmt.Fprintf(&sb, "  %-10s  %s\n", msg("later"), msg("forecastCerts", f.Later))
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+var (
+	gcpTargets  = flag.Bool("gcp", false, "Check the certificates and TLS endpoints in GCP instead of -file. Credentials are the application default credentials: GOOGLE_APPLICATION_CREDENTIALS, gcloud's or the instance service account. See -gcp-discover")
+	gcpProjects = flag.String("gcp-projects", "", "A comma separated list of the GCP projects to look in with -gcp. Defaults to the project of our credentials")
+	gcpDiscover = flag.String("gcp-discover", "sslcerts,certmanager,lb", "A comma separated list of what -gcp checks: 'sslcerts' (Compute Engine SSL certificates), 'certmanager' (Certificate Manager certificates) and 'lb' (the addresses of load balancer forwarding rules for HTTPS and SSL proxies)")
+)
+
+// gcpCertPrefix starts the target for a certificate stored in GCP, which is followed by the URL
+// of the certificate in the Compute Engine or Certificate Manager API. We check these by fetching
+// the certificate instead of connecting to anything.
+const gcpCertPrefix = "gcp-cert:"
+
+// gcpResourceLabel is the label we put the URL of the GCP resource a target came from in.
+const gcpResourceLabel = "gcp_resource"
+
+// These are the GCP APIs we use. gcpCertHosts are the hosts a gcpCertPrefix target may point at,
+// so a target can't get our credentials sent somewhere else.
+const (
+	gcpComputeAPI     = "https://compute.googleapis.com/compute/v1/"
+	gcpCertManagerAPI = "https://certificatemanager.googleapis.com/v1/"
+)
+
+var gcpCertHosts = map[string]bool{"compute.googleapis.com": true, "certificatemanager.googleapis.com": true}
+
+var (
+	gcpClientOnce sync.Once
+	gcpHTTP       *http.Client
+	gcpProject    string
+	gcpClientErr  error
+)
+
+// gcpClient returns an http.Client that authenticates with our application default credentials,
+// and the project those credentials are for, if they say.
+func gcpClient(ctx context.Context) (*http.Client, string, error) {
+	gcpClientOnce.Do(func() {
+		creds, err := google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/cloud-platform")
+		if err != nil {
+			gcpClientErr = fmt.Errorf("-gcp could not find the application default credentials: %s", err)
+			return
+		}
+		// The client outlives ctx, which only bounds finding the credentials.
+		gcpHTTP = oauth2.NewClient(context.Background(), creds.TokenSource)
+		gcpHTTP.Timeout = time.Minute
+		gcpProject = creds.ProjectID
+	})
+	return gcpHTTP, gcpProject, gcpClientErr
+}
+
+// gcpListers returns the cloudListers for everything -gcp-discover asks for in the projects we
+// look in. projects is the value of -gcp-projects and discover the value of -gcp-discover.
+func gcpListers(ctx context.Context, projects, discover string) ([]cloudLister, error) {
+	c, project, err := gcpClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ps []string
+	for _, p := range strings.Split(projects, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			ps = append(ps, p)
+		}
+	}
+	if len(ps) == 0 {
+		if project == "" {
+			return nil, fmt.Errorf("-gcp needs -gcp-projects, our credentials don't say what project they are for")
+		}
+		ps = []string{project}
+	}
+
+	var listers []cloudLister
+	for _, kind := range strings.Split(discover, ",") {
+		for _, p := range ps {
+			var base string
+			var parse func(base string) func([]byte) ([]target, string, error)
+			switch strings.TrimSpace(kind) {
+			case "sslcerts":
+				base, parse = gcpComputeAPI+"projects/"+url.PathEscape(p)+"/aggregated/sslCertificates?maxResults=500", sslCertificatesPage
+			case "certmanager":
+				base, parse = gcpCertManagerAPI+"projects/"+url.PathEscape(p)+"/locations/-/certificates?pageSize=500", certManagerPage
+			case "lb":
+				base, parse = gcpComputeAPI+"projects/"+url.PathEscape(p)+"/aggregated/forwardingRules?maxResults=500", forwardingRulesPage
+			default:
+				return nil, fmt.Errorf("-gcp-discover had %q, which is not 'sslcerts', 'certmanager' or 'lb'", kind)
+			}
+			listers = append(listers, restLister(integrationGCP, strings.TrimSpace(kind)+" in "+p, c, base, parse(base)))
+		}
+	}
+	return listers, nil
+}
+
+// gcpNextPage returns the URL of the page after the one base returned, given its nextPageToken.
+func gcpNextPage(base, token string) string {
+	if token == "" {
+		return ""
+	}
+	return base + "&pageToken=" + url.QueryEscape(token)
+}
+
+// gcpTarget returns the target for hostPort, labelled with the URL of the resource it is for.
+func gcpTarget(hostPort, resource string) target {
+	return target{HostPort: hostPort, Labels: map[string]string{gcpResourceLabel: resource}}
+}
+
+// sslCertificatesPage returns the parser for pages of the aggregated list of Compute Engine SSL
+// certificates starting at base. Each certificate is a target, whether it is self managed or
+// Google managed.
+func sslCertificatesPage(base string) func([]byte) ([]target, string, error) {
+	return func(page []byte) ([]target, string, error) {
+		list := struct {
+			Items map[string]struct {
+				SSLCertificates []struct {
+					SelfLink string `json:"selfLink"`
+				} `json:"sslCertificates"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}{}
+		if err := json.Unmarshal(page, &list); err != nil {
+			return nil, "", err
+		}
+		var ts []target
+		for _, scope := range list.Items {
+			for _, c := range scope.SSLCertificates {
+				ts = append(ts, gcpTarget(gcpCertPrefix+c.SelfLink, c.SelfLink))
+			}
+		}
+		return ts, gcpNextPage(base, list.NextPageToken), nil
+	}
+}
+
+// certManagerPage returns the parser for pages of Certificate Manager certificates starting at base.
+func certManagerPage(base string) func([]byte) ([]target, string, error) {
+	return func(page []byte) ([]target, string, error) {
+		list := struct {
+			Certificates []struct {
+				Name string `json:"name"`
+			} `json:"certificates"`
+			NextPageToken string `json:"nextPageToken"`
+		}{}
+		if err := json.Unmarshal(page, &list); err != nil {
+			return nil, "", err
+		}
+		var ts []target
+		for _, c := range list.Certificates {
+			link := gcpCertManagerAPI + c.Name
+			ts = append(ts, gcpTarget(gcpCertPrefix+link, link))
+		}
+		return ts, gcpNextPage(base, list.NextPageToken), nil
+	}
+}
+
+// forwardingRulesPage returns the parser for pages of the aggregated list of forwarding rules
+// starting at base. Rules that send traffic to an HTTPS or SSL proxy are checked at their address,
+// other rules don't terminate TLS in the load balancer.
+func forwardingRulesPage(base string) func([]byte) ([]target, string, error) {
+	return func(page []byte) ([]target, string, error) {
+		list := struct {
+			Items map[string]struct {
+				ForwardingRules []struct {
+					IPAddress string `json:"IPAddress"`
+					PortRange string `json:"portRange"`
+					Target    string `json:"target"`
+					SelfLink  string `json:"selfLink"`
+				} `json:"forwardingRules"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}{}
+		if err := json.Unmarshal(page, &list); err != nil {
+			return nil, "", err
+		}
+		var ts []target
+		for _, scope := range list.Items {
+			for _, r := range scope.ForwardingRules {
+				if !strings.Contains(r.Target, "/targetHttpsProxies/") && !strings.Contains(r.Target, "/targetSslProxies/") {
+					continue
+				}
+				// A proxy's port range is a single port, like "443-443".
+				port, _, _ := strings.Cut(r.PortRange, "-")
+				if port == "" {
+					port = "443"
+				}
+				ts = append(ts, gcpTarget(net.JoinHostPort(r.IPAddress, port), r.SelfLink))
+			}
+		}
+		return ts, gcpNextPage(base, list.NextPageToken), nil
+	}
+}
+
+// checkGCPCert checks a certificate stored in GCP, whose target is gcpCertPrefix + its URL. Like
+// checkACMCert, this catches certificates that expire before anything serves them. A Google
+// managed certificate has no certificate until it is provisioned, which is reported as an error.
+func checkGCPCert(hostPort string) result {
+	link := strings.TrimPrefix(hostPort, gcpCertPrefix)
+	v := result{HostPort: hostPort, Server: link, Status: statusOK}
+	fail := func(err error) result {
+		v.Err = err.Error()
+		v.find(findingStoredCert)
+		return v
+	}
+
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "https" || !gcpCertHosts[u.Host] {
+		return fail(fmt.Errorf("%q is not the URL of a GCP certificate", link))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	c, _, err := gcpClient(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	body, err := restGet(ctx, c, link)
+	if err != nil {
+		return fail(fmt.Errorf("could not get %s from GCP: %s", link, err))
+	}
+	// Compute Engine and Certificate Manager call the PEM encoded chain different things.
+	cert := struct {
+		Certificate    string `json:"certificate"`
+		PEMCertificate string `json:"pemCertificate"`
+	}{}
+	if err := json.Unmarshal(body, &cert); err != nil {
+		return fail(fmt.Errorf("could not decode %s: %s", link, err))
+	}
+	chain, err := pemCertificates([]byte(cert.Certificate + cert.PEMCertificate))
+	if err != nil {
+		return fail(fmt.Errorf("GCP returned a bad certificate for %s: %s", link, err))
+	}
+	if len(chain) == 0 {
+		return fail(fmt.Errorf("GCP has no certificate for %s, a managed certificate may not be provisioned yet", link))
+	}
+	v.describe(chain, optionsFor(hostPort).warnDays())
+	return v
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var groupByCert = flag.Bool("group-by-cert", false, "Write each certificate once, with every host that serves it, instead of once per host. A wildcard served by a whole fleet is one entry. Hosts we couldn't check are still listed one by one. Can't be used with -stream")
+
+// certGroup is a certificate and the hosts that serve it.
+type certGroup struct {
+	// Certificate describes the certificate. It is the first result with it in our sort order,
+	// so its fields about the host, like HostPort, are of that host.
+	Certificate result `json:"certificate"`
+	// Status is the worst status of the hosts that serve the certificate.
+	Status status `json:"status"`
+	// Hosts are the host:ports that serve the certificate, followed by the address with -all-ips.
+	Hosts []string `json:"hosts"`
+}
+
+// groupedRun is a run with its results grouped by certificate, which is the json output with
+// -group-by-cert.
+type groupedRun struct {
+	// ID is a ULID that identifies the run.
+	ID string `json:"id,omitempty"`
+	// Started is when the scan started.
+	Started time.Time `json:"started"`
+	// Certificates are the certificates we got, in the order of the first host with each.
+	Certificates []certGroup `json:"certificates"`
+	// Failed are the results we didn't get a certificate for.
+	Failed []result `json:"failed,omitempty"`
+	// Summary is the aggregate statistics for every result.
+	Summary *summary `json:"summary,omitempty"`
+	// Partial is set if the run was interrupted, so not every host was checked.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// groupByFingerprint groups results, which should be sorted, by the fingerprint of their
+// certificate. Results without a certificate are returned in failed.
+func groupByFingerprint(results []result) (groups []certGroup, failed []result) {
+	index := map[string]int{}
+	for _, v := range results {
+		if v.Fingerprint == "" {
+			failed = append(failed, v)
+			continue
+		}
+		host := v.HostPort
+		if v.Address != "" {
+			host += " (" + v.Address + ")"
+		}
+		i, ok := index[v.Fingerprint]
+		if !ok {
+			index[v.Fingerprint] = len(groups)
+			groups = append(groups, certGroup{Certificate: v, Status: v.Status, Hosts: []string{host}})
+			continue
+		}
+		g := &groups[i]
+		g.Hosts = append(g.Hosts, host)
+		if severity(v.Status) > severity(g.Status) {
+			g.Status = v.Status
+		}
+	}
+	return groups, failed
+}
+
+// groupTmpl is the text output of a certGroup.
+var groupTmpl = template.Must(template.New("").Funcs(template.FuncMap{"join": strings.Join, "t": msg}).Parse(`
+{{ t "certificate" }}: {{ .Certificate.Fingerprint }}
+{{- with .Certificate.Issuer }}
+{{ t "issuer" }}: {{ . }}
+{{- end }}
+{{- with .Certificate.SANs }}
+{{ t "sans" }}: {{ join . ", " }}
+{{- end }}
+{{ t "expiresOn" }}: {{ .Certificate.ExpiresOn }}
+{{ t "inDays" .Certificate.ExpireInDays }}
+{{ t "status" }}: {{ .Status }}
+{{ t "servedBy" (len .Hosts) }}: {{ join .Hosts ", " }}
+`,
+))
+
+// writeGrouped writes results grouped by certificate to w in our text format, the hosts we
+// couldn't check first.
+func writeGrouped(w io.Writer, results []result) error {
+	groups, failed := groupByFingerprint(results)
+	for _, v := range failed {
+		if err := writeText(w, v); err != nil {
+			return err
+		}
+	}
+	for _, g := range groups {
+		if err := groupTmpl.Execute(w, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkGroupFlags returns an error if -group-by-cert is used with a flag it can't work with.
+func checkGroupFlags() error {
+	if *groupByCert && *stream {
+		return fmt.Errorf("-group-by-cert needs every result before it can write any, so it can't be used with -stream")
+	}
+	return nil
+}
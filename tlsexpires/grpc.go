@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/johnsiilver/examples/tlsexpires/scanpb"
+	"google.golang.org/grpc"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var grpcListen = flag.String("grpc-listen", "", "Serve the gRPC Scanner service in scanpb/scan.proto on this address, like :9220, which checks the targets streamed to it. With -listen, we also scan every -interval, without it we only check what we are sent")
+
+// maxStreamChecks is how many targets of a Scan stream we check at a time. Targets sent faster
+// than we can check them wait in the stream, so a caller can't make us run away with memory.
+const maxStreamChecks = 100
+
+// statusProtos are the scanpb statuses of our statuses.
+var statusProtos = map[status]scanpb.Status{
+	statusOK:      scanpb.Status_STATUS_OK,
+	statusWarning: scanpb.Status_STATUS_WARNING,
+	statusError:   scanpb.Status_STATUS_ERROR,
+}
+
+// scanServer implements the gRPC Scanner service.
+type scanServer struct {
+	scanpb.UnimplementedScannerServer
+	api *checkAPI
+}
+
+// serveGRPC serves the Scanner service at -grpc-listen, checking hosts like api does. It only
+// returns if the server fails.
+func serveGRPC(api *checkAPI) error {
+	l, err := net.Listen("tcp", *grpcListen)
+	if err != nil {
+		return fmt.Errorf("-grpc-listen: %s", err)
+	}
+	s := grpc.NewServer()
+	scanpb.RegisterScannerServer(s, &scanServer{api: api})
+	log.Printf("serving gRPC at %s", *grpcListen)
+	return s.Serve(l)
+}
+
+// Scan implements scanpb.ScannerServer.Scan().
+func (s *scanServer) Scan(stream grpc.BidiStreamingServer[scanpb.Target, scanpb.Result]) error {
+	ctx := stream.Context()
+	results := make(chan *scanpb.Result, maxStreamChecks)
+	// recvErr is why we stopped receiving targets, if it wasn't the end of the stream. It is set
+	// before results is closed.
+	var recvErr error
+
+	go func() {
+		sem := make(chan struct{}, maxStreamChecks)
+		wg := sync.WaitGroup{}
+		defer func() {
+			wg.Wait()
+			close(results)
+		}()
+		for {
+			t, err := stream.Recv()
+			if err != nil {
+				if err != io.EOF {
+					recvErr = err
+				}
+				return
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				r := s.check(ctx, t)
+				select {
+				case results <- r:
+				case <-ctx.Done():
+				}
+			}()
+		}
+	}()
+
+	for {
+		select {
+		case r, ok := <-results:
+			if !ok {
+				return recvErr
+			}
+			if err := stream.Send(r); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return grpcstatus.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// check checks t. An invalid target is reported in its result, so it doesn't end the stream for
+// the targets after it.
+func (s *scanServer) check(ctx context.Context, t *scanpb.Target) *scanpb.Result {
+	var warnDays *int
+	if t.WarnDays != nil {
+		n := int(t.GetWarnDays())
+		warnDays = &n
+	}
+	opts, err := adHocOptions(t.GetHost(), t.GetSni(), t.GetProtocol(), t.GetStarttls(), warnDays)
+	if err != nil {
+		return &scanpb.Result{
+			Id:       t.GetId(),
+			HostPort: t.GetHost(),
+			Status:   scanpb.Status_STATUS_ERROR,
+			Error:    fmt.Sprintf("invalid target: %s", err),
+		}
+	}
+	return resultProto(t.GetId(), s.api.check(ctx, opts))
+}
+
+// resultProto returns v as a scanpb.Result for the target with id.
+func resultProto(id string, v result) *scanpb.Result {
+	r := &scanpb.Result{
+		Id:                 id,
+		HostPort:           v.HostPort,
+		Server:             v.Server,
+		Port:               v.Port,
+		Issuer:             v.Issuer,
+		Sans:               v.SANs,
+		TlsVersion:         v.TLSVersion,
+		ChainLength:        int32(v.ChainLength),
+		Fingerprint:        v.Fingerprint,
+		Serial:             v.Serial,
+		KeyAlgorithm:       v.KeyAlgorithm,
+		SignatureAlgorithm: v.SignatureAlgorithm,
+		KeyWeaknesses:      v.KeyWeaknesses,
+		Labels:             v.Labels,
+		Zone:               v.Zone,
+		Status:             statusProtos[v.Status],
+		Error:              v.Err,
+	}
+	if !v.ExpiresOn.IsZero() {
+		r.ExpiresOn = timestamppb.New(v.ExpiresOn)
+		r.DaysRemaining = int32(v.ExpireInDays())
+	}
+	for _, f := range v.Findings {
+		r.Findings = append(r.Findings, string(f))
+	}
+	return r
+}
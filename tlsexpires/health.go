@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+var checkTLSHealth = flag.Bool("tls-health", false, "Also check if each server resumes TLS sessions from the tickets it gives out and supports secure renegotiation (RFC 5746) with TLS 1.2, and report it with the result. A server without secure renegotiation gets a warning. This makes 3 more connections per host")
+
+const (
+	// ticketWait is how long we wait for a TLS 1.3 server to send a session ticket after the
+	// handshake. Servers send them right away, so this only runs out for ones that don't.
+	ticketWait = 2 * time.Second
+	// extensionRenegotiationInfo is the TLS extension of RFC 5746 secure renegotiation.
+	extensionRenegotiationInfo = 0xff01
+	// alertProtocolVersion is the TLS alert a server sends when it doesn't speak any version we offered.
+	alertProtocolVersion = 70
+)
+
+// tlsHealth is how a server handles the parts of TLS after the first handshake. 0-RTT isn't
+// reported, Go's TLS client only tells us if a ticket allows early data for QUIC.
+type tlsHealth struct {
+	// Resumption is if the server resumed the session of a ticket it gave us.
+	Resumption bool `json:"resumption"`
+	// SecureRenegotiation is if the server supports RFC 5746 secure renegotiation. It isn't
+	// set for servers that only speak TLS 1.3, which did away with renegotiation.
+	SecureRenegotiation *bool `json:"secureRenegotiation,omitempty"`
+	// Errors are why any of the checks couldn't be done.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// checkHealth checks how the server at dialAddr, named host, handles session resumption and
+// renegotiation. opts says how to reach the server.
+func checkHealth(ctx context.Context, d contextDialer, dialAddr, host string, opts *targetConfig) tlsHealth {
+	var h tlsHealth
+	resumed, err := checkResumption(ctx, d, dialAddr, host, opts)
+	if err != nil {
+		h.Errors = append(h.Errors, fmt.Sprintf("session resumption: %s", err))
+	}
+	h.Resumption = resumed
+
+	secure, err := checkRenegotiation(ctx, d, dialAddr, host, opts)
+	if err != nil {
+		h.Errors = append(h.Errors, fmt.Sprintf("secure renegotiation: %s", err))
+	}
+	h.SecureRenegotiation = secure
+	return h
+}
+
+// ticketCache is a tls.ClientSessionCache for a single server that tells us when the server
+// gave us a ticket.
+type ticketCache struct {
+	tls.ClientSessionCache
+	once sync.Once
+	got  chan struct{}
+}
+
+// Put implements tls.ClientSessionCache.Put().
+func (c *ticketCache) Put(key string, cs *tls.ClientSessionState) {
+	c.ClientSessionCache.Put(key, cs)
+	if cs != nil {
+		c.once.Do(func() { close(c.got) })
+	}
+}
+
+// checkResumption connects to the server twice and reports if the second connection resumed
+// the session of the first.
+func checkResumption(ctx context.Context, d contextDialer, dialAddr, host string, opts *targetConfig) (bool, error) {
+	cache := &ticketCache{ClientSessionCache: tls.NewLRUClientSessionCache(1), got: make(chan struct{})}
+	conf := opts.tlsConfig(host)
+	conf.ClientSessionCache = cache
+
+	conn, err := dialTLS(ctx, d, dialAddr, conf, opts.STARTTLS)
+	if err != nil {
+		return false, err
+	}
+	// TLS 1.3 tickets come after the handshake, and are only read along with application data.
+	// The read ends when we close the connection.
+	go conn.Read(make([]byte, 1))
+	select {
+	case <-cache.got:
+	case <-time.After(ticketWait):
+	case <-ctx.Done():
+	}
+	conn.Close()
+
+	select {
+	case <-cache.got:
+	default:
+		// Without a ticket there is nothing to resume.
+		return false, nil
+	}
+	conn, err = dialTLS(ctx, d, dialAddr, conf, opts.STARTTLS)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().DidResume, nil
+}
+
+// checkRenegotiation does a TLS 1.2 handshake with the server and reports if its ServerHello
+// has the renegotiation_info extension, which says it supports secure renegotiation. It returns
+// nil if the server doesn't speak TLS 1.2.
+func checkRenegotiation(ctx context.Context, d contextDialer, dialAddr, host string, opts *targetConfig) (*bool, error) {
+	conf := opts.tlsConfig(host)
+	conf.MinVersion, conf.MaxVersion = tls.VersionTLS10, tls.VersionTLS12
+	rd := &recordingDialer{contextDialer: d}
+	conn, err := dialTLS(ctx, rd, dialAddr, conf, opts.STARTTLS)
+	if err != nil {
+		// A server that only speaks TLS 1.3 turns us down with a protocol_version alert, it
+		// can't renegotiate at all.
+		if rd.conn != nil && serverAlert(rd.conn.serverHello()) == alertProtocolVersion {
+			return nil, nil
+		}
+		return nil, err
+	}
+	conn.Close()
+	exts, err := serverHelloExtensions(rd.conn.serverHello())
+	if err != nil {
+		return nil, err
+	}
+	secure := exts[extensionRenegotiationInfo]
+	return &secure, nil
+}
+
+// recordingDialer is a contextDialer that records what the server sends in the TLS handshake of
+// the connection it makes.
+type recordingDialer struct {
+	contextDialer
+	conn *recordingConn
+}
+
+// DialContext implements contextDialer.DialContext().
+func (r *recordingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := r.contextDialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	r.conn = &recordingConn{Conn: conn}
+	return r.conn, nil
+}
+
+// recordingConn records what the server sends once we start a TLS handshake, which skips
+// anything we read for STARTTLS.
+type recordingConn struct {
+	net.Conn
+	mu        sync.Mutex
+	handshake bool
+	read      []byte
+}
+
+// Write implements net.Conn.Write().
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	// 22 is the type of a TLS handshake record, which our ClientHello is sent in.
+	if len(b) > 0 && b[0] == 22 {
+		c.handshake = true
+	}
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+// Read implements net.Conn.Read().
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mu.Lock()
+	// The ServerHello is in the first few records, there is no need to keep the rest.
+	if c.handshake && len(c.read) < 16<<10 {
+		c.read = append(c.read, b[:n]...)
+	}
+	c.mu.Unlock()
+	return n, err
+}
+
+// serverHello returns what the server sent once the handshake started.
+func (c *recordingConn) serverHello() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.read
+}
+
+// serverAlert returns the description of the TLS alert at the start of b, the records a server
+// sent in its handshake, or 0 if it didn't start with one.
+func serverAlert(b []byte) uint8 {
+	var typ, level, desc uint8
+	s := cryptobyte.String(b)
+	// 21 is the type of an alert record, which has the alert's level and description.
+	if !s.ReadUint8(&typ) || typ != 21 || !s.Skip(2+2) || !s.ReadUint8(&level) || !s.ReadUint8(&desc) {
+		return 0
+	}
+	return desc
+}
+
+// serverHelloExtensions returns the types of the extensions in the ServerHello at the start of
+// b, the records a server sent in its handshake.
+func serverHelloExtensions(b []byte) (map[uint16]bool, error) {
+	// A handshake message can be split across records, so put the handshake records back together.
+	var msgs []byte
+	records := cryptobyte.String(b)
+	for !records.Empty() {
+		var typ uint8
+		var fragment cryptobyte.String
+		if !records.ReadUint8(&typ) || !records.Skip(2) || !records.ReadUint16LengthPrefixed(&fragment) || typ != 22 {
+			break
+		}
+		msgs = append(msgs, fragment...)
+	}
+
+	var typ uint8
+	var hello cryptobyte.String
+	s := cryptobyte.String(msgs)
+	if !s.ReadUint8(&typ) || typ != 2 || !s.ReadUint24LengthPrefixed(&hello) {
+		return nil, errors.New("the server didn't send a ServerHello")
+	}
+	var sessionID, extensions cryptobyte.String
+	// The version, random, session ID, cipher suite and compression method come first.
+	if !hello.Skip(2+32) || !hello.ReadUint8LengthPrefixed(&sessionID) || !hello.Skip(2+1) {
+		return nil, errors.New("the ServerHello is malformed")
+	}
+	exts := map[uint16]bool{}
+	if hello.Empty() {
+		return exts, nil
+	}
+	if !hello.ReadUint16LengthPrefixed(&extensions) {
+		return nil, errors.New("the ServerHello is malformed")
+	}
+	for !extensions.Empty() {
+		var ext uint16
+		var data cryptobyte.String
+		if !extensions.ReadUint16(&ext) || !extensions.ReadUint16LengthPrefixed(&data) {
+			return nil, errors.New("the ServerHello is malformed")
+		}
+		exts[ext] = true
+	}
+	return exts, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var historyFile = flag.String("history", "", "The path to a json file each run is saved to. Hosts whose issuer, key algorithm, chain length or TLS version changed since the last run are reported with a warning status")
+
+// history is the results of the last run, keyed by resultKey().
+type history map[string]result
+
+// loadHistory reads the last run from -history. It returns nil if -history isn't set or this
+// is the first run.
+func loadHistory() (history, error) {
+	if *historyFile == "" {
+		return nil, nil
+	}
+	r, err := readRun(*historyFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("-history: %s", err)
+	}
+	h := history{}
+	for _, v := range r.Results {
+		h[v.resultKey()] = v
+	}
+	return h, nil
+}
+
+// annotate records in v.Changes how the handshake with v.HostPort differs from the last run.
+// These aren't policy violations, but a new issuer or an extra certificate in the chain is
+// often the first sign of a bad deploy or a middlebox intercepting our traffic.
+func (h history) annotate(v *result) {
+	last, ok := h[v.resultKey()]
+	if !ok || last.Status == statusError || v.Status == statusError {
+		return
+	}
+
+	changed := func(what, was, is string) {
+		if was != is {
+			v.Changes = append(v.Changes, fmt.Sprintf("%s changed from %q to %q", what, was, is))
+		}
+	}
+	changed("issuer", last.Issuer, v.Issuer)
+	changed("key algorithm", last.KeyAlgorithm, v.KeyAlgorithm)
+	changed("TLS version", last.TLSVersion, v.TLSVersion)
+	if last.ChainLength != v.ChainLength {
+		v.Changes = append(v.Changes, fmt.Sprintf("chain length changed from %d to %d", last.ChainLength, v.ChainLength))
+	}
+
+	if len(v.Changes) > 0 {
+		v.find(findingChanged)
+	}
+}
+
+// saveHistory writes r to -history so the next run can compare against it. We write to a
+// temporary file and rename it, so a crash never leaves a partial history behind.
+func saveHistory(r run) error {
+	if *historyFile == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(*historyFile), ".history-*")
+	if err != nil {
+		return fmt.Errorf("could not save -history: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not save -history: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not save -history: %s", err)
+	}
+	return os.Rename(tmp.Name(), *historyFile)
+}
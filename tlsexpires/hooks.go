@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var onExpiringExec = flag.String("on-expiring-exec", "", `A command to run once for each certificate that expires within -warn-days once the scan is done, like "./renew.sh {{.Server}} {{.Port}}", to renew it or file a ticket. Each argument is a Go template of the result of the first host serving the certificate, like .Server, .Port, .HostPort, .ExpiresOn and .Labels, and .Hosts, every host:port that serves it. The command isn't run by a shell, so a hostname can't inject anything, quote arguments with spaces. It runs on every scan the certificate is still expiring, so it should do nothing when its work is already done`)
+
+// hookTimeout is how long -on-expiring-exec may run for each certificate.
+const hookTimeout = 5 * time.Minute
+
+// hook is a parsed -on-expiring-exec.
+type hook struct {
+	// args are the templates of the command's arguments, the first of which is the command.
+	args []*template.Template
+}
+
+// parseHook parses -on-expiring-exec. It returns nil if it isn't set.
+func parseHook() (*hook, error) {
+	if *onExpiringExec == "" {
+		return nil, nil
+	}
+	words, err := splitCommand(*onExpiringExec)
+	if err != nil {
+		return nil, fmt.Errorf("-on-expiring-exec: %s", err)
+	}
+	h := &hook{}
+	for _, w := range words {
+		t, err := template.New("").Funcs(template.FuncMap{"join": strings.Join, "labels": labelString}).Parse(w)
+		if err != nil {
+			return nil, fmt.Errorf("-on-expiring-exec: %s", err)
+		}
+		h.args = append(h.args, t)
+	}
+	return h, nil
+}
+
+// splitCommand splits cmd into words at spaces, like a shell. Single and double quotes keep
+// spaces in a word, and so do template actions, so {{ .Server }} is one word.
+func splitCommand(cmd string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote byte
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+				continue
+			}
+			word.WriteByte(c)
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case strings.HasPrefix(cmd[i:], "{{"):
+			end := strings.Index(cmd[i:], "}}")
+			if end < 0 {
+				return nil, fmt.Errorf("%q has an unclosed {{", cmd)
+			}
+			word.WriteString(cmd[i : i+end+2])
+			i += end + 1
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("%q has an unclosed %c", cmd, quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("%q has no command", cmd)
+	}
+	return words, nil
+}
+
+// hookCert is what the templates of -on-expiring-exec are executed with.
+type hookCert struct {
+	// result is the result of the first host that serves the certificate.
+	result
+	// Hosts are the host:ports that serve the certificate, in the order of their results.
+	Hosts []string
+}
+
+// expiringCerts returns a hookCert for each expiring certificate in results, in the order of
+// the first host with each. Results without a fingerprint each get their own.
+func expiringCerts(results []result) []hookCert {
+	var certs []hookCert
+	index := map[string]int{}
+	for _, v := range results {
+		if !slices.Contains(v.Findings, findingExpiring) {
+			continue
+		}
+		i, ok := index[v.Fingerprint]
+		if !ok || v.Fingerprint == "" {
+			index[v.Fingerprint] = len(certs)
+			certs = append(certs, hookCert{result: v, Hosts: []string{v.HostPort}})
+			continue
+		}
+		// With -all-ips a host has a result for each of its addresses.
+		if !slices.Contains(certs[i].Hosts, v.HostPort) {
+			certs[i].Hosts = append(certs[i].Hosts, v.HostPort)
+		}
+	}
+	return certs
+}
+
+// command returns the command h runs for c.
+func (h *hook) command(c hookCert) ([]string, error) {
+	var args []string
+	for _, t := range h.args {
+		var b strings.Builder
+		if err := t.Execute(&b, c); err != nil {
+			return nil, err
+		}
+		args = append(args, b.String())
+	}
+	return args, nil
+}
+
+// runHooks runs h once for every certificate in results that is expiring, one at a time, even
+// when several hosts serve it. Their output goes to stderr, so it doesn't mix with ours. It
+// returns an error if any of them failed, after running them all.
+func runHooks(ctx context.Context, h *hook, results []result) error {
+	if h == nil {
+		return nil
+	}
+	failed := 0
+	for _, c := range expiringCerts(results) {
+		args, err := h.command(c)
+		if err == nil {
+			err = runHook(ctx, args)
+		}
+		if err != nil {
+			log.Printf("-on-expiring-exec for %s failed: %s", c.HostPort, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("-on-expiring-exec failed for %d certificates", failed)
+	}
+	return nil
+}
+
+// runHook runs args, giving up after hookTimeout.
+func runHook(ctx context.Context, args []string) error {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	return cmd.Run()
+}
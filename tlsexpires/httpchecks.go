@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+var checkHTTP = flag.Bool("http-checks", false, "Also send a HEAD request to each server over the connection we checked it with, and report if it sends HSTS (Strict-Transport-Security) and for how long, if it stapled an OCSP response, and if plain HTTP on port 80 of the host redirects to HTTPS, for the TLS posture of web servers in one scan. A server without HSTS, or whose port 80 answers without redirecting to HTTPS, gets a warning. Not done for STARTTLS targets")
+
+// hstsHeader is the header a server sends HSTS in, see RFC 6797.
+const hstsHeader = "Strict-Transport-Security"
+
+// httpPosture is what the HTTP follow-up checks of -http-checks found.
+type httpPosture struct {
+	// Status is the status line of the response to our HEAD request, like "200 OK".
+	Status string `json:"status,omitempty"`
+	// HSTS is if the server sent an HSTS header with a max-age above 0.
+	HSTS bool `json:"hsts"`
+	// HSTSMaxAge is how long, in seconds, the HSTS header tells browsers to only use HTTPS.
+	HSTSMaxAge int64 `json:"hstsMaxAge,omitempty"`
+	// HSTSIncludeSubDomains and HSTSPreload are if the HSTS header has those directives.
+	HSTSIncludeSubDomains bool `json:"hstsIncludeSubDomains,omitempty"`
+	HSTSPreload           bool `json:"hstsPreload,omitempty"`
+	// OCSPStapled is if the server stapled an OCSP response to the handshake.
+	OCSPStapled bool `json:"ocspStapled"`
+	// RedirectsToHTTPS is if plain HTTP on port 80 redirects to HTTPS. It isn't set if we
+	// couldn't ask, like when nothing listens on port 80.
+	RedirectsToHTTPS *bool `json:"redirectsToHttps,omitempty"`
+	// Redirect is where plain HTTP redirected to.
+	Redirect string `json:"redirect,omitempty"`
+	// Errors are why any of the checks couldn't be done.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// checkHTTPPosture does the HTTP follow-up checks of the server at dialAddr, named host, over conn,
+// the connection we checked it with. d is used to connect to port 80.
+func checkHTTPPosture(ctx context.Context, d contextDialer, conn *tls.Conn, dialAddr, host string) *httpPosture {
+	cs := conn.ConnectionState()
+	p := &httpPosture{OCSPStapled: len(cs.OCSPResponse) > 0}
+
+	resp, err := headOverConn(ctx, conn, host)
+	if err != nil {
+		p.Errors = append(p.Errors, fmt.Sprintf("HEAD over TLS: %s", err))
+	} else {
+		p.Status = resp.Status
+		p.parseHSTS(resp.Header.Get(hstsHeader))
+	}
+
+	redirect, err := httpRedirect(ctx, d, dialAddr, host)
+	if err != nil {
+		p.Errors = append(p.Errors, fmt.Sprintf("plain HTTP: %s", err))
+		return p
+	}
+	p.Redirect = redirect
+	toHTTPS := strings.HasPrefix(strings.ToLower(redirect), "https://")
+	p.RedirectsToHTTPS = &toHTTPS
+	return p
+}
+
+// parseHSTS sets what p says about HSTS from v, the value of its header.
+func (p *httpPosture) parseHSTS(v string) {
+	for _, directive := range strings.Split(v, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "max-age":
+			p.HSTSMaxAge, _ = strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+		case "includesubdomains":
+			p.HSTSIncludeSubDomains = true
+		case "preload":
+			p.HSTSPreload = true
+		}
+	}
+	// max-age=0 tells browsers to forget HSTS for the host.
+	p.HSTS = p.HSTSMaxAge > 0
+}
+
+// headOverConn sends a HEAD request for / on host to conn and returns the response, speaking
+// HTTP/2 if that is what we negotiated with ALPN.
+func headOverConn(ctx context.Context, conn *tls.Conn, host string) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, (&url.URL{Scheme: "https", Host: host, Path: "/"}).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if conn.ConnectionState().NegotiatedProtocol == "h2" {
+		cc, err := (&http2.Transport{}).NewClientConn(conn)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := cc.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return resp, nil
+	}
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	defer conn.SetDeadline(time.Time{})
+	return roundTrip(conn, req)
+}
+
+// httpRedirect sends a HEAD request for / on host to port 80 of dialAddr, over plain HTTP, and
+// returns where the server redirected to, or "" if it didn't.
+func httpRedirect(ctx context.Context, d contextDialer, dialAddr, host string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	addr, _, _ := net.SplitHostPort(dialAddr)
+	conn, err := dialCached(ctx, d, net.JoinHostPort(addr, "80"))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, (&url.URL{Scheme: "http", Host: host, Path: "/"}).String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := roundTrip(conn, req)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode < 300 || resp.StatusCode > 399 {
+		return "", nil
+	}
+	return resp.Header.Get("Location"), nil
+}
+
+// roundTrip writes req to conn as HTTP/1.1 and reads the response. The connection is closed
+// after it, so the server doesn't wait for another request.
+func roundTrip(conn net.Conn, req *http.Request) (*http.Response, error) {
+	req.Close = true
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
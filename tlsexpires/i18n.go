@@ -0,0 +1,514 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+var lang = flag.String("lang", "", "The language of the text output: en, es, de or ja. Defaults to the language in $LANG, or en")
+
+// messages is our message catalog. It holds the human readable text of our output in every
+// language we support, keyed by language and then message. Messages are fmt format strings.
+// A message a language is missing falls back to English, and json output is never translated.
+var messages = map[string]map[string]string{
+	"en": {
+		"checking":           "Checking cerificate for server",
+		"address":            "Address",
+		"resumption":         "Session resumption",
+		"pqcGroup":           "Post-quantum %s",
+		"pqcReady":           "Accept post-quantum key exchange: %d of %d hosts",
+		"pqcNegotiated":      "Negotiate post-quantum key exchange: %d of %d hosts",
+		"trustStore":         "Trusted by %s",
+		"trustedBy":          "Trusted by %s: %d of %d hosts",
+		"tlsa":               "TLSA, matched",
+		"dnssec":             "TLSA signed with DNSSEC",
+		"httpStatus":         "HTTP status",
+		"hsts":               "HSTS",
+		"ocspStapled":        "OCSP stapled",
+		"httpRedirect":       "HTTP redirects to HTTPS",
+		"renegotiation":      "Secure renegotiation",
+		"yes":                "yes",
+		"no":                 "no",
+		"acmeRenew":          "Renew with ACME after",
+		"ariWindow":          "ARI renewal window",
+		"labels":             "Labels",
+		"version":            "Version",
+		"alpn":               "ALPN",
+		"alpnPath":           "ALPN %s",
+		"issuedOn":           "Issued On",
+		"expiresOn":          "Expires On",
+		"inDays":             "In %d days",
+		"serial":             "Serial",
+		"issuer":             "Issuer",
+		"sans":               "Names",
+		"fingerprint":        "SHA-256 Fingerprint",
+		"certificate":        "Certificate",
+		"status":             "Status",
+		"servedBy":           "Hosts (%d)",
+		"subjectKeyID":       "Subject Key ID",
+		"key":                "Key",
+		"signature":          "Signature",
+		"weakness":           "Weakness",
+		"changed":            "Changed",
+		"mismatch":           "Mismatch",
+		"protocols":          "Protocols",
+		"cipherSuites":       "TLS %s Cipher Suites",
+		"error":              "error",
+		"summary":            "Summary",
+		"report":             "TLS certificate report",
+		"expired":            "Expired",
+		"expiresLater":       "Expiring in 90 days or more",
+		"host":               "Host",
+		"days":               "Days",
+		"findings":           "Findings",
+		"reason":             "Reason",
+		"hostsChecked":       "Hosts checked",
+		"succeeded":          "Succeeded",
+		"failed":             "Failed",
+		"expiringIn":         "Expiring within %d days",
+		"minDays":            "Minimum days remaining",
+		"soonest":            "Soonest to expire",
+		"soonestOn":          "%s on %s",
+		"interrupted":        "Interrupted, these results are partial",
+		"finished":           "Finished",
+		"degraded":           "%s failed %d of %d times, last error",
+		"lookups":            "External lookups",
+		"transferred":        "Sent %d bytes, received %d bytes",
+		"timings":            "Timings",
+		"cachedAt":           "Checked at (from -cache)",
+		"latency":            "Latency",
+		"phaseDNS":           "DNS",
+		"phaseConnect":       "Connect",
+		"phaseSTARTTLS":      "STARTTLS",
+		"phaseHandshake":     "Handshake",
+		"phaseTotal":         "Total",
+		"hostCount":          "%d hosts",
+		"forecast":           "Renewal forecast",
+		"forecastCerts":      "%d certificates",
+		"later":              "Later",
+		"overBudget":         "Over budget",
+		"server":             "Server",
+		"tlsVersion":         "TLS Version",
+		"cipherSuite":        "Cipher Suite",
+		"chainVerification":  "Chain Verification",
+		"verifyFailed":       "FAILED: %s",
+		"ok":                 "ok",
+		"certificateN":       "Certificate %d (%s)",
+		"leaf":               "leaf",
+		"intermediate":       "intermediate",
+		"root":               "root",
+		"subject":            "Subject",
+		"notBefore":          "Not Before",
+		"notAfter":           "Not After",
+		"publicKey":          "Public Key",
+		"signatureAlgorithm": "Signature Algorithm",
+		"extensions":         "Extensions",
+		"critical":           "critical",
+		"scts":               "Signed Certificate Timestamps",
+		"none":               "none",
+		"sctVerified":        "verified",
+		"sctNotVerified":     "NOT VERIFIED: %s",
+		"sctAt":              "%s: %s at %s, %s",
+		"crtshLogged":        "certificate is logged",
+		"crtshNotLogged":     "certificate is NOT logged",
+		"noIssuerOCSP":       "server didn't send the issuing certificate, so we can't check OCSP",
+		"source":             "Source",
+		"thisUpdate":         "This Update",
+		"nextUpdate":         "Next Update",
+		"revokedAt":          "Revoked At",
+		"noCRL":              "no certificates in the chain have CRL distribution points",
+		"crlRevoked":         "REVOKED at %s (%s)",
+		"crlNotRevoked":      "not revoked (%s)",
+		"policyFindings":     "Policy Findings",
+		"conforms":           "conforms",
+		"deviates":           "DEVIATES",
+		"conformanceTally":   "%d hosts conform, %d hosts deviate from the manifest",
+	},
+	"es": {
+		"checking":           "Comprobando el certificado del servidor",
+		"address":            "Dirección",
+		"resumption":         "Reanudación de sesión",
+		"pqcGroup":           "Poscuántico %s",
+		"pqcReady":           "Aceptan intercambio de claves poscuántico: %d de %d hosts",
+		"pqcNegotiated":      "Negocian intercambio de claves poscuántico: %d de %d hosts",
+		"trustStore":         "De confianza para %s",
+		"trustedBy":          "De confianza para %s: %d de %d hosts",
+		"tlsa":               "TLSA, coincide",
+		"dnssec":             "TLSA firmado con DNSSEC",
+		"httpStatus":         "Estado HTTP",
+		"hsts":               "HSTS",
+		"ocspStapled":        "OCSP grapado",
+		"httpRedirect":       "HTTP redirige a HTTPS",
+		"renegotiation":      "Renegociación segura",
+		"yes":                "sí",
+		"no":                 "no",
+		"acmeRenew":          "Renovar con ACME después de",
+		"ariWindow":          "Ventana de renovación ARI",
+		"labels":             "Etiquetas",
+		"version":            "Versión",
+		"alpn":               "ALPN",
+		"alpnPath":           "ALPN %s",
+		"issuedOn":           "Emitido el",
+		"expiresOn":          "Caduca el",
+		"inDays":             "En %d días",
+		"serial":             "Número de serie",
+		"issuer":             "Emisor",
+		"sans":               "Nombres",
+		"fingerprint":        "Huella SHA-256",
+		"certificate":        "Certificado",
+		"status":             "Estado",
+		"servedBy":           "Hosts (%d)",
+		"subjectKeyID":       "Identificador de clave del sujeto",
+		"key":                "Clave",
+		"signature":          "Firma",
+		"weakness":           "Debilidad",
+		"changed":            "Cambio",
+		"mismatch":           "Discrepancia",
+		"protocols":          "Protocolos",
+		"cipherSuites":       "Conjuntos de cifrado de TLS %s",
+		"error":              "error",
+		"summary":            "Resumen",
+		"report":             "Informe de certificados TLS",
+		"expired":            "Caducados",
+		"expiresLater":       "Caducan en 90 días o más",
+		"host":               "Host",
+		"days":               "Días",
+		"findings":           "Hallazgos",
+		"reason":             "Motivo",
+		"hostsChecked":       "Hosts comprobados",
+		"succeeded":          "Correctos",
+		"failed":             "Fallidos",
+		"expiringIn":         "Caducan en %d días o menos",
+		"minDays":            "Mínimo de días restantes",
+		"soonest":            "El primero en caducar",
+		"soonestOn":          "%s el %s",
+		"interrupted":        "Interrumpido, estos resultados son parciales",
+		"finished":           "Terminado",
+		"degraded":           "%s falló %d de %d veces, último error",
+		"lookups":            "Consultas externas",
+		"transferred":        "Enviados %d bytes, recibidos %d bytes",
+		"timings":            "Tiempos",
+		"cachedAt":           "Comprobado el (de -cache)",
+		"latency":            "Latencia",
+		"phaseDNS":           "DNS",
+		"phaseConnect":       "Conexión",
+		"phaseSTARTTLS":      "STARTTLS",
+		"phaseHandshake":     "Negociación",
+		"phaseTotal":         "Total",
+		"hostCount":          "%d hosts",
+		"forecast":           "Previsión de renovaciones",
+		"forecastCerts":      "%d certificados",
+		"later":              "Después",
+		"overBudget":         "Presupuesto superado",
+		"server":             "Servidor",
+		"tlsVersion":         "Versión de TLS",
+		"cipherSuite":        "Conjunto de cifrado",
+		"chainVerification":  "Verificación de la cadena",
+		"verifyFailed":       "FALLÓ: %s",
+		"ok":                 "correcta",
+		"certificateN":       "Certificado %d (%s)",
+		"leaf":               "hoja",
+		"intermediate":       "intermedio",
+		"root":               "raíz",
+		"subject":            "Sujeto",
+		"notBefore":          "No válido antes de",
+		"notAfter":           "No válido después de",
+		"publicKey":          "Clave pública",
+		"signatureAlgorithm": "Algoritmo de firma",
+		"extensions":         "Extensiones",
+		"critical":           "crítica",
+		"scts":               "Marcas de tiempo de certificado firmadas (SCT)",
+		"none":               "ninguno",
+		"sctVerified":        "verificado",
+		"sctNotVerified":     "NO VERIFICADO: %s",
+		"sctAt":              "%s: %s el %s, %s",
+		"crtshLogged":        "el certificado está registrado",
+		"crtshNotLogged":     "el certificado NO está registrado",
+		"noIssuerOCSP":       "el servidor no envió el certificado emisor, así que no podemos comprobar OCSP",
+		"source":             "Origen",
+		"thisUpdate":         "Esta actualización",
+		"nextUpdate":         "Próxima actualización",
+		"revokedAt":          "Revocado el",
+		"noCRL":              "ningún certificado de la cadena tiene puntos de distribución de CRL",
+		"crlRevoked":         "REVOCADO el %s (%s)",
+		"crlNotRevoked":      "no revocado (%s)",
+		"policyFindings":     "Hallazgos de la política",
+		"conforms":           "cumple",
+		"deviates":           "SE DESVÍA",
+		"conformanceTally":   "%d hosts cumplen, %d hosts se desvían del manifiesto",
+	},
+	"de": {
+		"checking":           "Prüfe Zertifikat für Server",
+		"address":            "Adresse",
+		"resumption":         "Sitzungswiederaufnahme",
+		"pqcGroup":           "Post-Quanten %s",
+		"pqcReady":           "Akzeptieren Post-Quanten-Schlüsselaustausch: %d von %d Hosts",
+		"pqcNegotiated":      "Handeln Post-Quanten-Schlüsselaustausch aus: %d von %d Hosts",
+		"trustStore":         "Vertraut von %s",
+		"trustedBy":          "Vertraut von %s: %d von %d Hosts",
+		"tlsa":               "TLSA, passt",
+		"dnssec":             "TLSA mit DNSSEC signiert",
+		"httpStatus":         "HTTP-Status",
+		"hsts":               "HSTS",
+		"ocspStapled":        "OCSP-Stapling",
+		"httpRedirect":       "HTTP leitet auf HTTPS um",
+		"renegotiation":      "Sichere Neuverhandlung",
+		"yes":                "ja",
+		"no":                 "nein",
+		"acmeRenew":          "Mit ACME erneuern nach",
+		"ariWindow":          "ARI-Erneuerungsfenster",
+		"labels":             "Labels",
+		"version":            "Version",
+		"alpn":               "ALPN",
+		"alpnPath":           "ALPN %s",
+		"issuedOn":           "Ausgestellt am",
+		"expiresOn":          "Läuft ab am",
+		"inDays":             "In %d Tagen",
+		"serial":             "Seriennummer",
+		"issuer":             "Aussteller",
+		"sans":               "Namen",
+		"fingerprint":        "SHA-256-Fingerabdruck",
+		"certificate":        "Zertifikat",
+		"status":             "Status",
+		"servedBy":           "Hosts (%d)",
+		"subjectKeyID":       "Schlüsselkennung des Inhabers",
+		"key":                "Schlüssel",
+		"signature":          "Signatur",
+		"weakness":           "Schwachstelle",
+		"changed":            "Geändert",
+		"mismatch":           "Abweichung",
+		"protocols":          "Protokolle",
+		"cipherSuites":       "TLS %s Cipher-Suites",
+		"error":              "Fehler",
+		"summary":            "Zusammenfassung",
+		"report":             "TLS-Zertifikatsbericht",
+		"expired":            "Abgelaufen",
+		"expiresLater":       "Laufen in 90 Tagen oder später ab",
+		"host":               "Host",
+		"days":               "Tage",
+		"findings":           "Befunde",
+		"reason":             "Grund",
+		"hostsChecked":       "Geprüfte Hosts",
+		"succeeded":          "Erfolgreich",
+		"failed":             "Fehlgeschlagen",
+		"expiringIn":         "Laufen innerhalb von %d Tagen ab",
+		"minDays":            "Minimal verbleibende Tage",
+		"soonest":            "Läuft als Erstes ab",
+		"soonestOn":          "%s am %s",
+		"interrupted":        "Unterbrochen, diese Ergebnisse sind unvollständig",
+		"finished":           "Fertig",
+		"degraded":           "%s ist %d von %d Mal fehlgeschlagen, letzter Fehler",
+		"lookups":            "Externe Abfragen",
+		"transferred":        "%d Bytes gesendet, %d Bytes empfangen",
+		"timings":            "Zeiten",
+		"cachedAt":           "Geprüft am (aus -cache)",
+		"latency":            "Latenz",
+		"phaseDNS":           "DNS",
+		"phaseConnect":       "Verbindungsaufbau",
+		"phaseSTARTTLS":      "STARTTLS",
+		"phaseHandshake":     "Handshake",
+		"phaseTotal":         "Gesamt",
+		"hostCount":          "%d Hosts",
+		"forecast":           "Erneuerungsprognose",
+		"forecastCerts":      "%d Zertifikate",
+		"later":              "Später",
+		"overBudget":         "Budget überschritten",
+		"server":             "Server",
+		"tlsVersion":         "TLS-Version",
+		"cipherSuite":        "Cipher-Suite",
+		"chainVerification":  "Kettenprüfung",
+		"verifyFailed":       "FEHLGESCHLAGEN: %s",
+		"ok":                 "ok",
+		"certificateN":       "Zertifikat %d (%s)",
+		"leaf":               "Blatt",
+		"intermediate":       "Zwischenzertifikat",
+		"root":               "Wurzel",
+		"subject":            "Inhaber",
+		"notBefore":          "Nicht gültig vor",
+		"notAfter":           "Nicht gültig nach",
+		"publicKey":          "Öffentlicher Schlüssel",
+		"signatureAlgorithm": "Signaturalgorithmus",
+		"extensions":         "Erweiterungen",
+		"critical":           "kritisch",
+		"scts":               "Signierte Zertifikatszeitstempel (SCT)",
+		"none":               "keine",
+		"sctVerified":        "verifiziert",
+		"sctNotVerified":     "NICHT VERIFIZIERT: %s",
+		"sctAt":              "%s: %s am %s, %s",
+		"crtshLogged":        "Zertifikat ist protokolliert",
+		"crtshNotLogged":     "Zertifikat ist NICHT protokolliert",
+		"noIssuerOCSP":       "der Server hat das ausstellende Zertifikat nicht gesendet, daher können wir OCSP nicht prüfen",
+		"source":             "Quelle",
+		"thisUpdate":         "Diese Aktualisierung",
+		"nextUpdate":         "Nächste Aktualisierung",
+		"revokedAt":          "Widerrufen am",
+		"noCRL":              "kein Zertifikat der Kette hat CRL-Verteilungspunkte",
+		"crlRevoked":         "WIDERRUFEN am %s (%s)",
+		"crlNotRevoked":      "nicht widerrufen (%s)",
+		"policyFindings":     "Richtlinienbefunde",
+		"conforms":           "entspricht",
+		"deviates":           "WEICHT AB",
+		"conformanceTally":   "%d Hosts entsprechen dem Manifest, %d Hosts weichen davon ab",
+	},
+	"ja": {
+		"checking":           "サーバーの証明書を確認中",
+		"address":            "アドレス",
+		"resumption":         "セッション再開",
+		"pqcGroup":           "耐量子 %s",
+		"pqcReady":           "耐量子鍵交換を受け入れる: %d / %d ホスト",
+		"pqcNegotiated":      "耐量子鍵交換を選択する: %d / %d ホスト",
+		"trustStore":         "%s で信頼",
+		"trustedBy":          "%s で信頼: %d / %d ホスト",
+		"tlsa":               "TLSA、一致",
+		"dnssec":             "TLSA の DNSSEC 署名",
+		"httpStatus":         "HTTP ステータス",
+		"hsts":               "HSTS",
+		"ocspStapled":        "OCSP ステープリング",
+		"httpRedirect":       "HTTP から HTTPS へのリダイレクト",
+		"renegotiation":      "セキュアな再ネゴシエーション",
+		"yes":                "はい",
+		"no":                 "いいえ",
+		"acmeRenew":          "ACME での更新予定",
+		"ariWindow":          "ARI 更新期間",
+		"labels":             "ラベル",
+		"version":            "バージョン",
+		"alpn":               "ALPN",
+		"alpnPath":           "ALPN %s",
+		"issuedOn":           "発行日",
+		"expiresOn":          "有効期限",
+		"inDays":             "残り %d 日",
+		"serial":             "シリアル番号",
+		"issuer":             "発行者",
+		"sans":               "名前",
+		"fingerprint":        "SHA-256 フィンガープリント",
+		"certificate":        "証明書",
+		"status":             "ステータス",
+		"servedBy":           "ホスト (%d)",
+		"subjectKeyID":       "サブジェクト鍵識別子",
+		"key":                "鍵",
+		"signature":          "署名",
+		"weakness":           "脆弱性",
+		"changed":            "変更",
+		"mismatch":           "不一致",
+		"protocols":          "プロトコル",
+		"cipherSuites":       "TLS %s 暗号スイート",
+		"error":              "エラー",
+		"summary":            "概要",
+		"report":             "TLS 証明書レポート",
+		"expired":            "期限切れ",
+		"expiresLater":       "90 日以上先に期限切れ",
+		"host":               "ホスト",
+		"days":               "日数",
+		"findings":           "検出事項",
+		"reason":             "理由",
+		"hostsChecked":       "確認したホスト数",
+		"succeeded":          "成功",
+		"failed":             "失敗",
+		"expiringIn":         "%d 日以内に期限切れ",
+		"minDays":            "最小残り日数",
+		"soonest":            "最も早く期限切れになるもの",
+		"soonestOn":          "%s（%s）",
+		"interrupted":        "中断されました。結果は一部のみです",
+		"finished":           "完了",
+		"degraded":           "%s: %d / %d 回失敗、最後のエラー",
+		"lookups":            "外部への問い合わせ",
+		"transferred":        "送信 %d バイト、受信 %d バイト",
+		"timings":            "所要時間",
+		"cachedAt":           "確認日時 (-cache から)",
+		"latency":            "レイテンシ",
+		"phaseDNS":           "DNS",
+		"phaseConnect":       "接続",
+		"phaseSTARTTLS":      "STARTTLS",
+		"phaseHandshake":     "ハンドシェイク",
+		"phaseTotal":         "合計",
+		"hostCount":          "%d ホスト",
+		"forecast":           "更新予測",
+		"forecastCerts":      "%d 件の証明書",
+		"later":              "それ以降",
+		"overBudget":         "予算超過",
+		"server":             "サーバー",
+		"tlsVersion":         "TLS バージョン",
+		"cipherSuite":        "暗号スイート",
+		"chainVerification":  "チェーンの検証",
+		"verifyFailed":       "失敗: %s",
+		"ok":                 "OK",
+		"certificateN":       "証明書 %d (%s)",
+		"leaf":               "リーフ",
+		"intermediate":       "中間",
+		"root":               "ルート",
+		"subject":            "サブジェクト",
+		"notBefore":          "有効期間の開始",
+		"notAfter":           "有効期間の終了",
+		"publicKey":          "公開鍵",
+		"signatureAlgorithm": "署名アルゴリズム",
+		"extensions":         "拡張",
+		"critical":           "クリティカル",
+		"scts":               "署名付き証明書タイムスタンプ (SCT)",
+		"none":               "なし",
+		"sctVerified":        "検証済み",
+		"sctNotVerified":     "未検証: %s",
+		"sctAt":              "%s: %s（%s）、%s",
+		"crtshLogged":        "証明書はログに記録されています",
+		"crtshNotLogged":     "証明書はログに記録されていません",
+		"noIssuerOCSP":       "サーバーが発行者の証明書を送らなかったため、OCSP を確認できません",
+		"source":             "取得元",
+		"thisUpdate":         "今回の更新",
+		"nextUpdate":         "次回の更新",
+		"revokedAt":          "失効日時",
+		"noCRL":              "チェーン内のどの証明書にも CRL 配布ポイントがありません",
+		"crlRevoked":         "失効 %s (%s)",
+		"crlNotRevoked":      "失効していません (%s)",
+		"policyFindings":     "ポリシーの検出事項",
+		"conforms":           "適合",
+		"deviates":           "逸脱",
+		"conformanceTally":   "%d ホストが適合、%d ホストがマニフェストから逸脱",
+	},
+}
+
+// outputLang returns the language our text output is in: -lang, or the language of $LANG if
+// we support it, or English.
+func outputLang() string {
+	if *lang != "" {
+		return *lang
+	}
+	// $LANG looks like "de_DE.UTF-8".
+	l := strings.ToLower(os.Getenv("LANG"))
+	if i := strings.IndexAny(l, "_.@"); i >= 0 {
+		l = l[:i]
+	}
+	if _, ok := messages[l]; ok {
+		return l
+	}
+	return "en"
+}
+
+// checkLang returns an error if -lang is a language we don't have messages for.
+func checkLang() error {
+	if *lang == "" {
+		return nil
+	}
+	if _, ok := messages[*lang]; ok {
+		return nil
+	}
+	var langs []string
+	for l := range messages {
+		langs = append(langs, l)
+	}
+	sort.Strings(langs)
+	return fmt.Errorf("-lang=%s is not supported, use one of %s", *lang, strings.Join(langs, ", "))
+}
+
+// msg returns the message with key in our output language, formatted with args.
+func msg(key string, args ...any) string {
+	m, ok := messages[outputLang()][key]
+	if !ok {
+		m = messages["en"][key]
+	}
+	if len(args) == 0 {
+		return m
+	}
+	return fmt.Sprintf(m, args...)
+}
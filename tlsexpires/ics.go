@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// alarmFlags is a flag.Value for -ics-alarms, a list of how long before an expiry to remind.
+type alarmFlags []time.Duration
+
+var icsAlarms = alarmFlags{30 * 24 * time.Hour, 7 * 24 * time.Hour}
+
+func init() {
+	flag.Var(&icsAlarms, "ics-alarms", "How long before each expiry the events of the ics output remind, separated by commas like 30d,7d,1d. Empty has no reminders")
+}
+
+// String implements flag.Value.String().
+func (a *alarmFlags) String() string {
+	var out []string
+	for _, d := range *a {
+		dd := dayDuration(d)
+		out = append(out, dd.String())
+	}
+	return strings.Join(out, ",")
+}
+
+// Set implements flag.Value.Set().
+func (a *alarmFlags) Set(s string) error {
+	var alarms alarmFlags
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		d, err := parseDayDuration(part)
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return fmt.Errorf("-ics-alarms must be positive, had %q", part)
+		}
+		alarms = append(alarms, d)
+	}
+	*a = alarms
+	return nil
+}
+
+// icsSink writes an iCalendar (RFC 5545) file with an all day event on the day each certificate
+// expires, for teams to subscribe their calendar to. A certificate served by many hosts is one
+// event, and each event's UID is the certificate's fingerprint, so calendars update the events
+// they have instead of adding new ones.
+type icsSink struct {
+	w io.WriteCloser
+}
+
+// newICSSink returns an icsSink that writes to dest, a file or - for stdout.
+func newICSSink(dest string) (sink, error) {
+	w, err := createOutput(dest)
+	if err != nil {
+		return nil, err
+	}
+	return &icsSink{w: w}, nil
+}
+
+// Write implements sink.Write(). The calendar is written as a whole by Flush.
+func (s *icsSink) Write(v result) error {
+	return nil
+}
+
+// Flush implements sink.Flush().
+func (s *icsSink) Flush(r run) error {
+	stamp := r.Started.UTC().Format("20060102T150405Z")
+	lines := []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//" + incidentSource + "//EN", "CALSCALE:GREGORIAN", "X-WR-CALNAME:TLS certificate expirations"}
+	groups, _ := groupByFingerprint(filterOutput(r.Results))
+	for _, g := range groups {
+		c := g.Certificate
+		if c.ExpiresOn.IsZero() || c.ExpiresOn.Equal(sshCertForever) {
+			continue
+		}
+		day := c.ExpiresOn.UTC()
+		summary := fmt.Sprintf("TLS certificate of %s expires", g.Hosts[0])
+		if len(g.Hosts) > 1 {
+			summary = fmt.Sprintf("TLS certificate of %s and %d more hosts expires", g.Hosts[0], len(g.Hosts)-1)
+		}
+		desc := fmt.Sprintf("Expires on %s.\nServed by: %s\nNames: %s\nIssuer: %s\nSHA-256 Fingerprint: %s",
+			c.ExpiresOn.UTC().Format(time.RFC3339), strings.Join(g.Hosts, ", "), strings.Join(c.SANs, ", "), c.Issuer, c.Fingerprint)
+		if len(c.Labels) > 0 {
+			desc += "\nLabels: " + labelString(c.Labels)
+		}
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:"+strings.ToLower(strings.ReplaceAll(c.Fingerprint, ":", ""))+"@"+incidentSource,
+			"DTSTAMP:"+stamp,
+			"DTSTART;VALUE=DATE:"+day.Format("20060102"),
+			"DTEND;VALUE=DATE:"+day.AddDate(0, 0, 1).Format("20060102"),
+			"SUMMARY:"+icsText(summary),
+			"DESCRIPTION:"+icsText(desc),
+			"TRANSP:TRANSPARENT",
+		)
+		for _, a := range icsAlarms {
+			lines = append(lines, "BEGIN:VALARM", "ACTION:DISPLAY", "TRIGGER:"+icsTrigger(a), "DESCRIPTION:"+icsText(summary), "END:VALARM")
+		}
+		lines = append(lines, "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, l := range lines {
+		if _, err := io.WriteString(s.w, icsFold(l)+"\r\n"); err != nil {
+			return err
+		}
+	}
+	return s.w.Close()
+}
+
+// icsText escapes s for a TEXT value.
+func icsText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// icsTrigger returns the TRIGGER of an alarm d before the start of its event.
+func icsTrigger(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("-P%dD", d/(24*time.Hour))
+	}
+	return fmt.Sprintf("-PT%dS", int64(d/time.Second))
+}
+
+// icsFold folds l into lines of at most 75 bytes, each continuation starting with a space. It
+// doesn't split UTF-8 characters.
+func icsFold(l string) string {
+	var b strings.Builder
+	limit := 75
+	for len(l) > limit {
+		cut := limit
+		// Back up to the start of a UTF-8 character.
+		for cut > 0 && l[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(l[:cut])
+		b.WriteString("\r\n ")
+		l = l[cut:]
+		// The space starting a continuation counts toward its 75 bytes.
+		limit = 74
+	}
+	b.WriteString(l)
+	return b.String()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// crockford is the Crockford base32 alphabet ULIDs are written in.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID (https://github.com/ulid/spec) for t. ULIDs sort by time, so run IDs
+// sort in the order the runs started.
+func newULID(t time.Time) string {
+	var b [16]byte
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(t.UnixMilli()))
+	copy(b[:6], ms[2:])
+	rand.Read(b[6:])
+
+	// 128 bits is 26 base32 characters, with the first character only holding 3 bits.
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[b[15]&0x1f]
+		// Shift the whole 128 bit number right by 5.
+		for j := 15; j > 0; j-- {
+			b[j] = b[j]>>5 | b[j-1]<<3
+		}
+		b[0] >>= 5
+	}
+	return string(out)
+}
+
+// resultID returns the ID of the result for key in the run with runID. It is the same every
+// time it is computed, so anything that stores results can use it to ignore a result it already has.
+func resultID(runID, key string) string {
+	sum := sha256.Sum256([]byte(runID + "\x00" + key))
+	return hex.EncodeToString(sum[:16])
+}
+
+// assignIDs sets the ID of every result in results, which are from the run with runID.
+func assignIDs(runID string, results []result) {
+	for i := range results {
+		results[i].ID = resultID(runID, results[i].resultKey())
+	}
+}
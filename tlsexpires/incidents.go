@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	pagerDutyKey = flag.String("pagerduty-routing-key", "", "The routing key of a PagerDuty Events API v2 integration to open an incident in for each host that crosses -incident-days or that we can't check, and to resolve it once the host is fine")
+	opsgenieKey  = flag.String("opsgenie-api-key", "", "An Opsgenie API key to open an alert with for each host that crosses -incident-days or that we can't check, and to close it once the host is fine")
+	opsgenieURL  = flag.String("opsgenie-url", "https://api.opsgenie.com", "The Opsgenie API, which is https://api.eu.opsgenie.com for accounts in the EU")
+	incidentDays = flag.Int("incident-days", 7, "Certificates that expire in fewer than this many days open a PagerDuty or Opsgenie incident")
+)
+
+const (
+	// pagerDutyURL is the PagerDuty Events API v2.
+	pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	// incidentSource is the source we give PagerDuty and Opsgenie.
+	incidentSource = "tlsexpires"
+)
+
+// critical reports if v should have an incident open, and why. The why ends with v's labels, so
+// whoever is paged sees who owns the host.
+func critical(v result) (string, bool) {
+	labels := ""
+	if len(v.Labels) > 0 {
+		labels = " [" + labelString(v.Labels) + "]"
+	}
+	switch {
+	case v.Status == statusError:
+		return fmt.Sprintf("TLS check of %s%s failed: %s", v.resultKey(), labels, v.Err), true
+	case !v.ExpiresOn.IsZero() && v.ExpireInDays() < *incidentDays:
+		return fmt.Sprintf("TLS certificate of %s%s expires in %d days, on %s", v.resultKey(), labels, v.ExpireInDays(), v.ExpiresOn.Format(time.DateOnly)), true
+	}
+	return "", false
+}
+
+// queueIncidents queues the PagerDuty and Opsgenie events for r in -notify-queue: opening an
+// incident for each host that became critical and resolving the incident of each host that
+// recovered or, unless r is a Subset, is no longer checked. The hosts with an open incident are
+// kept in the queue too, so we only open an incident once and know what to resolve across runs.
+// Each incident is keyed by the host, which PagerDuty and Opsgenie use to deduplicate it. A
+// host's PagerDuty events go to the routing key of the first of routes it matches, or
+// -pagerduty-routing-key.
+func queueIncidents(r run, routes []*route) error {
+	paged := *pagerDutyKey != ""
+	for _, rt := range routes {
+		paged = paged || rt.PagerDuty != ""
+	}
+	if !paged && *opsgenieKey == "" {
+		return nil
+	}
+	ob, err := openOutbox(*notifyQueue, time.Duration(notifyMaxAge))
+	if err != nil {
+		return err
+	}
+	defer ob.Close()
+
+	open, err := ob.openIncidents()
+	if err != nil {
+		return err
+	}
+	// queue queues d, which opens the incident with key in its sink if opened is set and
+	// resolves it otherwise.
+	queue := func(d delivery, key string, opened bool, routingKey string) error {
+		if err := ob.enqueue(d); err != nil {
+			return fmt.Errorf("could not queue %s notification: %s", d.Channel, err)
+		}
+		return ob.setIncident(d.Channel+"/"+key, opened, routingKey)
+	}
+
+	checked := map[string]bool{}
+	for _, v := range r.Results {
+		key := incidentSource + "/" + v.resultKey()
+		checked[key] = true
+		summary, ok := critical(v)
+
+		// Which incidents are open is kept for each sink, so one added later still hears about
+		// hosts that were already critical.
+		if routingKey := destinationsFor(routes, v).PagerDuty; routingKey != "" {
+			if _, isOpen := open["pagerduty/"+key]; ok != isOpen {
+				if err := queue(pagerDutyEvent(routingKey, key, summary, v, ok, r.ID), key, ok, routingKey); err != nil {
+					return err
+				}
+			}
+		}
+		if _, isOpen := open["opsgenie/"+key]; *opsgenieKey != "" && ok != isOpen {
+			d, err := opsgenieEvent(key, summary, v, ok, r.ID)
+			if err != nil {
+				return err
+			}
+			if err := queue(d, key, ok, ""); err != nil {
+				return err
+			}
+		}
+	}
+	if r.Subset {
+		return nil
+	}
+
+	// The incidents of hosts that were removed from our targets would never be resolved otherwise.
+	for _, sinkKey := range slices.Sorted(maps.Keys(open)) {
+		sink, key, _ := strings.Cut(sinkKey, "/")
+		if checked[key] {
+			continue
+		}
+		var d delivery
+		switch sink {
+		case "pagerduty":
+			routingKey := open[sinkKey].RoutingKey
+			if routingKey == "" {
+				routingKey = *pagerDutyKey
+			}
+			if routingKey == "" {
+				continue
+			}
+			d = pagerDutyEvent(routingKey, key, "", result{}, false, r.ID)
+		case "opsgenie":
+			if *opsgenieKey == "" {
+				continue
+			}
+			if d, err = opsgenieEvent(key, "", result{}, false, r.ID); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		if err := queue(d, key, false, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openIncident is an incident we opened and haven't resolved.
+type openIncident struct {
+	// Opened is when we opened it.
+	Opened time.Time `json:"opened"`
+	// RoutingKey is the PagerDuty routing key we opened it with, which it is resolved with.
+	RoutingKey string `json:"routingKey,omitempty"`
+}
+
+// openIncidents returns the incidents we opened and haven't resolved, keyed by the sink and the
+// incident's key, like "pagerduty/tlsexpires/example.com:443". Incidents recorded before we
+// kept their routing key were recorded as only when they were opened.
+func (o *outbox) openIncidents() (map[string]openIncident, error) {
+	open := map[string]openIncident{}
+	err := o.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(incidentsBucket).ForEach(func(k, v []byte) error {
+			var i openIncident
+			if err := json.Unmarshal(v, &i); err != nil {
+				if err := i.Opened.UnmarshalText(v); err != nil {
+					return fmt.Errorf("incident %s in -notify-queue is corrupt: %s", k, err)
+				}
+			}
+			open[string(k)] = i
+			return nil
+		})
+	})
+	return open, err
+}
+
+// setIncident records if the incident with key is open, and the PagerDuty routing key it was
+// opened with.
+func (o *outbox) setIncident(key string, open bool, routingKey string) error {
+	return o.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(incidentsBucket)
+		if !open {
+			return b.Delete([]byte(key))
+		}
+		v, err := json.Marshal(openIncident{Opened: time.Now(), RoutingKey: routingKey})
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), v)
+	})
+}
+
+// pagerDutyEvent returns the PagerDuty Events API v2 event for the integration with routingKey
+// that triggers, if trigger is set, or resolves the incident with key.
+func pagerDutyEvent(routingKey, key, summary string, v result, trigger bool, runID string) delivery {
+	event := map[string]any{
+		"routing_key":  routingKey,
+		"event_action": "resolve",
+		"dedup_key":    key,
+	}
+	if trigger {
+		severity := "critical"
+		if v.Status == statusError {
+			severity = "error"
+		}
+		event["event_action"] = "trigger"
+		event["payload"] = map[string]any{
+			"summary":        truncate(summary, 1024),
+			"source":         v.HostPort,
+			"severity":       severity,
+			"component":      incidentSource,
+			"custom_details": v,
+		}
+	}
+	// Marshaling a map of strings and values can't fail.
+	b, _ := json.Marshal(event)
+	return delivery{
+		Key:     fmt.Sprintf("pagerduty/%s/%s/%s", event["event_action"], key, runID),
+		Channel: "pagerduty",
+		URL:     pagerDutyURL,
+		Body:    b,
+	}
+}
+
+// opsgenieEvent returns the Opsgenie request that creates, if create is set, or closes the alert
+// with the alias key.
+func opsgenieEvent(key, summary string, v result, create bool, runID string) (delivery, error) {
+	d := delivery{
+		Channel: "opsgenie",
+		Headers: map[string]string{"Authorization": "GenieKey " + *opsgenieKey},
+	}
+	var body map[string]any
+	if create {
+		priority := "P2"
+		if v.Status == statusError {
+			priority = "P1"
+		}
+		details, err := json.Marshal(v)
+		if err != nil {
+			return delivery{}, err
+		}
+		d.Key = fmt.Sprintf("opsgenie/create/%s/%s", key, runID)
+		d.URL = *opsgenieURL + "/v2/alerts"
+		body = map[string]any{
+			"message":     truncate(summary, 130),
+			"alias":       key,
+			"description": truncate(summary+"\n\n"+string(details), 15000),
+			"priority":    priority,
+			"source":      incidentSource,
+			"entity":      v.HostPort,
+			"details":     v.Labels,
+		}
+	} else {
+		d.Key = fmt.Sprintf("opsgenie/close/%s/%s", key, runID)
+		d.URL = fmt.Sprintf("%s/v2/alerts/%s/close?identifierType=alias", *opsgenieURL, url.PathEscape(key))
+		body = map[string]any{"source": incidentSource}
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return delivery{}, err
+	}
+	d.Body = b
+	return d, nil
+}
+
+// truncate returns s cut to at most n characters, which is what PagerDuty and Opsgenie allow.
+// It never cuts a character in half.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-3]) + "..."
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var strict = flag.Bool("strict", false, "Exit with an error if any optional integration (OCSP, CRL, CT, crt.sh, ACME renewalInfo, DANE TLSA lookups, Jira, ServiceNow, Kubernetes, AWS, GCP or Azure discovery) failed during the run. Without it they fail soft and are reported in the summary")
+
+// These are our optional integrations. When one fails, we carry on without it and report the
+// failure in the run's summary instead of failing the scan.
+const (
+	integrationOCSP       = "ocsp"
+	integrationCRL        = "crl"
+	integrationCT         = "ct"
+	integrationCrtSh      = "crt.sh"
+	integrationK8s        = "k8s"
+	integrationAWS        = "aws"
+	integrationGCP        = "gcp"
+	integrationAzure      = "azure"
+	integrationACME       = "acme"
+	integrationJira       = "jira"
+	integrationServiceNow = "servicenow"
+	integrationDANE       = "dane"
+)
+
+// integrationHealth is how an optional integration fared during a run.
+type integrationHealth struct {
+	// Attempts is how many times we used the integration.
+	Attempts int `json:"attempts"`
+	// Failures is how many of Attempts failed.
+	Failures int `json:"failures"`
+	// LastError is the last failure.
+	LastError string `json:"lastError,omitempty"`
+}
+
+var (
+	integrationsMu sync.Mutex
+	integrations   = map[string]*integrationHealth{}
+)
+
+// integrationUsed records that we used the integration called name, and the error if it failed.
+func integrationUsed(name string, err error) {
+	integrationsMu.Lock()
+	defer integrationsMu.Unlock()
+
+	h, ok := integrations[name]
+	if !ok {
+		h = &integrationHealth{}
+		integrations[name] = h
+	}
+	h.Attempts++
+	if err != nil {
+		h.Failures++
+		h.LastError = err.Error()
+	}
+}
+
+// resetIntegrations forgets how the integrations have done, for the start of a run.
+func resetIntegrations() {
+	integrationsMu.Lock()
+	defer integrationsMu.Unlock()
+	integrations = map[string]*integrationHealth{}
+}
+
+// integrationReport returns how every integration we used has done since the start of the run.
+func integrationReport() map[string]integrationHealth {
+	integrationsMu.Lock()
+	defer integrationsMu.Unlock()
+
+	if len(integrations) == 0 {
+		return nil
+	}
+	report := map[string]integrationHealth{}
+	for name, h := range integrations {
+		report[name] = *h
+	}
+	return report
+}
+
+// checkStrict returns an error if -strict is set and any integration in report failed.
+func checkStrict(report map[string]integrationHealth) error {
+	if !*strict {
+		return nil
+	}
+	var failed []string
+	for name, h := range report {
+		if h.Failures > 0 {
+			failed = append(failed, fmt.Sprintf("%s (%s)", name, h.LastError))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	sort.Strings(failed)
+	return fmt.Errorf("-strict: integrations failed: %s", strings.Join(failed, ", "))
+}
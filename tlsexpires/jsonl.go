@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// jsonlSyncInterval is the longest a jsonlSink goes without syncing its file to disk.
+const jsonlSyncInterval = 5 * time.Second
+
+// jsonlSink writes each result as a line of json as soon as its check finishes, so a long scan
+// can be tailed and ingested as it goes instead of once it is done. The file is synced every
+// jsonlSyncInterval, so a crash loses little of it. It is always one line per result, even with
+// -group-by-cert.
+type jsonlSink struct {
+	w        io.WriteCloser
+	enc      *json.Encoder
+	lastSync time.Time
+}
+
+// newJSONLSink returns a jsonlSink that writes to dest, a file or - for stdout.
+func newJSONLSink(dest string) (sink, error) {
+	w, err := createOutput(dest)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlSink{w: w, enc: json.NewEncoder(w), lastSync: time.Now()}, nil
+}
+
+// Write implements sink.Write().
+func (j *jsonlSink) Write(v result) error {
+	if !shouldOutput(v) {
+		return nil
+	}
+	if err := j.enc.Encode(v); err != nil {
+		return err
+	}
+	if time.Since(j.lastSync) >= jsonlSyncInterval {
+		return j.sync()
+	}
+	return nil
+}
+
+// Flush implements sink.Flush(). The results were all written by Write.
+func (j *jsonlSink) Flush(r run) error {
+	if err := j.sync(); err != nil {
+		return err
+	}
+	return j.w.Close()
+}
+
+// sync syncs our file to disk. Stdout isn't synced, it might be a pipe.
+func (j *jsonlSink) sync() error {
+	j.lastSync = time.Now()
+	if f, ok := j.w.(*os.File); ok {
+		return f.Sync()
+	}
+	return nil
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	k8sTargets   = flag.Bool("k8s", false, "Check the TLS endpoints and certificates in the Kubernetes cluster we are running in instead of -file. See -k8s-discover")
+	k8sNamespace = flag.String("k8s-namespace", "", "Only look in this namespace with -k8s. Empty is every namespace")
+	k8sDiscover  = flag.String("k8s-discover", "services,ingresses,gateways,secrets", "A comma separated list of what -k8s checks: 'services' (TLS ports), 'ingresses' (TLS hosts), 'gateways' (Gateway API HTTPS and TLS listeners) and 'secrets' (the certificates in kubernetes.io/tls secrets)")
+)
+
+// k8sSecretPrefix starts the target for a kubernetes.io/tls secret, which is followed by
+// namespace/name. We check these by reading the secret instead of connecting to anything.
+const k8sSecretPrefix = "k8s-secret:"
+
+// k8sServiceAccountDir is where Kubernetes mounts the credentials of the pod's service account.
+const k8sServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// k8sClient talks to the API server of the cluster we are running in.
+type k8sClient struct {
+	client *http.Client
+	api    string
+	token  string
+}
+
+var (
+	k8sClientOnce sync.Once
+	k8sAPI        *k8sClient
+	k8sAPIErr     error
+)
+
+// inClusterClient returns the k8sClient for the cluster we are running in, using our pod's
+// service account.
+func inClusterClient() (*k8sClient, error) {
+	k8sClientOnce.Do(func() {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			k8sAPIErr = fmt.Errorf("-k8s only works inside a Kubernetes cluster")
+			return
+		}
+		token, err := os.ReadFile(k8sServiceAccountDir + "/token")
+		if err != nil {
+			k8sAPIErr = fmt.Errorf("-k8s could not read the service account token: %s", err)
+			return
+		}
+		ca, err := os.ReadFile(k8sServiceAccountDir + "/ca.crt")
+		if err != nil {
+			k8sAPIErr = fmt.Errorf("-k8s could not read the cluster CA: %s", err)
+			return
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			k8sAPIErr = fmt.Errorf("-k8s: the cluster CA has no certificates")
+			return
+		}
+		k8sAPI = &k8sClient{
+			client: &http.Client{
+				Timeout:   time.Minute,
+				Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+			},
+			api:   "https://" + net.JoinHostPort(host, port),
+			token: strings.TrimSpace(string(token)),
+		}
+	})
+	return k8sAPI, k8sAPIErr
+}
+
+// k8sStatusError is an error status from the API server.
+type k8sStatusError struct {
+	path   string
+	status int
+	text   string
+}
+
+func (e *k8sStatusError) Error() string {
+	return fmt.Sprintf("-k8s: GET %s returned %s", e.path, e.text)
+}
+
+// get fetches path from the API server and decodes the json response into out.
+func (c *k8sClient) get(ctx context.Context, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.api+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &k8sStatusError{path: path, status: resp.StatusCode, text: resp.Status}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("-k8s: could not decode GET %s: %s", path, err)
+	}
+	return nil
+}
+
+// k8sKind is a kind of object we discover targets in.
+type k8sKind struct {
+	// path is the path to list the objects in every namespace. nsPath is the path to list them
+	// in one namespace, %s is replaced by the namespace.
+	path, nsPath string
+	// selector is a field selector for the objects we want, if any.
+	selector string
+	// optional kinds are APIs a cluster might not have, so them not being found isn't a failure.
+	optional bool
+	// targets returns the targets in a single object.
+	targets func(item json.RawMessage) ([]target, error)
+}
+
+// k8sKinds are the kinds of objects -k8s-discover can list, keyed by the name the flag uses.
+var k8sKinds = map[string]k8sKind{
+	"services": {
+		path:    "/api/v1/services",
+		nsPath:  "/api/v1/namespaces/%s/services",
+		targets: serviceTargets,
+	},
+	"ingresses": {
+		path:     "/apis/networking.k8s.io/v1/ingresses",
+		nsPath:   "/apis/networking.k8s.io/v1/namespaces/%s/ingresses",
+		optional: true,
+		targets:  ingressTargets,
+	},
+	"gateways": {
+		path:     "/apis/gateway.networking.k8s.io/v1/gateways",
+		nsPath:   "/apis/gateway.networking.k8s.io/v1/namespaces/%s/gateways",
+		optional: true,
+		targets:  gatewayTargets,
+	},
+	"secrets": {
+		path:     "/api/v1/secrets",
+		nsPath:   "/api/v1/namespaces/%s/secrets",
+		selector: "type=kubernetes.io/tls",
+		targets:  secretTargets,
+	},
+}
+
+// k8sProvider provides a target for everything -k8s-discover asks for in a Kubernetes cluster.
+// It lists objects a page at a time, so large clusters don't need to fit in memory. A host that
+// more than one object points at, like an Ingress and a Gateway, is only provided once.
+type k8sProvider struct {
+	client    *k8sClient
+	namespace string
+	kinds     []string
+
+	pending []target
+	cont    string
+	seen    map[string]bool
+}
+
+// newK8sProvider returns a k8sProvider for the cluster we are running in. An empty namespace
+// means every namespace. discover is the value of -k8s-discover.
+func newK8sProvider(namespace, discover string) (*k8sProvider, error) {
+	var kinds []string
+	for _, k := range strings.Split(discover, ",") {
+		k = strings.TrimSpace(k)
+		if _, ok := k8sKinds[k]; !ok {
+			return nil, fmt.Errorf("-k8s-discover had %q, which is not 'services', 'ingresses', 'gateways' or 'secrets'", k)
+		}
+		kinds = append(kinds, k)
+	}
+	c, err := inClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	return &k8sProvider{client: c, namespace: namespace, kinds: kinds, seen: map[string]bool{}}, nil
+}
+
+// Next implements targetProvider.Next().
+func (k *k8sProvider) Next(ctx context.Context) (target, error) {
+	for {
+		for len(k.pending) > 0 {
+			t := k.pending[0]
+			k.pending = k.pending[1:]
+			if k.seen[t.HostPort] {
+				continue
+			}
+			k.seen[t.HostPort] = true
+			return t, nil
+		}
+		if len(k.kinds) == 0 {
+			return target{}, io.EOF
+		}
+		if err := k.nextPage(ctx); err != nil {
+			return target{}, err
+		}
+	}
+}
+
+// k8sList is the part of a Kubernetes list we use.
+type k8sList struct {
+	Metadata struct {
+		Continue string `json:"continue"`
+	} `json:"metadata"`
+	Items []json.RawMessage `json:"items"`
+}
+
+// nextPage lists the next page of the kind we are on and adds their targets to k.pending.
+func (k *k8sProvider) nextPage(ctx context.Context) error {
+	name := k.kinds[0]
+	kind := k8sKinds[name]
+	path := kind.path
+	if k.namespace != "" {
+		path = fmt.Sprintf(kind.nsPath, url.PathEscape(k.namespace))
+	}
+	q := url.Values{"limit": {"500"}}
+	if k.cont != "" {
+		q.Set("continue", k.cont)
+	}
+	if kind.selector != "" {
+		q.Set("fieldSelector", kind.selector)
+	}
+
+	list := k8sList{}
+	err := k.client.get(ctx, path+"?"+q.Encode(), &list)
+	var se *k8sStatusError
+	switch {
+	case err == nil:
+		integrationUsed(integrationK8s, nil)
+	case kind.optional && errors.As(err, &se) && se.status == http.StatusNotFound:
+		log.Printf("-k8s is skipping %s, the cluster doesn't have them", name)
+		k.kinds, k.cont = k.kinds[1:], ""
+		return nil
+	default:
+		// Like all our integrations, we carry on without what we couldn't list.
+		integrationUsed(integrationK8s, fmt.Errorf("listing %s: %s", name, err))
+		log.Printf("warning: -k8s is skipping %s: %s", name, err)
+		k.kinds, k.cont = k.kinds[1:], ""
+		return nil
+	}
+
+	for _, item := range list.Items {
+		ts, err := kind.targets(item)
+		if err != nil {
+			return fmt.Errorf("-k8s: could not decode %s: %s", name, err)
+		}
+		k.pending = append(k.pending, ts...)
+	}
+	k.cont = list.Metadata.Continue
+	if k.cont == "" {
+		k.kinds = k.kinds[1:]
+	}
+	return nil
+}
+
+// k8sMetadata is the metadata of an object that we use.
+type k8sMetadata struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// serviceTargets returns the TLS ports of a Service. We consider a port to be TLS if it is 443,
+// or its name or appProtocol mentions https or tls.
+func serviceTargets(item json.RawMessage) ([]target, error) {
+	svc := struct {
+		Metadata k8sMetadata `json:"metadata"`
+		Spec     struct {
+			Ports []struct {
+				Name        string `json:"name"`
+				Port        int    `json:"port"`
+				AppProtocol string `json:"appProtocol"`
+			} `json:"ports"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(item, &svc); err != nil {
+		return nil, err
+	}
+	var ts []target
+	host := svc.Metadata.Name + "." + svc.Metadata.Namespace + ".svc"
+	for _, p := range svc.Spec.Ports {
+		name := strings.ToLower(p.Name + " " + p.AppProtocol)
+		if p.Port == 443 || strings.Contains(name, "https") || strings.Contains(name, "tls") {
+			ts = append(ts, target{HostPort: net.JoinHostPort(host, strconv.Itoa(p.Port))})
+		}
+	}
+	return ts, nil
+}
+
+// ingressTargets returns host:443 for each host an Ingress serves TLS for. Wildcard hosts are
+// skipped because there is no single name to connect to.
+func ingressTargets(item json.RawMessage) ([]target, error) {
+	ing := struct {
+		Spec struct {
+			TLS []struct {
+				Hosts []string `json:"hosts"`
+			} `json:"tls"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(item, &ing); err != nil {
+		return nil, err
+	}
+	var ts []target
+	for _, t := range ing.Spec.TLS {
+		for _, h := range t.Hosts {
+			if h != "" && !strings.HasPrefix(h, "*") {
+				ts = append(ts, target{HostPort: net.JoinHostPort(h, "443")})
+			}
+		}
+	}
+	return ts, nil
+}
+
+// gatewayTargets returns hostname:port for each HTTPS or TLS listener of a Gateway. Listeners
+// without a hostname, or with a wildcard one, are skipped because there is no single name to
+// connect to.
+func gatewayTargets(item json.RawMessage) ([]target, error) {
+	gw := struct {
+		Spec struct {
+			Listeners []struct {
+				Hostname string `json:"hostname"`
+				Port     int    `json:"port"`
+				Protocol string `json:"protocol"`
+			} `json:"listeners"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(item, &gw); err != nil {
+		return nil, err
+	}
+	var ts []target
+	for _, l := range gw.Spec.Listeners {
+		if l.Protocol != "HTTPS" && l.Protocol != "TLS" {
+			continue
+		}
+		if l.Hostname != "" && !strings.HasPrefix(l.Hostname, "*") {
+			ts = append(ts, target{HostPort: net.JoinHostPort(l.Hostname, strconv.Itoa(l.Port))})
+		}
+	}
+	return ts, nil
+}
+
+// secretTargets returns the target for a kubernetes.io/tls Secret.
+func secretTargets(item json.RawMessage) ([]target, error) {
+	s := struct {
+		Metadata k8sMetadata `json:"metadata"`
+	}{}
+	if err := json.Unmarshal(item, &s); err != nil {
+		return nil, err
+	}
+	return []target{{HostPort: k8sSecretPrefix + s.Metadata.Namespace + "/" + s.Metadata.Name}}, nil
+}
+
+// checkK8sSecret checks the certificate in a kubernetes.io/tls Secret, whose target is
+// k8sSecretPrefix + namespace/name. This catches certificates that are about to expire before
+// anything serves them, and ones that nothing we can reach serves at all.
+func checkK8sSecret(hostPort string) result {
+	ref := strings.TrimPrefix(hostPort, k8sSecretPrefix)
+	namespace, name, _ := strings.Cut(ref, "/")
+	v := result{HostPort: hostPort, Server: ref, Status: statusOK}
+	fail := func(err error) result {
+		v.Err = err.Error()
+		v.find(findingBadSecret)
+		return v
+	}
+
+	c, err := inClusterClient()
+	if err != nil {
+		return fail(err)
+	}
+	secret := struct {
+		Data map[string][]byte `json:"data"`
+	}{}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	if err := c.get(ctx, "/api/v1/namespaces/"+url.PathEscape(namespace)+"/secrets/"+url.PathEscape(name), &secret); err != nil {
+		return fail(err)
+	}
+
+	var chain []*x509.Certificate
+	rest := secret.Data["tls.crt"]
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fail(fmt.Errorf("secret %s has a bad certificate in tls.crt: %s", ref, err))
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return fail(fmt.Errorf("secret %s has no certificates in tls.crt", ref))
+	}
+	v.describe(chain, optionsFor(hostPort).warnDays())
+	if _, err := tls.X509KeyPair(secret.Data["tls.crt"], secret.Data["tls.key"]); err != nil {
+		v.Err = fmt.Sprintf("secret %s: tls.key doesn't go with tls.crt: %s", ref, err)
+		v.find(findingBadSecret)
+	}
+	return v
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"flag"
+	"fmt"
+)
+
+var (
+	minRSABits   = flag.Int("min-rsa-bits", 2048, "RSA keys smaller than this many bits are reported with a warning status")
+	minECDSABits = flag.Int("min-ecdsa-bits", 256, "ECDSA keys on curves smaller than this many bits are reported with a warning status")
+)
+
+// keyWeaknesses returns the reasons modern clients are likely to reject cert that have nothing to
+// do with when it expires: a key smaller than -min-rsa-bits or -min-ecdsa-bits, or a signature
+// made with MD5 or SHA-1.
+func keyWeaknesses(cert *x509.Certificate) []string {
+	var out []string
+	switch k := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if bits := k.N.BitLen(); bits < *minRSABits {
+			out = append(out, fmt.Sprintf("RSA key is %d bits, the minimum is %d", bits, *minRSABits))
+		}
+	case *ecdsa.PublicKey:
+		if bits := k.Curve.Params().BitSize; bits < *minECDSABits {
+			out = append(out, fmt.Sprintf("ECDSA key is %d bits, the minimum is %d", bits, *minECDSABits))
+		}
+	}
+
+	switch cert.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		out = append(out, fmt.Sprintf("certificate is signed with %s, which clients no longer accept", cert.SignatureAlgorithm))
+	}
+	return out
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/cryptobyte"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+var keystorePass = flag.String("keystore-pass", "", "The password of the Java keystores and PKCS#12 files found with -scan-path. keystores in -config can set one per file. Without a password, PKCS#12 files are tried with no password and \"changeit\"")
+
+// keystoreExts are the extensions of Java keystores. Since Java 9 these are usually PKCS#12
+// files, which we tell apart from JKS and JCEKS by their contents.
+var keystoreExts = map[string]bool{".jks": true, ".jceks": true, ".keystore": true, ".ks": true}
+
+// keystoreAliasLabel is the label we put the alias of a keystore entry in.
+const keystoreAliasLabel = "keystore_alias"
+
+// keystoreConfig is the password of a keystore in -config.
+//
+// In YAML:
+//
+//	keystores:
+//	  - path: /opt/app/conf/server.jks
+//	    passwordEnv: APP_KEYSTORE_PASS
+type keystoreConfig struct {
+	// Path is the path of the keystore, as found with -scan-path.
+	Path string `yaml:"path" toml:"path"`
+	// Password is the password of the keystore.
+	Password string `yaml:"password" toml:"password"`
+	// PasswordEnv is an environment variable that holds the password, so it doesn't have to be
+	// in the config.
+	PasswordEnv string `yaml:"passwordEnv" toml:"passwordEnv"`
+}
+
+// validate checks k's options.
+func (k *keystoreConfig) validate() error {
+	if k.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if k.Password != "" && k.PasswordEnv != "" {
+		return fmt.Errorf("password and passwordEnv can't be used together")
+	}
+	if k.PasswordEnv != "" {
+		if _, ok := os.LookupEnv(k.PasswordEnv); !ok {
+			return fmt.Errorf("passwordEnv %s is not set", k.PasswordEnv)
+		}
+	}
+	abs, err := filepath.Abs(k.Path)
+	if err != nil {
+		return err
+	}
+	k.Path = abs
+	return nil
+}
+
+// keystorePassword returns the password for the keystore at path, and if we were given one.
+func keystorePassword(path string) (string, bool) {
+	if loadConfig() == nil {
+		if abs, err := filepath.Abs(path); err == nil {
+			if k, ok := keystoreConfigs[abs]; ok {
+				if k.PasswordEnv != "" {
+					return os.Getenv(k.PasswordEnv), true
+				}
+				return k.Password, true
+			}
+		}
+	}
+	if *keystorePass != "" {
+		return *keystorePass, true
+	}
+	return "", false
+}
+
+// keystoreEntry is a certificate entry in a keystore. An entry with a key has its certificate
+// first, followed by the rest of its chain.
+type keystoreEntry struct {
+	alias string
+	chain []*x509.Certificate
+}
+
+// isKeystore reports if the file at path is a Java keystore or PKCS#12 file, going by its name.
+func isKeystore(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return keystoreExts[ext] || pkcs12Exts[ext]
+}
+
+// jksMagic and jceksMagic start JKS and JCEKS keystores.
+const (
+	jksMagic   = 0xfeedfeed
+	jceksMagic = 0xcececece
+)
+
+// keystoreEntries returns the certificate entries in the Java keystore or PKCS#12 file at path.
+func keystoreEntries(path string) ([]keystoreEntry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	password, given := keystorePassword(path)
+	if len(b) >= 4 {
+		if magic := binary.BigEndian.Uint32(b); magic == jksMagic || magic == jceksMagic {
+			entries, err := readJKS(b, password, given)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", path, err)
+			}
+			return entries, nil
+		}
+	}
+	passwords := []string{"", pkcs12.DefaultPassword}
+	if given {
+		passwords = []string{password}
+	}
+	entries, err := decodePKCS12(b, passwords)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return entries, nil
+}
+
+// decodePKCS12 returns the entries in the PKCS#12 file b, trying each of passwords. A file with
+// a key is a single entry, a trust store has an entry for each certificate. PKCS#12 files don't
+// have aliases we can read, so entries are numbered from 1.
+func decodePKCS12(b []byte, passwords []string) ([]keystoreEntry, error) {
+	for _, pw := range passwords {
+		_, cert, chain, err := pkcs12.DecodeChain(b, pw)
+		if err == nil {
+			return []keystoreEntry{{alias: "1", chain: append([]*x509.Certificate{cert}, chain...)}}, nil
+		}
+		if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			continue
+		}
+		// Trust stores have certificates but no key, which DecodeChain won't accept.
+		certs, err := pkcs12.DecodeTrustStore(b, pw)
+		if err == nil {
+			var entries []keystoreEntry
+			for i, cert := range certs {
+				entries = append(entries, keystoreEntry{alias: strconv.Itoa(i + 1), chain: []*x509.Certificate{cert}})
+			}
+			return entries, nil
+		}
+		if !errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			return nil, err
+		}
+	}
+	if len(passwords) == 1 {
+		return nil, fmt.Errorf("the PKCS#12 password is wrong")
+	}
+	return nil, fmt.Errorf("PKCS#12 file needs a password we don't have, set -keystore-pass or keystores in -config")
+}
+
+// readJKS returns the certificate entries in the JKS or JCEKS keystore b. Certificates aren't
+// encrypted in these keystores, so we can read them without the password. If we were given a
+// password, we check the keystore's integrity with it, which is how a wrong password is noticed.
+func readJKS(b []byte, password string, given bool) ([]keystoreEntry, error) {
+	if len(b) < sha1.Size {
+		return nil, fmt.Errorf("keystore is truncated")
+	}
+	body, digest := b[:len(b)-sha1.Size], b[len(b)-sha1.Size:]
+	if given {
+		// The digest is SHA-1 over the password as UTF-16, a fixed phrase and the keystore.
+		h := sha1.New()
+		for _, c := range utf16.Encode([]rune(password)) {
+			h.Write([]byte{byte(c >> 8), byte(c)})
+		}
+		h.Write([]byte("Mighty Aphrodite"))
+		h.Write(body)
+		if !bytes.Equal(h.Sum(nil), digest) {
+			return nil, fmt.Errorf("the keystore password is wrong, or the keystore is corrupt")
+		}
+	}
+
+	in := cryptobyte.String(body)
+	var magic, version, count uint32
+	if !in.ReadUint32(&magic) || !in.ReadUint32(&version) || !in.ReadUint32(&count) {
+		return nil, fmt.Errorf("keystore is truncated")
+	}
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("keystore version %d is not supported", version)
+	}
+	// readCert reads a certificate, which has its type first in version 2.
+	readCert := func() (*x509.Certificate, error) {
+		var typ, der cryptobyte.String
+		if version == 2 && !in.ReadUint16LengthPrefixed(&typ) {
+			return nil, fmt.Errorf("keystore is truncated")
+		}
+		if version == 2 && string(typ) != "X.509" {
+			return nil, fmt.Errorf("certificate type %q is not supported", typ)
+		}
+		if !readUint32Prefixed(&in, &der) {
+			return nil, fmt.Errorf("keystore is truncated")
+		}
+		return x509.ParseCertificate(der)
+	}
+
+	var entries []keystoreEntry
+	for i := uint32(0); i < count; i++ {
+		var tag uint32
+		var alias, key cryptobyte.String
+		// Each entry has a tag, an alias and when it was created.
+		if !in.ReadUint32(&tag) || !in.ReadUint16LengthPrefixed(&alias) || !in.Skip(8) {
+			return nil, fmt.Errorf("keystore is truncated")
+		}
+		e := keystoreEntry{alias: string(alias)}
+		switch tag {
+		case 1:
+			// A private key, which we skip, and its certificate chain.
+			var n uint32
+			if !readUint32Prefixed(&in, &key) || !in.ReadUint32(&n) {
+				return nil, fmt.Errorf("keystore is truncated")
+			}
+			for j := uint32(0); j < n; j++ {
+				cert, err := readCert()
+				if err != nil {
+					return nil, fmt.Errorf("entry %q: %s", e.alias, err)
+				}
+				e.chain = append(e.chain, cert)
+			}
+		case 2:
+			// A trusted certificate.
+			cert, err := readCert()
+			if err != nil {
+				return nil, fmt.Errorf("entry %q: %s", e.alias, err)
+			}
+			e.chain = []*x509.Certificate{cert}
+		default:
+			// JCEKS secret keys are serialized Java objects, which we can't find the end of.
+			return nil, fmt.Errorf("entry %q is a kind we can't read (%d)", e.alias, tag)
+		}
+		if len(e.chain) > 0 {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// readUint32Prefixed reads a value with a 32 bit length before it from in into out.
+func readUint32Prefixed(in *cryptobyte.String, out *cryptobyte.String) bool {
+	var n uint32
+	return in.ReadUint32(&n) && in.ReadBytes((*[]byte)(out), int(n))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// latency is how long a phase of the checks took across the hosts of a run, in seconds.
+type latency struct {
+	// Hosts is the number of hosts the phase was timed for.
+	Hosts int     `json:"hosts"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// latencies are the latency of each phase of the checks of a run. A phase no host had, like
+// STARTTLS without any starttls options, is nil.
+type latencies struct {
+	DNS       *latency `json:"dns,omitempty"`
+	Connect   *latency `json:"connect,omitempty"`
+	STARTTLS  *latency `json:"starttls,omitempty"`
+	Handshake *latency `json:"handshake,omitempty"`
+	Total     *latency `json:"total,omitempty"`
+}
+
+// summarizeLatency returns the latencies of the phases of results, or nil if none were timed,
+// like when every result is from a certificate file.
+func summarizeLatency(results []result) *latencies {
+	var dns, connect, starttls, handshake, total []float64
+	for _, v := range results {
+		t := v.Timings
+		if t == nil {
+			continue
+		}
+		for _, p := range []struct {
+			s *[]float64
+			v float64
+		}{{&dns, t.DNS}, {&connect, t.Connect}, {&starttls, t.STARTTLS}, {&handshake, t.Handshake}, {&total, t.Total}} {
+			if p.v > 0 {
+				*p.s = append(*p.s, p.v)
+			}
+		}
+	}
+	if len(total) == 0 {
+		return nil
+	}
+	return &latencies{
+		DNS:       newLatency(dns),
+		Connect:   newLatency(connect),
+		STARTTLS:  newLatency(starttls),
+		Handshake: newLatency(handshake),
+		Total:     newLatency(total),
+	}
+}
+
+// newLatency returns the latency of samples, or nil if there are none.
+func newLatency(samples []float64) *latency {
+	if len(samples) == 0 {
+		return nil
+	}
+	slices.Sort(samples)
+	return &latency{
+		Hosts: len(samples),
+		P50:   percentile(samples, 50),
+		P90:   percentile(samples, 90),
+		P99:   percentile(samples, 99),
+		Max:   samples[len(samples)-1],
+	}
+}
+
+// percentile returns the p-th percentile of sorted with the nearest rank method, so it is
+// always one of the samples.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// seconds returns s seconds as a duration rounded for people to read, like 21.3ms.
+func seconds(s float64) string {
+	d := time.Duration(s * float64(time.Second))
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(100 * time.Microsecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+// timingsText returns t as one line, like "DNS 1ms, Connect 9.1ms, Handshake 20.9ms, Total 31ms".
+func timingsText(t *timings) string {
+	var parts []string
+	for _, p := range []struct {
+		key string
+		v   float64
+	}{{"phaseDNS", t.DNS}, {"phaseConnect", t.Connect}, {"phaseSTARTTLS", t.STARTTLS}, {"phaseHandshake", t.Handshake}, {"phaseTotal", t.Total}} {
+		if p.v > 0 {
+			parts = append(parts, msg(p.key)+" "+seconds(p.v))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// latencyText returns l as one line, like "p50 21ms, p90 40ms, p99 1.2s, max 3s (120 hosts)".
+func latencyText(l *latency) string {
+	return fmt.Sprintf("p50 %s, p90 %s, p99 %s, max %s (%s)", seconds(l.P50), seconds(l.P90), seconds(l.P99), seconds(l.Max), msg("hostCount", l.Hosts))
+}
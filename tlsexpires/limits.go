@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+const (
+	// fdReserve are the file descriptors we keep for everything else, like our input, -history
+	// and the notification queue.
+	fdReserve = 64
+	// memPerCheck is roughly how much memory a check in flight uses, between TLS buffers, the
+	// certificate chain and the goroutines doing the work.
+	memPerCheck = 512 << 10
+)
+
+// fdsPerCheck is how many file descriptors a check can have open at once with our flags: its
+// connection, a DNS, OCSP, CRL or CT lookup, and the connections our flags make while it is open.
+func fdsPerCheck() int {
+	n := 2
+	// Happy Eyeballs races a connection to each address family.
+	if *fallbackDelay >= 0 {
+		n++
+	}
+	// -http-checks connects to port 80 of the host.
+	if *checkHTTP {
+		n++
+	}
+	// -pqc and -enumerate make their connections one at a time.
+	if *checkPQC || *enumerate {
+		n++
+	}
+	return n
+}
+
+// resourceBudget is the most checks we can run at once without running out of file descriptors
+// or memory, and why.
+type resourceBudget struct {
+	// maxChecks is the most checks we can run at once. 0 means we found no limit.
+	maxChecks int
+	// reason says which limit sets maxChecks.
+	reason string
+}
+
+// findBudget looks at our file descriptor limit, cgroup memory limit and GOMEMLIMIT to work
+// out how many checks we can run at once. We only budget half of the memory for checks, the
+// rest is for results and the garbage collector's headroom.
+func findBudget() resourceBudget {
+	b := resourceBudget{}
+	if fds, ok := fdLimit(); ok {
+		n := max(1, (int(min(fds, math.MaxInt32))-fdReserve)/fdsPerCheck())
+		b = resourceBudget{maxChecks: n, reason: fmt.Sprintf("the file descriptor limit (ulimit -n) is %d", fds)}
+	}
+	if mem, source, ok := memoryLimit(); ok {
+		n := max(1, int(min(mem/2/memPerCheck, math.MaxInt32)))
+		if b.maxChecks == 0 || n < b.maxChecks {
+			b = resourceBudget{maxChecks: n, reason: fmt.Sprintf("the %s memory limit is %d MiB", source, mem>>20)}
+		}
+	}
+	return b
+}
+
+// memoryLimit returns the smallest of our cgroup memory limit and GOMEMLIMIT, and which one it
+// is. ok is false if neither is set.
+func memoryLimit() (limit uint64, source string, ok bool) {
+	if l := debug.SetMemoryLimit(-1); l != math.MaxInt64 {
+		limit, source, ok = uint64(l), "GOMEMLIMIT", true
+	}
+	if l, found := cgroupMemoryLimit(); found && (!ok || l < limit) {
+		limit, source, ok = l, "cgroup", true
+	}
+	return limit, source, ok
+}
+
+// cgroupMemoryLimit returns the memory limit of our cgroup, trying cgroup v2 and then v1.
+// found is false if we aren't in a cgroup with a limit, which includes not being on Linux.
+func cgroupMemoryLimit() (limit uint64, found bool) {
+	for _, path := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		s := strings.TrimSpace(string(b))
+		if s == "max" {
+			return 0, false
+		}
+		l, err := strconv.ParseUint(s, 10, 64)
+		// cgroup v1 reports no limit as a huge number rounded down to the page size.
+		if err != nil || l >= 1<<62 {
+			return 0, false
+		}
+		return l, true
+	}
+	return 0, false
+}
+
+// capConcurrency lowers the concurrency of the zones in zc so that together they fit in b,
+// keeping the share each zone gets. It logs a warning if it had to. Zones with an agent are left
+// alone, as their checks run on the agent and only wait here.
+func (zc *zoneConfig) capConcurrency(b resourceBudget) {
+	var zones []*zone
+	total := 0
+	for _, z := range append([]*zone{&zc.Default}, zc.Zones...) {
+		if z.Agent != "" {
+			continue
+		}
+		zones = append(zones, z)
+		total += z.Concurrency
+	}
+	if b.maxChecks == 0 || total <= b.maxChecks {
+		return
+	}
+	for _, z := range zones {
+		z.Concurrency = max(1, z.Concurrency*b.maxChecks/total)
+	}
+	log.Printf("warning: lowering concurrency from %d to about %d checks at a time because %s", total, b.maxChecks, b.reason)
+}
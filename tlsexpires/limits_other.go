@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// fdLimit returns the most file descriptors we can have open. We don't know it on this platform.
+func fdLimit() (uint64, bool) {
+	return 0, false
+}
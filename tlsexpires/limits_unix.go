@@ -0,0 +1,16 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// fdLimit returns the most file descriptors we can have open. Go raises the soft limit to the
+// hard limit when it starts, so this is usually the hard limit. Unlimited comes back as a number
+// too big to ever cap us.
+func fdLimit() (uint64, bool) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, false
+	}
+	return uint64(rl.Cur), true
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// markdownSink writes a markdown report, with a summary and a table for each urgency, for
+// pasting into issues, wikis and chat.
+type markdownSink struct {
+	w io.WriteCloser
+}
+
+// newMarkdownSink returns a markdownSink that writes to dest, a file or - for stdout.
+func newMarkdownSink(dest string) (sink, error) {
+	w, err := createOutput(dest)
+	if err != nil {
+		return nil, err
+	}
+	return &markdownSink{w: w}, nil
+}
+
+// Write implements sink.Write(). The report is written as a whole by Flush.
+func (m *markdownSink) Write(v result) error {
+	return nil
+}
+
+// urgencyGroup is a table of the markdown report.
+type urgencyGroup struct {
+	// Title is the heading of the table.
+	Title string
+	// Results are the results in the table, soonest to expire first.
+	Results []result
+}
+
+// markdownReport is what markdownTmpl receives.
+type markdownReport struct {
+	run
+	// Failed are the hosts we couldn't check.
+	Failed []result
+	// Groups are the certificates by how soon they expire, most urgent first. Groups without
+	// certificates are left out.
+	Groups []urgencyGroup
+}
+
+// Flush implements sink.Flush().
+func (m *markdownSink) Flush(r run) error {
+	rep := markdownReport{run: r}
+	groups := []urgencyGroup{{Title: msg("expired")}, {Title: msg("expiringIn", 7)}, {Title: msg("expiringIn", 30)}, {Title: msg("expiringIn", 90)}, {Title: msg("expiresLater")}}
+	for _, v := range filterOutput(r.Results) {
+		if v.Status == statusError && v.ExpiresOn.IsZero() {
+			rep.Failed = append(rep.Failed, v)
+			continue
+		}
+		left := until(v.ExpiresOn)
+		switch {
+		case left < 0:
+			groups[0].Results = append(groups[0].Results, v)
+		case left < 7*24*time.Hour:
+			groups[1].Results = append(groups[1].Results, v)
+		case left < 30*24*time.Hour:
+			groups[2].Results = append(groups[2].Results, v)
+		case left < 90*24*time.Hour:
+			groups[3].Results = append(groups[3].Results, v)
+		default:
+			groups[4].Results = append(groups[4].Results, v)
+		}
+	}
+	for _, g := range groups {
+		if len(g.Results) > 0 {
+			rep.Groups = append(rep.Groups, g)
+		}
+	}
+	if err := markdownTmpl.Execute(m.w, rep); err != nil {
+		return err
+	}
+	return m.w.Close()
+}
+
+// mdCell escapes s for a cell of a markdown table.
+func mdCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\r", "", "\n", " ").Replace(s)
+}
+
+var markdownTmpl = template.Must(template.New("").Funcs(template.FuncMap{
+	"t":       msg,
+	"cell":    mdCell,
+	"labels":  labelString,
+	"latency": latencyText,
+	"findings": func(v result) string {
+		return strings.Join(v.findingNames(), ", ")
+	},
+}).Parse(`# {{ t "report" }}
+
+{{ with .ID }}{{ . }}, {{ end }}{{ .Started.UTC.Format "2006-01-02 15:04 MST" }}{{ if .Partial }} ({{ t "interrupted" }}){{ end }}
+{{ with .Summary }}
+| {{ t "summary" }} | |
+|---|---|
+| {{ t "hostsChecked" }} | {{ .Total }} |
+| {{ t "succeeded" }} | {{ .Succeeded }} |
+| {{ t "failed" }} | {{ .Failed }} |
+| {{ t "expiringIn" 7 }} | {{ .Within7Days }} |
+| {{ t "expiringIn" 30 }} | {{ .Within30Days }} |
+| {{ t "expiringIn" 90 }} | {{ .Within90Days }} |
+{{- if .Soonest }}
+| {{ t "soonest" }} | {{ cell .Soonest }} ({{ .SoonestExpiresOn.Format "2006-01-02" }}) |
+{{- end }}
+{{- with .Latency }}{{ with .Handshake }}
+| {{ t "latency" }}, {{ t "phaseHandshake" }} | {{ latency . }} |
+{{- end }}{{ end }}
+{{ end }}
+{{- with .Failed }}
+## {{ t "failed" }} ({{ len . }})
+
+| {{ t "host" }} | {{ t "reason" }} | {{ t "labels" }} |
+|---|---|---|
+{{- range . }}
+| {{ cell .HostPort }}{{ with .Address }} ({{ . }}){{ end }} | {{ cell .Err }} | {{ cell (labels .Labels) }} |
+{{- end }}
+{{ end }}
+{{- range .Groups }}
+## {{ .Title }} ({{ len .Results }})
+
+| {{ t "host" }} | {{ t "expiresOn" }} | {{ t "days" }} | {{ t "issuer" }} | {{ t "findings" }} | {{ t "labels" }} |
+|---|---|---|---|---|---|
+{{- range .Results }}
+| {{ cell .HostPort }}{{ with .Address }} ({{ . }}){{ end }} | {{ .ExpiresOn.UTC.Format "2006-01-02" }} | {{ .ExpireInDays }} | {{ cell .Issuer }} | {{ cell (findings .) }} | {{ cell (labels .Labels) }} |
+{{- end }}
+{{ end -}}
+`))
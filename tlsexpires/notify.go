@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	notifyTemplates = flag.String("notify-templates", "", "A directory of *.tmpl files that redefine the notification message templates (slack, teams, email.subject, email.body, webhook, digest, digest.subject, digest.webhook, rotation, rotation.webhook)")
+	notifyWebhook   = flag.String("notify-webhook", "", "A URL to POST the webhook notification template to when any host needs attention")
+	notifySlack     = flag.String("notify-slack", "", "A Slack incoming webhook URL to send the slack notification template to when any host needs attention")
+	notifyTeams     = flag.String("notify-teams", "", "A Microsoft Teams incoming webhook URL to send the teams notification template to when any host needs attention")
+	notifyQueue     = flag.String("notify-queue", defaultOutboxPath(), "The file notifications are queued in until they are delivered, so ones we can't send are retried on the next run")
+	notifyRetryFor  = flag.Duration("notify-retry-for", time.Minute, "How long to keep retrying notifications that fail before leaving them queued for the next run")
+
+	notifyMaxAge = dayDuration(3 * 24 * time.Hour)
+)
+
+func init() {
+	flag.Var(&notifyMaxAge, "notify-max-age", "How long a queued notification is retried, across runs, before it is dropped (like 3d or 12h)")
+}
+
+// defaultNotifyTemplates are the message bodies we send when the user doesn't provide their own.
+// Each notification channel renders the template with its name. Users override one by putting
+// a {{ define "name" }} of the same name in a file in -notify-templates.
+const defaultNotifyTemplates = `
+{{- define "slack" -}}
+*TLS certificate check: {{ len .Results }} of {{ .Total }} hosts need attention*
+{{- range .Groups }}
+*{{ .Status }}* ({{ .Count }})
+{{- range .Results }}
+• ` + "`{{ .HostPort }}`" + ` {{ template "detail" . }}
+{{- end }}
+{{- end }}
+{{- end -}}
+
+{{- define "teams" -}}
+**TLS certificate check: {{ len .Results }} of {{ .Total }} hosts need attention**
+{{ range .Groups }}
+**{{ .Status }}** ({{ .Count }})
+{{ range .Results }}
+- {{ .HostPort }} {{ template "detail" . }}
+{{- end }}
+{{ end }}
+{{- end -}}
+
+{{- define "email.subject" -}}
+TLS certificate check: {{ len .Results }} of {{ .Total }} hosts need attention
+{{- end -}}
+
+{{- define "email.body" -}}
+The TLS certificate check that started at {{ .Started.Format "2006-01-02 15:04 MST" }} found {{ len .Results }} of {{ .Total }} hosts that need attention.
+{{ range .Groups }}
+{{ .Status }} ({{ .Count }}):
+{{- range .Results }}
+  {{ .HostPort }} {{ template "detail" . }}
+{{- end }}
+{{ end }}
+{{- end -}}
+
+{{- define "webhook" -}}
+{{ json . }}
+{{- end -}}
+
+{{- define "digest" -}}
+TLS certificate digest for the check that started at {{ .Started.Format "2006-01-02 15:04 MST" }}
+{{ .Summary.Succeeded }} of {{ .Summary.Total }} hosts checked, {{ .Summary.Failed }} failed, {{ .Expiring }} expire within {{ .Window }}
+{{- range .Groups }}
+
+{{ .Zone }}: {{ .Summary.Succeeded }} of {{ .Summary.Total }} hosts checked, {{ .Summary.Failed }} failed
+{{- range .Expiring }}
+  {{ .HostPort }} {{ template "detail" . }}
+{{- end }}
+{{- range .Failed }}
+  {{ .HostPort }} {{ template "detail" . }}
+{{- end }}
+{{- end }}
+{{- end -}}
+
+{{- define "digest.subject" -}}
+TLS certificate digest: {{ .Expiring }} expire within {{ .Window }}, {{ .Summary.Failed }} failed
+{{- end -}}
+
+{{- define "digest.webhook" -}}
+{{ json . }}
+{{- end -}}
+
+{{- define "rotation" -}}
+TLS certificate check: {{ len .Rotations }} hosts serve a new certificate, {{ .Unexpected }} of them unexpectedly
+{{- range .Rotations }}
+  {{ .Is.HostPort }}{{ with .Is.Address }} ({{ . }}){{ end }} {{ if .Renewal }}renewed{{ else }}changed unexpectedly{{ end }}: expires {{ .Is.ExpiresOn.Format "2006-01-02" }}, issued by {{ .Is.Issuer }}
+    was {{ .Was.Fingerprint }}, expiring {{ .Was.ExpiresOn.Format "2006-01-02" }}, issued by {{ .Was.Issuer }}
+    now {{ .Is.Fingerprint }}
+{{- end }}
+{{- end -}}
+
+{{- define "rotation.webhook" -}}
+{{ json . }}
+{{- end -}}
+
+{{- define "detail" -}}
+{{ if eq .Status "error" }}error: {{ .Err }}{{ else }}expires {{ .ExpiresOn.Format "2006-01-02" }} (in {{ .ExpireInDays }} days){{ end }}
+{{- with .Labels }} [{{ labels . }}]{{ end }}
+{{- end -}}
+`
+
+// notifyFuncs are the extra functions notification templates can use.
+var notifyFuncs = template.FuncMap{
+	// json renders any value as JSON, which keeps webhook templates from breaking on quotes.
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	// labels renders labels as key=value pairs.
+	"labels": labelString,
+}
+
+// notifyGroup is a summary of the results that share a status.
+type notifyGroup struct {
+	// Status is the status every result in the group has.
+	Status status `json:"status"`
+	// Count is the number of results in the group.
+	Count int `json:"count"`
+	// Results are the results in the group, soonest to expire first.
+	Results []result `json:"results"`
+}
+
+// notification is what the notification templates receive.
+type notification struct {
+	// RunID is the ID of the run.
+	RunID string `json:"runId,omitempty"`
+	// Started is when the run started.
+	Started time.Time `json:"started"`
+	// Total is the number of hosts that were checked.
+	Total int `json:"total"`
+	// Results are all results that need attention, which is everything that wasn't statusOK.
+	Results []result `json:"results"`
+	// Groups are the Results grouped by status, errors first.
+	Groups []notifyGroup `json:"groups"`
+}
+
+// newNotification turns a run into the data our notification templates receive.
+func newNotification(r run) notification {
+	n := notification{RunID: r.ID, Started: r.Started, Total: len(r.Results)}
+
+	byStatus := map[status]*notifyGroup{}
+	for _, v := range r.Results {
+		if v.Status == statusOK {
+			continue
+		}
+		n.Results = append(n.Results, v)
+		g, ok := byStatus[v.Status]
+		if !ok {
+			g = &notifyGroup{Status: v.Status}
+			byStatus[v.Status] = g
+		}
+		g.Count++
+		g.Results = append(g.Results, v)
+	}
+	for _, s := range []status{statusError, statusWarning} {
+		if g, ok := byStatus[s]; ok {
+			n.Groups = append(n.Groups, *g)
+		}
+	}
+	return n
+}
+
+// loadNotifyTemplates returns our default notification templates with any templates the user
+// defined in -notify-templates replacing them.
+func loadNotifyTemplates() (*template.Template, error) {
+	t := template.Must(template.New("").Funcs(notifyFuncs).Parse(defaultNotifyTemplates))
+	if *notifyTemplates == "" {
+		return t, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(*notifyTemplates, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("-notify-templates=%s has no *.tmpl files", *notifyTemplates)
+	}
+	t, err = t.ParseFiles(files...)
+	if err != nil {
+		return nil, fmt.Errorf("problem parsing -notify-templates: %s", err)
+	}
+	return t, nil
+}
+
+// renderNotification renders the template with name for n, which is a notification or a digest.
+func renderNotification(t *template.Template, name string, n any) (string, error) {
+	if t.Lookup(name) == nil {
+		return "", fmt.Errorf("there is no notification template named %q", name)
+	}
+	b := strings.Builder{}
+	if err := t.ExecuteTemplate(&b, name, n); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// chatMessage is the body Slack and Teams incoming webhooks take. Both render Markdown in Text,
+// each their own flavor of it, which is what the slack and teams templates are written in.
+type chatMessage struct {
+	Text string `json:"text"`
+}
+
+// notify sends the notifications for r to every channel the user configured, splitting them
+// up by -routes.
+func notify(r run) error {
+	if *notifyWebhook == "" && *notifySlack == "" && *notifyTeams == "" && *smtpServer == "" &&
+		*pagerDutyKey == "" && *opsgenieKey == "" && *webhookURL == "" && *routesFile == "" {
+		return nil
+	}
+	routes, err := loadRoutes()
+	if err != nil {
+		return err
+	}
+	if err := queueIncidents(r, routes); err != nil {
+		return err
+	}
+	t, err := loadNotifyTemplates()
+	if err != nil {
+		return err
+	}
+
+	var ds []delivery
+	for _, rr := range splitRun(routes, r) {
+		routed, err := routedDeliveries(t, rr)
+		if err != nil {
+			return err
+		}
+		ds = append(ds, routed...)
+	}
+	results, err := webhookDeliveries(r, *webhookURL)
+	if err != nil {
+		return err
+	}
+	return deliver(append(ds, results...))
+}
+
+// routedDeliveries returns the webhook, chat and email notifications for the hosts in rr, sent to
+// its destinations.
+func routedDeliveries(t *template.Template, rr routedRun) ([]delivery, error) {
+	channels := []struct {
+		name, url string
+		// chat is if the template is sent as the text of a chatMessage instead of as is.
+		chat bool
+	}{
+		{"webhook", rr.Webhook, false},
+		{"slack", rr.Slack, true},
+		{"teams", rr.Teams, true},
+	}
+
+	var ds []delivery
+	if n := newNotification(rr.run); len(n.Results) > 0 {
+		for _, c := range channels {
+			if c.url == "" {
+				continue
+			}
+			body, err := renderNotification(t, c.name, n)
+			if err != nil {
+				return nil, err
+			}
+			b := []byte(body)
+			if c.chat {
+				if b, err = json.Marshal(chatMessage{Text: body}); err != nil {
+					return nil, err
+				}
+			}
+			ds = append(ds, delivery{Key: rr.key(c.name), Channel: c.name, URL: c.url, Body: b})
+		}
+	}
+	d, ok, err := emailDelivery(t, rr)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		ds = append(ds, d)
+	}
+	return ds, nil
+}
+
+// deliver queues ds in -notify-queue and then sends everything in the queue. Because
+// notifications are queued before we send them, anything we can't deliver now, along with
+// anything left over from earlier runs, is retried instead of lost.
+func deliver(ds []delivery) error {
+	ob, err := openOutbox(*notifyQueue, time.Duration(notifyMaxAge))
+	if err != nil {
+		return err
+	}
+	defer ob.Close()
+
+	for _, d := range ds {
+		if err := ob.enqueue(d); err != nil {
+			return fmt.Errorf("could not queue %s notification: %s", d.Channel, err)
+		}
+	}
+
+	left, err := ob.flush(*notifyRetryFor)
+	if err != nil {
+		return err
+	}
+	if left > 0 {
+		log.Printf("%d notifications could not be delivered yet, they will be retried on the next run", left)
+	}
+	return nil
+}
+
+// preview implements the "preview" subcommand. It renders a notification template against the
+// json output of a previous run, so people can see what their messages will look like.
+func preview(args []string) error {
+	fs := subcommandFlags("preview")
+	from := fs.String("from", "", "The path to the json output (-format=json) of a previous run")
+	to := fs.String("to", "", "The rotation templates compare two runs. This is the json output of the run after -from")
+	name := fs.String("template", "slack", "The name of the notification template to render")
+	fs.Parse(args)
+	if err := applyProfile(fs); err != nil {
+		return err
+	}
+
+	if *from == "" {
+		return fmt.Errorf("preview requires -from")
+	}
+	r, err := readRun(*from)
+	if err != nil {
+		return err
+	}
+	t, err := loadNotifyTemplates()
+	if err != nil {
+		return err
+	}
+	var s string
+	switch {
+	case strings.HasPrefix(*name, "rotation"):
+		if *to == "" {
+			return fmt.Errorf("preview of %s requires -to", *name)
+		}
+		next, err := readRun(*to)
+		if err != nil {
+			return err
+		}
+		s, err = renderNotification(t, *name, newRotationEvent(r, next))
+		if err != nil {
+			return err
+		}
+	case strings.HasPrefix(*name, "digest"):
+		s, err = renderNotification(t, *name, newDigest(r, time.Duration(digestWindow)))
+	default:
+		s, err = renderNotification(t, *name, newNotification(r))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(s)
+	return nil
+}
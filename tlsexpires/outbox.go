@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// outboxBucket is the bbolt bucket our deliveries are stored in.
+	outboxBucket = []byte("deliveries")
+	// keysBucket holds the Key of every delivery we queued and when, so a delivery is only
+	// queued once no matter how many times the run that made it is retried.
+	keysBucket = []byte("keys")
+	// incidentsBucket holds the key of every PagerDuty or Opsgenie incident we opened and haven't
+	// resolved, see queueIncidents().
+	incidentsBucket = []byte("incidents")
+)
+
+// notifyClient is used to deliver notifications.
+var notifyClient = &http.Client{Timeout: 30 * time.Second}
+
+// delivery is a notification waiting to be sent.
+type delivery struct {
+	// ID is the key of the delivery in the outbox.
+	ID uint64 `json:"id"`
+	// Key identifies the delivery across runs, like the channel and run ID. It is sent as the
+	// Idempotency-Key header, so receivers can ignore a delivery they got before we heard back.
+	Key string `json:"key,omitempty"`
+	// Channel is the kind of notification, like "webhook".
+	Channel string `json:"channel"`
+	// URL is where we POST Body, or for email, smtp:// and the SMTP server we send it through.
+	URL string `json:"url"`
+	// From and To are the envelope sender and recipients of an email.
+	From string   `json:"from,omitempty"`
+	To   []string `json:"to,omitempty"`
+	// Headers are added to the request.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is the request body.
+	Body []byte `json:"body"`
+	// Created is when the delivery was queued.
+	Created time.Time `json:"created"`
+	// Attempts is how many times we have tried to send it.
+	Attempts int `json:"attempts"`
+	// NextAttempt is the soonest we should try again.
+	NextAttempt time.Time `json:"nextAttempt"`
+	// LastError is why the last attempt failed.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// permanentError is a delivery failure that trying again won't fix, like a 404.
+type permanentError struct {
+	err error
+}
+
+func (p permanentError) Error() string {
+	return p.err.Error()
+}
+
+// outbox is a persistent queue of notifications. Notifications are written to it before we try
+// to send them and only removed once they are sent, so a notification generated while Slack
+// is down is sent on a later run instead of being lost.
+type outbox struct {
+	db *bolt.DB
+	// maxAge is how long we keep trying to send a delivery before giving up on it.
+	maxAge time.Duration
+}
+
+// defaultOutboxPath returns where we keep the outbox if -notify-queue isn't set.
+func defaultOutboxPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "tlsexpires", "outbox.db")
+}
+
+// openOutbox opens the outbox at path, creating it if it doesn't exist.
+func openOutbox(path string, maxAge time.Duration) (*outbox, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open notification queue %s: %s", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(outboxBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(keysBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(incidentsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &outbox{db: db, maxAge: maxAge}, nil
+}
+
+// Close closes the outbox.
+func (o *outbox) Close() error {
+	return o.db.Close()
+}
+
+// enqueue adds d to the outbox, to be sent as soon as possible. If a delivery with the same Key
+// was queued within the outbox's max age, d is dropped.
+func (o *outbox) enqueue(d delivery) error {
+	return o.db.Update(func(tx *bolt.Tx) error {
+		if d.Key != "" {
+			keys := tx.Bucket(keysBucket)
+			if keys.Get([]byte(d.Key)) != nil {
+				return nil
+			}
+			queued := binary.BigEndian.AppendUint64(nil, uint64(time.Now().Unix()))
+			if err := keys.Put([]byte(d.Key), queued); err != nil {
+				return err
+			}
+		}
+
+		b := tx.Bucket(outboxBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		d.ID = id
+		d.Created = time.Now()
+		d.NextAttempt = d.Created
+		return o.put(b, d)
+	})
+}
+
+// put stores d in b.
+func (o *outbox) put(b *bolt.Bucket, d delivery) error {
+	v, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return b.Put(outboxKey(d.ID), v)
+}
+
+// outboxKey is the bbolt key for a delivery ID. Big endian keeps deliveries in the order they were queued.
+func outboxKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+// pending returns every delivery in the outbox.
+func (o *outbox) pending() ([]delivery, error) {
+	var ds []delivery
+	err := o.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).ForEach(func(k, v []byte) error {
+			d := delivery{}
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			ds = append(ds, d)
+			return nil
+		})
+	})
+	return ds, err
+}
+
+// remove deletes the delivery with id from the outbox.
+func (o *outbox) remove(id uint64) error {
+	return o.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).Delete(outboxKey(id))
+	})
+}
+
+// pruneKeys forgets the keys of deliveries queued longer ago than the outbox's max age. Those
+// deliveries have been sent or dropped by now.
+func (o *outbox) pruneKeys() error {
+	if o.maxAge <= 0 {
+		return nil
+	}
+	cutoff := time.Now().Add(-o.maxAge).Unix()
+	return o.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(keysBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if int64(binary.BigEndian.Uint64(v)) < cutoff {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// retryLater records that sending d failed with err and schedules the next attempt.
+func (o *outbox) retryLater(d delivery, err error) error {
+	d.Attempts++
+	d.LastError = err.Error()
+	d.NextAttempt = time.Now().Add(backoff(d.Attempts))
+	return o.db.Update(func(tx *bolt.Tx) error {
+		return o.put(tx.Bucket(outboxBucket), d)
+	})
+}
+
+// backoff returns how long to wait after the given number of failed attempts. It doubles from
+// 5 seconds up to 30 minutes.
+func backoff(attempts int) time.Duration {
+	d := 5 * time.Second
+	for i := 1; i < attempts && d < 30*time.Minute; i++ {
+		d *= 2
+	}
+	if d > 30*time.Minute {
+		d = 30 * time.Minute
+	}
+	return d
+}
+
+// flush sends every delivery in the outbox that is due, retrying failures with backoff for up to
+// patience. Anything still unsent after that stays in the outbox for the next run. It returns how
+// many deliveries are still waiting.
+func (o *outbox) flush(patience time.Duration) (int, error) {
+	if err := o.pruneKeys(); err != nil {
+		return 0, err
+	}
+	giveUp := time.Now().Add(patience)
+	for {
+		ds, err := o.pending()
+		if err != nil {
+			return 0, err
+		}
+
+		var waiting []delivery
+		for _, d := range ds {
+			if o.maxAge > 0 && time.Since(d.Created) > o.maxAge {
+				log.Printf("dropping %s notification %d after %d attempts, it is older than the max age of %s: %s", d.Channel, d.ID, d.Attempts, o.maxAge, d.LastError)
+				if err := o.remove(d.ID); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			if time.Now().Before(d.NextAttempt) {
+				waiting = append(waiting, d)
+				continue
+			}
+
+			err := d.send()
+			switch err.(type) {
+			case nil:
+				if err := o.remove(d.ID); err != nil {
+					return 0, err
+				}
+			case permanentError:
+				log.Printf("dropping %s notification %d, it can never be delivered: %s", d.Channel, d.ID, err)
+				if err := o.remove(d.ID); err != nil {
+					return 0, err
+				}
+			default:
+				log.Printf("%s notification %d failed, will retry: %s", d.Channel, d.ID, err)
+				if err := o.retryLater(d, err); err != nil {
+					return 0, err
+				}
+				d.NextAttempt = time.Now().Add(backoff(d.Attempts + 1))
+				waiting = append(waiting, d)
+			}
+		}
+
+		if len(waiting) == 0 {
+			return 0, nil
+		}
+		next := waiting[0].NextAttempt
+		for _, d := range waiting[1:] {
+			if d.NextAttempt.Before(next) {
+				next = d.NextAttempt
+			}
+		}
+		if next.After(giveUp) {
+			return len(waiting), nil
+		}
+		time.Sleep(time.Until(next))
+	}
+}
+
+// send makes a single attempt to deliver d.
+func (d delivery) send() error {
+	if strings.HasPrefix(d.URL, "smtp://") {
+		return d.sendMail()
+	}
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.Key != "" {
+		req.Header.Set("Idempotency-Key", d.Key)
+	}
+	for k, v := range d.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("%s returned %s: %s", d.URL, resp.Status, body)
+	}
+	return permanentError{fmt.Errorf("%s returned %s: %s", d.URL, resp.Status, body)}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+var (
+	onlyExpiringWithin dayDuration
+
+	stream        = flag.Bool("stream", false, "Print text results as soon as each check finishes instead of sorting them once all checks are done")
+	preserveOrder = flag.Bool("preserve-order", false, "Sort results in the order of the input instead of by days until expiration")
+)
+
+func init() {
+	flag.Var(&onlyExpiringWithin, "only-expiring-within", "Only output certificates that expire within this window (like 30d or 2w), plus hosts we couldn't check. The summary still covers every host")
+}
+
+// tmpl is a Go text template. I use this to output your text output.
+// template.Must() means it must compile or it crashes, and I create a
+// new template that parses the text you see.
+var tmpl = template.Must(template.New("").Funcs(template.FuncMap{"join": strings.Join, "labels": labelString, "t": msg, "yesNo": yesNo, "timings": timingsText}).Parse(`
+{{ t "checking" }}: {{ .Server }}
+{{- with .Address }}
+{{ t "address" }}: {{ . }}
+{{- end }}
+{{- with .Labels }}
+{{ t "labels" }}: {{ labels . }}
+{{- end }}
+{{- with .TLSVersion }}
+{{ t "version" }}: TLS {{ . }}{{ with $.CipherSuite }} ({{ . }}{{ with $.KeyExchange }}, {{ . }}{{ end }}){{ end }}
+{{- end }}
+{{- with .ALPN }}
+{{ t "alpn" }}: {{ . }}
+{{- end }}
+{{- range .ALPNPaths }}
+{{ t "alpnPath" .Protocol }}: {{ with .Error }}{{ t "error" }}: {{ . }}{{ else }}{{ .Fingerprint }}{{ end }}
+{{- end }}
+{{- if not .IssuedOn.IsZero }}
+{{ t "issuedOn" }}: {{ .IssuedOn }}
+{{- end }}
+{{ t "expiresOn" }}: {{ .ExpiresOn }}
+{{ t "inDays" .ExpireInDays }}
+{{- with .Issuer }}
+{{ t "issuer" }}: {{ . }}
+{{- end }}
+{{- with .SANs }}
+{{ t "sans" }}: {{ join . ", " }}
+{{- end }}
+{{ t "serial" }}: {{ .Serial }}
+{{ t "fingerprint" }}: {{ .Fingerprint }}
+{{- with .SubjectKeyID }}
+{{ t "subjectKeyID" }}: {{ . }}
+{{- end }}
+{{ t "key" }}: {{ .KeyAlgorithm }}
+{{ t "signature" }}: {{ .SignatureAlgorithm }}
+{{- range .KeyWeaknesses }}
+{{ t "weakness" }}: {{ . }}
+{{- end }}
+{{- with .TLSHealth }}
+{{ t "resumption" }}: {{ yesNo .Resumption }}
+{{- with .SecureRenegotiation }}
+{{ t "renegotiation" }}: {{ yesNo . }}
+{{- end }}
+{{- range .Errors }}
+{{ t "error" }}: {{ . }}
+{{- end }}
+{{- end }}
+{{- with .HTTP }}
+{{- with .Status }}
+{{ t "httpStatus" }}: {{ . }}
+{{ t "hsts" }}: {{ yesNo $.HTTP.HSTS }}{{ if $.HTTP.HSTS }} (max-age={{ $.HTTP.HSTSMaxAge }}{{ if $.HTTP.HSTSIncludeSubDomains }}; includeSubDomains{{ end }}{{ if $.HTTP.HSTSPreload }}; preload{{ end }}){{ end }}
+{{- end }}
+{{ t "ocspStapled" }}: {{ yesNo .OCSPStapled }}
+{{- with .RedirectsToHTTPS }}
+{{ t "httpRedirect" }}: {{ yesNo . }}{{ with $.HTTP.Redirect }} ({{ . }}){{ end }}
+{{- end }}
+{{- range .Errors }}
+{{ t "error" }}: {{ . }}
+{{- end }}
+{{- end }}
+{{- with .DANE }}
+{{- range .Records }}
+{{ t "tlsa" }}: {{ . }} ({{ yesNo .Matched }})
+{{- end }}
+{{- if .Records }}
+{{ t "dnssec" }}: {{ yesNo .Secure }}
+{{- end }}
+{{- with .Error }}
+{{ t "error" }}: {{ . }}
+{{- end }}
+{{- end }}
+{{- range .TrustStores }}
+{{ t "trustStore" .Store }}: {{ yesNo .Verified }}{{ with .Error }} ({{ . }}){{ end }}
+{{- end }}
+{{- range .PostQuantum }}
+{{ t "pqcGroup" .Group }}: {{ yesNo .Accepted }}{{ with .Error }} ({{ . }}){{ end }}
+{{- end }}
+{{- with .ACME }}
+{{ t "acmeRenew" }}: {{ .RenewAfter }}
+{{- if not .SuggestedStart.IsZero }}
+{{ t "ariWindow" }}: {{ .SuggestedStart }} - {{ .SuggestedEnd }}
+{{- end }}
+{{- with .Error }}
+{{ t "error" }}: {{ . }}
+{{- end }}
+{{- end }}
+{{- with .Timings }}
+{{ t "timings" }}: {{ timings . }}
+{{- end }}
+{{- if not .CachedAt.IsZero }}
+{{ t "cachedAt" }}: {{ .CachedAt }}
+{{- end }}
+{{- range .Changes }}
+{{ t "changed" }}: {{ . }}
+{{- end }}
+{{- with .Mismatch }}
+{{ t "mismatch" }}: {{ . }}
+{{- end }}
+{{- with .Enumeration }}
+{{ t "protocols" }}: {{ join .Protocols ", " }}
+{{- range $version, $suites := .CipherSuites }}
+{{ t "cipherSuites" $version }}: {{ join $suites ", " }}
+{{- end }}
+{{- range .Weaknesses }}
+{{ t "weakness" }}: {{ . }}
+{{- end }}
+{{- end }}
+`,
+))
+
+// yesNo returns "yes" or "no" for b in the language of our output.
+func yesNo(b bool) string {
+	if b {
+		return msg("yes")
+	}
+	return msg("no")
+}
+
+// writeText writes v to w in our text format.
+func writeText(w io.Writer, v result) error {
+	if v.Status == statusError {
+		// The labels say who owns the host, which is what whoever reads an error needs next.
+		labels := ""
+		if len(v.Labels) > 0 {
+			labels = " [" + labelString(v.Labels) + "]"
+		}
+		if v.Address != "" {
+			_, err := fmt.Fprintf(w, "%q (%s)%s: %s: %s\n", v.HostPort, v.Address, labels, msg("error"), v.Err)
+			return err
+		}
+		_, err := fmt.Fprintf(w, "%q%s: %s: %s\n", v.HostPort, labels, msg("error"), v.Err)
+		return err
+	}
+	return tmpl.Execute(w, v)
+}
+
+// shouldOutput reports if v should be in our output. Everything but certificates outside of
+// -only-expiring-within is.
+func shouldOutput(v result) bool {
+	if onlyExpiringWithin == 0 || v.Status == statusError {
+		return true
+	}
+	return until(v.ExpiresOn) < time.Duration(onlyExpiringWithin)
+}
+
+// filterOutput returns the results that shouldOutput() says should be in our output.
+func filterOutput(results []result) []result {
+	var out []result
+	for _, v := range results {
+		if shouldOutput(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// sortResults sorts results so that our output is the same every run. By default that is the
+// soonest to expire first, with hosts we couldn't check before all others. With -preserve-order
+// this is the order of the input.
+func sortResults(results []result) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if *preserveOrder {
+			return results[i].order < results[j].order
+		}
+		if !results[i].ExpiresOn.Equal(results[j].ExpiresOn) {
+			return results[i].ExpiresOn.Before(results[j].ExpiresOn)
+		}
+		if results[i].HostPort != results[j].HostPort {
+			return results[i].HostPort < results[j].HostPort
+		}
+		return results[i].Address < results[j].Address
+	})
+}
+
+// collect checks every host:port on hostPorts and returns all the results in sorted order. If
+// ctx is done first, it returns the results of the checks that finished.
+func collect(ctx context.Context, hostPorts <-chan string, labels *targetLabels) []result {
+	var results []result
+	mu := sync.Mutex{}
+	checkAll(ctx, hostPorts, labels, func(v result) {
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, v)
+	})
+	sortResults(results)
+	return results
+}
+
+// output checks every host:port on hostPorts and writes the results to each -output, or to
+// stdout in -format. Once everything is written, it sends any notifications, files tickets,
+// saves the run to -history and runs -on-expiring-exec. If ctx is done first, like when we are
+// interrupted, it writes the results of the checks that finished marked as partial, and doesn't
+// notify or save them. subset is set if hostPorts are only some of our hosts, see run.Subset.
+func output(ctx context.Context, hostPorts <-chan string, labels *targetLabels, subset bool) {
+	if err := checkLang(); err != nil {
+		log.Fatal(err)
+	}
+	sinks, err := openSinks()
+	if err != nil {
+		log.Fatal(err)
+	}
+	h, err := parseHook()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tickets, err := ticketers()
+	if err != nil {
+		log.Fatal(err)
+	}
+	started := time.Now()
+	runID := newULID(started)
+	cp, err := openCheckpoint(runID, started)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cp != nil {
+		runID, started = cp.header.RunID, cp.header.Started
+	}
+	scanCheckpoint = cp
+	resetIntegrations()
+	resetUsage()
+
+	var results []result
+	checkAll(ctx, hostPorts, labels, func(v result) {
+		// The ID is set now for the sinks that write each result as it comes in.
+		v.ID = resultID(runID, v.resultKey())
+		results = append(results, v)
+		for _, s := range sinks {
+			if err := s.Write(v); err != nil {
+				log.Fatal(err)
+			}
+		}
+	})
+	sortResults(results)
+	assignIDs(runID, results)
+	sum := summarize(results)
+	sum.Forecast = newForecast(results, *forecastPeriod, clock.Now())
+	sum.Integrations = integrationReport()
+	sum.Usage = usageReport()
+	for _, s := range sinks {
+		if err := s.Flush(run{ID: runID, Started: started, Results: results, Summary: &sum, Partial: ctx.Err() != nil}); err != nil {
+			log.Fatal(err)
+		}
+	}
+	warnBudget(usageReport())
+
+	// A partial run would look like the hosts we didn't get to were removed.
+	if ctx.Err() != nil {
+		log.Printf("interrupted after checking %d hosts, not sending notifications, filing tickets, saving the run or running -on-expiring-exec", len(results))
+		cp.close(false)
+		return
+	}
+	r := run{ID: runID, Started: started, Results: results, Subset: subset}
+	if err := notify(r); err != nil {
+		log.Fatal(err)
+	}
+	fileTickets(ctx, tickets, r)
+	if err := saveHistory(r); err != nil {
+		log.Fatal(err)
+	}
+	if err := saveStore(r); err != nil {
+		log.Fatal(err)
+	}
+	cp.close(true)
+	if err := runHooks(ctx, h, results); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkStrict(integrationReport()); err != nil {
+		log.Fatal(err)
+	}
+}
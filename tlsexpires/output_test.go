@@ -0,0 +1,100 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSortResults(t *testing.T) {
+	setClock(t, testNow)
+	keepFlags(t, "preserve-order")
+
+	results := func() []result {
+		return []result{
+			{HostPort: "later:443", ExpiresOn: testNow.Add(90 * day), order: 0},
+			{HostPort: "b:443", ExpiresOn: testNow.Add(10 * day), order: 1},
+			{HostPort: "down:443", Status: statusError, order: 2},
+			{HostPort: "a:443", ExpiresOn: testNow.Add(10 * day), order: 3},
+			{HostPort: "a:443", Address: "10.0.0.2", ExpiresOn: testNow.Add(10 * day), order: 4},
+			{HostPort: "a:443", Address: "10.0.0.1", ExpiresOn: testNow.Add(10 * day), order: 5},
+		}
+	}
+
+	tests := []struct {
+		desc          string
+		preserveOrder string
+		want          []string
+	}{
+		{
+			desc:          "soonest first, hosts we couldn't check before them",
+			preserveOrder: "false",
+			want:          []string{"down:443", "a:443", "a:443 10.0.0.1", "a:443 10.0.0.2", "b:443", "later:443"},
+		},
+		{
+			desc:          "-preserve-order",
+			preserveOrder: "true",
+			want:          []string{"later:443", "b:443", "down:443", "a:443", "a:443 10.0.0.2", "a:443 10.0.0.1"},
+		},
+	}
+	for _, test := range tests {
+		setFlag(t, "preserve-order", test.preserveOrder)
+		got := results()
+		sortResults(got)
+		var keys []string
+		for _, v := range got {
+			keys = append(keys, v.resultKey())
+		}
+		if !slices.Equal(keys, test.want) {
+			t.Errorf("TestSortResults(%s): got %q, want %q", test.desc, keys, test.want)
+		}
+	}
+}
+
+func TestShouldOutput(t *testing.T) {
+	setClock(t, testNow)
+	keepFlags(t, "only-expiring-within")
+
+	tests := []struct {
+		desc   string
+		within string
+		v      result
+		want   bool
+	}{
+		{
+			desc:   "without -only-expiring-within",
+			within: "0",
+			v:      result{Status: statusOK, ExpiresOn: testNow.Add(300 * day)},
+			want:   true,
+		},
+		{
+			desc:   "inside the window",
+			within: "30d",
+			v:      result{Status: statusWarning, ExpiresOn: testNow.Add(29 * day)},
+			want:   true,
+		},
+		{
+			desc:   "outside the window",
+			within: "30d",
+			v:      result{Status: statusOK, ExpiresOn: testNow.Add(31 * day)},
+			want:   false,
+		},
+		{
+			desc:   "expired",
+			within: "2w",
+			v:      result{Status: statusError, ExpiresOn: testNow.Add(-day)},
+			want:   true,
+		},
+		{
+			desc:   "a host we couldn't check",
+			within: "30d",
+			v:      result{Status: statusError},
+			want:   true,
+		},
+	}
+	for _, test := range tests {
+		setFlag(t, "only-expiring-within", test.within)
+		if got := shouldOutput(test.v); got != test.want {
+			t.Errorf("TestShouldOutput(%s): got %t, want %t", test.desc, got, test.want)
+		}
+	}
+}
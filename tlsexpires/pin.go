@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// pins is a flag.Value for the repeatable -pin-check flag. It maps a host:port to the SHA-256
+// fingerprint, in lower case hex without colons, its certificate must have.
+type pins map[string]string
+
+var pinChecks = pins{}
+
+func init() {
+	flag.Var(pinChecks, "pin-check", "host:port=sha256:<fingerprint> that the host's certificate must match, with or without colons. Can be repeated")
+}
+
+// String implements flag.Value.String().
+func (p pins) String() string {
+	var out []string
+	for hostPort, fp := range p {
+		out = append(out, hostPort+"=sha256:"+fp)
+	}
+	return strings.Join(out, ",")
+}
+
+// Set implements flag.Value.Set().
+func (p pins) Set(s string) error {
+	hostPort, pin, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("-pin-check must be host:port=sha256:<fingerprint>, was %q", s)
+	}
+	fp, ok := strings.CutPrefix(strings.ToLower(pin), "sha256:")
+	if !ok {
+		return fmt.Errorf("-pin-check %q: only sha256 pins are supported", s)
+	}
+	fp = strings.ReplaceAll(fp, ":", "")
+	if b, err := hex.DecodeString(fp); err != nil || len(b) != 32 {
+		return fmt.Errorf("-pin-check %q: fingerprint must be 32 hex encoded bytes", s)
+	}
+	p[strings.TrimSpace(hostPort)] = fp
+	return nil
+}
+
+// checkPin returns an error if -pin-check has a pin for hostPort that cert doesn't match.
+func checkPin(hostPort string, cert *x509.Certificate) error {
+	want, ok := pinChecks[hostPort]
+	if !ok {
+		return nil
+	}
+	got := fingerprint(cert)
+	if strings.ReplaceAll(strings.ToLower(got), ":", "") != want {
+		return fmt.Errorf("certificate fingerprint %s does not match -pin-check sha256:%s", got, want)
+	}
+	return nil
+}
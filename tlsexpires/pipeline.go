@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	stageBuffer  = flag.Int("stage-buffer", 100, "How many results can wait between stages of a scan. When output is slow, scanning slows down instead of holding more results than this in memory")
+	stageMetrics = flag.Bool("stage-metrics", false, "After a scan, write how long each stage was busy, waited for input and waited on the next stage to stderr")
+)
+
+// stage records what one stage of our scan pipeline spent its time doing. A scan is a chain of
+// stages, discover -> resolve -> check -> evaluate -> emit, joined by bounded channels. A stage
+// that spends a lot of time blocked is waiting on a slow stage after it, one that spends a lot of
+// time waiting is starved by a slow stage before it.
+type stage struct {
+	name string
+
+	items   atomic.Int64
+	busy    atomic.Int64
+	waiting atomic.Int64
+	blocked atomic.Int64
+}
+
+// recv receives from in, recording how long s waited for something to do.
+func recv[T any](s *stage, in <-chan T) (T, bool) {
+	start := time.Now()
+	v, ok := <-in
+	s.waiting.Add(int64(time.Since(start)))
+	return v, ok
+}
+
+// send sends v to out, recording how long the next stage made s wait.
+func send[T any](s *stage, out chan<- T, v T) {
+	start := time.Now()
+	out <- v
+	s.blocked.Add(int64(time.Since(start)))
+	s.items.Add(1)
+}
+
+// worked records that s spent the time since start doing work.
+func (s *stage) worked(start time.Time) {
+	s.busy.Add(int64(time.Since(start)))
+}
+
+// pipeline is the stages of a single scan.
+type pipeline struct {
+	discover, resolve, check, evaluate, emit stage
+}
+
+// newPipeline returns a pipeline with its stages named.
+func newPipeline() *pipeline {
+	p := &pipeline{}
+	p.discover.name = "discover"
+	p.resolve.name = "resolve"
+	p.check.name = "check"
+	p.evaluate.name = "evaluate"
+	p.emit.name = "emit"
+	return p
+}
+
+// writeMetrics writes a table of what each stage spent its time on to w. Times for the check
+// stage are summed across every worker, so they can be longer than the scan.
+func (p *pipeline) writeMetrics(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Stage\tItems\tBusy\tWaiting For Input\tBlocked On Output")
+	for _, s := range []*stage{&p.discover, &p.resolve, &p.check, &p.evaluate, &p.emit} {
+		fmt.Fprintf(
+			tw, "%s\t%d\t%s\t%s\t%s\n",
+			s.name,
+			s.items.Load(),
+			time.Duration(s.busy.Load()).Round(time.Millisecond),
+			time.Duration(s.waiting.Load()).Round(time.Millisecond),
+			time.Duration(s.blocked.Load()).Round(time.Millisecond),
+		)
+	}
+	return tw.Flush()
+}
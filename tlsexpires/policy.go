@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// issuerFlags is a flag.Value for the repeatable -expect-issuer flag.
+type issuerFlags []string
+
+var (
+	expectIssuers   issuerFlags
+	maxValidityDays = flag.Int("max-validity-days", 0, "Certificates valid for more than this many days in total, from NotBefore to NotAfter, are reported with a warning, like 398 for the CA/Browser Forum limit on public certificates. An over-long certificate is usually from an internal CA that skipped our policy. 0 doesn't check")
+)
+
+func init() {
+	flag.Var(&expectIssuers, "expect-issuer", "A CA a certificate must be issued by, like \"Let's Encrypt\", matched without regard to case against the issuer's distinguished name. A certificate from any other CA is reported as an error, to catch certificates from CAs that shouldn't be issuing ours. Can be repeated to allow several CAs. issuerRules in -config set the CAs for hosts with certain labels")
+}
+
+// String implements flag.Value.String().
+func (i *issuerFlags) String() string {
+	return strings.Join(*i, ",")
+}
+
+// Set implements flag.Value.Set().
+func (i *issuerFlags) Set(s string) error {
+	if s = strings.TrimSpace(s); s == "" {
+		return fmt.Errorf("-expect-issuer can't be empty")
+	}
+	*i = append(*i, s)
+	return nil
+}
+
+// issuerRule sets the CAs that may issue the certificates of hosts with certain labels, instead
+// of -expect-issuer.
+//
+// In YAML:
+//
+//	issuerRules:
+//	  - labels: {team: payments}
+//	    issuers: [DigiCert]
+//	  - labels: {env: dev}
+//	    issuers: [Let's Encrypt, Internal CA]
+type issuerRule struct {
+	// Labels are the labels, and their values, a host must have for the rule to apply to it. A
+	// rule without labels applies to every host.
+	Labels map[string]string `yaml:"labels" toml:"labels"`
+	// Issuers are the CAs allowed to issue the certificates, like -expect-issuer.
+	Issuers []string `yaml:"issuers" toml:"issuers"`
+}
+
+// validate checks r's options.
+func (r *issuerRule) validate() error {
+	if len(r.Issuers) == 0 {
+		return fmt.Errorf("issuers must be set")
+	}
+	for _, i := range r.Issuers {
+		if strings.TrimSpace(i) == "" {
+			return fmt.Errorf("issuers can't be empty")
+		}
+	}
+	return nil
+}
+
+// matches reports if r applies to a host with labels.
+func (r *issuerRule) matches(labels map[string]string) bool {
+	for k, want := range r.Labels {
+		if got, ok := labels[k]; !ok || got != want {
+			return false
+		}
+	}
+	return true
+}
+
+// expectedIssuers returns the CAs allowed to issue the certificate of a host with labels, from
+// the first issuerRule in -config that matches it, or -expect-issuer. It returns nil if any CA
+// is allowed.
+func expectedIssuers(labels map[string]string) []string {
+	for _, r := range issuerRules {
+		if r.matches(labels) {
+			return r.Issuers
+		}
+	}
+	return expectIssuers
+}
+
+// checkIssuer records a finding in v if its certificate was issued by a CA it doesn't expect. v
+// must have its labels.
+func checkIssuer(v *result) {
+	expected := expectedIssuers(v.Labels)
+	// Without an issuer we didn't get a certificate, which is its own finding.
+	if v.Issuer == "" || len(expected) == 0 {
+		return
+	}
+	issuer := strings.ToLower(v.Issuer)
+	for _, e := range expected {
+		if strings.Contains(issuer, strings.ToLower(e)) {
+			return
+		}
+	}
+	v.find(findingUnexpectedIssuer)
+	v.Err = fmt.Sprintf("certificate was issued by %s, which is not one of the expected issuers %q", v.Issuer, expected)
+}
+
+// checkValidity records a finding in v if its certificate is valid for more than maxDays in
+// total. maxDays of 0 is no limit.
+func checkValidity(v *result, maxDays int) {
+	if maxDays <= 0 || v.IssuedOn.IsZero() || v.ExpiresOn.IsZero() {
+		return
+	}
+	if validityDays(v.IssuedOn, v.ExpiresOn) > maxDays {
+		v.find(findingLongValidity)
+	}
+}
+
+// validityDays returns the number of days a certificate valid from notBefore to notAfter is
+// valid for, counting a partial day as a whole one.
+func validityDays(notBefore, notAfter time.Time) int {
+	return int(math.Ceil(notAfter.Sub(notBefore).Hours() / 24))
+}
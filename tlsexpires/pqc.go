@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"net"
+	"slices"
+)
+
+var checkPQC = flag.Bool("pqc", false, "Also check which post-quantum hybrid key exchange groups, like X25519MLKEM768, each server accepts by offering them one at a time, for a post-quantum readiness inventory. A server that turns all of them down gets a warning. Only TLS 1.3 has them. This makes 3 more connections per host")
+
+// pqcGroups are the post-quantum hybrid key exchange groups -pqc offers, the most widely
+// deployed first.
+var pqcGroups = []tls.CurveID{tls.X25519MLKEM768, tls.SecP256r1MLKEM768, tls.SecP384r1MLKEM1024}
+
+// pqcGroup is the result of a handshake offering only one of the pqcGroups.
+type pqcGroup struct {
+	// Group is the group we offered.
+	Group string `json:"group"`
+	// Accepted is if the server completed the handshake with it.
+	Accepted bool `json:"accepted"`
+	// Error is why the handshake failed, if it did.
+	Error string `json:"error,omitempty"`
+	// rejected is if the server turned the group down with a TLS alert, instead of the
+	// handshake failing some other way, like the connection timing out.
+	rejected bool
+}
+
+// pqcSummary is how ready the hosts checked with -pqc are for post-quantum key exchange.
+type pqcSummary struct {
+	// Hosts is the number of hosts checked with -pqc.
+	Hosts int `json:"hosts"`
+	// Accepting is the number of those that accept at least one hybrid group.
+	Accepting int `json:"accepting"`
+	// Negotiated is the number of those that picked a hybrid group when offered it alongside
+	// the classic groups, like clients do.
+	Negotiated int `json:"negotiated"`
+}
+
+// checkPQCGroups connects to dialAddr once for each of pqcGroups, offering only that group, to
+// find which the server accepts.
+func checkPQCGroups(ctx context.Context, d contextDialer, dialAddr, host string, opts *targetConfig) []pqcGroup {
+	groups := make([]pqcGroup, 0, len(pqcGroups))
+	for _, id := range pqcGroups {
+		g := pqcGroup{Group: id.String()}
+		conf := opts.tlsConfig(host)
+		conf.MinVersion, conf.MaxVersion = tls.VersionTLS13, tls.VersionTLS13
+		conf.CurvePreferences = []tls.CurveID{id}
+		conn, err := dialTLS(ctx, d, dialAddr, conf, opts.STARTTLS)
+		if err != nil {
+			// crypto/tls reports an alert from the server as a "remote error".
+			var opErr *net.OpError
+			g.Error, g.rejected = err.Error(), errors.As(err, &opErr) && opErr.Op == "remote error"
+			groups = append(groups, g)
+			continue
+		}
+		g.Accepted = conn.ConnectionState().CurveID == id
+		conn.Close()
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// pqcRejected reports if the server turned down every one of groups. It is false if a handshake
+// failed for some other reason and the server accepted none of the rest, since then we don't know.
+func pqcRejected(groups []pqcGroup) bool {
+	for _, g := range groups {
+		if g.Accepted || !g.rejected {
+			return false
+		}
+	}
+	return len(groups) > 0
+}
+
+// summarizePQC returns how ready the hosts of results are for post-quantum key exchange, or nil
+// if none were checked with -pqc.
+func summarizePQC(results []result) *pqcSummary {
+	var s pqcSummary
+	for _, v := range results {
+		if v.PostQuantum == nil {
+			continue
+		}
+		s.Hosts++
+		if slices.ContainsFunc(v.PostQuantum, func(g pqcGroup) bool { return g.Accepted }) {
+			s.Accepting++
+		}
+		if slices.ContainsFunc(pqcGroups, func(id tls.CurveID) bool { return id.String() == v.KeyExchange }) {
+			s.Negotiated++
+		}
+	}
+	if s.Hosts == 0 {
+		return nil
+	}
+	return &s
+}
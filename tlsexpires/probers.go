@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// probeTarget is a target for a prober to check.
+type probeTarget struct {
+	// HostPort is the target as we were given it, like example.com:443, or file:/etc/cert.pem
+	// for a stored certificate.
+	HostPort string
+	// Addr is the IP address to connect to instead of resolving the host, if it is set.
+	Addr string
+	// Dialer makes the connections to the target, which go through its zone.
+	Dialer contextDialer
+	// Options are the options to check the target with, see optionsFor().
+	Options *targetConfig
+}
+
+// prober checks the certificate of a kind of target. Probe returns an error if it couldn't get a
+// certificate to check at all, like when a handshake fails, which is reported as a handshake
+// failure. Anything wrong with a certificate it did get is recorded in the returned result.
+//
+// A protocol we don't support, like an in-house one, is added by a file that implements a prober
+// for it and registers it with registerProber() from an init(), without touching how targets
+// are dispatched to their prober. The file goes in this package, as probers are compiled in
+// rather than imported.
+type prober interface {
+	Probe(ctx context.Context, t probeTarget) (result, error)
+}
+
+var (
+	// probers are the probers of servers, keyed by the protocol a target picks with protocol:
+	// in -config.
+	probers = map[string]prober{}
+	// storedProbers are the probers of certificates that are stored somewhere instead of served,
+	// keyed by the prefix of their targets.
+	storedProbers = map[string]prober{}
+)
+
+func init() {
+	// STARTTLS is a way to get to the TLS handshake, so the tls prober does it for targets with
+	// starttls: set.
+	registerProber("tls", serverProber(getTLSInfo))
+	registerProber("ssh", serverProber(getSSHInfo))
+
+	registerStoredProber(k8sSecretPrefix, storedProber(checkK8sSecret))
+	registerStoredProber(awsACMPrefix, storedProber(checkACMCert))
+	registerStoredProber(gcpCertPrefix, storedProber(checkGCPCert))
+	registerStoredProber(azureCertPrefix, storedProber(checkAzureCert))
+	registerStoredProber(certFilePrefix, storedProber(checkCertFile))
+	registerStoredProber(winStorePrefix, storedProber(checkWinStoreCert))
+	registerStoredProber(certStdinTarget, storedProber(checkCertStdin))
+}
+
+// registerProber makes p the prober of servers with protocol. It panics if protocol already has
+// a prober, as two files claiming the same protocol is a bug.
+func registerProber(protocol string, p prober) {
+	if _, ok := probers[protocol]; ok {
+		panic(fmt.Sprintf("protocol %q has two probers", protocol))
+	}
+	probers[protocol] = p
+}
+
+// registerStoredProber makes p the prober of targets that start with prefix, like "file:". It
+// panics if prefix already has a prober.
+func registerStoredProber(prefix string, p prober) {
+	if _, ok := storedProbers[prefix]; ok {
+		panic(fmt.Sprintf("target prefix %q has two probers", prefix))
+	}
+	storedProbers[prefix] = p
+}
+
+// proberNames returns the protocols of probers, sorted.
+func proberNames() []string {
+	var names []string
+	for name := range probers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// serverProber is a func that checks a server as a prober, like getTLSInfo().
+type serverProber func(ctx context.Context, d contextDialer, hostPort, addr string, opts *targetConfig) (result, error)
+
+// Probe implements prober.Probe().
+func (f serverProber) Probe(ctx context.Context, t probeTarget) (result, error) {
+	return f(ctx, t.Dialer, t.HostPort, t.Addr, t.Options)
+}
+
+// storedProber is a func that checks a stored certificate as a prober, like checkCertFile().
+// The func records its failures in the result it returns.
+type storedProber func(hostPort string) result
+
+// Probe implements prober.Probe().
+func (f storedProber) Probe(_ context.Context, t probeTarget) (result, error) {
+	return f(t.HostPort), nil
+}
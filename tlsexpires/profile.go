@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+var profileName = flag.String("profile", "", "The profile in -config to take flags from, like external or internal-strict, so a scan doesn't need a dozen flags to say how it is done. Flags given on the command line win over the profile's")
+
+// profile is a named set of flags in -config, which -profile picks. Each key is the name of a
+// flag and its value is what to set the flag to. A flag that can be repeated, like -output, can
+// have a list.
+//
+// In YAML:
+//
+//	profiles:
+//	  external:
+//	    warn-days: 30
+//	    proxy: socks5://bastion:1080
+//	    output: [json:external.json, text:-]
+//	  internal-strict:
+//	    warn-days: 45
+//	    ca-file: /etc/pki/internal-root.pem
+//	    crl: true
+type profile map[string]any
+
+// profileFlagsNotAllowed are flags a profile can't set, because we have read them by the time
+// we read the profile.
+var profileFlagsNotAllowed = []string{"config", "profile"}
+
+// validate checks that every key of p is a flag a profile can set, with a value we can set it to.
+func (p profile) validate() error {
+	for _, name := range slices.Sorted(maps.Keys(p)) {
+		if slices.Contains(profileFlagsNotAllowed, name) {
+			return fmt.Errorf("a profile can't set -%s", name)
+		}
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("-%s is not a flag", name)
+		}
+		if _, err := profileValues(p[name]); err != nil {
+			return fmt.Errorf("-%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// profileValues returns what to set a flag to for v, its value in a profile. A list sets the
+// flag once for each of its values.
+func profileValues(v any) ([]string, error) {
+	list, ok := v.([]any)
+	if !ok {
+		list = []any{v}
+	}
+	var values []string
+	for _, e := range list {
+		switch e.(type) {
+		case nil, []any, map[string]any:
+			return nil, fmt.Errorf("must be a value or a list of values")
+		}
+		values = append(values, fmt.Sprint(e))
+	}
+	return values, nil
+}
+
+// applyProfile sets the flags of the -profile in -config that weren't given on the command
+// line, which fs parsed. It must be called right after fs.Parse(), before anything reads the
+// flags. fs is flag.CommandLine for a scan, or the FlagSet of a subcommand.
+func applyProfile(fs *flag.FlagSet) error {
+	if *profileName == "" {
+		return nil
+	}
+	if *configFile == "" {
+		return fmt.Errorf("-profile needs a -config with the profile in it")
+	}
+	cf, err := decodeConfig(*configFile)
+	if err != nil {
+		return fmt.Errorf("-config: %s", err)
+	}
+	p, ok := cf.Profiles[*profileName]
+	if !ok {
+		return fmt.Errorf("-config has no profile %q, it has %s", *profileName, strings.Join(slices.Sorted(maps.Keys(cf.Profiles)), ", "))
+	}
+	if err := p.validate(); err != nil {
+		return fmt.Errorf("-profile %s: %s", *profileName, err)
+	}
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, name := range slices.Sorted(maps.Keys(p)) {
+		if given[name] {
+			continue
+		}
+		values, _ := profileValues(p[name])
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("-profile %s: -%s: %s", *profileName, name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyProfile(t *testing.T) {
+	keepFlags(t, "config", "profile", "warn-days", "max-validity-days", "preserve-order")
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(config, []byte(`
+profiles:
+  strict:
+    warn-days: 45
+    max-validity-days: 200
+    preserve-order: true
+  bad:
+    not-a-flag: 1
+  nested:
+    warn-days: {days: 45}
+  recursive:
+    profile: strict
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc            string
+		args            []string
+		wantWarnDays    int
+		wantMaxValidity int
+		wantPreserve    bool
+		wantErr         bool
+	}{
+		{
+			desc:            "the profile sets the flags",
+			args:            []string{"-config", config, "-profile", "strict"},
+			wantWarnDays:    45,
+			wantMaxValidity: 200,
+			wantPreserve:    true,
+		},
+		{
+			desc:            "the command line wins over the profile",
+			args:            []string{"-config", config, "-profile", "strict", "-warn-days", "10"},
+			wantWarnDays:    10,
+			wantMaxValidity: 200,
+			wantPreserve:    true,
+		},
+		{
+			desc:            "without -profile the defaults stay",
+			args:            []string{"-config", config},
+			wantWarnDays:    30,
+			wantMaxValidity: 0,
+		},
+		{
+			desc:    "a profile that doesn't exist",
+			args:    []string{"-config", config, "-profile", "lax"},
+			wantErr: true,
+		},
+		{
+			desc:    "a profile with a key that isn't a flag",
+			args:    []string{"-config", config, "-profile", "bad"},
+			wantErr: true,
+		},
+		{
+			desc:    "a profile with a value that isn't one",
+			args:    []string{"-config", config, "-profile", "nested"},
+			wantErr: true,
+		},
+		{
+			desc:    "a profile can't pick a profile",
+			args:    []string{"-config", config, "-profile", "recursive"},
+			wantErr: true,
+		},
+		{
+			desc:    "-profile without -config",
+			args:    []string{"-profile", "strict"},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		for name, value := range map[string]string{"config": "", "profile": "", "warn-days": "30", "max-validity-days": "0", "preserve-order": "false"} {
+			setFlag(t, name, value)
+		}
+		fs := subcommandFlags("test")
+		if err := fs.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+
+		err := applyProfile(fs)
+		switch {
+		case err == nil && test.wantErr:
+			t.Errorf("TestApplyProfile(%s): got err == nil, want err != nil", test.desc)
+			continue
+		case err != nil && !test.wantErr:
+			t.Errorf("TestApplyProfile(%s): got err == %s, want err == nil", test.desc, err)
+			continue
+		case err != nil:
+			continue
+		}
+		if *warnDays != test.wantWarnDays || *maxValidityDays != test.wantMaxValidity || *preserveOrder != test.wantPreserve {
+			t.Errorf("TestApplyProfile(%s): got -warn-days=%d -max-validity-days=%d -preserve-order=%t, want %d, %d, %t", test.desc, *warnDays, *maxValidityDays, *preserveOrder, test.wantWarnDays, test.wantMaxValidity, test.wantPreserve)
+		}
+	}
+}
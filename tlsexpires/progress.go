@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var noProgress = flag.Bool("no-progress", false, "Don't show how far along a scan is on stderr. It is only shown when stderr is a terminal")
+
+// progressInterval is how often we redraw the progress line.
+const progressInterval = 250 * time.Millisecond
+
+// progress draws a line on stderr with how many hosts we have checked, how many of them failed
+// and about how long the rest will take. A nil *progress draws nothing, so callers don't need to
+// check if it is shown.
+type progress struct {
+	w     io.Writer
+	start time.Time
+
+	// queued is how many checks have been queued, checked how many have finished.
+	queued, checked, failed atomic.Int64
+	// allQueued is set once we have every target, before that we don't know the total.
+	allQueued atomic.Bool
+
+	stop, stopped chan struct{}
+}
+
+// startProgress starts drawing the progress of a scan. It returns nil if -no-progress is set or
+// stderr isn't a terminal, like when we run from cron, and for the scans of -listen, which nobody
+// watches. It also returns nil if -stream is writing results to the same terminal, where the
+// two would garble each other.
+func startProgress() *progress {
+	if *noProgress || *listen != "" || !isTerminal(os.Stderr) || (*stream && isTerminal(os.Stdout)) {
+		return nil
+	}
+	p := &progress{w: os.Stderr, start: time.Now(), stop: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(p.stopped)
+		t := time.NewTicker(progressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				p.draw()
+			case <-p.stop:
+				// Leave the line empty for whatever is written next.
+				fmt.Fprint(p.w, "\r\033[K")
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// isTerminal reports if f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// queue records that n more checks were queued.
+func (p *progress) queue(n int) {
+	if p != nil {
+		p.queued.Add(int64(n))
+	}
+}
+
+// doneQueueing records that every check has been queued, so we know the total.
+func (p *progress) doneQueueing() {
+	if p != nil {
+		p.allQueued.Store(true)
+	}
+}
+
+// record records the result of a check.
+func (p *progress) record(v result) {
+	if p == nil {
+		return
+	}
+	p.checked.Add(1)
+	if v.Status == statusError {
+		p.failed.Add(1)
+	}
+}
+
+// end stops drawing and clears the progress line.
+func (p *progress) end() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.stopped
+}
+
+// draw redraws the progress line.
+func (p *progress) draw() {
+	checked, queued, failed := p.checked.Load(), p.queued.Load(), p.failed.Load()
+	total := fmt.Sprintf("%d+", queued)
+	left := ""
+	if p.allQueued.Load() {
+		total = fmt.Sprint(queued)
+		if checked > 0 && checked < queued {
+			perCheck := time.Since(p.start) / time.Duration(checked)
+			left = fmt.Sprintf(", about %s left", (perCheck * time.Duration(queued-checked)).Round(time.Second))
+		}
+	}
+	fmt.Fprintf(p.w, "\r\033[KChecked %d of %s, %d failed%s", checked, total, failed, left)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var prometheusURL = flag.String("prometheus-url", "", "Check the endpoints the Prometheus server at this URL, like http://prometheus:9090, probes with blackbox_exporter instead of -file. Each result gets the labels Prometheus has for the probe")
+
+// promClient is used to query -prometheus-url.
+var promClient = &http.Client{Timeout: time.Minute}
+
+// promProvider provides the HTTPS and TLS endpoints that a Prometheus server probes with
+// blackbox_exporter, so an inventory can start from what is already monitored. We ask Prometheus
+// instead of reading its config because its targets are usually found by service discovery.
+type promProvider struct {
+	targets []target
+}
+
+// newPromProvider returns a promProvider for the Prometheus server at u.
+func newPromProvider(ctx context.Context, u string) (*promProvider, error) {
+	api, err := url.JoinPath(u, "/api/v1/targets")
+	if err != nil {
+		return nil, fmt.Errorf("-prometheus-url=%s is not a URL: %s", u, err)
+	}
+	body, err := restGet(ctx, promClient, api+"?state=active")
+	if err != nil {
+		return nil, fmt.Errorf("-prometheus-url: %s", err)
+	}
+	resp := struct {
+		Status string `json:"status"`
+		Data   struct {
+			ActiveTargets []struct {
+				Labels    map[string]string `json:"labels"`
+				ScrapeURL string            `json:"scrapeUrl"`
+			} `json:"activeTargets"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("-prometheus-url: could not decode the targets: %s", err)
+	}
+	if resp.Status != "success" {
+		return nil, fmt.Errorf("-prometheus-url: listing the targets returned status %q", resp.Status)
+	}
+
+	p := &promProvider{}
+	seen := map[string]bool{}
+	for _, t := range resp.Data.ActiveTargets {
+		hostPort, ok := probedHostPort(t.ScrapeURL)
+		if !ok || seen[hostPort] {
+			continue
+		}
+		seen[hostPort] = true
+		labels := map[string]string{}
+		for k, v := range t.Labels {
+			// Labels starting with __ are internal to Prometheus.
+			if !strings.HasPrefix(k, "__") {
+				labels[k] = v
+			}
+		}
+		p.targets = append(p.targets, target{HostPort: hostPort, Labels: labels})
+	}
+	return p, nil
+}
+
+// probedHostPort returns the host:port a blackbox_exporter scrape URL probes over TLS. Probes of
+// https URLs always use TLS. Probes of a plain host:port only count if their module looks like it
+// is for TLS, like "tcp_tls" or "ssl_expiry", because the module's config isn't in the API.
+func probedHostPort(scrapeURL string) (string, bool) {
+	u, err := url.Parse(scrapeURL)
+	if err != nil || !strings.HasSuffix(u.Path, "/probe") {
+		return "", false
+	}
+	q := u.Query()
+	probed := q.Get("target")
+	if probed == "" {
+		return "", false
+	}
+	if t, err := url.Parse(probed); err == nil && t.Scheme != "" && t.Host != "" {
+		if t.Scheme != "https" {
+			return "", false
+		}
+		port := t.Port()
+		if port == "" {
+			port = "443"
+		}
+		return net.JoinHostPort(t.Hostname(), port), true
+	}
+	module := strings.ToLower(q.Get("module"))
+	if !strings.Contains(module, "tls") && !strings.Contains(module, "ssl") {
+		return "", false
+	}
+	if _, _, err := net.SplitHostPort(probed); err != nil {
+		return "", false
+	}
+	return probed, true
+}
+
+// Next implements targetProvider.Next().
+func (p *promProvider) Next(ctx context.Context) (target, error) {
+	if err := ctx.Err(); err != nil {
+		return target{}, err
+	}
+	if len(p.targets) == 0 {
+		return target{}, io.EOF
+	}
+	t := p.targets[0]
+	p.targets = p.targets[1:]
+	return t, nil
+}
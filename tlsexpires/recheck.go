@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// recheck implements the "recheck" subcommand. It reads the json output of a previous run and
+// checks only the hosts whose status matched -only. This makes the fix and verify loop during
+// an incident fast, because we don't have to rescan every host we know about.
+func recheck(args []string) error {
+	fs := subcommandFlags("recheck")
+	from := fs.String("from", "", "The path to the json output (-format=json) of a previous run")
+	only := fs.String("only", "errors,warnings", "A comma separated list of statuses to recheck: 'errors', 'warnings'")
+	fs.Parse(args)
+	if err := applyProfile(fs); err != nil {
+		return err
+	}
+
+	if *from == "" {
+		return fmt.Errorf("recheck requires -from")
+	}
+
+	want := map[status]bool{}
+	for _, s := range strings.Split(*only, ",") {
+		switch strings.TrimSpace(s) {
+		case "errors":
+			want[statusError] = true
+		case "warnings":
+			want[statusWarning] = true
+		default:
+			return fmt.Errorf("-only had %q, which is not 'errors' or 'warnings'", s)
+		}
+	}
+
+	last, err := readRun(*from)
+	if err != nil {
+		return err
+	}
+
+	// With -all-ips there is a result for each address of a host, but we check the host once and
+	// it is expanded to its addresses again. The labels of the last run come along, since the
+	// provider that gave them to a host isn't asked again.
+	labels := &targetLabels{labels: map[string]map[string]string{}}
+	var rechecked []string
+	seen := map[string]bool{}
+	for _, v := range last.Results {
+		if !want[v.Status] || seen[v.HostPort] {
+			continue
+		}
+		seen[v.HostPort] = true
+		rechecked = append(rechecked, v.HostPort)
+		labels.set(v.HostPort, v.Labels)
+	}
+
+	hostPorts := make(chan string, 1)
+	go func() {
+		defer close(hostPorts)
+		for _, hostPort := range rechecked {
+			hostPorts <- hostPort
+		}
+	}()
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	output(ctx, hostPorts, labels, true)
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"time"
+)
+
+// rotation is a host that started serving a different certificate.
+type rotation struct {
+	certChange
+	// Renewal is if the change looks like a renewal: the same issuer and a later expiry.
+	// Anything else is worth a look, it could be a bad deploy or someone intercepting our
+	// traffic.
+	Renewal bool `json:"renewal"`
+}
+
+// rotationEvent is what the rotation notification templates receive.
+type rotationEvent struct {
+	// RunID is the ID of the scan that saw the new certificates.
+	RunID string `json:"runId,omitempty"`
+	// Started is when the scan started.
+	Started time.Time `json:"started"`
+	// Rotations are the hosts that serve a different certificate than in the scan before.
+	Rotations []rotation `json:"rotations"`
+}
+
+// Unexpected is how many of the rotations aren't renewals.
+func (e rotationEvent) Unexpected() int {
+	n := 0
+	for _, r := range e.Rotations {
+		if !r.Renewal {
+			n++
+		}
+	}
+	return n
+}
+
+// newRotationEvent returns the hosts in cur that serve a different certificate than in prev.
+func newRotationEvent(prev, cur run) rotationEvent {
+	e := rotationEvent{RunID: cur.ID, Started: cur.Started}
+	for _, c := range diffRuns(prev, cur).NewCerts {
+		renewal := c.Was.Issuer == c.Is.Issuer && c.Is.ExpiresOn.After(c.Was.ExpiresOn)
+		e.Rotations = append(e.Rotations, rotation{certChange: c, Renewal: renewal})
+	}
+	return e
+}
+
+// previousScan returns the scan to look for rotations against: the last scan, or if we haven't
+// finished one since we started, the one in -history. ok is false if there is neither. Only
+// scan() calls it, which is what changes e.last, so it doesn't need e.mu.
+func (e *exporter) previousScan() (r run, ok bool) {
+	if e.scanned {
+		return e.last, true
+	}
+	if *historyFile == "" {
+		return run{}, false
+	}
+	r, err := readRun(*historyFile)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("could not read -history to look for certificate rotations: %s", err)
+		}
+		return run{}, false
+	}
+	return r, true
+}
+
+// notifyRotations logs every rotation in e and sends them to -notify-webhook.
+func notifyRotations(e rotationEvent) error {
+	for _, r := range e.Rotations {
+		kind := "renewed"
+		if !r.Renewal {
+			kind = "changed unexpectedly"
+		}
+		log.Printf("certificate for %s %s: fingerprint %s, issuer %q, expires %s (was %s, issuer %q, expires %s)",
+			r.Is.resultKey(), kind, r.Is.Fingerprint, r.Is.Issuer, r.Is.ExpiresOn.Format(time.DateOnly),
+			r.Was.Fingerprint, r.Was.Issuer, r.Was.ExpiresOn.Format(time.DateOnly))
+	}
+	if len(e.Rotations) == 0 || *notifyWebhook == "" {
+		return nil
+	}
+
+	t, err := loadNotifyTemplates()
+	if err != nil {
+		return err
+	}
+	body, err := renderNotification(t, "rotation.webhook", e)
+	if err != nil {
+		return err
+	}
+	return deliver([]delivery{{Key: "rotation/" + e.RunID, Channel: "rotation", URL: *notifyWebhook, Body: []byte(body)}})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+)
+
+var routesFile = flag.String("routes", "", "The path to a json file of routes that send the notifications about hosts with certain labels or hostnames to their own Slack, Teams, webhook, email and PagerDuty destinations, so one scan can serve many teams. Hosts no route matches are notified through the -notify-*, -smtp-to and -pagerduty-routing-key flags")
+
+// destinations are where the notifications about a set of hosts go.
+type destinations struct {
+	// Webhook, Slack and Teams are like -notify-webhook, -notify-slack and -notify-teams.
+	Webhook string `json:"webhook,omitempty"`
+	Slack   string `json:"slack,omitempty"`
+	Teams   string `json:"teams,omitempty"`
+	// Email are the addresses to email through -smtp-server, like -smtp-to.
+	Email []string `json:"email,omitempty"`
+	// PagerDuty is the routing key of the PagerDuty integration to open incidents in, like
+	// -pagerduty-routing-key.
+	PagerDuty string `json:"pagerdutyRoutingKey,omitempty"`
+}
+
+// flagDestinations returns the destinations of our flags, which are for hosts no route matches.
+func flagDestinations() destinations {
+	d := destinations{Webhook: *notifyWebhook, Slack: *notifySlack, Teams: *notifyTeams, PagerDuty: *pagerDutyKey}
+	for _, addr := range strings.Split(*smtpTo, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			d.Email = append(d.Email, addr)
+		}
+	}
+	return d
+}
+
+// route sends the notifications about the hosts it matches to its own destinations. A host
+// matches if it has all of Labels and its hostname matches one of Hosts. A route without Labels
+// or Hosts doesn't check that part.
+type route struct {
+	// Name is the name of the route, which keeps its notifications apart from other routes'.
+	Name string `json:"name"`
+	// Labels are the labels, and their values, a host must have, like {"team": "payments"}.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Hosts are path.Match() patterns for hostnames, like "*.payments.example.com".
+	Hosts []string `json:"hosts,omitempty"`
+	destinations
+}
+
+// routeConfig is the format of the -routes file.
+type routeConfig struct {
+	// Routes are checked in order and the first route a host matches is the one its
+	// notifications go through.
+	Routes []*route `json:"routes"`
+}
+
+// loadRoutes reads -routes. It returns no routes if it isn't set.
+func loadRoutes() ([]*route, error) {
+	if *routesFile == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(*routesFile)
+	if err != nil {
+		return nil, err
+	}
+	rc := routeConfig{}
+	if err := json.Unmarshal(b, &rc); err != nil {
+		return nil, fmt.Errorf("-routes=%s is not valid: %s", *routesFile, err)
+	}
+
+	seen := map[string]bool{}
+	for _, r := range rc.Routes {
+		if r.Name == "" || seen[r.Name] {
+			return nil, fmt.Errorf("-routes=%s: every route must have a unique name, had %q", *routesFile, r.Name)
+		}
+		seen[r.Name] = true
+		for _, h := range r.Hosts {
+			if _, err := path.Match(h, ""); err != nil {
+				return nil, fmt.Errorf("route %q has bad host pattern %q: %s", r.Name, h, err)
+			}
+		}
+		if len(r.Email) > 0 && (*smtpServer == "" || *smtpFrom == "") {
+			return nil, fmt.Errorf("route %q emails, which requires -smtp-server and -smtp-from", r.Name)
+		}
+	}
+	return rc.Routes, nil
+}
+
+// matches reports if v is one of the hosts r routes.
+func (r *route) matches(v result) bool {
+	for k, want := range r.Labels {
+		if got, ok := v.Labels[k]; !ok || got != want {
+			return false
+		}
+	}
+	if len(r.Hosts) == 0 {
+		return true
+	}
+	// Stored certificates don't have a host, their pattern matches the whole target.
+	host, _, err := net.SplitHostPort(v.HostPort)
+	if err != nil {
+		host = v.HostPort
+	}
+	host = strings.ToLower(host)
+	for _, pattern := range r.Hosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// routeFor returns the first of routes that matches v, or nil if none do.
+func routeFor(routes []*route, v result) *route {
+	for _, r := range routes {
+		if r.matches(v) {
+			return r
+		}
+	}
+	return nil
+}
+
+// destinationsFor returns where the notifications about v go.
+func destinationsFor(routes []*route, v result) destinations {
+	if r := routeFor(routes, v); r != nil {
+		return r.destinations
+	}
+	return flagDestinations()
+}
+
+// routedRun is the part of a run whose notifications go to the same destinations.
+type routedRun struct {
+	// name is the name of the route, "" for the hosts no route matches.
+	name string
+	destinations
+	run
+}
+
+// key returns the outbox key of the notification for channel, which is unique to the route so
+// each route's notification is sent.
+func (rr routedRun) key(channel string) string {
+	if rr.name == "" {
+		return channel + "/" + rr.ID
+	}
+	return channel + "/" + rr.name + "/" + rr.ID
+}
+
+// splitRun splits r by the route each of its results goes through. The hosts no route matches
+// come first, with the destinations of our flags, followed by each route that matched any.
+// Without routes, that is all of r, even if it has no results.
+func splitRun(routes []*route, r run) []routedRun {
+	unrouted := routedRun{destinations: flagDestinations(), run: r}
+	unrouted.Results = nil
+	byRoute := map[*route]*routedRun{}
+	for _, v := range r.Results {
+		rt := routeFor(routes, v)
+		if rt == nil {
+			unrouted.Results = append(unrouted.Results, v)
+			continue
+		}
+		rr, ok := byRoute[rt]
+		if !ok {
+			rr = &routedRun{name: rt.Name, destinations: rt.destinations, run: r}
+			rr.Results = nil
+			byRoute[rt] = rr
+		}
+		rr.Results = append(rr.Results, v)
+	}
+
+	var out []routedRun
+	if len(routes) == 0 || len(unrouted.Results) > 0 {
+		out = append(out, unrouted)
+	}
+	for _, rt := range routes {
+		if rr, ok := byRoute[rt]; ok {
+			out = append(out, *rr)
+		}
+	}
+	return out
+}
@@ -0,0 +1,5 @@
+// Package scanpb is the gRPC API tlsexpires serves with -grpc-listen. It is generated from
+// scan.proto.
+package scanpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative scan.proto
@@ -0,0 +1,490 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: scan.proto
+
+// The gRPC API tlsexpires serves with -grpc-listen, for systems that check many certificates
+// and want typed results instead of parsing our json.
+
+package scanpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Status is the outcome of checking a target.
+type Status int32
+
+const (
+	Status_STATUS_UNSPECIFIED Status = 0
+	// STATUS_OK means we got a certificate and it isn't expiring soon.
+	Status_STATUS_OK Status = 1
+	// STATUS_WARNING means we got a certificate, but it expires within warn_days or has a
+	// weakness.
+	Status_STATUS_WARNING Status = 2
+	// STATUS_ERROR means we couldn't get a certificate, or the target was invalid.
+	Status_STATUS_ERROR Status = 3
+)
+
+// Enum value maps for Status.
+var (
+	Status_name = map[int32]string{
+		0: "STATUS_UNSPECIFIED",
+		1: "STATUS_OK",
+		2: "STATUS_WARNING",
+		3: "STATUS_ERROR",
+	}
+	Status_value = map[string]int32{
+		"STATUS_UNSPECIFIED": 0,
+		"STATUS_OK":          1,
+		"STATUS_WARNING":     2,
+		"STATUS_ERROR":       3,
+	}
+)
+
+func (x Status) Enum() *Status {
+	p := new(Status)
+	*p = x
+	return p
+}
+
+func (x Status) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Status) Descriptor() protoreflect.EnumDescriptor {
+	return file_scan_proto_enumTypes[0].Descriptor()
+}
+
+func (Status) Type() protoreflect.EnumType {
+	return &file_scan_proto_enumTypes[0]
+}
+
+func (x Status) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Status.Descriptor instead.
+func (Status) EnumDescriptor() ([]byte, []int) {
+	return file_scan_proto_rawDescGZIP(), []int{0}
+}
+
+// Target is a host to check and the options to check it with. Options that aren't set use the
+// flags tlsexpires was started with.
+type Target struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// id is returned in the target's Result. It can be anything.
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// host is the host:port to check. CIDRs and port ranges aren't allowed.
+	Host string `protobuf:"bytes,2,opt,name=host,proto3" json:"host,omitempty"`
+	// sni is the server name we ask for and verify the certificate against. Defaults to the host in
+	// host.
+	Sni string `protobuf:"bytes,3,opt,name=sni,proto3" json:"sni,omitempty"`
+	// protocol is "tls", the default, or "ssh" to check an OpenSSH host certificate.
+	Protocol string `protobuf:"bytes,4,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	// starttls is the plain text protocol to upgrade to TLS, like "smtp".
+	Starttls string `protobuf:"bytes,5,opt,name=starttls,proto3" json:"starttls,omitempty"`
+	// warn_days overrides -warn-days.
+	WarnDays      *int32 `protobuf:"varint,6,opt,name=warn_days,json=warnDays,proto3,oneof" json:"warn_days,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Target) Reset() {
+	*x = Target{}
+	mi := &file_scan_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Target) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Target) ProtoMessage() {}
+
+func (x *Target) ProtoReflect() protoreflect.Message {
+	mi := &file_scan_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Target.ProtoReflect.Descriptor instead.
+func (*Target) Descriptor() ([]byte, []int) {
+	return file_scan_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Target) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Target) GetHost() string {
+	if x != nil {
+		return x.Host
+	}
+	return ""
+}
+
+func (x *Target) GetSni() string {
+	if x != nil {
+		return x.Sni
+	}
+	return ""
+}
+
+func (x *Target) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *Target) GetStarttls() string {
+	if x != nil {
+		return x.Starttls
+	}
+	return ""
+}
+
+func (x *Target) GetWarnDays() int32 {
+	if x != nil && x.WarnDays != nil {
+		return *x.WarnDays
+	}
+	return 0
+}
+
+// Result is the result of checking a Target. It has the same fields as a result in our json
+// output.
+type Result struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// id is the id of the Target.
+	Id       string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	HostPort string `protobuf:"bytes,2,opt,name=host_port,json=hostPort,proto3" json:"host_port,omitempty"`
+	Server   string `protobuf:"bytes,3,opt,name=server,proto3" json:"server,omitempty"`
+	Port     string `protobuf:"bytes,4,opt,name=port,proto3" json:"port,omitempty"`
+	// expires_on is when the certificate expires. Not set if status is STATUS_ERROR.
+	ExpiresOn *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=expires_on,json=expiresOn,proto3" json:"expires_on,omitempty"`
+	// days_remaining is how many whole days are left until expires_on.
+	DaysRemaining      int32             `protobuf:"varint,6,opt,name=days_remaining,json=daysRemaining,proto3" json:"days_remaining,omitempty"`
+	Issuer             string            `protobuf:"bytes,7,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	Sans               []string          `protobuf:"bytes,8,rep,name=sans,proto3" json:"sans,omitempty"`
+	TlsVersion         string            `protobuf:"bytes,9,opt,name=tls_version,json=tlsVersion,proto3" json:"tls_version,omitempty"`
+	ChainLength        int32             `protobuf:"varint,10,opt,name=chain_length,json=chainLength,proto3" json:"chain_length,omitempty"`
+	Fingerprint        string            `protobuf:"bytes,11,opt,name=fingerprint,proto3" json:"fingerprint,omitempty"`
+	Serial             string            `protobuf:"bytes,12,opt,name=serial,proto3" json:"serial,omitempty"`
+	KeyAlgorithm       string            `protobuf:"bytes,13,opt,name=key_algorithm,json=keyAlgorithm,proto3" json:"key_algorithm,omitempty"`
+	SignatureAlgorithm string            `protobuf:"bytes,14,opt,name=signature_algorithm,json=signatureAlgorithm,proto3" json:"signature_algorithm,omitempty"`
+	KeyWeaknesses      []string          `protobuf:"bytes,15,rep,name=key_weaknesses,json=keyWeaknesses,proto3" json:"key_weaknesses,omitempty"`
+	Labels             map[string]string `protobuf:"bytes,16,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// zone is the -zones zone the target was checked in.
+	Zone   string `protobuf:"bytes,17,opt,name=zone,proto3" json:"zone,omitempty"`
+	Status Status `protobuf:"varint,18,opt,name=status,proto3,enum=tlsexpires.v1.Status" json:"status,omitempty"`
+	// findings are why status isn't STATUS_OK, see "tlsexpires codes".
+	Findings []string `protobuf:"bytes,19,rep,name=findings,proto3" json:"findings,omitempty"`
+	// error is why we couldn't check the target.
+	Error         string `protobuf:"bytes,20,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Result) Reset() {
+	*x = Result{}
+	mi := &file_scan_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Result) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Result) ProtoMessage() {}
+
+func (x *Result) ProtoReflect() protoreflect.Message {
+	mi := &file_scan_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Result.ProtoReflect.Descriptor instead.
+func (*Result) Descriptor() ([]byte, []int) {
+	return file_scan_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Result) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Result) GetHostPort() string {
+	if x != nil {
+		return x.HostPort
+	}
+	return ""
+}
+
+func (x *Result) GetServer() string {
+	if x != nil {
+		return x.Server
+	}
+	return ""
+}
+
+func (x *Result) GetPort() string {
+	if x != nil {
+		return x.Port
+	}
+	return ""
+}
+
+func (x *Result) GetExpiresOn() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresOn
+	}
+	return nil
+}
+
+func (x *Result) GetDaysRemaining() int32 {
+	if x != nil {
+		return x.DaysRemaining
+	}
+	return 0
+}
+
+func (x *Result) GetIssuer() string {
+	if x != nil {
+		return x.Issuer
+	}
+	return ""
+}
+
+func (x *Result) GetSans() []string {
+	if x != nil {
+		return x.Sans
+	}
+	return nil
+}
+
+func (x *Result) GetTlsVersion() string {
+	if x != nil {
+		return x.TlsVersion
+	}
+	return ""
+}
+
+func (x *Result) GetChainLength() int32 {
+	if x != nil {
+		return x.ChainLength
+	}
+	return 0
+}
+
+func (x *Result) GetFingerprint() string {
+	if x != nil {
+		return x.Fingerprint
+	}
+	return ""
+}
+
+func (x *Result) GetSerial() string {
+	if x != nil {
+		return x.Serial
+	}
+	return ""
+}
+
+func (x *Result) GetKeyAlgorithm() string {
+	if x != nil {
+		return x.KeyAlgorithm
+	}
+	return ""
+}
+
+func (x *Result) GetSignatureAlgorithm() string {
+	if x != nil {
+		return x.SignatureAlgorithm
+	}
+	return ""
+}
+
+func (x *Result) GetKeyWeaknesses() []string {
+	if x != nil {
+		return x.KeyWeaknesses
+	}
+	return nil
+}
+
+func (x *Result) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Result) GetZone() string {
+	if x != nil {
+		return x.Zone
+	}
+	return ""
+}
+
+func (x *Result) GetStatus() Status {
+	if x != nil {
+		return x.Status
+	}
+	return Status_STATUS_UNSPECIFIED
+}
+
+func (x *Result) GetFindings() []string {
+	if x != nil {
+		return x.Findings
+	}
+	return nil
+}
+
+func (x *Result) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_scan_proto protoreflect.FileDescriptor
+
+const file_scan_proto_rawDesc = "" +
+	"\n" +
+	"\n" +
+	"scan.proto\x12\rtlsexpires.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xa6\x01\n" +
+	"\x06Target\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04host\x18\x02 \x01(\tR\x04host\x12\x10\n" +
+	"\x03sni\x18\x03 \x01(\tR\x03sni\x12\x1a\n" +
+	"\bprotocol\x18\x04 \x01(\tR\bprotocol\x12\x1a\n" +
+	"\bstarttls\x18\x05 \x01(\tR\bstarttls\x12 \n" +
+	"\twarn_days\x18\x06 \x01(\x05H\x00R\bwarnDays\x88\x01\x01B\f\n" +
+	"\n" +
+	"_warn_days\"\xd5\x05\n" +
+	"\x06Result\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1b\n" +
+	"\thost_port\x18\x02 \x01(\tR\bhostPort\x12\x16\n" +
+	"\x06server\x18\x03 \x01(\tR\x06server\x12\x12\n" +
+	"\x04port\x18\x04 \x01(\tR\x04port\x129\n" +
+	"\n" +
+	"expires_on\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\texpiresOn\x12%\n" +
+	"\x0edays_remaining\x18\x06 \x01(\x05R\rdaysRemaining\x12\x16\n" +
+	"\x06issuer\x18\a \x01(\tR\x06issuer\x12\x12\n" +
+	"\x04sans\x18\b \x03(\tR\x04sans\x12\x1f\n" +
+	"\vtls_version\x18\t \x01(\tR\n" +
+	"tlsVersion\x12!\n" +
+	"\fchain_length\x18\n" +
+	" \x01(\x05R\vchainLength\x12 \n" +
+	"\vfingerprint\x18\v \x01(\tR\vfingerprint\x12\x16\n" +
+	"\x06serial\x18\f \x01(\tR\x06serial\x12#\n" +
+	"\rkey_algorithm\x18\r \x01(\tR\fkeyAlgorithm\x12/\n" +
+	"\x13signature_algorithm\x18\x0e \x01(\tR\x12signatureAlgorithm\x12%\n" +
+	"\x0ekey_weaknesses\x18\x0f \x03(\tR\rkeyWeaknesses\x129\n" +
+	"\x06labels\x18\x10 \x03(\v2!.tlsexpires.v1.Result.LabelsEntryR\x06labels\x12\x12\n" +
+	"\x04zone\x18\x11 \x01(\tR\x04zone\x12-\n" +
+	"\x06status\x18\x12 \x01(\x0e2\x15.tlsexpires.v1.StatusR\x06status\x12\x1a\n" +
+	"\bfindings\x18\x13 \x03(\tR\bfindings\x12\x14\n" +
+	"\x05error\x18\x14 \x01(\tR\x05error\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01*U\n" +
+	"\x06Status\x12\x16\n" +
+	"\x12STATUS_UNSPECIFIED\x10\x00\x12\r\n" +
+	"\tSTATUS_OK\x10\x01\x12\x12\n" +
+	"\x0eSTATUS_WARNING\x10\x02\x12\x10\n" +
+	"\fSTATUS_ERROR\x10\x032C\n" +
+	"\aScanner\x128\n" +
+	"\x04Scan\x12\x15.tlsexpires.v1.Target\x1a\x15.tlsexpires.v1.Result(\x010\x01B3Z1github.com/johnsiilver/examples/tlsexpires/scanpbb\x06proto3"
+
+var (
+	file_scan_proto_rawDescOnce sync.Once
+	file_scan_proto_rawDescData []byte
+)
+
+func file_scan_proto_rawDescGZIP() []byte {
+	file_scan_proto_rawDescOnce.Do(func() {
+		file_scan_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_scan_proto_rawDesc), len(file_scan_proto_rawDesc)))
+	})
+	return file_scan_proto_rawDescData
+}
+
+var file_scan_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_scan_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_scan_proto_goTypes = []any{
+	(Status)(0),                   // 0: tlsexpires.v1.Status
+	(*Target)(nil),                // 1: tlsexpires.v1.Target
+	(*Result)(nil),                // 2: tlsexpires.v1.Result
+	nil,                           // 3: tlsexpires.v1.Result.LabelsEntry
+	(*timestamppb.Timestamp)(nil), // 4: google.protobuf.Timestamp
+}
+var file_scan_proto_depIdxs = []int32{
+	4, // 0: tlsexpires.v1.Result.expires_on:type_name -> google.protobuf.Timestamp
+	3, // 1: tlsexpires.v1.Result.labels:type_name -> tlsexpires.v1.Result.LabelsEntry
+	0, // 2: tlsexpires.v1.Result.status:type_name -> tlsexpires.v1.Status
+	1, // 3: tlsexpires.v1.Scanner.Scan:input_type -> tlsexpires.v1.Target
+	2, // 4: tlsexpires.v1.Scanner.Scan:output_type -> tlsexpires.v1.Result
+	4, // [4:5] is the sub-list for method output_type
+	3, // [3:4] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_scan_proto_init() }
+func file_scan_proto_init() {
+	if File_scan_proto != nil {
+		return
+	}
+	file_scan_proto_msgTypes[0].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_scan_proto_rawDesc), len(file_scan_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_scan_proto_goTypes,
+		DependencyIndexes: file_scan_proto_depIdxs,
+		EnumInfos:         file_scan_proto_enumTypes,
+		MessageInfos:      file_scan_proto_msgTypes,
+	}.Build()
+	File_scan_proto = out.File
+	file_scan_proto_goTypes = nil
+	file_scan_proto_depIdxs = nil
+}
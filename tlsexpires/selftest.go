@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// fixture is a deliberately broken TLS server, in the spirit of badssl.com, and the status we
+// expect a check of it to have.
+type fixture struct {
+	// Name describes what is wrong with the server.
+	Name string
+	// Want is the status check() must give the server.
+	Want status
+
+	chain  []*x509.Certificate
+	key    crypto.Signer
+	staple []byte
+}
+
+// testCA is the root and intermediate that sign our fixture certificates.
+type testCA struct {
+	root, inter       *x509.Certificate
+	rootKey, interKey *rsa.PrivateKey
+	serial            int64
+}
+
+// newTestCA creates a root and an intermediate it signed.
+func newTestCA() (*testCA, error) {
+	ca := &testCA{}
+	var err error
+	if ca.rootKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		return nil, err
+	}
+	if ca.interKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	root := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "tlsexpires selftest root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if ca.root, err = ca.sign(root, &ca.rootKey.PublicKey, nil, ca.rootKey); err != nil {
+		return nil, err
+	}
+	inter := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "tlsexpires selftest intermediate"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(5 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if ca.inter, err = ca.sign(inter, &ca.interKey.PublicKey, ca.root, ca.rootKey); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// sign creates a certificate from tmpl for pub, signed by parent. A nil parent makes the
+// certificate self-signed.
+func (ca *testCA) sign(tmpl *x509.Certificate, pub crypto.PublicKey, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, error) {
+	ca.serial++
+	tmpl.SerialNumber = big.NewInt(ca.serial)
+	if parent == nil {
+		parent = tmpl
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, parentKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// leaf returns a server certificate template for dnsName that is valid from notBefore to notAfter.
+func leaf(dnsName string, notBefore, notAfter time.Time) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:     pkix.Name{CommonName: dnsName},
+		DNSNames:    []string{dnsName},
+		NotBefore:   notBefore,
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+}
+
+// newFixtures returns our matrix of broken servers. Every certificate is for "localhost"
+// unless being for the wrong host is the point.
+func newFixtures(ca *testCA) ([]fixture, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	day := 24 * time.Hour
+	valid := func() *x509.Certificate { return leaf("localhost", now.Add(-day), now.Add(365*day)) }
+
+	var fixtures []fixture
+	add := func(name string, want status, tmpl *x509.Certificate, k *rsa.PrivateKey, parent *x509.Certificate, parentKey crypto.Signer, withInter bool) (*x509.Certificate, error) {
+		c, err := ca.sign(tmpl, &k.PublicKey, parent, parentKey)
+		if err != nil {
+			return nil, fmt.Errorf("fixture %s: %s", name, err)
+		}
+		f := fixture{Name: name, Want: want, chain: []*x509.Certificate{c}, key: k}
+		if withInter {
+			f.chain = append(f.chain, ca.inter)
+		}
+		fixtures = append(fixtures, f)
+		return c, nil
+	}
+
+	if _, err := add("valid", statusOK, valid(), key, ca.inter, ca.interKey, true); err != nil {
+		return nil, err
+	}
+	if _, err := add("expiring-soon", statusWarning, leaf("localhost", now.Add(-day), now.Add(day+time.Hour)), key, ca.inter, ca.interKey, true); err != nil {
+		return nil, err
+	}
+	if _, err := add("expired", statusError, leaf("localhost", now.Add(-60*day), now.Add(-day)), key, ca.inter, ca.interKey, true); err != nil {
+		return nil, err
+	}
+	if _, err := add("wrong-host", statusError, leaf("wrong.host.invalid", now.Add(-day), now.Add(365*day)), key, ca.inter, ca.interKey, true); err != nil {
+		return nil, err
+	}
+	if _, err := add("untrusted", statusError, valid(), key, nil, key, false); err != nil {
+		return nil, err
+	}
+	if _, err := add("incomplete-chain", statusError, valid(), key, ca.inter, ca.interKey, false); err != nil {
+		return nil, err
+	}
+	sha1 := valid()
+	sha1.SignatureAlgorithm = x509.SHA1WithRSA
+	if _, err := add("sha1", statusError, sha1, key, ca.inter, ca.interKey, true); err != nil {
+		return nil, err
+	}
+	if _, err := add("weak-key", statusWarning, valid(), weakKey, ca.inter, ca.interKey, true); err != nil {
+		return nil, err
+	}
+
+	revoked, err := add("revoked-stapled", statusError, valid(), key, ca.inter, ca.interKey, true)
+	if err != nil {
+		return nil, err
+	}
+	staple, err := ocsp.CreateResponse(ca.inter, ca.inter, ocsp.Response{
+		Status:       ocsp.Revoked,
+		SerialNumber: revoked.SerialNumber,
+		ThisUpdate:   now.Add(-time.Hour),
+		NextUpdate:   now.Add(day),
+		RevokedAt:    now.Add(-time.Hour),
+	}, ca.interKey)
+	if err != nil {
+		return nil, fmt.Errorf("fixture revoked-stapled: %s", err)
+	}
+	fixtures[len(fixtures)-1].staple = staple
+
+	return fixtures, nil
+}
+
+// serve starts a TLS server for f on a random localhost port and returns its address. The
+// server runs until the program exits.
+func (f fixture) serve() (string, error) {
+	cert := tls.Certificate{PrivateKey: f.key, OCSPStaple: f.staple, Leaf: f.chain[0]}
+	for _, c := range f.chain {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return net.JoinHostPort("localhost", port), nil
+}
+
+// selftest implements the "selftest" subcommand. It starts a local TLS server for each of our
+// fixtures, checks them the same way a scan does and reports any that we classified wrong.
+// With -serve it instead prints the servers and keeps them running, so they can be used as
+// targets for a real scan.
+func selftest(args []string) error {
+	fs := subcommandFlags("selftest")
+	serve := fs.Bool("serve", false, "Print the host:port of each fixture and serve them until interrupted instead of checking them")
+	fs.Parse(args)
+	if err := applyProfile(fs); err != nil {
+		return err
+	}
+
+	ca, err := newTestCA()
+	if err != nil {
+		return err
+	}
+	fixtures, err := newFixtures(ca)
+	if err != nil {
+		return err
+	}
+
+	// Our fixtures are signed by our own root, so that is the only root we trust.
+	rootCAs = x509.NewCertPool()
+	rootCAs.AddCert(ca.root)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	failed := 0
+	for _, f := range fixtures {
+		hostPort, err := f.serve()
+		if err != nil {
+			return fmt.Errorf("could not start fixture %s: %s", f.Name, err)
+		}
+		if *serve {
+			fmt.Fprintf(w, "%s\t%s\twant %s\n", hostPort, f.Name, f.Want)
+			continue
+		}
+
+		v := check(context.Background(), defaultDialer, hostPort, "", nil)
+		result := "PASS"
+		if v.Status != f.Want {
+			result = "FAIL"
+			failed++
+		}
+		detail := v.Err
+		if detail == "" && len(v.KeyWeaknesses) > 0 {
+			detail = v.KeyWeaknesses[0]
+		}
+		fmt.Fprintf(w, "%s\t%s\twant %s\tgot %s\t%s\n", result, f.Name, f.Want, v.Status, detail)
+	}
+	w.Flush()
+
+	if *serve {
+		rootFile := filepath.Join(os.TempDir(), "tlsexpires-selftest-root.pem")
+		if err := os.WriteFile(rootFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.root.Raw}), 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("Scan these with -ca-file=%s, interrupt to stop serving\n", rootFile)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		return nil
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d fixtures were classified wrong", failed, len(fixtures))
+	}
+	return nil
+}
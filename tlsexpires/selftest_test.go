@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+)
+
+// TestSelftest is the selftest subcommand as a test: each of our broken servers must be
+// classified the way a scan is expected to classify it.
+func TestSelftest(t *testing.T) {
+	ca, err := newTestCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures, err := newFixtures(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := rootCAs
+	rootCAs = x509.NewCertPool()
+	rootCAs.AddCert(ca.root)
+	t.Cleanup(func() { rootCAs = old })
+
+	for _, f := range fixtures {
+		t.Run(f.Name, func(t *testing.T) {
+			hostPort, err := f.serve()
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := check(context.Background(), defaultDialer, hostPort, "", nil)
+			if v.Status != f.Want {
+				t.Errorf("TestSelftest(%s): got status %s (%s), want %s", f.Name, v.Status, v.Err, f.Want)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	listen   = flag.String("listen", "", "Run as a service that scans every -interval and serves Prometheus metrics for the last scan at /metrics on this address, like :9219. A dashboard of the results is at /ui, backed by json at /api/status and /api/results. POST a json {\"host\": \"host:port\"} to /check to check a host on demand")
+	interval = flag.Duration("interval", time.Hour, "How often to scan with -listen")
+)
+
+// zeroConfigListen is where we serve metrics when we configure ourselves in Kubernetes.
+const zeroConfigListen = ":9219"
+
+// zeroConfig makes "kubectl run tlsexpires" useful without any flags. When we are in a
+// Kubernetes cluster and weren't told what to check, we check the cluster's services and serve
+// metrics for them. Flags that were given are left alone.
+func zeroConfig() {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return
+	}
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if sourceFlagSet() || set["scan-path"] || set["windows-store"] {
+		return
+	}
+
+	flag.Set("k8s", "true")
+	if !set["listen"] {
+		flag.Set("listen", zeroConfigListen)
+	}
+	log.Printf("no targets were given, so we are checking the services in this cluster and serving metrics at %s/metrics", *listen)
+}
+
+// exporter holds the last scan and serves it as Prometheus metrics and on the dashboard.
+type exporter struct {
+	mu       sync.Mutex
+	last     run
+	duration time.Duration
+	scanned  bool
+	scans    int
+	failures int
+	// trends are the days remaining of each host at its recent scans, keyed by resultKey().
+	trends map[string][]trendPoint
+	// rotations are how many times a host started serving a different certificate since we
+	// started, and unexpectedRotations are the ones that weren't renewals.
+	rotations           int
+	unexpectedRotations int
+	// tickets are the ticketers we file tickets in after each scan.
+	tickets map[string]ticketer
+	// hook is the -on-expiring-exec we run after each scan.
+	hook *hook
+}
+
+// serve runs us as a service. We scan every -interval and serve the results of the last scan
+// that finished at /metrics and /ui, and check hosts on demand at /check and, with -grpc-listen,
+// over gRPC. It only returns if a server fails.
+func serve() error {
+	api, err := newCheckAPI()
+	if err != nil {
+		return err
+	}
+	trends, err := storedTrends(dashboardScans)
+	if err != nil {
+		return err
+	}
+	tickets, err := ticketers()
+	if err != nil {
+		return err
+	}
+	h, err := parseHook()
+	if err != nil {
+		return err
+	}
+	e := &exporter{trends: trends, tickets: tickets, hook: h}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	mux.Handle("POST /check", api)
+	e.registerDashboard(mux)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	srv := &http.Server{Addr: *listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	errc := make(chan error, 2)
+	go func() { errc <- srv.ListenAndServe() }()
+	if *grpcListen != "" {
+		go func() { errc <- serveGRPC(api) }()
+	}
+	log.Printf("serving metrics at %s/metrics and a dashboard at %s/ui", *listen, *listen)
+
+	for {
+		e.scan()
+		select {
+		case err := <-errc:
+			return err
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// scan checks every target and makes the results what we serve. Like a normal run, it sends
+// notifications, files tickets, saves -history and runs -on-expiring-exec. It also reports hosts that serve a different certificate
+// than in the scan before, see notifyRotations(). If we can't read our targets, we keep serving the last
+// scan rather than one that is missing hosts.
+func (e *exporter) scan() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := time.Now()
+	resetIntegrations()
+	resetUsage()
+	p, err := newTargetProvider(ctx)
+	if err != nil {
+		log.Printf("scan failed, could not read targets: %s", err)
+		e.failed()
+		return
+	}
+	hostPorts, labels, errc := streamTargets(ctx, p)
+	results := collect(ctx, hostPorts, labels)
+	if err := <-errc; err != nil {
+		log.Printf("scan failed, could not read targets: %s", err)
+		e.failed()
+		return
+	}
+
+	r := run{ID: newULID(started), Started: started, Results: results}
+	assignIDs(r.ID, results)
+	sum := summarize(results)
+	sum.Integrations = integrationReport()
+	sum.Usage = usageReport()
+	warnBudget(sum.Usage)
+	r.Summary = &sum
+	if err := notify(r); err != nil {
+		log.Printf("could not send notifications: %s", err)
+	}
+	fileTickets(ctx, e.tickets, r)
+	var rot rotationEvent
+	if prev, ok := e.previousScan(); ok {
+		rot = newRotationEvent(prev, r)
+		if err := notifyRotations(rot); err != nil {
+			log.Printf("could not send certificate rotation notifications: %s", err)
+		}
+	}
+	if err := saveHistory(r); err != nil {
+		log.Print(err)
+	}
+	if err := saveStore(r); err != nil {
+		log.Print(err)
+	}
+	if err := runHooks(ctx, e.hook, results); err != nil {
+		log.Print(err)
+	}
+	logLabelCollisions(results)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.last, e.duration, e.scanned = r, time.Since(started), true
+	e.scans++
+	e.rotations += len(rot.Rotations)
+	e.unexpectedRotations += rot.Unexpected()
+	e.recordTrends(r)
+	log.Printf("scan %s checked %d hosts, %d failed", r.ID, sum.Total, sum.Failed)
+}
+
+// failed records a scan that didn't finish.
+func (e *exporter) failed() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.scans++
+	e.failures++
+}
+
+// promEscape escapes s for use as a Prometheus label value.
+var promEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace
+
+// promLabelName returns the Prometheus label for the result label k. It is prefixed with label_
+// so it can't clash with our own labels, and anything Prometheus doesn't allow in a label name
+// becomes _, the way kube-state-metrics exports Kubernetes labels.
+func promLabelName(k string) string {
+	return "label_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, k)
+}
+
+// promLabels returns labels as Prometheus label pairs, each starting with a comma. Labels that
+// only differ in characters promLabelName() replaces, like team-a and team_a, would be the same
+// Prometheus label, which Prometheus rejects the whole scrape for. The first in sorted order is
+// kept and the others are returned in dropped.
+func promLabels(labels map[string]string) (pairs string, dropped []string) {
+	names := map[string]bool{}
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		name := promLabelName(k)
+		if names[name] {
+			dropped = append(dropped, k)
+			continue
+		}
+		names[name] = true
+		pairs += fmt.Sprintf(`,%s="%s"`, name, promEscape(labels[k]))
+	}
+	return pairs, dropped
+}
+
+// logLabelCollisions logs the labels of results that promLabels() drops, so someone can rename them.
+func logLabelCollisions(results []result) {
+	dropped := map[string]bool{}
+	for _, v := range results {
+		_, d := promLabels(v.Labels)
+		for _, k := range d {
+			dropped[k] = true
+		}
+	}
+	if len(dropped) > 0 {
+		log.Printf("labels %s are the same Prometheus label as another label of their host, so they aren't in /metrics", strings.Join(slices.Sorted(maps.Keys(dropped)), ", "))
+	}
+}
+
+// ServeHTTP implements http.Handler by writing our metrics in the Prometheus text format.
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("tlsexpires_scans_total", "counter", "Scans started since we started.")
+	fmt.Fprintf(w, "tlsexpires_scans_total %d\n", e.scans)
+	metric("tlsexpires_scan_failures_total", "counter", "Scans that couldn't read their targets.")
+	fmt.Fprintf(w, "tlsexpires_scan_failures_total %d\n", e.failures)
+	metric("tlsexpires_cert_rotations_total", "counter", "Times a host started serving a different certificate since we started.")
+	fmt.Fprintf(w, "tlsexpires_cert_rotations_total{renewal=\"true\"} %d\n", e.rotations-e.unexpectedRotations)
+	fmt.Fprintf(w, "tlsexpires_cert_rotations_total{renewal=\"false\"} %d\n", e.unexpectedRotations)
+	if !e.scanned {
+		return
+	}
+	metric("tlsexpires_last_scan_timestamp_seconds", "gauge", "When the last scan that finished started.")
+	fmt.Fprintf(w, "tlsexpires_last_scan_timestamp_seconds %d\n", e.last.Started.Unix())
+	metric("tlsexpires_last_scan_duration_seconds", "gauge", "How long the last scan that finished took.")
+	fmt.Fprintf(w, "tlsexpires_last_scan_duration_seconds %g\n", e.duration.Seconds())
+	if u := e.last.Summary.Usage; u != nil {
+		metric("tlsexpires_last_scan_lookups", "gauge", "External lookups the last scan that finished made, by kind.")
+		for kind, n := range u.Lookups {
+			fmt.Fprintf(w, "tlsexpires_last_scan_lookups{kind=\"%s\"} %d\n", promEscape(kind), n)
+		}
+		metric("tlsexpires_last_scan_bytes", "gauge", "Bytes the last scan that finished sent and received.")
+		fmt.Fprintf(w, "tlsexpires_last_scan_bytes{direction=\"sent\"} %d\n", u.BytesSent)
+		fmt.Fprintf(w, "tlsexpires_last_scan_bytes{direction=\"received\"} %d\n", u.BytesReceived)
+	}
+
+	labels := func(v result) string {
+		pairs, _ := promLabels(v.Labels)
+		return fmt.Sprintf(`host_port="%s",address="%s",zone="%s"`, promEscape(v.HostPort), promEscape(v.Address), promEscape(v.Zone)) + pairs
+	}
+	metric("tlsexpires_cert_not_after_timestamp_seconds", "gauge", "When the certificate a host serves expires. Hosts we couldn't check have no value.")
+	for _, v := range e.last.Results {
+		if v.Status != statusError {
+			fmt.Fprintf(w, "tlsexpires_cert_not_after_timestamp_seconds{%s} %d\n", labels(v), v.ExpiresOn.Unix())
+		}
+	}
+	metric("tlsexpires_check_status", "gauge", "1 for the status of the last check of a host, 0 for the others.")
+	for _, v := range e.last.Results {
+		for _, s := range statuses {
+			n := 0
+			if s.Code == string(v.Status) {
+				n = 1
+			}
+			fmt.Fprintf(w, "tlsexpires_check_status{%s,status=\"%s\"} %d\n", labels(v), s.Code, n)
+		}
+	}
+}
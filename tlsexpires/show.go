@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// show implements the "show" subcommand. Where a scan tells you a little about a lot of hosts,
+// show tells you everything we can find out about a single host.
+func show(args []string) error {
+	fs := subcommandFlags("show")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tlsexpires show [flags] host:port")
+		printDefaults(fs)
+	}
+	fs.Parse(args)
+	if err := applyProfile(fs); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	hostPort := fs.Arg(0)
+
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return fmt.Errorf("argument must be the DNS hostname or IP address + ':' + port, was %q", hostPort)
+	}
+
+	// We skip verification during the handshake so that we can show the details of certificates
+	// that are broken, which is usually why someone is looking. We do the verification ourselves below.
+	zc, err := loadZones()
+	if err != nil {
+		return err
+	}
+	if err := loadRootCAs(); err != nil {
+		return err
+	}
+	if err := loadTrustStores(); err != nil {
+		return err
+	}
+	if err := loadConfig(); err != nil {
+		return err
+	}
+	opts := optionsFor(hostPort)
+	conf := opts.tlsConfig(host)
+	conf.InsecureSkipVerify = true
+	conn, err := dialTLS(context.Background(), zc.zoneFor(context.Background(), hostPort).dialer, hostPort, conf, opts.STARTTLS)
+	if err != nil {
+		return fmt.Errorf("server doesn't support SSL certificate err: %s", err)
+	}
+	defer conn.Close()
+	cs := conn.ConnectionState()
+
+	// The findings are the ones a scan of the host would have, so the two can't disagree.
+	v := check(context.Background(), zc.zoneFor(context.Background(), hostPort).dialer, hostPort, "", nil)
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	writeDetails(w, hostPort, conf.ServerName, cs, v)
+	return nil
+}
+
+// writeDetails writes everything we know about the connection to w. serverName is the name we
+// verify the certificate for and v is the result of checking the host the way a scan does.
+func writeDetails(w io.Writer, hostPort, serverName string, cs tls.ConnectionState, v result) {
+	chain := cs.PeerCertificates
+	leaf := chain[0]
+	var issuer *x509.Certificate
+	if len(chain) > 1 {
+		issuer = chain[1]
+	}
+
+	fmt.Fprintf(w, "%s: %s\n", msg("server"), hostPort)
+	fmt.Fprintf(w, "%s: %s\n", msg("tlsVersion"), tlsVersionName(cs.Version))
+	fmt.Fprintf(w, "%s: %s\n", msg("cipherSuite"), tls.CipherSuiteName(cs.CipherSuite))
+
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, verifyErr := leaf.Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates, Roots: rootCAs})
+	if verifyErr != nil {
+		fmt.Fprintf(w, "%s: %s\n", msg("chainVerification"), msg("verifyFailed", verifyErr))
+	} else {
+		fmt.Fprintf(w, "%s: %s\n", msg("chainVerification"), msg("ok"))
+	}
+
+	for i, c := range chain {
+		kind := msg("intermediate")
+		switch {
+		case i == 0:
+			kind = msg("leaf")
+		case c.IsCA && c.Subject.String() == c.Issuer.String():
+			kind = msg("root")
+		}
+		fmt.Fprintf(w, "\n%s:\n", msg("certificateN", i, kind))
+		writeCert(w, c)
+	}
+
+	fmt.Fprintf(w, "\n%s:\n", msg("scts"))
+	ctResults, err := verifySCTs(leaf, issuer, cs.SignedCertificateTimestamps)
+	if err != nil {
+		fmt.Fprintf(w, "  %s: %s\n", msg("error"), err)
+	}
+	if err == nil && len(ctResults) == 0 {
+		fmt.Fprintf(w, "  %s\n", msg("none"))
+	}
+	for _, r := range ctResults {
+		name := r.Log
+		if name == "" {
+			name = hex.EncodeToString(r.SCT.LogID[:])
+		}
+		verified := msg("sctVerified")
+		if r.Err != nil {
+			verified = msg("sctNotVerified", r.Err)
+		}
+		fmt.Fprintf(w, "  %s\n", msg("sctAt", r.SCT.Source, name, r.SCT.Timestamp.Format(time.RFC3339), verified))
+	}
+	if *ctQuery {
+		logged, err := crtshLogged(leaf)
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "  crt.sh: %s: %s\n", msg("error"), err)
+		case logged:
+			fmt.Fprintf(w, "  crt.sh: %s\n", msg("crtshLogged"))
+		default:
+			fmt.Fprintf(w, "  crt.sh: %s\n", msg("crtshNotLogged"))
+		}
+	}
+
+	fmt.Fprintln(w, "\nOCSP:")
+	if issuer == nil {
+		fmt.Fprintf(w, "  %s: %s\n", msg("error"), msg("noIssuerOCSP"))
+	} else {
+		resp, source, err := ocspStatus(leaf, issuer, cs.OCSPResponse)
+		if source != "" {
+			fmt.Fprintf(w, "  %s: %s\n", msg("source"), source)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "  %s: %s\n", msg("error"), err)
+		} else {
+			fmt.Fprintf(w, "  %s: %s\n", msg("status"), ocspStatusName(resp.Status))
+			fmt.Fprintf(w, "  %s: %s\n", msg("thisUpdate"), resp.ThisUpdate)
+			fmt.Fprintf(w, "  %s: %s\n", msg("nextUpdate"), resp.NextUpdate)
+			if resp.Status == ocsp.Revoked {
+				fmt.Fprintf(w, "  %s: %s\n", msg("revokedAt"), resp.RevokedAt)
+			}
+		}
+	}
+
+	fmt.Fprintln(w, "\nCRL:")
+	crlResults := checkCRLs(chain)
+	if len(crlResults) == 0 {
+		fmt.Fprintf(w, "  %s\n", msg("noCRL"))
+	}
+	for _, r := range crlResults {
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(w, "  %s: %s: %s\n", r.Cert.Subject, msg("error"), r.Err)
+		case r.Revoked:
+			fmt.Fprintf(w, "  %s: %s\n", r.Cert.Subject, msg("crlRevoked", r.RevokedAt, r.URL))
+		default:
+			fmt.Fprintf(w, "  %s: %s\n", r.Cert.Subject, msg("crlNotRevoked", r.URL))
+		}
+	}
+
+	fmt.Fprintf(w, "\n%s:\n", msg("policyFindings"))
+	fmt.Fprintf(w, "  %s: %s\n", msg("status"), v.Status)
+	if len(v.Findings) == 0 {
+		fmt.Fprintf(w, "  %s\n", msg("none"))
+	}
+	for _, f := range v.Findings {
+		fmt.Fprintf(w, "  - %s: %s\n", f, findings[f].Description)
+	}
+	if v.Err != "" {
+		fmt.Fprintf(w, "  %s: %s\n", msg("error"), v.Err)
+	}
+}
+
+// writeCert writes the details of a single certificate to w.
+func writeCert(w io.Writer, c *x509.Certificate) {
+	fmt.Fprintf(w, "  %s: %s\n", msg("subject"), c.Subject)
+	fmt.Fprintf(w, "  %s: %s\n", msg("issuer"), c.Issuer)
+	fmt.Fprintf(w, "  %s: %s\n", msg("serial"), colonHex(c.SerialNumber.Bytes()))
+	fmt.Fprintf(w, "  %s: %s\n", msg("notBefore"), c.NotBefore)
+	fmt.Fprintf(w, "  %s: %s (%s)\n", msg("notAfter"), c.NotAfter, msg("inDays", result{ExpiresOn: c.NotAfter}.ExpireInDays()))
+	fmt.Fprintf(w, "  %s: %s\n", msg("publicKey"), keyDescription(c))
+	fmt.Fprintf(w, "  %s: %s\n", msg("signatureAlgorithm"), c.SignatureAlgorithm)
+	fmt.Fprintf(w, "  %s: %s\n", msg("fingerprint"), fingerprint(c))
+	fmt.Fprintf(w, "  %s:\n", msg("extensions"))
+	for _, ext := range c.Extensions {
+		critical := ""
+		if ext.Critical {
+			critical = " (" + msg("critical") + ")"
+		}
+		fmt.Fprintf(w, "    %s%s: %s\n", extensionName(ext.Id), critical, extensionValue(c, ext.Id.String(), ext.Value))
+	}
+}
+
+// extensionValue returns a human readable version of the extension with oid. We use the fields
+// the x509 package already decoded where we can.
+func extensionValue(c *x509.Certificate, oid string, raw []byte) string {
+	switch oid {
+	case "2.5.29.14":
+		return colonHex(c.SubjectKeyId)
+	case "2.5.29.35":
+		return colonHex(c.AuthorityKeyId)
+	case "2.5.29.15":
+		return strings.Join(keyUsages(c), ", ")
+	case "2.5.29.37":
+		return strings.Join(extKeyUsages(c), ", ")
+	case "2.5.29.19":
+		if !c.IsCA {
+			return "CA:FALSE"
+		}
+		if c.MaxPathLen > 0 || c.MaxPathLenZero {
+			return fmt.Sprintf("CA:TRUE, pathlen:%d", c.MaxPathLen)
+		}
+		return "CA:TRUE"
+	case "2.5.29.17":
+		var names []string
+		for _, n := range c.DNSNames {
+			names = append(names, "DNS:"+n)
+		}
+		for _, ip := range c.IPAddresses {
+			names = append(names, "IP:"+ip.String())
+		}
+		for _, e := range c.EmailAddresses {
+			names = append(names, "email:"+e)
+		}
+		for _, u := range c.URIs {
+			names = append(names, "URI:"+u.String())
+		}
+		return strings.Join(names, ", ")
+	case "2.5.29.31":
+		return strings.Join(c.CRLDistributionPoints, ", ")
+	case "2.5.29.32":
+		var ids []string
+		for _, p := range c.Policies {
+			ids = append(ids, p.String())
+		}
+		return strings.Join(ids, ", ")
+	case "1.3.6.1.5.5.7.1.1":
+		var parts []string
+		for _, o := range c.OCSPServer {
+			parts = append(parts, "OCSP:"+o)
+		}
+		for _, i := range c.IssuingCertificateURL {
+			parts = append(parts, "CA Issuers:"+i)
+		}
+		return strings.Join(parts, ", ")
+	case "1.3.6.1.4.1.11129.2.4.2":
+		scts, err := embeddedSCTs(c)
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("%d SCTs", len(scts))
+	}
+	return fmt.Sprintf("%d bytes", len(raw))
+}
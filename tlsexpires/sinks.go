@@ -0,0 +1,335 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// outputFlags is a flag.Value for the repeatable -output flag.
+type outputFlags []string
+
+var outputs outputFlags
+
+func init() {
+	flag.Var(&outputs, "output", "kind:destination of a place to write the results to, like json:results.json or text:- for stdout. The kinds are text, json, jsonl, which writes each result as a line of json as soon as its check finishes, for tailing long scans, csv, ics, a calendar of the expiry dates, and markdown, a report for issues and wikis, which write to a file, webhook, which POSTs each result to a URL like -webhook, and database, which records the run in a sqlite:// or postgres:// URL like -store. Can be repeated to write to several places at once. Without it, -format is written to stdout")
+}
+
+// String implements flag.Value.String().
+func (o *outputFlags) String() string {
+	return strings.Join(*o, ",")
+}
+
+// Set implements flag.Value.Set().
+func (o *outputFlags) Set(s string) error {
+	kind, dest, ok := strings.Cut(s, ":")
+	if !ok || dest == "" {
+		return fmt.Errorf("-output must be kind:destination, was %q", s)
+	}
+	if _, ok := sinkKinds[kind]; !ok {
+		return fmt.Errorf("-output kind %q is not supported, use one of %s", kind, strings.Join(sinkNames(), ", "))
+	}
+	*o = append(*o, s)
+	return nil
+}
+
+// sink is a place output() writes results to. Write is called with each result as its check
+// finishes, from one goroutine at a time. Flush is called once with the run when the scan is
+// done, with its results sorted and its summary, and finishes writing it. A new kind of sink is
+// a file in this package with a constructor in sinkKinds.
+type sink interface {
+	Write(v result) error
+	Flush(r run) error
+}
+
+// sinkKinds make the sinks of -output, keyed by the kind before the ':'. dest is what comes
+// after it.
+var sinkKinds = map[string]func(dest string) (sink, error){
+	"text":     newTextSink,
+	"json":     newJSONSink,
+	"jsonl":    newJSONLSink,
+	"csv":      newCSVSink,
+	"webhook":  newWebhookSink,
+	"database": newDatabaseSink,
+	"ics":      newICSSink,
+	"markdown": newMarkdownSink,
+}
+
+// sinkNames returns the kinds of sinkKinds, sorted.
+func sinkNames() []string {
+	var names []string
+	for name := range sinkKinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// openSinks opens the sinks of -output, or the -format sink to stdout if it isn't set.
+func openSinks() ([]sink, error) {
+	outs := outputs
+	if len(outs) == 0 {
+		if _, ok := sinkKinds[*format]; !ok || *format == "webhook" || *format == "database" {
+			return nil, fmt.Errorf("-format=%s is not supported", *format)
+		}
+		outs = outputFlags{*format + ":-"}
+	}
+
+	if err := checkGroupFlags(); err != nil {
+		return nil, err
+	}
+	if err := checkForecastFlag(); err != nil {
+		return nil, err
+	}
+
+	var sinks []sink
+	stdout := 0
+	for _, o := range outs {
+		kind, dest, _ := strings.Cut(o, ":")
+		if dest == "-" {
+			// Two sinks writing to stdout would garble each other.
+			if stdout++; stdout > 1 {
+				return nil, fmt.Errorf("only one -output can write to stdout")
+			}
+		}
+		s, err := sinkKinds[kind](dest)
+		if err != nil {
+			return nil, fmt.Errorf("-output %s: %s", kind, err)
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+// createOutput returns the file dest, which it creates, or stdout if dest is "-".
+func createOutput(dest string) (io.WriteCloser, error) {
+	if dest == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(dest)
+}
+
+// nopCloser is an io.WriteCloser whose Close does nothing, so we don't close stdout.
+type nopCloser struct {
+	io.Writer
+}
+
+// Close implements io.Closer.Close().
+func (nopCloser) Close() error { return nil }
+
+// textSink writes our text format, the results and then the summary. With -stream, each result
+// is written as soon as its check finishes.
+type textSink struct {
+	w io.WriteCloser
+}
+
+// newTextSink returns a textSink that writes text to dest, a file or - for stdout.
+func newTextSink(dest string) (sink, error) {
+	w, err := createOutput(dest)
+	if err != nil {
+		return nil, err
+	}
+	return &textSink{w: w}, nil
+}
+
+// Write implements sink.Write().
+func (t *textSink) Write(v result) error {
+	if !*stream || !shouldOutput(v) {
+		return nil
+	}
+	return writeText(t.w, v)
+}
+
+// Flush implements sink.Flush().
+func (t *textSink) Flush(r run) error {
+	switch {
+	case *groupByCert:
+		if err := writeGrouped(t.w, filterOutput(r.Results)); err != nil {
+			return err
+		}
+	case !*stream:
+		for _, v := range filterOutput(r.Results) {
+			if err := writeText(t.w, v); err != nil {
+				return err
+			}
+		}
+	}
+	if err := summaryTmpl.Execute(t.w, r.Summary); err != nil {
+		return err
+	}
+	if r.Summary.Forecast != nil {
+		if err := writeForecast(t.w, r.Summary.Forecast); err != nil {
+			return err
+		}
+	}
+	if r.Partial {
+		fmt.Fprintln(t.w, msg("interrupted"))
+	}
+	fmt.Fprintln(t.w, msg("finished"))
+	return t.w.Close()
+}
+
+// jsonSink writes the run as json, which is what the recheck subcommand and others read.
+type jsonSink struct {
+	w io.WriteCloser
+}
+
+// newJSONSink returns a jsonSink that writes json to dest, a file or - for stdout.
+func newJSONSink(dest string) (sink, error) {
+	w, err := createOutput(dest)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonSink{w: w}, nil
+}
+
+// Write implements sink.Write(). The run is written as a whole by Flush.
+func (j *jsonSink) Write(v result) error {
+	return nil
+}
+
+// Flush implements sink.Flush().
+func (j *jsonSink) Flush(r run) error {
+	r.Results = filterOutput(r.Results)
+	var out any = r
+	if *groupByCert {
+		g := groupedRun{ID: r.ID, Started: r.Started, Summary: r.Summary, Partial: r.Partial}
+		g.Certificates, g.Failed = groupByFingerprint(r.Results)
+		out = g
+	}
+	enc := json.NewEncoder(j.w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	return j.w.Close()
+}
+
+// csvColumns are the columns of the csv sink, one row per result. They are the columns -store
+// has for each result, so the two are easy to load into the same tools, and the labels.
+var csvColumns = []string{
+	"id", "host_port", "address", "status", "expires_on", "days_remaining", "issuer", "sans", "fingerprint",
+	"serial", "key_algorithm", "signature_algorithm", "tls_version", "chain_length", "findings", "error", "labels",
+}
+
+// csvSink writes a row for each result, for spreadsheets.
+type csvSink struct {
+	w io.WriteCloser
+}
+
+// newCSVSink returns a csvSink that writes csv to dest, a file or - for stdout.
+func newCSVSink(dest string) (sink, error) {
+	w, err := createOutput(dest)
+	if err != nil {
+		return nil, err
+	}
+	return &csvSink{w: w}, nil
+}
+
+// Write implements sink.Write(). The rows are written sorted by Flush.
+func (c *csvSink) Write(v result) error {
+	return nil
+}
+
+// Flush implements sink.Flush().
+func (c *csvSink) Flush(r run) error {
+	rows := filterOutput(r.Results)
+	if *groupByCert {
+		// A certificate's row has every host that serves it in host_port.
+		groups, failed := groupByFingerprint(rows)
+		rows = failed
+		for _, g := range groups {
+			v := g.Certificate
+			v.HostPort, v.Address, v.Status = strings.Join(g.Hosts, " "), "", g.Status
+			rows = append(rows, v)
+		}
+	}
+	w := csv.NewWriter(c.w)
+	w.Write(csvColumns)
+	for _, v := range rows {
+		var expiresOn, days string
+		if !v.ExpiresOn.IsZero() {
+			expiresOn, days = v.ExpiresOn.UTC().Format(time.RFC3339), strconv.Itoa(v.ExpireInDays())
+		}
+		w.Write([]string{
+			v.ID, v.HostPort, v.Address, string(v.Status), expiresOn, days, v.Issuer, strings.Join(v.SANs, " "), v.Fingerprint,
+			v.Serial, v.KeyAlgorithm, v.SignatureAlgorithm, v.TLSVersion, strconv.Itoa(v.ChainLength), strings.Join(v.findingNames(), " "), v.Err, labelString(v.Labels),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return c.w.Close()
+}
+
+// webhookSink POSTs each result to a URL once the scan is done, the same way -webhook does, with
+// -webhook-breaches, -webhook-header and the signature. The results are sent through
+// -notify-queue, so ones that fail are retried.
+type webhookSink struct {
+	url string
+}
+
+// newWebhookSink returns a webhookSink that sends results to the URL dest.
+func newWebhookSink(dest string) (sink, error) {
+	if !strings.HasPrefix(dest, "http://") && !strings.HasPrefix(dest, "https://") {
+		return nil, fmt.Errorf("%s is not an http:// or https:// URL", dest)
+	}
+	return &webhookSink{url: dest}, nil
+}
+
+// Write implements sink.Write(). The results are queued together by Flush, like -webhook.
+func (s *webhookSink) Write(v result) error {
+	return nil
+}
+
+// Flush implements sink.Flush().
+func (s *webhookSink) Flush(r run) error {
+	ds, err := webhookDeliveries(r, s.url)
+	if err != nil {
+		return err
+	}
+	return deliver(ds)
+}
+
+// databaseSink records the run in a database, in the tables -store uses.
+type databaseSink struct {
+	db *sql.DB
+}
+
+// newDatabaseSink returns a databaseSink that records runs in the database URL dest.
+func newDatabaseSink(dest string) (sink, error) {
+	db, err := openDatabase(dest)
+	if err != nil {
+		return nil, err
+	}
+	return &databaseSink{db: db}, nil
+}
+
+// Write implements sink.Write(). The run is recorded in one transaction by Flush.
+func (d *databaseSink) Write(v result) error {
+	return nil
+}
+
+// Flush implements sink.Flush(). Like -store, it doesn't record a partial run, which would look
+// like the hosts we didn't get to were removed.
+func (d *databaseSink) Flush(r run) error {
+	defer d.db.Close()
+	if r.Partial {
+		log.Printf("not recording the partial run in -output database")
+		return nil
+	}
+	if err := saveRun(d.db, r); err != nil {
+		return fmt.Errorf("-output database: %s", err)
+	}
+	return nil
+}
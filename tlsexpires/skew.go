@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+var clockSkew = flag.Duration("clock-skew", 0, "How far the clocks of our host and the CAs may disagree. A certificate that isn't valid yet is reported as an error, unless it becomes valid within this long, when it gets a warning that a clock is off instead")
+
+// clockResetBefore is the time before which a NotBefore suggests the certificate was made on a
+// host whose clock had been reset, like an appliance that generates its own certificate at boot
+// before it has the time.
+var clockResetBefore = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// notYetValidChain returns the chain the server presented if err is the handshake failing only
+// because the leaf isn't valid yet, so the certificate can still be reported on. The chain must
+// verify with conf at the leaf's NotBefore, or nil is returned.
+func notYetValidChain(err error, conf *tls.Config) []*x509.Certificate {
+	var verr *tls.CertificateVerificationError
+	var ierr x509.CertificateInvalidError
+	if !errors.As(err, &verr) || !errors.As(verr.Err, &ierr) || ierr.Reason != x509.Expired {
+		return nil
+	}
+	chain := verr.UnverifiedCertificates
+	// The handshake verified the chain at the actual time, not -now.
+	if len(chain) == 0 || !time.Now().Before(chain[0].NotBefore) {
+		return nil
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       conf.ServerName,
+		Roots:         conf.RootCAs,
+		Intermediates: intermediates,
+		CurrentTime:   chain[0].NotBefore,
+	}
+	if _, err := chain[0].Verify(opts); err != nil {
+		return nil
+	}
+	return chain
+}
+
+// checkClock records a finding in v if its certificate isn't valid yet, or its validity period
+// suggests that a clock is off.
+func checkClock(v *result) {
+	if v.IssuedOn.IsZero() {
+		return
+	}
+	wait := until(v.IssuedOn)
+	switch {
+	case wait > *clockSkew:
+		v.find(findingNotYetValid)
+		v.Err = fmt.Sprintf("certificate is not valid until %s, %s from now", v.IssuedOn, wait.Round(time.Second))
+	case wait > 0:
+		v.find(findingClockSkew)
+	case v.IssuedOn.Before(clockResetBefore), !v.ExpiresOn.IsZero() && v.ExpiresOn.Before(v.IssuedOn):
+		v.find(findingClockSkew)
+	}
+}
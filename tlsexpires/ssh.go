@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	sshProbe = flag.Bool("ssh", false, "Check the OpenSSH host certificate of every target instead of its TLS certificate. protocol: ssh in -config does this for a single target")
+	sshCAs   = flag.String("ssh-ca", "", "A file of the public keys of the SSH CAs that sign our host certificates, in authorized_keys or known_hosts @cert-authority format. With it, -ssh also verifies each host certificate is signed by one of them, is valid now and names the host")
+)
+
+// sshCertAlgos are the host key algorithms we offer, so that servers with a host certificate
+// present it instead of their plain host key.
+var sshCertAlgos = []string{
+	ssh.CertAlgoED25519v01,
+	ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+	ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01,
+}
+
+// errGotHostKey ends an SSH handshake once we have the host key, we never log in.
+var errGotHostKey = errors.New("got the host key")
+
+// sshCertForever is the expiry we report for host certificates that never expire. JSON can't
+// hold times after the year 9999.
+var sshCertForever = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+var (
+	sshCAOnce sync.Once
+	sshCAKeys []ssh.PublicKey
+	sshCAErr  error
+)
+
+// loadSSHCAs reads -ssh-ca, if it is set. It only reads the file once.
+func loadSSHCAs() ([]ssh.PublicKey, error) {
+	sshCAOnce.Do(func() {
+		if *sshCAs == "" {
+			return
+		}
+		b, err := os.ReadFile(*sshCAs)
+		if err != nil {
+			sshCAErr = fmt.Errorf("-ssh-ca: %s", err)
+			return
+		}
+		for n, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			// known_hosts lines are "@cert-authority <hosts> <key>", which ParseKnownHosts
+			// understands. Anything else is an authorized_keys line.
+			var key ssh.PublicKey
+			if strings.HasPrefix(line, "@") {
+				_, _, key, _, _, err = ssh.ParseKnownHosts([]byte(line))
+			} else {
+				key, _, _, _, err = ssh.ParseAuthorizedKey([]byte(line))
+			}
+			if err != nil {
+				sshCAErr = fmt.Errorf("-ssh-ca: line %d: %s", n+1, err)
+				return
+			}
+			sshCAKeys = append(sshCAKeys, key)
+		}
+		if len(sshCAKeys) == 0 {
+			sshCAErr = fmt.Errorf("-ssh-ca: %s has no keys", *sshCAs)
+		}
+	})
+	return sshCAKeys, sshCAErr
+}
+
+// sshHostKey does an SSH handshake with dialAddr through d, offering algos, and returns the host
+// key the server presented.
+func sshHostKey(ctx context.Context, d contextDialer, dialAddr string, algos []string) (ssh.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	conn, err := dialCached(ctx, d, dialAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	var key ssh.PublicKey
+	conf := &ssh.ClientConfig{
+		User:              "tlsexpires",
+		HostKeyAlgorithms: algos,
+		HostKeyCallback: func(_ string, _ net.Addr, k ssh.PublicKey) error {
+			key = k
+			return errGotHostKey
+		},
+	}
+	_, end := phase(ctx, "ssh.handshake")
+	_, _, _, err = ssh.NewClientConn(conn, dialAddr, conf)
+	if key != nil {
+		end(nil)
+		return key, nil
+	}
+	end(err)
+	return nil, err
+}
+
+// getSSHInfo connects to hostPort with SSH and returns the result for its host certificate. It
+// returns an error if we can't connect or hostPort is badly formed. A server without a host
+// certificate isn't an error, its result says so and has the fingerprint of its host key. opts
+// are the options to check it with. If addr is set, we connect to that IP address instead of
+// resolving the host.
+func getSSHInfo(ctx context.Context, d contextDialer, hostPort, addr string, opts *targetConfig) (result, error) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return result{}, fmt.Errorf("hostPort must be the DNS hostname or IP address + ':' + port, was %q", hostPort)
+	}
+	dialAddr := hostPort
+	if addr != "" {
+		dialAddr = net.JoinHostPort(addr, port)
+	}
+	v := result{HostPort: hostPort, Server: host, Port: port, Address: addr, Status: statusOK}
+
+	key, err := sshHostKey(ctx, d, dialAddr, sshCertAlgos)
+	if err != nil {
+		// The server has no certificate if it won't agree to any certificate algorithm. Get its
+		// plain host key so the result has a fingerprint to go on.
+		if !strings.Contains(err.Error(), "no common algorithm for host key") {
+			return result{}, fmt.Errorf("SSH handshake failed: %s", err)
+		}
+		if key, err = sshHostKey(ctx, d, dialAddr, nil); err != nil {
+			return result{}, fmt.Errorf("SSH handshake failed: %s", err)
+		}
+		v.Fingerprint = ssh.FingerprintSHA256(key)
+		v.KeyAlgorithm = key.Type()
+		v.Err = fmt.Sprintf("the server has no SSH host certificate, its host key is %s", v.Fingerprint)
+		v.find(findingSSHNoCert)
+		return v, nil
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return result{}, fmt.Errorf("the server presented a %s host key when asked for a certificate", key.Type())
+	}
+
+	if cert.ValidAfter != 0 {
+		v.IssuedOn = time.Unix(int64(cert.ValidAfter), 0).UTC()
+	}
+	v.ExpiresOn = sshCertForever
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		v.ExpiresOn = time.Unix(int64(cert.ValidBefore), 0).UTC()
+	}
+	v.Issuer = "SSH CA " + ssh.FingerprintSHA256(cert.SignatureKey)
+	v.SANs = cert.ValidPrincipals
+	v.Serial = strconv.FormatUint(cert.Serial, 10)
+	v.Fingerprint = ssh.FingerprintSHA256(cert.Key)
+	v.KeyAlgorithm = cert.Key.Type()
+	v.SignatureAlgorithm = cert.Signature.Format
+	if v.ExpireInDays() < opts.warnDays() {
+		v.find(findingExpiring)
+	}
+
+	cas, err := loadSSHCAs()
+	if err != nil {
+		return result{}, err
+	}
+	if len(cas) > 0 {
+		checker := &ssh.CertChecker{
+			IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+				for _, ca := range cas {
+					if bytes.Equal(auth.Marshal(), ca.Marshal()) {
+						return true
+					}
+				}
+				return false
+			},
+			Clock: clock.Now,
+		}
+		if err := checker.CheckHostKey(net.JoinHostPort(host, port), nil, cert); err != nil {
+			v.Err = fmt.Sprintf("host certificate failed verification against -ssh-ca: %s", err)
+			v.find(findingHandshake)
+		}
+	}
+	return v, nil
+}
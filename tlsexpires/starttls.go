@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// starttlsProtocols are the plain text protocols we can upgrade to TLS, keyed by the name used
+// in -config. Each one negotiates the upgrade on conn and returns once the server is ready for
+// the TLS handshake.
+var starttlsProtocols = map[string]func(conn net.Conn) error{
+	"smtp": starttlsSMTP,
+	"imap": starttlsIMAP,
+	"pop3": starttlsPOP3,
+	"ftp":  starttlsFTP,
+}
+
+// starttlsNames returns the names of starttlsProtocols, sorted.
+func starttlsNames() []string {
+	var names []string
+	for name := range starttlsProtocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// starttls negotiates the upgrade to TLS on conn with protocol, which is a key of starttlsProtocols.
+func starttls(conn net.Conn, protocol string) error {
+	f, ok := starttlsProtocols[protocol]
+	if !ok {
+		return fmt.Errorf("STARTTLS protocol %q is not supported", protocol)
+	}
+	if err := f(conn); err != nil {
+		return fmt.Errorf("STARTTLS (%s): %s", protocol, err)
+	}
+	return nil
+}
+
+// The functions below read the server with buffered readers. That is safe because a server
+// sends nothing after agreeing to upgrade until we start the TLS handshake.
+
+// starttlsSMTP upgrades an SMTP connection (RFC 3207).
+func starttlsSMTP(conn net.Conn) error {
+	tp := textproto.NewConn(conn)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return err
+	}
+	if err := tp.PrintfLine("EHLO tlsexpires"); err != nil {
+		return err
+	}
+	_, ext, err := tp.ReadResponse(250)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(strings.ToUpper(ext), "STARTTLS") {
+		return fmt.Errorf("server doesn't offer STARTTLS")
+	}
+	if err := tp.PrintfLine("STARTTLS"); err != nil {
+		return err
+	}
+	_, _, err = tp.ReadResponse(220)
+	return err
+}
+
+// starttlsFTP upgrades an FTP control connection (RFC 4217).
+func starttlsFTP(conn net.Conn) error {
+	tp := textproto.NewConn(conn)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return err
+	}
+	if err := tp.PrintfLine("AUTH TLS"); err != nil {
+		return err
+	}
+	_, _, err := tp.ReadResponse(234)
+	return err
+}
+
+// starttlsIMAP upgrades an IMAP connection (RFC 3501).
+func starttlsIMAP(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	greeting, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(greeting))
+	}
+	if _, err := fmt.Fprint(conn, "a1 STARTTLS\r\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		// Skip untagged responses until we get the answer to our command.
+		if !strings.HasPrefix(line, "a1 ") {
+			continue
+		}
+		if !strings.HasPrefix(line, "a1 OK") {
+			return fmt.Errorf("server refused STARTTLS: %s", strings.TrimSpace(line))
+		}
+		return nil
+	}
+}
+
+// starttlsPOP3 upgrades a POP3 connection (RFC 2595).
+func starttlsPOP3(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	greeting, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "+OK") {
+		return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(greeting))
+	}
+	if _, err := fmt.Fprint(conn, "STLS\r\n"); err != nil {
+		return err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("server refused STLS: %s", strings.TrimSpace(line))
+	}
+	return nil
+}
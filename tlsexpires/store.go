@@ -0,0 +1,265 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+var storeURL = flag.String("store", "", "A database to record every result in, with when it was checked: sqlite:///path/to/results.db or a postgres:// URL. Unlike -history, which only keeps the last run, this keeps them all. See the changes and diff subcommands")
+
+// storeSchema creates the tables of -store. It is written to work with SQLite and Postgres.
+// Times are stored in UTC.
+var storeSchema = []string{
+	`CREATE TABLE IF NOT EXISTS runs (
+		id TEXT PRIMARY KEY,
+		started TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS results (
+		run_id TEXT NOT NULL REFERENCES runs (id),
+		started TIMESTAMP NOT NULL,
+		host_port TEXT NOT NULL,
+		address TEXT NOT NULL,
+		status TEXT NOT NULL,
+		expires_on TIMESTAMP,
+		days_remaining INTEGER,
+		issuer TEXT NOT NULL,
+		fingerprint TEXT NOT NULL,
+		serial TEXT NOT NULL,
+		key_algorithm TEXT NOT NULL,
+		tls_version TEXT NOT NULL,
+		chain_length INTEGER NOT NULL,
+		findings TEXT NOT NULL,
+		error TEXT NOT NULL,
+		result TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS results_host_port ON results (host_port, started)`,
+	`CREATE INDEX IF NOT EXISTS results_started ON results (started)`,
+}
+
+var (
+	storeOnce sync.Once
+	storeDB   *sql.DB
+	storeErr  error
+)
+
+// openStore opens -store and creates its tables if they don't exist. It returns nil if -store
+// isn't set. It only opens the database once.
+func openStore() (*sql.DB, error) {
+	storeOnce.Do(func() {
+		if *storeURL == "" {
+			return
+		}
+		storeDB, storeErr = openDatabase(*storeURL)
+		if storeErr != nil {
+			storeErr = fmt.Errorf("-store: %s", storeErr)
+		}
+	})
+	return storeDB, storeErr
+}
+
+// openDatabase opens the database at u, a sqlite:// or postgres:// URL like -store, and creates
+// its tables if they don't exist.
+func openDatabase(u string) (*sql.DB, error) {
+	driver, dsn, err := storeDriver(u)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == "sqlite" {
+		// SQLite only has one writer at a time, more connections just wait on each other.
+		db.SetMaxOpenConns(1)
+	}
+	for _, stmt := range storeSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("could not create tables: %s", err)
+		}
+	}
+	return db, nil
+}
+
+// storeDriver returns the database/sql driver and data source name for the database URL u.
+func storeDriver(u string) (driver, dsn string, err error) {
+	switch {
+	case strings.HasPrefix(u, "sqlite://"):
+		path := strings.TrimPrefix(u, "sqlite://")
+		if path == "" {
+			return "", "", fmt.Errorf("%s has no path", u)
+		}
+		// Waiting for a lock, like one another run holds, is better than failing.
+		return "sqlite", "file:" + path + "?_pragma=busy_timeout(10000)&_time_format=sqlite", nil
+	case strings.HasPrefix(u, "postgres://"), strings.HasPrefix(u, "postgresql://"):
+		return "pgx", u, nil
+	}
+	return "", "", fmt.Errorf("the database must start with sqlite:// or postgres://")
+}
+
+// saveStore records every result of r in -store.
+func saveStore(r run) error {
+	db, err := openStore()
+	if err != nil || db == nil {
+		return err
+	}
+	if err := saveRun(db, r); err != nil {
+		return fmt.Errorf("-store: %s", err)
+	}
+	return nil
+}
+
+// saveRun records every result of r in db, which openDatabase() opened.
+func saveRun(db *sql.DB, r run) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	started := r.Started.UTC()
+	if _, err := tx.Exec(`INSERT INTO runs (id, started) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING`, r.ID, started); err != nil {
+		return fmt.Errorf("could not save run %s: %s", r.ID, err)
+	}
+	insert, err := tx.Prepare(`INSERT INTO results (run_id, started, host_port, address, status, expires_on, days_remaining,
+		issuer, fingerprint, serial, key_algorithm, tls_version, chain_length, findings, error, result)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, v := range r.Results {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var expiresOn *time.Time
+		var days *int
+		if !v.ExpiresOn.IsZero() {
+			t, d := v.ExpiresOn.UTC(), v.ExpireInDays()
+			expiresOn, days = &t, &d
+		}
+		_, err = insert.Exec(r.ID, started, v.HostPort, v.Address, string(v.Status), expiresOn, days,
+			v.Issuer, v.Fingerprint, v.Serial, v.KeyAlgorithm, v.TLSVersion, v.ChainLength, strings.Join(v.findingNames(), ","), v.Err, string(b))
+		if err != nil {
+			return fmt.Errorf("could not save the result for %s: %s", v.HostPort, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// storedTrends returns the days remaining of each host at the last n runs in -store, keyed by
+// resultKey(), so the dashboard has trends from before we started. It returns nil if -store
+// isn't set.
+func storedTrends(n int) (map[string][]trendPoint, error) {
+	db, err := openStore()
+	if err != nil || db == nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT host_port, address, started, days_remaining FROM results
+		WHERE status <> 'error' AND started >= (SELECT MIN(started) FROM (SELECT started FROM runs ORDER BY started DESC LIMIT $1) AS recent)
+		ORDER BY started`, n)
+	if err != nil {
+		return nil, fmt.Errorf("-store: %s", err)
+	}
+	defer rows.Close()
+
+	trends := map[string][]trendPoint{}
+	for rows.Next() {
+		var v result
+		var p trendPoint
+		if err := rows.Scan(&v.HostPort, &v.Address, &p.Scan, &p.DaysRemaining); err != nil {
+			return nil, fmt.Errorf("-store: %s", err)
+		}
+		trends[v.resultKey()] = append(trends[v.resultKey()], p)
+	}
+	return trends, rows.Err()
+}
+
+// certSpan is a time a host served the same certificate, from the results in -store.
+type certSpan struct {
+	address, fingerprint, issuer string
+	expiresOn, first, last       time.Time
+}
+
+// changesCmd implements the "changes" subcommand. It lists each certificate the hosts it is
+// given have served according to -store, and when we saw it first and last, which says when
+// their certificates changed.
+func changesCmd(args []string) error {
+	fs := subcommandFlags("changes")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s changes [flags] host:port...:\n", os.Args[0])
+		printDefaults(fs)
+	}
+	fs.Parse(args)
+	if err := applyProfile(fs); err != nil {
+		return err
+	}
+
+	if *storeURL == "" {
+		return fmt.Errorf("changes requires -store")
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("changes requires at least one host:port")
+	}
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, hostPort := range fs.Args() {
+		spans, err := certSpans(db, hostPort)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s:\n", hostPort)
+		if len(spans) == 0 {
+			fmt.Fprintln(tw, "  no certificates recorded")
+			continue
+		}
+		fmt.Fprintln(tw, "  first seen\tlast seen\taddress\tfingerprint\tissuer\texpires on")
+		for _, s := range spans {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\t%s\n", s.first.Format(time.RFC3339), s.last.Format(time.RFC3339),
+				s.address, s.fingerprint, s.issuer, s.expiresOn.Format(time.DateOnly))
+		}
+	}
+	return tw.Flush()
+}
+
+// certSpans returns the times hostPort served each certificate, oldest first for each address.
+// A certificate that was replaced and came back has a span for each time it was served.
+func certSpans(db *sql.DB, hostPort string) ([]certSpan, error) {
+	rows, err := db.Query(`SELECT address, fingerprint, issuer, expires_on, started FROM results
+		WHERE host_port = $1 AND status <> 'error' ORDER BY address, started`, hostPort)
+	if err != nil {
+		return nil, fmt.Errorf("-store: %s", err)
+	}
+	defer rows.Close()
+
+	var spans []certSpan
+	for rows.Next() {
+		var s certSpan
+		var expiresOn sql.NullTime
+		if err := rows.Scan(&s.address, &s.fingerprint, &s.issuer, &expiresOn, &s.first); err != nil {
+			return nil, fmt.Errorf("-store: %s", err)
+		}
+		s.expiresOn, s.last = expiresOn.Time, s.first
+		if n := len(spans); n > 0 && spans[n-1].address == s.address && spans[n-1].fingerprint == s.fingerprint {
+			spans[n-1].last = s.first
+			continue
+		}
+		spans = append(spans, s)
+	}
+	return spans, rows.Err()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"text/template"
+	"time"
+)
+
+// summaryTmpl is the text version of a summary.
+var summaryTmpl = template.Must(template.New("").Funcs(template.FuncMap{"t": msg, "latency": latencyText}).Parse(`
+{{ t "summary" }}:
+  {{ t "hostsChecked" }}: {{ .Total }}
+  {{ t "succeeded" }}: {{ .Succeeded }}
+  {{ t "failed" }}: {{ .Failed }}
+  {{ t "expiringIn" 7 }}: {{ .Within7Days }}
+  {{ t "expiringIn" 30 }}: {{ .Within30Days }}
+  {{ t "expiringIn" 90 }}: {{ .Within90Days }}
+{{- if .Soonest }}
+  {{ t "minDays" }}: {{ .MinDaysRemaining }}
+  {{ t "soonest" }}: {{ t "soonestOn" .Soonest .SoonestExpiresOn }}
+{{- end }}
+{{- with .Latency }}
+  {{ t "latency" }}:
+{{- with .DNS }}
+    {{ t "phaseDNS" }}: {{ latency . }}
+{{- end }}
+{{- with .Connect }}
+    {{ t "phaseConnect" }}: {{ latency . }}
+{{- end }}
+{{- with .STARTTLS }}
+    {{ t "phaseSTARTTLS" }}: {{ latency . }}
+{{- end }}
+{{- with .Handshake }}
+    {{ t "phaseHandshake" }}: {{ latency . }}
+{{- end }}
+{{- with .Total }}
+    {{ t "phaseTotal" }}: {{ latency . }}
+{{- end }}
+{{- end }}
+{{- range $name, $s := .TrustStores }}
+  {{ t "trustedBy" $name $s.Verified $s.Hosts }}
+{{- end }}
+{{- with .PostQuantum }}
+  {{ t "pqcReady" .Accepting .Hosts }}
+  {{ t "pqcNegotiated" .Negotiated .Hosts }}
+{{- end }}
+{{- with .Usage }}
+  {{ t "lookups" }}: {{ .TotalLookups }}{{ with .LookupList }} ({{ . }}){{ end }}
+  {{ t "transferred" .BytesSent .BytesReceived }}
+{{- range .OverBudget }}
+  {{ t "overBudget" }}: {{ . }}
+{{- end }}
+{{- end }}
+{{- range $name, $h := .Integrations }}
+{{- if $h.Failures }}
+  {{ t "degraded" $name $h.Failures $h.Attempts }}: {{ $h.LastError }}
+{{- end }}
+{{- end }}
+`,
+))
+
+// summary holds aggregate statistics about a run. With thousands of hosts, the per host
+// output isn't something anyone can read, this is.
+type summary struct {
+	// Total is the number of hosts we checked.
+	Total int `json:"total"`
+	// Succeeded is the number of hosts that aren't statusError.
+	Succeeded int `json:"succeeded"`
+	// Failed is the number of hosts that are statusError: we couldn't get a certificate from
+	// them, or clients will reject the one we got.
+	Failed int `json:"failed"`
+	// Within7Days is the number of certificates that expire in the next 7 days. These and the
+	// other expiry statistics count every certificate we got, even from hosts that failed.
+	Within7Days int `json:"within7Days"`
+	// Within30Days is the number of certificates that expire in the next 30 days. This includes Within7Days.
+	Within30Days int `json:"within30Days"`
+	// Within90Days is the number of certificates that expire in the next 90 days. This includes Within30Days.
+	Within90Days int `json:"within90Days"`
+	// MinDaysRemaining is the least number of days any certificate has before it expires.
+	MinDaysRemaining int `json:"minDaysRemaining"`
+	// Soonest is the host:port whose certificate expires first. Empty if we got no certificates.
+	Soonest string `json:"soonest,omitempty"`
+	// SoonestExpiresOn is when the certificate for Soonest expires.
+	SoonestExpiresOn time.Time `json:"soonestExpiresOn,omitzero"`
+	// Latency is how long the phases of the checks took, so slow handshakes stand out.
+	Latency *latencies `json:"latency,omitempty"`
+	// TrustStores are how many hosts' chains verify against each -trust-store bundle, keyed by its name.
+	TrustStores map[string]trustStoreSummary `json:"trustStores,omitempty"`
+	// PostQuantum is how many hosts accept post-quantum key exchange. Only set with -pqc.
+	PostQuantum *pqcSummary `json:"postQuantum,omitempty"`
+	// Forecast is how many certificates expire in each week or month of the next year. Only set with -forecast.
+	Forecast *forecast `json:"forecast,omitempty"`
+	// Integrations are how the optional integrations we used during the run fared, keyed by name.
+	Integrations map[string]integrationHealth `json:"integrations,omitempty"`
+	// Usage is what the run cost in external lookups and traffic.
+	Usage *usage `json:"usage,omitempty"`
+}
+
+// summarize calculates the summary for results.
+func summarize(results []result) summary {
+	s := summary{Total: len(results), Latency: summarizeLatency(results), PostQuantum: summarizePQC(results), TrustStores: summarizeTrustStores(results)}
+	for _, v := range results {
+		if v.Status == statusError {
+			s.Failed++
+		} else {
+			s.Succeeded++
+		}
+		if v.ExpiresOn.IsZero() {
+			continue
+		}
+
+		days := v.ExpireInDays()
+		switch {
+		case days < 7:
+			s.Within7Days++
+			fallthrough
+		case days < 30:
+			s.Within30Days++
+			fallthrough
+		case days < 90:
+			s.Within90Days++
+		}
+
+		if s.Soonest == "" || v.ExpiresOn.Before(s.SoonestExpiresOn) {
+			s.Soonest = v.HostPort
+			s.SoonestExpiresOn = v.ExpiresOn
+			s.MinDaysRemaining = days
+		}
+	}
+	return s
+}
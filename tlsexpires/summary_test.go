@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	setClock(t, testNow)
+
+	tests := []struct {
+		desc    string
+		results []result
+		want    summary
+	}{
+		{
+			desc: "no results",
+		},
+		{
+			desc: "buckets include the ones below them",
+			results: []result{
+				{HostPort: "a:443", Status: statusWarning, ExpiresOn: testNow.Add(3 * day)},
+				{HostPort: "b:443", Status: statusWarning, ExpiresOn: testNow.Add(20 * day)},
+				{HostPort: "c:443", Status: statusOK, ExpiresOn: testNow.Add(60 * day)},
+				{HostPort: "d:443", Status: statusOK, ExpiresOn: testNow.Add(200 * day)},
+			},
+			want: summary{
+				Total: 4, Succeeded: 4,
+				Within7Days: 1, Within30Days: 2, Within90Days: 3,
+				MinDaysRemaining: 3, Soonest: "a:443", SoonestExpiresOn: testNow.Add(3 * day),
+			},
+		},
+		{
+			desc: "a failed host with a certificate counts towards expiry",
+			results: []result{
+				{HostPort: "revoked:443", Status: statusError, ExpiresOn: testNow.Add(10 * day)},
+				{HostPort: "ok:443", Status: statusOK, ExpiresOn: testNow.Add(100 * day)},
+			},
+			want: summary{
+				Total: 2, Succeeded: 1, Failed: 1,
+				Within30Days: 1, Within90Days: 1,
+				MinDaysRemaining: 10, Soonest: "revoked:443", SoonestExpiresOn: testNow.Add(10 * day),
+			},
+		},
+		{
+			desc: "a failed host without a certificate doesn't",
+			results: []result{
+				{HostPort: "down:443", Status: statusError},
+				{HostPort: "ok:443", Status: statusOK, ExpiresOn: testNow.Add(100 * day)},
+			},
+			want: summary{
+				Total: 2, Succeeded: 1, Failed: 1,
+				MinDaysRemaining: 100, Soonest: "ok:443", SoonestExpiresOn: testNow.Add(100 * day),
+			},
+		},
+		{
+			desc: "an expired certificate is the soonest",
+			results: []result{
+				{HostPort: "ok:443", Status: statusOK, ExpiresOn: testNow.Add(100 * day)},
+				{HostPort: "expired:443", Status: statusError, ExpiresOn: testNow.Add(-day)},
+			},
+			want: summary{
+				Total: 2, Succeeded: 1, Failed: 1,
+				Within7Days: 1, Within30Days: 1, Within90Days: 1,
+				MinDaysRemaining: 0, Soonest: "expired:443", SoonestExpiresOn: testNow.Add(-day),
+			},
+		},
+	}
+	for _, test := range tests {
+		got := summarize(test.results)
+		if got.Total != test.want.Total || got.Succeeded != test.want.Succeeded || got.Failed != test.want.Failed {
+			t.Errorf("TestSummarize(%s): got %d total, %d succeeded, %d failed, want %d, %d, %d", test.desc, got.Total, got.Succeeded, got.Failed, test.want.Total, test.want.Succeeded, test.want.Failed)
+		}
+		if got.Within7Days != test.want.Within7Days || got.Within30Days != test.want.Within30Days || got.Within90Days != test.want.Within90Days {
+			t.Errorf("TestSummarize(%s): got %d/%d/%d within 7/30/90 days, want %d/%d/%d", test.desc, got.Within7Days, got.Within30Days, got.Within90Days, test.want.Within7Days, test.want.Within30Days, test.want.Within90Days)
+		}
+		if got.Soonest != test.want.Soonest || !got.SoonestExpiresOn.Equal(test.want.SoonestExpiresOn) || got.MinDaysRemaining != test.want.MinDaysRemaining {
+			t.Errorf("TestSummarize(%s): got soonest %q on %s in %d days, want %q on %s in %d days", test.desc, got.Soonest, got.SoonestExpiresOn.Format(time.RFC3339), got.MinDaysRemaining, test.want.Soonest, test.want.SoonestExpiresOn.Format(time.RFC3339), test.want.MinDaysRemaining)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var targetsURL = flag.String("targets-url", "", "An http(s) URL that returns the host:port to check, one per line, instead of -file")
+
+// target is a single thing to check.
+type target struct {
+	// HostPort is the host:port to connect to.
+	HostPort string
+	// Labels describe where the target came from, like the ID of the cloud resource it is for.
+	// They are copied into the result.
+	Labels map[string]string
+}
+
+// targetLabels are the Labels of the targets our providers gave us in one scan, keyed by
+// HostPort. Only the host:port makes it through a scan, so this is how its results get their
+// labels. Each scan has its own, so a label removed from -file is gone from the next scan.
+type targetLabels struct {
+	mu     sync.Mutex
+	labels map[string]map[string]string
+}
+
+// set records labels as the ones the provider gave hostPort.
+func (l *targetLabels) set(hostPort string, labels map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.labels[hostPort] = labels
+}
+
+// get returns the labels the provider gave hostPort. l can be nil, for hosts that didn't come
+// from a provider.
+func (l *targetLabels) get(hostPort string) map[string]string {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.labels[hostPort]
+}
+
+// labelsFor returns the labels for the result of hostPort, whose provider gave it provided.
+// Labels in -config win over the ones a provider gave.
+func labelsFor(hostPort string, provided map[string]string) map[string]string {
+	configured := optionsFor(hostPort).Labels
+	switch {
+	case len(provided) == 0:
+		return configured
+	case len(configured) == 0:
+		return provided
+	}
+	merged := maps.Clone(provided)
+	maps.Copy(merged, configured)
+	return merged
+}
+
+// labelString returns labels as key=value pairs sorted by key and separated by spaces, the way
+// they are written after a host:port in -file.
+func labelString(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// targetProvider streams the targets we check, so that a large inventory never has to be held
+// in memory all at once. Next returns io.EOF when there are no more targets.
+//
+// tlsexpires is a command, not a library, so a provider for an in-house inventory is compiled
+// in: a file in this package that implements targetProvider, and a case in sourceProvider() for
+// the flag that selects it.
+type targetProvider interface {
+	Next(ctx context.Context) (target, error)
+}
+
+// sourceFlags are the flags that say where our targets come from.
+var sourceFlags = []string{"file", "targets-url", "config", "k8s", "aws", "gcp", "azure", "prometheus-url", "consul"}
+
+// sourceFlagSet reports if any of sourceFlags were set.
+func sourceFlagSet() bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if slices.Contains(sourceFlags, f.Name) {
+			set = true
+		}
+	})
+	return set
+}
+
+// newTargetProvider returns the targetProvider the flags ask for, with CIDRs and port ranges
+// expanded, followed by the files found with -scan-path and the certificates in -windows-store.
+// -cert-file and -cert-stdin replace all of these. Anything it opens is closed when ctx is done.
+func newTargetProvider(ctx context.Context) (targetProvider, error) {
+	if *certFile != "" || *certStdin {
+		return newCertInputProvider()
+	}
+	var providers []targetProvider
+	if (*scanPath == "" && *windowsStores == "") || sourceFlagSet() {
+		p, err := sourceProvider(ctx)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, &expandProvider{inner: p})
+	}
+	if *scanPath != "" {
+		p, err := newFileProvider(*scanPath)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	if *windowsStores != "" {
+		p, err := newWinStoreProvider(*windowsStores)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	if len(providers) == 1 {
+		return providers[0], nil
+	}
+	return &multiProvider{providers: providers}, nil
+}
+
+// sourceProvider returns the targetProvider for where the flags say our targets come from.
+func sourceProvider(ctx context.Context) (targetProvider, error) {
+	if *configFile != "" {
+		if err := loadConfig(); err != nil {
+			return nil, err
+		}
+		// A -config with only profiles and settings leaves the targets to the other flags.
+		if len(configTargets) > 0 {
+			return &configProvider{targets: configTargets}, nil
+		}
+	}
+	switch {
+	case *k8sTargets:
+		return newK8sProvider(*k8sNamespace, *k8sDiscover)
+	case *awsTargets || *gcpTargets || *azureTargets:
+		return newCloudProvider(ctx)
+	case *consulAddr != "":
+		return newConsulProvider(ctx, *consulAddr, *consulTag, *consulDC)
+	case *prometheusURL != "":
+		return newPromProvider(ctx, *prometheusURL)
+	case *targetsURL != "":
+		return newHTTPProvider(ctx, *targetsURL)
+	case *ipFile == "-":
+		// Files included from stdin are relative to where we are run from.
+		return newLineProvider(os.Stdin, "", "."), nil
+	}
+
+	// This opens the file at "/path/to/file.txt".
+	file, err := os.Open(*ipFile)
+	if err != nil {
+		return nil, err
+	}
+	// Close the file when we are done with it.
+	context.AfterFunc(ctx, func() { file.Close() })
+	return newLineProvider(file, *ipFile, filepath.Dir(*ipFile)), nil
+}
+
+// streamTargets sends the HostPort of every target p provides on the returned channel, which is
+// closed once p is out of targets, and records their labels in the returned targetLabels. If p
+// fails, the error is sent on the returned error channel after the targets are closed.
+func streamTargets(ctx context.Context, p targetProvider) (<-chan string, *targetLabels, <-chan error) {
+	hostPorts := make(chan string, 1)
+	labels := &targetLabels{labels: map[string]map[string]string{}}
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(hostPorts)
+		for {
+			t, err := p.Next(ctx)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+			if len(t.Labels) > 0 {
+				labels.set(t.HostPort, t.Labels)
+			}
+			select {
+			case hostPorts <- t.HostPort:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return hostPorts, labels, errc
+}
+
+// lineProvider provides a target for each line of a reader. It is used for files, stdin and
+// -targets-url. Blank lines and anything after a # are ignored, and a line of
+// "@include other-file.txt" provides the targets of that file before carrying on, so a large
+// inventory can be split into a file per team. The host:port can be followed by labels for its
+// results, like "example.com:443 team=payments env=prod".
+type lineProvider struct {
+	// sources are the files we are reading. The last one is the one we are reading now, the
+	// ones before it are the files that included it.
+	sources []*lineSource
+}
+
+// lineSource is a single file a lineProvider reads.
+type lineSource struct {
+	scanner *bufio.Scanner
+	// name is the path of the file, or "" if it isn't a file.
+	name string
+	// line is the line number we are on.
+	line int
+	// dir is the directory @include paths are relative to, or "" if the source can't include
+	// files.
+	dir string
+	// closer closes the file if we opened it for an @include.
+	closer io.Closer
+}
+
+// newLineProvider returns a lineProvider that reads r. name is the path r was opened from,
+// which is used to detect include cycles. dir is the directory @include paths are relative
+// to, if it is "" @include isn't allowed.
+func newLineProvider(r io.Reader, name, dir string) *lineProvider {
+	if name != "" {
+		if abs, err := filepath.Abs(name); err == nil {
+			name = abs
+		}
+	}
+	return &lineProvider{sources: []*lineSource{{scanner: bufio.NewScanner(r), name: name, dir: dir}}}
+}
+
+// Next implements targetProvider.Next().
+func (l *lineProvider) Next(ctx context.Context) (target, error) {
+	for len(l.sources) > 0 {
+		src := l.sources[len(l.sources)-1]
+		if !src.scanner.Scan() {
+			if err := src.scanner.Err(); err != nil {
+				return target{}, err
+			}
+			if src.closer != nil {
+				src.closer.Close()
+			}
+			l.sources = l.sources[:len(l.sources)-1]
+			continue
+		}
+		src.line++
+		if err := ctx.Err(); err != nil {
+			return target{}, err
+		}
+
+		// Remove any comment and trim any space characters from the line.
+		line, _, _ := strings.Cut(src.scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if path, ok := strings.CutPrefix(line, "@include"); ok {
+			if err := l.include(ctx, src, strings.TrimSpace(path)); err != nil {
+				return target{}, err
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		t := target{HostPort: fields[0]}
+		for _, f := range fields[1:] {
+			k, v, ok := strings.Cut(f, "=")
+			if !ok || k == "" {
+				return target{}, fmt.Errorf("%s: %q must be a label like team=payments", src.where(), f)
+			}
+			if t.Labels == nil {
+				t.Labels = map[string]string{}
+			}
+			t.Labels[k] = v
+		}
+		return t, nil
+	}
+	return target{}, io.EOF
+}
+
+// where returns the line of src we are on, for errors.
+func (src *lineSource) where() string {
+	if src.name != "" {
+		return fmt.Sprintf("%s:%d", src.name, src.line)
+	}
+	return fmt.Sprintf("line %d", src.line)
+}
+
+// include starts reading the file at path, which src included.
+func (l *lineProvider) include(ctx context.Context, src *lineSource, path string) error {
+	where := src.where()
+	switch {
+	case src.dir == "":
+		return fmt.Errorf("%s: @include can only be used in files", where)
+	case path == "":
+		return fmt.Errorf("%s: @include needs the path of a file", where)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(src.dir, path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("%s: %s", where, err)
+	}
+	for _, s := range l.sources {
+		if s.name == abs {
+			return fmt.Errorf("%s: @include %s is a cycle, that file is already being read", where, path)
+		}
+	}
+
+	file, err := os.Open(abs)
+	if err != nil {
+		return fmt.Errorf("%s: %s", where, err)
+	}
+	context.AfterFunc(ctx, func() { file.Close() })
+	l.sources = append(l.sources, &lineSource{scanner: bufio.NewScanner(file), name: abs, dir: filepath.Dir(abs), closer: file})
+	return nil
+}
+
+// targetsClient is used to fetch -targets-url. We read the body as we scan, which can take far
+// longer than any timeout we'd pick, so only the wait for the response headers is limited.
+var targetsClient = &http.Client{Transport: &http.Transport{ResponseHeaderTimeout: time.Minute}}
+
+// newHTTPProvider returns a lineProvider that reads the body of u as it downloads.
+func newHTTPProvider(ctx context.Context, u string) (*lineProvider, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := targetsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("-targets-url=%s returned %s", u, resp.Status)
+	}
+	context.AfterFunc(ctx, func() { resp.Body.Close() })
+	return newLineProvider(resp.Body, "", ""), nil
+}
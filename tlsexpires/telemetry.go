@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var otlpEndpoint = flag.String("otlp-endpoint", "", "An OpenTelemetry collector to export traces and metrics to with OTLP over gRPC, like http://localhost:4317, or https:// for TLS. Each check is a span, with the DNS, connect, STARTTLS and handshake phases under it. The standard OTEL_* environment variables, like OTEL_SERVICE_NAME and OTEL_EXPORTER_OTLP_HEADERS, also apply")
+
+// instrumentation is the name of our tracer and meter.
+const instrumentation = "github.com/johnsiilver/examples/tlsexpires"
+
+// tracer makes our spans. It does nothing until setupTelemetry() sets the global tracer provider.
+var tracer = otel.Tracer(instrumentation)
+
+// probeDuration and probeResults are our OpenTelemetry metrics, nil if -otlp-endpoint isn't set.
+var (
+	probeDuration metric.Float64Histogram
+	probeResults  metric.Int64Counter
+)
+
+// setupTelemetry starts exporting traces and metrics to -otlp-endpoint. The returned func flushes
+// what hasn't been exported yet, and should be called before we exit.
+func setupTelemetry() (shutdown func(), err error) {
+	if *otlpEndpoint == "" {
+		return func() {}, nil
+	}
+	ctx := context.Background()
+
+	// Attributes from OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME replace ours.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "tlsexpires")),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("-otlp-endpoint: %s", err)
+	}
+	traces, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(*otlpEndpoint))
+	if err != nil {
+		return nil, fmt.Errorf("-otlp-endpoint: %s", err)
+	}
+	metrics, err := otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithEndpointURL(*otlpEndpoint))
+	if err != nil {
+		return nil, fmt.Errorf("-otlp-endpoint: %s", err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traces), sdktrace.WithResource(res))
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metrics)), sdkmetric.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+
+	meter := mp.Meter(instrumentation)
+	probeDuration, err = meter.Float64Histogram("tlsexpires.probe.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("How long checking a host took, from looking up its address to finishing its checks"))
+	if err != nil {
+		return nil, err
+	}
+	probeResults, err = meter.Int64Counter("tlsexpires.probes",
+		metric.WithDescription("Hosts checked, by the status of the result"))
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := tp.Shutdown(ctx); err != nil {
+			log.Printf("could not export traces to -otlp-endpoint: %s", err)
+		}
+		if err := mp.Shutdown(ctx); err != nil {
+			log.Printf("could not export metrics to -otlp-endpoint: %s", err)
+		}
+	}, nil
+}
+
+// probe is the span and metrics of checking a single host.
+type probe struct {
+	span     trace.Span
+	protocol string
+	start    time.Time
+	phases   *phaseTimes
+}
+
+// timings are how long the phases of a check took, in seconds like our metrics. A phase that
+// happened more than once, like the handshakes of -tls-health after the first, has the first.
+type timings struct {
+	// DNS is looking up the host's addresses. Unset when we connected to an address or through a proxy.
+	DNS float64 `json:"dnsSeconds,omitempty"`
+	// Connect is the TCP connection.
+	Connect float64 `json:"connectSeconds,omitempty"`
+	// STARTTLS is upgrading the connection to TLS. Only set with a starttls option.
+	STARTTLS float64 `json:"starttlsSeconds,omitempty"`
+	// Handshake is the TLS or SSH handshake.
+	Handshake float64 `json:"handshakeSeconds,omitempty"`
+	// Total is the whole check, including the optional checks after the handshake.
+	Total float64 `json:"totalSeconds"`
+}
+
+// phaseTimes collects the timings of a check from its phases, which find it in their context.
+type phaseTimes struct {
+	mu sync.Mutex
+	t  timings
+}
+
+// phaseTimesKey is the context key of a check's *phaseTimes.
+type phaseTimesKey struct{}
+
+// record records that the phase called name took d, unless it was already recorded.
+func (p *phaseTimes) record(name string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var f *float64
+	switch name {
+	case "dns":
+		f = &p.t.DNS
+	case "connect":
+		f = &p.t.Connect
+	case "starttls":
+		f = &p.t.STARTTLS
+	case "tls.handshake", "ssh.handshake":
+		f = &p.t.Handshake
+	default:
+		return
+	}
+	if *f == 0 {
+		*f = d.Seconds()
+	}
+}
+
+// startProbe starts the span for checking hostPort, at addr if it is set, with the prober of
+// protocol, like "tls" or "ssh". The returned context carries the span, for the phases of the check.
+func startProbe(ctx context.Context, hostPort, addr, protocol string) (context.Context, probe) {
+	ctx, span := tracer.Start(ctx, "check "+hostPort,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("tlsexpires.host_port", hostPort),
+			attribute.String("tlsexpires.protocol", protocol),
+			attribute.String("tlsexpires.address", addr),
+		),
+	)
+	pt := &phaseTimes{}
+	ctx = context.WithValue(ctx, phaseTimesKey{}, pt)
+	return ctx, probe{span: span, protocol: protocol, start: time.Now(), phases: pt}
+}
+
+// timings returns how long the phases of the check have taken so far.
+func (p probe) timings() *timings {
+	p.phases.mu.Lock()
+	defer p.phases.mu.Unlock()
+	t := p.phases.t
+	t.Total = time.Since(p.start).Seconds()
+	return &t
+}
+
+// end records the result of the check, v, and ends its span.
+func (p probe) end(v result) {
+	attrs := []attribute.KeyValue{
+		attribute.String("tlsexpires.protocol", p.protocol),
+		attribute.String("tlsexpires.status", string(v.Status)),
+	}
+	if probeDuration != nil {
+		ctx := context.Background()
+		probeDuration.Record(ctx, time.Since(p.start).Seconds(), metric.WithAttributes(attrs...))
+		probeResults.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+
+	p.span.SetAttributes(attrs[1], attribute.StringSlice("tlsexpires.findings", v.findingNames()))
+	if !v.ExpiresOn.IsZero() {
+		p.span.SetAttributes(attribute.Int("tlsexpires.days_remaining", v.ExpireInDays()))
+	}
+	if v.Status == statusError {
+		p.span.SetStatus(otelcodes.Error, v.Err)
+	}
+	p.span.End()
+}
+
+// phase starts a span for a phase of a check, like the TLS handshake, under the check's span in
+// ctx. Calling end with how the phase went ends it, and records how long it took in the check's
+// timings.
+func phase(ctx context.Context, name string) (_ context.Context, end func(err error)) {
+	start := time.Now()
+	pt, _ := ctx.Value(phaseTimesKey{}).(*phaseTimes)
+	ctx, span := tracer.Start(ctx, name)
+	return ctx, func(err error) {
+		if pt != nil {
+			pt.record(name, time.Since(start))
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(otelcodes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	jiraURL        = flag.String("jira-url", "", "The Jira to file a ticket in for each certificate that expires within -warn-days, like https://example.atlassian.net. A certificate with an open ticket has it updated instead of getting another. Needs -jira-project and -jira-user")
+	jiraProject    = flag.String("jira-project", "", "The key of the -jira-url project to file tickets in")
+	jiraIssueType  = flag.String("jira-issue-type", "Task", "The type of the tickets filed in -jira-url")
+	jiraUser       = flag.String("jira-user", "", "The user to file -jira-url tickets as, with the API token in $"+jiraTokenEnv)
+	serviceNowURL  = flag.String("servicenow-url", "", "The ServiceNow instance to file a ticket in for each certificate that expires within -warn-days, like https://example.service-now.com. A certificate with an active ticket has it updated instead of getting another. Needs -servicenow-user")
+	serviceNowUser = flag.String("servicenow-user", "", "The user to file -servicenow-url tickets as, with the password in $"+serviceNowPasswordEnv)
+	serviceNowTbl  = flag.String("servicenow-table", "incident", "The -servicenow-url table tickets are records in")
+)
+
+const (
+	// jiraTokenEnv is the environment variable with the API token of -jira-user. It isn't a flag
+	// so that it doesn't show up in ps.
+	jiraTokenEnv = "TLSEXPIRES_JIRA_TOKEN"
+	// serviceNowPasswordEnv is the environment variable with the password of -servicenow-user.
+	serviceNowPasswordEnv = "TLSEXPIRES_SERVICENOW_PASSWORD"
+)
+
+// ticketClient is used to talk to Jira and ServiceNow.
+var ticketClient = &http.Client{Timeout: 30 * time.Second}
+
+// ticket is what we file about an expiring certificate.
+type ticket struct {
+	// Key identifies the certificate across runs, so we find its ticket again. It is derived
+	// from the fingerprint.
+	Key string
+	// Summary is the one line title.
+	Summary string
+	// Description has the hosts, owners, expiry and chain.
+	Description string
+}
+
+// ticketer files tickets in a ticketing system. It finds the open ticket with a key itself, so
+// we don't keep any state, and a ticket closed by hand is filed again if the certificate is
+// still expiring.
+type ticketer interface {
+	// find returns the ID of the open ticket with key, or "" if there isn't one.
+	find(ctx context.Context, key string) (string, error)
+	// create files t.
+	create(ctx context.Context, t ticket) error
+	// update replaces the summary and description of the ticket with id with those of t.
+	update(ctx context.Context, id string, t ticket) error
+}
+
+// ticketers returns the ticketers our flags set up, keyed by their integration name.
+func ticketers() (map[string]ticketer, error) {
+	ts := map[string]ticketer{}
+	if *jiraURL != "" {
+		if *jiraProject == "" || *jiraUser == "" {
+			return nil, fmt.Errorf("-jira-url requires -jira-project and -jira-user")
+		}
+		ts[integrationJira] = &jira{base: strings.TrimSuffix(*jiraURL, "/"), user: *jiraUser, token: os.Getenv(jiraTokenEnv)}
+	}
+	if *serviceNowURL != "" {
+		if *serviceNowUser == "" {
+			return nil, fmt.Errorf("-servicenow-url requires -servicenow-user")
+		}
+		ts[integrationServiceNow] = &serviceNow{base: strings.TrimSuffix(*serviceNowURL, "/"), table: *serviceNowTbl, user: *serviceNowUser, password: os.Getenv(serviceNowPasswordEnv)}
+	}
+	return ts, nil
+}
+
+// fileTickets files or updates a ticket in each of ts for every certificate in r that expires
+// within -warn-days. A certificate served by several hosts gets one ticket. A ticketer that
+// fails is reported like any other integration, and doesn't stop the others.
+func fileTickets(ctx context.Context, ts map[string]ticketer, r run) {
+	if len(ts) == 0 {
+		return
+	}
+	var expiring []result
+	for _, v := range r.Results {
+		if slices.Contains(v.Findings, findingExpiring) {
+			expiring = append(expiring, v)
+		}
+	}
+	groups, _ := groupByFingerprint(expiring)
+	for _, g := range groups {
+		t := newTicket(g, r.Results)
+		for name, tr := range ts {
+			err := fileTicket(ctx, tr, t)
+			integrationUsed(name, err)
+			if err != nil {
+				log.Printf("could not file the %s ticket for %s: %s", name, g.Certificate.Fingerprint, err)
+			}
+		}
+	}
+}
+
+// fileTicket updates the open ticket for t in tr, or creates one if there isn't one.
+func fileTicket(ctx context.Context, tr ticketer, t ticket) error {
+	id, err := tr.find(ctx, t.Key)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		return tr.update(ctx, id, t)
+	}
+	return tr.create(ctx, t)
+}
+
+// newTicket returns the ticket for the certificate of g. results are every result of the run,
+// which the hosts of g are found in for their labels.
+func newTicket(g certGroup, results []result) ticket {
+	c := g.Certificate
+	fp := strings.ToLower(strings.ReplaceAll(c.Fingerprint, ":", ""))
+	t := ticket{Key: incidentSource + "-" + fp[:16]}
+
+	hosts := g.Hosts[0]
+	if len(g.Hosts) > 1 {
+		hosts = fmt.Sprintf("%s and %d more hosts", hosts, len(g.Hosts)-1)
+	}
+	labels := ""
+	if len(c.Labels) > 0 {
+		labels = " [" + labelString(c.Labels) + "]"
+	}
+	t.Summary = truncate(fmt.Sprintf("TLS certificate of %s%s expires in %d days, on %s", hosts, labels, c.ExpireInDays(), c.ExpiresOn.Format(time.DateOnly)), 250)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "The certificate expires on %s, in %d days.\n\n", c.ExpiresOn.UTC().Format(time.RFC3339), c.ExpireInDays())
+	fmt.Fprintf(&b, "Served by:\n")
+	for _, v := range results {
+		if v.Fingerprint != c.Fingerprint || !slices.Contains(v.Findings, findingExpiring) {
+			continue
+		}
+		fmt.Fprintf(&b, "  %s", v.resultKey())
+		if len(v.Labels) > 0 {
+			fmt.Fprintf(&b, " [%s]", labelString(v.Labels))
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "\nNames: %s\n", strings.Join(c.SANs, ", "))
+	fmt.Fprintf(&b, "Issuer: %s\n", c.Issuer)
+	fmt.Fprintf(&b, "Serial: %s\n", c.Serial)
+	fmt.Fprintf(&b, "SHA-256 Fingerprint: %s\n", c.Fingerprint)
+	if len(c.CAs) > 0 {
+		fmt.Fprintf(&b, "\nChain:\n")
+		for _, ca := range c.CAs {
+			fmt.Fprintf(&b, "  %s, expires %s\n", ca.Subject, ca.ExpiresOn.Format(time.DateOnly))
+		}
+	}
+	fmt.Fprintf(&b, "\nFiled by %s, which updates this ticket while the certificate is expiring. Key: %s\n", incidentSource, t.Key)
+	t.Description = b.String()
+	return t
+}
+
+// ticketRequest sends a request with the json body, if it isn't nil, authenticated as user, and
+// decodes the json response into out, if it isn't nil.
+func ticketRequest(ctx context.Context, method, url, user, password string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(user, password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := ticketClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, truncate(string(b), 200))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("%s %s: %s", method, url, err)
+	}
+	return nil
+}
+
+// jira is a ticketer for Jira. A ticket's key is one of its labels.
+type jira struct {
+	base, user, token string
+}
+
+// find implements ticketer.find().
+func (j *jira) find(ctx context.Context, key string) (string, error) {
+	jql := fmt.Sprintf("project = %q AND labels = %q AND statusCategory != Done", *jiraProject, key)
+	var resp struct {
+		Issues []struct {
+			Key string `json:"key"`
+		} `json:"issues"`
+	}
+	u := j.base + "/rest/api/2/search?fields=key&maxResults=1&jql=" + url.QueryEscape(jql)
+	if err := ticketRequest(ctx, http.MethodGet, u, j.user, j.token, nil, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Issues) == 0 {
+		return "", nil
+	}
+	return resp.Issues[0].Key, nil
+}
+
+// create implements ticketer.create().
+func (j *jira) create(ctx context.Context, t ticket) error {
+	body := map[string]any{"fields": map[string]any{
+		"project":     map[string]string{"key": *jiraProject},
+		"issuetype":   map[string]string{"name": *jiraIssueType},
+		"summary":     t.Summary,
+		"description": t.Description,
+		"labels":      []string{incidentSource, t.Key},
+	}}
+	return ticketRequest(ctx, http.MethodPost, j.base+"/rest/api/2/issue", j.user, j.token, body, nil)
+}
+
+// update implements ticketer.update().
+func (j *jira) update(ctx context.Context, id string, t ticket) error {
+	body := map[string]any{"fields": map[string]any{"summary": t.Summary, "description": t.Description}}
+	return ticketRequest(ctx, http.MethodPut, j.base+"/rest/api/2/issue/"+url.PathEscape(id), j.user, j.token, body, nil)
+}
+
+// serviceNow is a ticketer for ServiceNow. A ticket's key is its correlation_id.
+type serviceNow struct {
+	base, table, user, password string
+}
+
+// find implements ticketer.find().
+func (s *serviceNow) find(ctx context.Context, key string) (string, error) {
+	var resp struct {
+		Result []struct {
+			SysID string `json:"sys_id"`
+		} `json:"result"`
+	}
+	q := url.Values{
+		"sysparm_query":  {"correlation_id=" + key + "^active=true"},
+		"sysparm_fields": {"sys_id"},
+		"sysparm_limit":  {"1"},
+	}
+	if err := ticketRequest(ctx, http.MethodGet, s.tableURL()+"?"+q.Encode(), s.user, s.password, nil, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Result) == 0 {
+		return "", nil
+	}
+	return resp.Result[0].SysID, nil
+}
+
+// create implements ticketer.create().
+func (s *serviceNow) create(ctx context.Context, t ticket) error {
+	body := map[string]string{
+		"short_description":   t.Summary,
+		"description":         t.Description,
+		"correlation_id":      t.Key,
+		"correlation_display": incidentSource,
+	}
+	return ticketRequest(ctx, http.MethodPost, s.tableURL(), s.user, s.password, body, nil)
+}
+
+// update implements ticketer.update().
+func (s *serviceNow) update(ctx context.Context, id string, t ticket) error {
+	body := map[string]string{"short_description": t.Summary, "description": t.Description}
+	return ticketRequest(ctx, http.MethodPatch, s.tableURL()+"/"+url.PathEscape(id), s.user, s.password, body, nil)
+}
+
+// tableURL returns the URL of the Table API for our table.
+func (s *serviceNow) tableURL() string {
+	return s.base + "/api/now/table/" + url.PathEscape(s.table)
+}
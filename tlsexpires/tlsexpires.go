@@ -1,57 +1,231 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
-	"text/template"
+	"syscall"
 	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+var (
+	ipFile   = flag.String("file", "", "The path to the file that has the host:port, one per line, optionally followed by labels for its results like team=payments env=prod. # starts a comment and @include other-file.txt reads the targets in another file. - reads from stdin")
+	format   = flag.String("format", "text", "The output format, 'text', 'json', 'jsonl', a line of json for each result as soon as its check finishes, 'csv', 'ics', an iCalendar file with an event for each certificate's expiry, or 'markdown', a report for issues, wikis and chat, written to stdout unless -output is set. Save the json output to use with the recheck subcommand")
+	warnDays = flag.Int("warn-days", 30, "Certificates that expire in fewer than this many days are reported with a warning status")
+	caFile   = flag.String("ca-file", "", "A PEM file of root certificates to trust instead of the system roots, like those of an internal CA")
+)
+
+// rootCAs are the roots we verify certificates against. nil means the system roots.
+var (
+	rootCAs     *x509.CertPool
+	rootCAsOnce sync.Once
+	rootCAsErr  error
+)
+
+// loadRootCAs sets rootCAs from -ca-file, if it is set. It only reads the file once, so that
+// scans and POST /check can't change rootCAs under each other.
+func loadRootCAs() error {
+	rootCAsOnce.Do(func() {
+		if *caFile == "" {
+			return
+		}
+		b, err := os.ReadFile(*caFile)
+		if err != nil {
+			rootCAsErr = err
+			return
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			rootCAsErr = fmt.Errorf("-ca-file=%s has no PEM encoded certificates", *caFile)
+			return
+		}
+		rootCAs = pool
+	})
+	return rootCAsErr
+}
+
+// status is the outcome of checking a single host.
+type status string
+
+const (
+	// statusOK means we got a certificate and it isn't expiring soon.
+	statusOK status = "ok"
+	// statusWarning means we got a certificate, but it expires within -warn-days.
+	statusWarning status = "warning"
+	// statusError means we couldn't get a certificate at all, or the certificate was revoked.
+	statusError status = "error"
 )
 
-var ipFile = flag.String("file", "", "The path to the file that has the host:port, one per line")
-
-// tmpl is a Go text template. I use this to output your text output.
-// template.Must() means it must compile or it crashes, and I create a
-// new template that parses the text you see.
-var tmpl = template.Must(template.New("").Parse(`
-Checking cerificate for server: {{ .Server }}
-Version: TLS {{ .TLSVersion }}
-Expires On: {{ .ExpiresOn }}
-In {{ .ExpireInDays }} days
-`,
-))
-
-// values are values that the template will receive.
-type values struct {
+// result is what we found checking a host. It is the one data model of tlsexpires: every
+// output format, sink, subcommand and API is made from it, and its json is what -format=json
+// writes and recheck, diff and -history read back in. Programs outside this package use it
+// through that json, from -format=json, the /check API or gRPC.
+type result struct {
+	// ID identifies this result. It is derived from the run ID and the host, see resultID().
+	ID string `json:"id,omitempty"`
+	// HostPort is the host:port line from the input file.
+	HostPort string `json:"hostPort"`
 	// Server is the name of the server.
-	Server string
+	Server string `json:"server"`
 	// Port is the TCP port the server listens on.
-	Port string
+	Port string `json:"port"`
+	// Address is the IP address we connected to. Only set with -all-ips.
+	Address string `json:"address,omitempty"`
+	// IssuedOn is when the certificate became valid, its NotBefore.
+	IssuedOn time.Time `json:"issuedOn,omitzero"`
 	// ExpiresOn is when the TLS certificate expires.
-	ExpiresOn time.Time
+	ExpiresOn time.Time `json:"expiresOn"`
+	// Issuer is the distinguished name of the CA that issued the certificate.
+	Issuer string `json:"issuer,omitempty"`
+	// SANs are the DNS names and IP addresses the certificate is valid for.
+	SANs []string `json:"sans,omitempty"`
+	// TLSVersion is the human readable TLS version the server negotiated.
+	TLSVersion string `json:"tlsVersion,omitempty"`
+	// CipherSuite is the name of the cipher suite the server negotiated, like "TLS_AES_128_GCM_SHA256".
+	CipherSuite string `json:"cipherSuite,omitempty"`
+	// KeyExchange is the key exchange group the server negotiated, like "X25519MLKEM768".
+	KeyExchange string `json:"keyExchange,omitempty"`
+	// ALPN is the protocol the server chose from the ones we offered with -alpn, if it chose one.
+	ALPN string `json:"alpn,omitempty"`
+	// ALPNPaths are the handshakes offering each -alpn protocol on its own. Only set when there
+	// is more than one.
+	ALPNPaths []alpnPath `json:"alpnPaths,omitempty"`
+	// CAs are the CA certificates the leaf chains to, its issuer first. For servers, this is the
+	// chain we verified, which ends at the root.
+	CAs []chainCert `json:"cas,omitempty"`
+	// Verified is if the chain the server presented verified against the roots for the name we
+	// connected to. It is false for a chain that didn't, and unset for checks without a chain to
+	// verify, like certificate files.
+	Verified *bool `json:"verified,omitempty"`
+	// ChainLength is the number of certificates the server presented, including the leaf.
+	ChainLength int `json:"chainLength,omitempty"`
+	// Fingerprint is the SHA-256 fingerprint of the leaf certificate as colon separated hex. With
+	// -ssh it is the OpenSSH SHA256: fingerprint of the host key.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Serial is the leaf certificate's serial number as colon separated hex.
+	Serial string `json:"serial,omitempty"`
+	// SubjectKeyID is the leaf certificate's Subject Key Identifier as colon separated hex.
+	SubjectKeyID string `json:"subjectKeyId,omitempty"`
+	// KeyAlgorithm is the leaf certificate's public key algorithm and size, like "RSA 2048" or "ECDSA P-256".
+	KeyAlgorithm string `json:"keyAlgorithm,omitempty"`
+	// SignatureAlgorithm is the algorithm the issuer signed the leaf certificate with, like "SHA256-RSA".
+	SignatureAlgorithm string `json:"signatureAlgorithm,omitempty"`
+	// KeyWeaknesses are the problems with the leaf certificate's key or signature, see keyWeaknesses().
+	KeyWeaknesses []string `json:"keyWeaknesses,omitempty"`
+	// Enumeration is every TLS version and cipher suite the server accepts. Only set with -enumerate.
+	Enumeration *enumeration `json:"enumeration,omitempty"`
+	// TLSHealth is how the server handles session resumption and renegotiation. Only set with -tls-health.
+	TLSHealth *tlsHealth `json:"tlsHealth,omitempty"`
+	// PostQuantum are the handshakes offering each post-quantum hybrid group on its own. Only
+	// set with -pqc.
+	PostQuantum []pqcGroup `json:"postQuantum,omitempty"`
+	// TrustStores are whether the chain the server presented verifies against each of the
+	// -trust-store bundles.
+	TrustStores []trustStoreResult `json:"trustStores,omitempty"`
+	// DANE is how the certificates the server presented match its TLSA records. Only set with -check-dane.
+	DANE *daneResult `json:"dane,omitempty"`
+	// HTTP is what the HTTP follow-up checks found, like if the server sends HSTS. Only set with -http-checks.
+	HTTP *httpPosture `json:"http,omitempty"`
+	// ACME is when the certificate should be renewed. Only set for Let's Encrypt certificates.
+	ACME *acmeRenewal `json:"acme,omitempty"`
+	// Timings are how long each phase of the check took.
+	Timings *timings `json:"timings,omitempty"`
+	// Agent is the -agent-of agent that checked the host, for hosts in a zone with an agent.
+	Agent string `json:"agent,omitempty"`
+	// CachedAt is when the server was checked, if the result came from -cache instead of this run.
+	CachedAt time.Time `json:"cachedAt,omitzero"`
+	// Changes are how the handshake differs from the last run. Only set with -history.
+	Changes []string `json:"changes,omitempty"`
+	// Mismatch is set with -all-ips when the addresses of HostPort don't all serve the same certificate.
+	Mismatch string `json:"mismatch,omitempty"`
+	// Labels are the labels of the host in -config and the ones where we discovered it gave it,
+	// like the ARN of an AWS resource.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Zone is the -zones zone the host was checked in.
+	Zone string `json:"zone,omitempty"`
+	// Status is the outcome of the check.
+	Status status `json:"status"`
+	// Findings are why Status isn't statusOK, see the "codes" subcommand for what each means.
+	Findings []finding `json:"findings,omitempty"`
+	// Err is the reason we couldn't check the server. Only set if Status is statusError.
+	Err string `json:"error,omitempty"`
+	// CRLError is set if -crl was provided and we couldn't check a CRL for the chain.
+	CRLError string `json:"crlError,omitempty"`
+	// ValidSCTs is the number of SCTs that verified against a known log. Only set with -ct.
+	ValidSCTs int `json:"validSCTs,omitempty"`
+	// CTLogged is if crt.sh has seen the certificate in a CT log. Only set with -ct-query.
+	CTLogged *bool `json:"ctLogged,omitempty"`
+	// CTError is the first problem we had verifying SCTs or querying crt.sh.
+	CTError string `json:"ctError,omitempty"`
+
+	// order is the position of HostPort in the input, starting at 0.
+	order int
+	// addrs is how many addresses of HostPort we are checking with -all-ips.
+	addrs int
+}
 
-	// version is the TLS version number as specified by the TLS spec.
-	version uint16
+// resultKey identifies the host and, with -all-ips, the address that v is the result for.
+func (v result) resultKey() string {
+	if v.Address == "" {
+		return v.HostPort
+	}
+	return v.HostPort + " " + v.Address
 }
 
 // ExpireInDays converts ExpiresOn to the number of days until the cert expires.
-func (v values) ExpireInDays() int {
-	x := int(time.Until(v.ExpiresOn).Hours()/24)
+func (v result) ExpireInDays() int {
+	x := int(until(v.ExpiresOn).Hours() / 24)
 	if x < 0 {
 		x = 0
 	}
 	return x
 }
 
-// Version returns the TLS version as a human readable string.
-func (v values) TLSVersion() string {
-	switch v.version {
+// run is what we output in json format. It is also what the recheck subcommand reads back in.
+type run struct {
+	// ID is a ULID that identifies the run.
+	ID string `json:"id,omitempty"`
+	// Started is when the scan started.
+	Started time.Time `json:"started"`
+	// Results are the results for every host that was checked.
+	Results []result `json:"results"`
+	// Summary is the aggregate statistics for Results.
+	Summary *summary `json:"summary,omitempty"`
+	// Partial is set if the run was interrupted, so not every host was checked.
+	Partial bool `json:"partial,omitempty"`
+	// Subset is set if only some of our hosts were checked on purpose, like by recheck, so the
+	// hosts that aren't in Results weren't removed.
+	Subset bool `json:"subset,omitempty"`
+}
+
+// readRun reads the json output (-format=json) of a previous run from path.
+func readRun(path string) (run, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return run{}, err
+	}
+	r := run{}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return run{}, fmt.Errorf("%s does not look like the output of -format=json: %s", path, err)
+	}
+	return r, nil
+}
+
+// tlsVersionName returns the TLS version as a human readable string.
+func tlsVersionName(version uint16) string {
+	switch version {
 	case tls.VersionTLS10:
 		return "1.0"
 	case tls.VersionTLS11:
@@ -64,86 +238,558 @@ func (v values) TLSVersion() string {
 	return "unknown version"
 }
 
-// getTLSInfo takes a host:port string, connects via TLS and returns our values. An error is returned
-// if we can't connect, TLS is not present, or hostPort is badly formed.
-func getTLSInfo(hostPort string) (values, error) {
+// keyExchangeName returns the name of the key exchange group id, or "" if there wasn't one, like
+// with TLS 1.2 RSA key exchange.
+func keyExchangeName(id tls.CurveID) string {
+	if id == 0 {
+		return ""
+	}
+	return id.String()
+}
+
+// getTLSInfo takes a host:port string, connects via TLS and returns our result. An error is returned
+// if we can't connect, TLS is not present, or hostPort is badly formed. d is used to make the connection
+// and opts are the options to check it with. If addr is set, we connect to that IP address instead of
+// resolving the host.
+func getTLSInfo(ctx context.Context, d contextDialer, hostPort, addr string, opts *targetConfig) (result, error) {
 	host, port, err := net.SplitHostPort(hostPort)
 	if err != nil {
-		return values{}, fmt.Errorf("hostPort must be the DNS hostname or IP address + ':' + port, was %q", hostPort)
+		return result{}, fmt.Errorf("hostPort must be the DNS hostname or IP address + ':' + port, was %q", hostPort)
+	}
+	dialAddr := hostPort
+	if addr != "" {
+		dialAddr = net.JoinHostPort(addr, port)
 	}
 
-	conn, err := tls.Dial("tcp", hostPort, nil)
+	conf := opts.tlsConfig(host)
+	conn, err := dialTLS(ctx, d, dialAddr, conf, opts.STARTTLS)
 	if err != nil {
-		return values{}, fmt.Errorf("server doesn't support SSL certificate err: %s", err)
+		// A certificate that isn't valid yet is reported by check(), which says by how long.
+		if chain := notYetValidChain(err, conf); chain != nil {
+			verified := false
+			v := result{HostPort: hostPort, Server: host, Port: port, Address: addr, Status: statusOK, Verified: &verified}
+			v.describe(chain, opts.warnDays())
+			return v, nil
+		}
+		if *checkDANE {
+			if chain, d := daneOnlyChain(ctx, err, host, port); chain != nil {
+				verified := false
+				v := result{HostPort: hostPort, Server: host, Port: port, Address: addr, Status: statusOK, Verified: &verified, DANE: d}
+				v.describe(chain, opts.warnDays())
+				return v, nil
+			}
+		}
+		return result{}, fmt.Errorf("server doesn't support SSL certificate err: %w", err)
 	}
 	defer conn.Close()
 
 	cs := conn.ConnectionState()
-	v := values{
-		Server:    host,
-		Port:      port,
-		ExpiresOn: cs.PeerCertificates[0].NotAfter,
-		version:   cs.Version,
+	leaf := cs.PeerCertificates[0]
+	verified := true
+	v := result{
+		HostPort:    hostPort,
+		Server:      host,
+		Port:        port,
+		Address:     addr,
+		TLSVersion:  tlsVersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		KeyExchange: keyExchangeName(cs.CurveID),
+		ALPN:        cs.NegotiatedProtocol,
+		Verified:    &verified,
+		Status:      statusOK,
+	}
+	v.describe(cs.PeerCertificates, opts.warnDays())
+	v.CAs = chainCerts(cs.VerifiedChains[0][1:])
+
+	if *dumpCerts != "" {
+		if err := dumpChain(host, port, cs.PeerCertificates); err != nil {
+			log.Printf("could not write the certificates for %s to -dump-certs: %s", hostPort, err)
+		}
+	}
+	v.TrustStores = checkTrustStores(cs.PeerCertificates, conf.ServerName)
+	if trustStoreRejected(v.TrustStores) {
+		v.find(findingUntrustedByStore)
+	}
+	if err := checkPin(hostPort, leaf); err != nil {
+		v.find(findingPinMismatch)
+		v.Err = err.Error()
+	}
+
+	// A stapled OCSP response costs us nothing to check, so we always do.
+	if chain := cs.VerifiedChains[0]; len(cs.OCSPResponse) > 0 && len(chain) > 1 {
+		resp, err := ocsp.ParseResponseForCert(cs.OCSPResponse, leaf, chain[1])
+		if err != nil {
+			err = fmt.Errorf("%s stapled a bad OCSP response: %s", hostPort, err)
+		}
+		integrationUsed(integrationOCSP, err)
+		if err == nil && resp.Status == ocsp.Revoked {
+			v.find(findingRevokedOCSP)
+			v.Err = fmt.Sprintf("certificate was revoked on %s according to the stapled OCSP response", resp.RevokedAt)
+		}
+	}
+
+	if *checkCRL {
+		for _, r := range checkCRLs(cs.VerifiedChains[0]) {
+			integrationUsed(integrationCRL, r.Err)
+			switch {
+			case r.Revoked:
+				v.find(findingRevokedCRL)
+				v.Err = fmt.Sprintf("certificate %q was revoked on %s according to CRL %s", r.Cert.Subject, r.RevokedAt, r.URL)
+			case r.Err != nil && v.CRLError == "":
+				v.CRLError = r.Err.Error()
+			}
+		}
+	}
+	if *checkCT {
+		addCT(&v, cs)
+	}
+	if *checkDANE {
+		dctx, end := phase(ctx, "dane")
+		v.DANE = checkDANERecords(dctx, host, port, cs.PeerCertificates, cs.VerifiedChains[0])
+		end(nil)
+		// DANE clients ignore records that aren't signed, so a mismatch only matters if they are.
+		switch d := v.DANE; {
+		case d == nil:
+		case d.Error != "":
+			v.find(findingDANELookup)
+		case len(d.Records) == 0:
+		case !d.Secure:
+			v.find(findingDANEInsecure)
+		case !d.Matched:
+			v.find(findingDANEMismatch)
+			v.Err = fmt.Sprintf("the certificates match none of the TLSA records at %s", d.Name)
+		}
+	}
+	if *enumerate {
+		ectx, end := phase(ctx, "enumerate")
+		e := enumerateTLS(ectx, d, dialAddr, host, opts)
+		end(nil)
+		v.Enumeration = &e
+		if len(e.Weaknesses) > 0 {
+			v.find(findingProtocolWeakness)
+		}
+	}
+	if protos := opts.alpn(); len(protos) > 1 {
+		actx, end := phase(ctx, "alpn")
+		v.ALPNPaths = checkALPNPaths(actx, d, dialAddr, host, opts, protos)
+		end(nil)
+		if alpnMismatch(v.ALPNPaths, v.Fingerprint) {
+			v.find(findingALPNMismatch)
+		}
+	}
+	if *checkTLSHealth {
+		hctx, end := phase(ctx, "tls.health")
+		h := checkHealth(hctx, d, dialAddr, host, opts)
+		end(nil)
+		v.TLSHealth = &h
+		if h.SecureRenegotiation != nil && !*h.SecureRenegotiation {
+			v.find(findingRenegotiation)
+		}
+	}
+	if *checkHTTP && opts.STARTTLS == "" {
+		hctx, end := phase(ctx, "http")
+		v.HTTP = checkHTTPPosture(hctx, d, conn, dialAddr, conf.ServerName)
+		end(nil)
+		if v.HTTP.Status != "" && !v.HTTP.HSTS {
+			v.find(findingNoHSTS)
+		}
+		if r := v.HTTP.RedirectsToHTTPS; r != nil && !*r {
+			v.find(findingNoRedirect)
+		}
+	}
+	if *checkPQC {
+		pctx, end := phase(ctx, "pqc")
+		v.PostQuantum = checkPQCGroups(pctx, d, dialAddr, host, opts)
+		end(nil)
+		if pqcRejected(v.PostQuantum) {
+			v.find(findingNoPostQuantum)
+		}
 	}
 	return v, nil
 }
 
-func main() {
-	// Causes the flags defined to be read in, almost always the first line in main().
-	flag.Parse()
+// describe fills in what v says about the certificate chain, whose first certificate is the
+// leaf, and records findings for the leaf expiring within warnDays or having a weak key.
+func (v *result) describe(chain []*x509.Certificate, warnDays int) {
+	leaf := chain[0]
+	v.IssuedOn = leaf.NotBefore
+	v.ExpiresOn = leaf.NotAfter
+	v.Issuer = leaf.Issuer.String()
+	v.SANs = subjectAltNames(leaf)
+	v.ChainLength = len(chain)
+	v.CAs = chainCerts(chain[1:])
+	v.Fingerprint = fingerprint(leaf)
+	v.Serial = colonHex(leaf.SerialNumber.Bytes())
+	v.SubjectKeyID = colonHex(leaf.SubjectKeyId)
+	v.KeyAlgorithm = keyDescription(leaf)
+	v.SignatureAlgorithm = leaf.SignatureAlgorithm.String()
+	v.KeyWeaknesses = keyWeaknesses(leaf)
+	v.ACME = acmeRenewalFor(leaf)
 
-	// limit is a limiter that prevents over 100 TLS connections at a time.
-	limit := make(chan struct{}, 100)
+	if v.ExpireInDays() < warnDays {
+		v.find(findingExpiring)
+	}
+	if len(v.KeyWeaknesses) > 0 {
+		v.find(findingKeyWeakness)
+	}
+}
+
+// check is getTLSInfo, except a failure is recorded in the returned result instead of being
+// returned. provided are the labels the provider of hostPort gave it.
+func check(ctx context.Context, d contextDialer, hostPort, addr string, provided map[string]string) result {
+	return assess(ctx, checkTarget(ctx, d, hostPort, addr), provided)
+}
 
-	// This opens the file at "/path/to/file.txt".
-	file, err := os.Open(*ipFile)
+// assess adds our labels to v, the result of checking its target here or by an agent, and
+// checks it against the policies that are ours rather than the checker's, like -expect-issuer.
+// provided are the labels the provider of its target gave it.
+func assess(ctx context.Context, v result, provided map[string]string) result {
+	v.Labels = labelsFor(v.HostPort, provided)
+	checkIssuer(&v)
+	checkValidity(&v, optionsFor(v.HostPort).maxValidityDays())
+	checkClock(&v)
+	checkACME(ctx, &v)
+	return v
+}
+
+// checkTarget does the work of check().
+func checkTarget(ctx context.Context, d contextDialer, hostPort, addr string) result {
+	for prefix, p := range storedProbers {
+		if strings.HasPrefix(hostPort, prefix) {
+			// Stored certificate probers record their failures in the result.
+			v, _ := p.Probe(ctx, probeTarget{HostPort: hostPort})
+			return v
+		}
+	}
+	opts := optionsFor(hostPort)
+	if v, ok := cachedResult(hostPort, addr, opts); ok {
+		return v
+	}
+	v := checkServer(ctx, d, hostPort, addr, opts)
+	// A check we cut short says nothing about the host, so it isn't cached.
+	if ctx.Err() == nil {
+		cacheResult(hostPort, addr, opts, v)
+	}
+	return v
+}
+
+// checkServer checks the server at hostPort with the prober of its protocol and opts. A failure
+// is recorded in the returned result.
+func checkServer(ctx context.Context, d contextDialer, hostPort, addr string, opts *targetConfig) result {
+	protocol := opts.protocol()
+	ctx, p := startProbe(ctx, hostPort, addr, protocol)
+	v, err := probers[protocol].Probe(ctx, probeTarget{HostPort: hostPort, Addr: addr, Dialer: d, Options: opts})
+	if err != nil {
+		host, port, _ := net.SplitHostPort(hostPort)
+		v = result{HostPort: hostPort, Server: host, Port: port, Address: addr, Status: statusOK, Err: err.Error()}
+		var verr *tls.CertificateVerificationError
+		if errors.As(err, &verr) {
+			verified := false
+			v.Verified = &verified
+		}
+		v.find(findingHandshake)
+	}
+	v.Timings = p.timings()
+	p.end(v)
+	return v
+}
+
+// checkAll checks every host:port it receives on hostPorts and calls report() with the result,
+// which has the labels in labels. labels can be nil. It returns when hostPorts is closed and
+// every check has finished. report() is only called from one goroutine at a time, and scanning
+// slows down to match it if it is slow.
+func checkAll(ctx context.Context, hostPorts <-chan string, labels *targetLabels, report func(v result)) {
+	if err := checkFamilyFlags(); err != nil {
+		log.Fatal(err)
+	}
+	zc, err := loadZones()
+	if err != nil {
+		log.Fatal(err)
+	}
+	zc.capConcurrency(findBudget())
+	if err := loadRootCAs(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadTrustStores(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadConfig(); err != nil {
+		log.Fatal(err)
+	}
+	// Zones with an agent need the hub, which subcommands haven't started.
+	if err := startAgentHub(); err != nil {
+		log.Fatal(err)
+	}
+	h, err := loadHistory()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer file.Close() // Close the file when main() ends.
 
-	// We are going to use this to scan the file line by line.
-	scanner := bufio.NewScanner(file)
-	// wg will let us know when all of our concurrent operations are done.
-	wg := sync.WaitGroup{}
+	// Each stage runs in its own goroutines and hands its work to the next stage over a bounded
+	// channel, so a slow stage makes the ones before it wait instead of piling up results.
+	p := newPipeline()
+	prog := startProgress()
+	discovered := make(chan zoneWork, *stageBuffer)
+	checked := make(chan result, *stageBuffer)
+	evaluated := make(chan result, *stageBuffer)
 
-	// Scan each line from the file.
-	for scanner.Scan() {
-		// Trim any space characters from the line and assign it to hostPort.
-		hostPort := strings.TrimSpace(scanner.Text())
-		if hostPort == "" {
-			continue
+	// discover numbers each host:port in the order we received it.
+	go func() {
+		defer close(discovered)
+		order := 0
+		for {
+			hostPort, ok := recv(&p.discover, hostPorts)
+			// Once we are interrupted we don't start on any more hosts.
+			if !ok || ctx.Err() != nil {
+				return
+			}
+			send(&p.discover, discovered, zoneWork{hostPort: hostPort, order: order})
+			order++
 		}
+	}()
 
-		// Add a counter for our concurrent operation.
-		wg.Add(1)
-		limit <- struct{}{} // Only proceed if < 100 operations are in effect.
+	// wg will let us know when all of our check workers are done.
+	wg := sync.WaitGroup{}
 
-		// Start a concurrent operation.
-		go func() {
-			defer wg.Done()            // remove a counter for a concurrent operation when this closes.
-			defer func() { <-limit }() // remove a limit when this operation is done.
+	// resolve sends each host to the zone it is in. Each zone gets its own queue and workers,
+	// which limits how many hosts in the zone we check at a time. Finding a host's zone can take
+	// a DNS lookup, so resolveWorkers hosts are looked up at a time, and a slow lookup doesn't
+	// hold up the rest. Each zone's queue holds up to -stage-buffer hosts, after which the hosts
+	// of that zone wait for its workers.
+	go func() {
+		var mu sync.Mutex
+		workers := map[*zone]*zoneWorkers{}
+		// feeders will let us know when every zone has queued its hosts.
+		feeders := sync.WaitGroup{}
+		resolvers := sync.WaitGroup{}
+		for range resolveWorkers {
+			resolvers.Go(func() {
+				for {
+					w, ok := recv(&p.resolve, discovered)
+					if !ok {
+						return
+					}
+					start := time.Now()
+					z := zc.zoneFor(ctx, w.hostPort)
+					mu.Lock()
+					zw, ok := workers[z]
+					if !ok {
+						zw = &zoneWorkers{zone: z, in: make(chan zoneWork, *stageBuffer), queue: make(chan zoneWork), prog: prog, labels: labels}
+						zw.start(ctx, &wg, &p.check, checked)
+						feeders.Go(func() { zw.feed(ctx, &p.resolve) })
+						workers[z] = zw
+					}
+					mu.Unlock()
+					p.resolve.worked(start)
+					send(&p.resolve, zw.in, w)
+				}
+			})
+		}
+		resolvers.Wait()
+		for _, zw := range workers {
+			close(zw.in)
+		}
+		feeders.Wait()
+		prog.doneQueueing()
+		// Wait for all checks to end.
+		wg.Wait()
+		close(checked)
+	}()
 
-			// Get our TLS info
-			v, err := getTLSInfo(hostPort)
-			if err != nil {
-				fmt.Printf("%q: error: %s\n", hostPort, err)
+	// evaluate compares each result to the last run and, with -all-ips, to the results for the
+	// other addresses of the same host.
+	go func() {
+		defer close(evaluated)
+		backends := backendGroups{}
+		for {
+			v, ok := recv(&p.evaluate, checked)
+			if !ok {
 				return
 			}
-			// Render our text to stdout.
-			if err := tmpl.Execute(os.Stdout, v); err != nil {
+			start := time.Now()
+			if h != nil {
+				h.annotate(&v)
+			}
+			ready := backends.add(v)
+			p.evaluate.worked(start)
+			for _, v := range ready {
+				send(&p.evaluate, evaluated, v)
+			}
+		}
+	}()
+
+	// emit hands each result to report().
+	for {
+		v, ok := recv(&p.emit, evaluated)
+		if !ok {
+			break
+		}
+		start := time.Now()
+		report(v)
+		p.emit.worked(start)
+		p.emit.items.Add(1)
+		prog.record(v)
+	}
+	prog.end()
+
+	if *stageMetrics {
+		if err := p.writeMetrics(os.Stderr); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// subcommandFlags returns a FlagSet for a subcommand. The FlagSet understands all of our
+// top level flags in addition to the ones the subcommand adds.
+func subcommandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s %s:\n", os.Args[0], name)
+		printDefaults(fs)
+	}
+	return fs
+}
+
+func main() {
+	// Subcommands are the first argument and have their own flags.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "recheck":
+			if err := recheck(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "show":
+			if err := show(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "verify":
+			if err := verify(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "preview":
+			if err := preview(os.Args[2:]); err != nil {
 				log.Fatal(err)
 			}
-		}()
+			return
+		case "selftest":
+			if err := selftest(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "codes":
+			if err := codes(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "digest":
+			if err := digestCmd(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "diff":
+			if err := diffCmd(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "changes":
+			if err := changesCmd(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "cas":
+			if err := casCmd(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
 	}
 
-	// Wait for all concurrent operations to end.
-	wg.Wait()
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		printDefaults(flag.CommandLine)
+		writeCodes(flag.CommandLine.Output())
+	}
+	// Causes the flags defined to be read in, almost always the first line in main().
+	flag.Parse()
+	if err := applyProfile(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	zeroConfig()
 
-	// If we had a problem reading the file, throw a fatal error.
-	if err := scanner.Err(); err != nil {
+	shutdownTelemetry, err := setupTelemetry()
+	if err != nil {
 		log.Fatal(err)
 	}
+	defer shutdownTelemetry()
 
-	fmt.Println("Finished")
+	if *agentOf != "" {
+		ctx, cancel := interruptContext()
+		defer cancel()
+		if err := runAgent(ctx); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := startAgentHub(); err != nil {
+		log.Fatal(err)
+	}
+	if *listen != "" {
+		log.Fatal(serve())
+	}
+	if *grpcListen != "" {
+		api, err := newCheckAPI()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Fatal(serveGRPC(api))
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	// This is where our host:ports come from, by default the lines of -file.
+	p, err := newTargetProvider(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hostPorts, labels, errc := streamTargets(ctx, p)
+
+	output(ctx, hostPorts, labels, false)
+
+	// If we had a problem reading our targets, throw a fatal error. Being interrupted while
+	// reading them isn't one.
+	if err := <-errc; err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+	if ctx.Err() != nil {
+		shutdownTelemetry()
+		// So scripts know we didn't finish.
+		os.Exit(exitInterrupted)
+	}
+}
+
+// interruptContext returns a context that is done once we get SIGINT (Ctrl-C) or SIGTERM, which
+// stops a scan early with the results it has. A second signal kills us as usual, for when
+// waiting on the checks in progress takes too long.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case s := <-sigs:
+			log.Printf("got %s, stopping the checks in progress and writing the results so far (again to exit now)", s)
+			signal.Reset(os.Interrupt, syscall.SIGTERM)
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(sigs)
+		}
+	}()
+	return ctx, cancel
 }
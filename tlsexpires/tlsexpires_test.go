@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testNow is what "now" is in tests that use setClock.
+var testNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// day is a day, for tests that work in days.
+const day = 24 * time.Hour
+
+// setClock makes clock a fixedClock at now for the rest of the test, like -now does.
+func setClock(t *testing.T, now time.Time) {
+	t.Helper()
+	old := clock
+	clock = fixedClock{t: now}
+	t.Cleanup(func() { clock = old })
+}
+
+// keepFlags puts the flags with names back the way they are once the test is done, for tests
+// that set them.
+func keepFlags(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		f := flag.Lookup(name)
+		if f == nil {
+			t.Fatalf("-%s is not a flag", name)
+		}
+		old := f.Value.String()
+		t.Cleanup(func() { f.Value.Set(old) })
+	}
+}
+
+// setFlag sets the flag name to value for the rest of the test.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	keepFlags(t, name)
+	if err := flag.Set(name, value); err != nil {
+		t.Fatalf("-%s=%s: %s", name, value, err)
+	}
+}
+
+func TestExpireInDays(t *testing.T) {
+	setClock(t, testNow)
+
+	tests := []struct {
+		desc      string
+		expiresOn time.Time
+		want      int
+	}{
+		{desc: "in 10 days", expiresOn: testNow.Add(10 * day), want: 10},
+		{desc: "a partial day is not counted", expiresOn: testNow.Add(10*day - time.Hour), want: 9},
+		{desc: "later today", expiresOn: testNow.Add(time.Hour), want: 0},
+		{desc: "expired", expiresOn: testNow.Add(-day), want: 0},
+		{desc: "no certificate", want: 0},
+	}
+	for _, test := range tests {
+		got := result{ExpiresOn: test.expiresOn}.ExpireInDays()
+		if got != test.want {
+			t.Errorf("TestExpireInDays(%s): got %d days, want %d", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	setClock(t, testNow)
+	keepFlags(t, "clock-skew")
+	ca, err := newTestCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc         string
+		notBefore    time.Time
+		notAfter     time.Time
+		warnDays     int
+		maxValidity  int
+		clockSkew    time.Duration
+		wantStatus   status
+		wantFindings []finding
+	}{
+		{
+			desc:       "valid for a year",
+			notBefore:  testNow.Add(-day),
+			notAfter:   testNow.Add(365 * day),
+			warnDays:   30,
+			wantStatus: statusOK,
+		},
+		{
+			desc:         "expires within -warn-days",
+			notBefore:    testNow.Add(-60 * day),
+			notAfter:     testNow.Add(20 * day),
+			warnDays:     30,
+			wantStatus:   statusWarning,
+			wantFindings: []finding{findingExpiring},
+		},
+		{
+			desc:       "expires on the last day of -warn-days",
+			notBefore:  testNow.Add(-60 * day),
+			notAfter:   testNow.Add(30 * day),
+			warnDays:   30,
+			wantStatus: statusOK,
+		},
+		// The handshake fails for an expired certificate, which makes it an error. Here we only
+		// see what the expiry itself adds.
+		{
+			desc:         "expired",
+			notBefore:    testNow.Add(-60 * day),
+			notAfter:     testNow.Add(-day),
+			warnDays:     30,
+			wantStatus:   statusWarning,
+			wantFindings: []finding{findingExpiring},
+		},
+		{
+			desc:         "valid for longer than -max-validity-days",
+			notBefore:    testNow.Add(-day),
+			notAfter:     testNow.Add(398 * day),
+			warnDays:     30,
+			maxValidity:  397,
+			wantStatus:   statusWarning,
+			wantFindings: []finding{findingLongValidity},
+		},
+		{
+			desc:         "not valid yet",
+			notBefore:    testNow.Add(time.Hour),
+			notAfter:     testNow.Add(365 * day),
+			warnDays:     30,
+			wantStatus:   statusError,
+			wantFindings: []finding{findingNotYetValid},
+		},
+		{
+			desc:         "not valid yet, but within -clock-skew",
+			notBefore:    testNow.Add(time.Hour),
+			notAfter:     testNow.Add(365 * day),
+			warnDays:     30,
+			clockSkew:    2 * time.Hour,
+			wantStatus:   statusWarning,
+			wantFindings: []finding{findingClockSkew},
+		},
+	}
+
+	for _, test := range tests {
+		setFlag(t, "clock-skew", test.clockSkew.String())
+		tmpl := leaf("localhost", test.notBefore, test.notAfter)
+		c, err := ca.sign(tmpl, &ca.interKey.PublicKey, ca.inter, ca.interKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v := result{HostPort: "localhost:443", Status: statusOK}
+		v.describe([]*x509.Certificate{c, ca.inter}, test.warnDays)
+		checkValidity(&v, test.maxValidity)
+		checkClock(&v)
+
+		if v.Status != test.wantStatus {
+			t.Errorf("TestStatus(%s): got status %s, want %s", test.desc, v.Status, test.wantStatus)
+		}
+		if !slices.Equal(v.Findings, test.wantFindings) {
+			t.Errorf("TestStatus(%s): got findings %v, want %v", test.desc, v.Findings, test.wantFindings)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trustStores is a flag.Value for the repeatable -trust-store flag. It maps the name of a
+// bundle of root certificates to the PEM file it is in.
+type trustStores map[string]string
+
+var trustStoreFiles = trustStores{}
+
+func init() {
+	flag.Var(trustStoreFiles, "trust-store", "name=roots.pem of a bundle of root certificates to also verify each chain against, like mozilla=cacert.pem, apple=apple-roots.pem or android-7=android7-roots.pem, to see which clients would reject it, such as when a root is removed from a store or expires. With -now, the chains are verified at that time. A chain that doesn't verify against one of them gets a warning. Can be repeated")
+}
+
+// String implements flag.Value.String().
+func (t trustStores) String() string {
+	var out []string
+	for _, name := range slices.Sorted(maps.Keys(t)) {
+		out = append(out, name+"="+t[name])
+	}
+	return strings.Join(out, ",")
+}
+
+// Set implements flag.Value.Set().
+func (t trustStores) Set(s string) error {
+	name, path, ok := strings.Cut(s, "=")
+	name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+	if !ok || name == "" || path == "" {
+		return fmt.Errorf("-trust-store must be name=roots.pem, was %q", s)
+	}
+	if _, ok := t[name]; ok {
+		return fmt.Errorf("-trust-store %s is given more than once", name)
+	}
+	t[name] = path
+	return nil
+}
+
+// trustPools are the root bundles of -trust-store, keyed by name.
+var (
+	trustPools     map[string]*x509.CertPool
+	trustPoolsOnce sync.Once
+	trustPoolsErr  error
+)
+
+// loadTrustStores sets trustPools from -trust-store. Like loadRootCAs, it only reads the files once.
+func loadTrustStores() error {
+	trustPoolsOnce.Do(func() {
+		pools := map[string]*x509.CertPool{}
+		for name, path := range trustStoreFiles {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				trustPoolsErr = fmt.Errorf("-trust-store %s: %s", name, err)
+				return
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(b) {
+				trustPoolsErr = fmt.Errorf("-trust-store %s=%s has no PEM encoded certificates", name, path)
+				return
+			}
+			pools[name] = pool
+		}
+		trustPools = pools
+	})
+	return trustPoolsErr
+}
+
+// trustStoreResult is whether a chain verifies against one of the -trust-store bundles.
+type trustStoreResult struct {
+	// Store is the name of the bundle.
+	Store string `json:"store"`
+	// Verified is if the chain verified against it.
+	Verified bool `json:"verified"`
+	// Root is the subject of the root in the bundle the chain verified to. When it can verify to
+	// several, like when a root is cross-signed, it is the one that expires last.
+	Root string `json:"root,omitempty"`
+	// RootExpiresOn is when Root expires, after which clients with only this bundle reject the chain.
+	RootExpiresOn time.Time `json:"rootExpiresOn,omitzero"`
+	// Error is why the chain didn't verify.
+	Error string `json:"error,omitempty"`
+}
+
+// checkTrustStores verifies chain, the certificates a server named host presented with the leaf
+// first, against each of the -trust-store bundles, in the order of their names.
+func checkTrustStores(chain []*x509.Certificate, host string) []trustStoreResult {
+	if len(trustPools) == 0 {
+		return nil
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	results := make([]trustStoreResult, 0, len(trustPools))
+	for _, name := range slices.Sorted(maps.Keys(trustPools)) {
+		r := trustStoreResult{Store: name}
+		chains, err := chain[0].Verify(x509.VerifyOptions{
+			DNSName:       host,
+			Roots:         trustPools[name],
+			Intermediates: intermediates,
+			CurrentTime:   clock.Now(),
+		})
+		if err != nil {
+			r.Error = err.Error()
+			results = append(results, r)
+			continue
+		}
+		r.Verified = true
+		for _, c := range chains {
+			root := c[len(c)-1]
+			if root.NotAfter.After(r.RootExpiresOn) {
+				r.Root, r.RootExpiresOn = root.Subject.String(), root.NotAfter
+			}
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// trustStoreRejected reports if any of results didn't verify.
+func trustStoreRejected(results []trustStoreResult) bool {
+	return slices.ContainsFunc(results, func(r trustStoreResult) bool { return !r.Verified })
+}
+
+// trustStoreSummary is how many hosts' chains verify against a -trust-store bundle.
+type trustStoreSummary struct {
+	// Verified is the number of hosts whose chain verified against it.
+	Verified int `json:"verified"`
+	// Hosts is the number of hosts whose chain was verified against it.
+	Hosts int `json:"hosts"`
+}
+
+// summarizeTrustStores returns how many of the hosts of results verify against each -trust-store
+// bundle, keyed by its name, or nil if there are none.
+func summarizeTrustStores(results []result) map[string]trustStoreSummary {
+	var s map[string]trustStoreSummary
+	for _, v := range results {
+		for _, r := range v.TrustStores {
+			if s == nil {
+				s = map[string]trustStoreSummary{}
+			}
+			ts := s[r.Store]
+			ts.Hosts++
+			if r.Verified {
+				ts.Verified++
+			}
+			s[r.Store] = ts
+		}
+	}
+	return s
+}
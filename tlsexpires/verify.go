@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// manifest declares the TLS state we expect our hosts to have. The verify subcommand reads
+// it and reports every host that doesn't match.
+type manifest struct {
+	// Hosts are the hosts we expect something of.
+	Hosts []manifestHost `json:"hosts"`
+}
+
+// manifestHost is what we expect of a single host.
+type manifestHost struct {
+	// HostPort is the host:port to check.
+	HostPort string `json:"hostPort"`
+	// Issuer must be in the distinguished name of the certificate's issuer, like "Let's Encrypt"
+	// or "CN=R3". Empty means any issuer is fine.
+	Issuer string `json:"issuer,omitempty"`
+	// SANs are the DNS names and IP addresses the certificate must have, and the only ones it
+	// may have. Empty means we don't care.
+	SANs []string `json:"sans,omitempty"`
+	// MinValidity is how long the certificate must still be valid for, like "30d".
+	MinValidity dayDuration `json:"minValidity,omitempty"`
+}
+
+// conformance is the verify result for a single host, or one address of it with -all-ips.
+type conformance struct {
+	// HostPort is the host:port that was checked. For a manifest host with a CIDR or port range,
+	// it is one of the targets that expands into.
+	HostPort string `json:"hostPort"`
+	// Address is the IP address that was checked. Only set with -all-ips.
+	Address string `json:"address,omitempty"`
+	// Conforms is true if there are no Deviations.
+	Conforms bool `json:"conforms"`
+	// Deviations are the ways the host differs from the manifest.
+	Deviations []string `json:"deviations,omitempty"`
+	// Result is what we found when we checked the host.
+	Result result `json:"result"`
+}
+
+// conformanceReport is the output of the verify subcommand.
+type conformanceReport struct {
+	// Checked is when the hosts were checked.
+	Checked time.Time `json:"checked"`
+	// Conforming is the number of hosts that match the manifest.
+	Conforming int `json:"conforming"`
+	// Deviating is the number of hosts that don't.
+	Deviating int `json:"deviating"`
+	// Hosts are the results for each host in manifest order. A host has one for each of its
+	// addresses with -all-ips, and for each target its CIDR or port range expands into.
+	Hosts []conformance `json:"hosts"`
+}
+
+// conformanceTmpl is the text version of a conformanceReport.
+var conformanceTmpl = template.Must(template.New("").Funcs(template.FuncMap{"t": msg}).Parse(`
+{{- range .Hosts }}
+{{- if .Conforms }}
+{{ .HostPort }}{{ with .Address }} ({{ . }}){{ end }}: {{ t "conforms" }}
+{{- else }}
+{{ .HostPort }}{{ with .Address }} ({{ . }}){{ end }}: {{ t "deviates" }}
+{{- range .Deviations }}
+  - {{ . }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{ t "conformanceTally" .Conforming .Deviating }}
+`,
+))
+
+// deviations compares what we found for a host against what the manifest expects.
+func deviations(want manifestHost, got result) []string {
+	if got.Status == statusError {
+		return []string{fmt.Sprintf("could not check the host: %s", got.Err)}
+	}
+
+	var devs []string
+	if want.Issuer != "" && !strings.Contains(got.Issuer, want.Issuer) {
+		devs = append(devs, fmt.Sprintf("issuer is %q, expected %q", got.Issuer, want.Issuer))
+	}
+
+	if len(want.SANs) > 0 {
+		have := map[string]bool{}
+		for _, s := range got.SANs {
+			have[strings.ToLower(s)] = true
+		}
+		expected := map[string]bool{}
+		for _, s := range want.SANs {
+			s = strings.ToLower(s)
+			expected[s] = true
+			if !have[s] {
+				devs = append(devs, fmt.Sprintf("SAN %q is missing", s))
+			}
+		}
+		for _, s := range got.SANs {
+			if !expected[strings.ToLower(s)] {
+				devs = append(devs, fmt.Sprintf("SAN %q was not expected", s))
+			}
+		}
+	}
+
+	if want.MinValidity != 0 {
+		left := until(got.ExpiresOn)
+		if left < time.Duration(want.MinValidity) {
+			devs = append(devs, fmt.Sprintf("certificate expires in %d days, expected at least %s", got.ExpireInDays(), want.MinValidity.String()))
+		}
+	}
+	return devs
+}
+
+// verify implements the "verify" subcommand. It checks every host in a manifest and reports how
+// each deviates from what was declared. It exits with status 1 if any host deviates, which makes
+// it easy to run continuously from CI or cron.
+func verify(args []string) error {
+	fs := subcommandFlags("verify")
+	manifestPath := fs.String("manifest", "", "The path to a json manifest of the expected state of each host")
+	fs.Parse(args)
+	if err := applyProfile(fs); err != nil {
+		return err
+	}
+
+	if *manifestPath == "" {
+		return fmt.Errorf("verify requires -manifest")
+	}
+	b, err := os.ReadFile(*manifestPath)
+	if err != nil {
+		return err
+	}
+	m := manifest{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("-manifest=%s is not valid: %s", *manifestPath, err)
+	}
+
+	// Hosts with a CIDR or port range are expanded like in a scan. wants is the manifest host of
+	// each target, by the order we check it in.
+	var targets []string
+	var wants []manifestHost
+	for _, h := range m.Hosts {
+		next, err := expandTarget(h.HostPort)
+		if err != nil {
+			return fmt.Errorf("-manifest=%s: %s", *manifestPath, err)
+		}
+		if next == nil {
+			targets, wants = append(targets, h.HostPort), append(wants, h)
+			continue
+		}
+		for t, ok := next(); ok; t, ok = next() {
+			targets, wants = append(targets, t.HostPort), append(wants, h)
+		}
+	}
+
+	hostPorts := make(chan string, 1)
+	go func() {
+		defer close(hostPorts)
+		for _, t := range targets {
+			hostPorts <- t
+		}
+	}()
+
+	report := conformanceReport{Checked: clock.Now()}
+	results := collect(context.Background(), hostPorts, nil)
+	// With -all-ips, a target has a result for each of its addresses.
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].order != results[j].order {
+			return results[i].order < results[j].order
+		}
+		return results[i].Address < results[j].Address
+	})
+	for _, v := range results {
+		c := conformance{HostPort: v.HostPort, Address: v.Address, Result: v}
+		c.Deviations = deviations(wants[v.order], v)
+		c.Conforms = len(c.Deviations) == 0
+		if c.Conforms {
+			report.Conforming++
+		} else {
+			report.Deviating++
+		}
+		report.Hosts = append(report.Hosts, c)
+	}
+
+	switch *format {
+	case "text":
+		if err := conformanceTmpl.Execute(os.Stdout, report); err != nil {
+			return err
+		}
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	default:
+		log.Fatalf("-format=%s is not supported", *format)
+	}
+
+	if report.Deviating > 0 {
+		os.Exit(exitFailure)
+	}
+	return nil
+}
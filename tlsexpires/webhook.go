@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// webhookHeaders is a flag.Value for the repeatable -webhook-header flag.
+type webhookHeaders map[string]string
+
+var (
+	webhookURL      = flag.String("webhook", "", "A URL to POST each result to as json after every run, to feed results into your own automation. The body is signed with $TLSEXPIRES_WEBHOOK_SECRET if it is set, see -webhook-header")
+	webhookBreaches = flag.Bool("webhook-breaches", false, "Only POST the results to -webhook that aren't ok")
+
+	webhookHeader = webhookHeaders{}
+)
+
+func init() {
+	flag.Var(webhookHeader, "webhook-header", "'Name: value' of a header to send to -webhook, like an Authorization header. Can be repeated")
+}
+
+// webhookSecretEnv is the environment variable with the key we sign -webhook bodies with. The
+// HMAC-SHA256 of the body is sent hex encoded in the X-Tlsexpires-Signature header, like
+// "sha256=4c1f...", which receivers check to know the result came from us.
+const webhookSecretEnv = "TLSEXPIRES_WEBHOOK_SECRET"
+
+// String implements flag.Value.String().
+func (w webhookHeaders) String() string {
+	var out []string
+	for k, v := range w {
+		out = append(out, k+": "+v)
+	}
+	return strings.Join(out, ",")
+}
+
+// Set implements flag.Value.Set().
+func (w webhookHeaders) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	k = strings.TrimSpace(k)
+	if !ok || k == "" {
+		return fmt.Errorf("-webhook-header must be 'Name: value', was %q", s)
+	}
+	w[http.CanonicalHeaderKey(k)] = strings.TrimSpace(v)
+	return nil
+}
+
+// webhookDeliveries returns a delivery to url, like -webhook, for each result in r that should
+// be sent.
+func webhookDeliveries(r run, url string) ([]delivery, error) {
+	if url == "" {
+		return nil, nil
+	}
+	secret := os.Getenv(webhookSecretEnv)
+
+	var ds []delivery
+	for _, v := range r.Results {
+		if *webhookBreaches && v.Status == statusOK {
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		headers := map[string]string{}
+		for k, v := range webhookHeader {
+			headers[k] = v
+		}
+		if secret != "" {
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(b)
+			headers["X-Tlsexpires-Signature"] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		}
+		ds = append(ds, delivery{
+			Key:     fmt.Sprintf("result/%s/%s/%s", url, r.ID, v.resultKey()),
+			Channel: "result webhook",
+			URL:     url,
+			Headers: headers,
+			Body:    b,
+		})
+	}
+	return ds, nil
+}
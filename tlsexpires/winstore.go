@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var windowsStores = flag.String("windows-store", "", "A comma separated list of Windows certificate stores to check every certificate in, like LocalMachine/My,LocalMachine/WebHosting for IIS and WinRM. A store is LocalMachine or CurrentUser, a /, and the store's name. They are checked alongside the other targets, or on their own if no other targets are given. Only on Windows")
+
+// winStorePrefix starts the target for a certificate in a -windows-store store, which is
+// followed by the store, a / and the certificate's SHA-1 thumbprint, which is how Windows tools
+// like certlm.msc and PowerShell's Cert: drive name it.
+const winStorePrefix = "winstore:"
+
+// winStoreLabel is the label we put the store a certificate is in in.
+const winStoreLabel = "windows_store"
+
+// winStoreLocations are the locations a -windows-store store can be in.
+var winStoreLocations = []string{"LocalMachine", "CurrentUser"}
+
+// winStoreCerts are the certificates we found in the stores, keyed by their target. They are
+// kept from when we list the stores, so a check doesn't open the store again.
+var winStoreCerts = struct {
+	sync.Mutex
+	m map[string]*x509.Certificate
+}{m: map[string]*x509.Certificate{}}
+
+// newWinStoreProvider returns a provider of a target for every certificate in the comma
+// separated list of stores.
+func newWinStoreProvider(stores string) (*fileProvider, error) {
+	p := &fileProvider{}
+	for _, store := range strings.Split(stores, ",") {
+		store = strings.TrimSpace(store)
+		if store == "" {
+			continue
+		}
+		location, name, err := splitWinStore(store)
+		if err != nil {
+			return nil, fmt.Errorf("-windows-store: %s", err)
+		}
+		ders, err := windowsStoreCerts(location, name)
+		if err != nil {
+			return nil, fmt.Errorf("-windows-store %s: %s", store, err)
+		}
+		for _, der := range ders {
+			t := target{HostPort: winStorePrefix + store + "/" + fmt.Sprintf("%X", sha1.Sum(der)), Labels: map[string]string{winStoreLabel: store}}
+			cert, err := x509.ParseCertificate(der)
+			winStoreCerts.Lock()
+			// A certificate we can't parse has no entry, so its check reports it.
+			if err == nil {
+				winStoreCerts.m[t.HostPort] = cert
+			}
+			winStoreCerts.Unlock()
+			p.targets = append(p.targets, t)
+		}
+	}
+	return p, nil
+}
+
+// checkWinStoreCert checks a certificate in a -windows-store store, whose target is
+// winStorePrefix, the store and its thumbprint. Stores hold certificates one by one, so we only
+// have the leaf.
+func checkWinStoreCert(hostPort string) result {
+	v := result{HostPort: hostPort, Server: strings.TrimPrefix(hostPort, winStorePrefix), Status: statusOK}
+	winStoreCerts.Lock()
+	cert, ok := winStoreCerts.m[hostPort]
+	winStoreCerts.Unlock()
+	if !ok {
+		v.Err = fmt.Sprintf("%s is not a certificate we could parse", v.Server)
+		v.find(findingCertFile)
+		return v
+	}
+	v.describe([]*x509.Certificate{cert}, optionsFor(hostPort).warnDays())
+	return v
+}
+
+// splitWinStore splits store, like LocalMachine/My, into its location and name.
+func splitWinStore(store string) (location, name string, err error) {
+	location, name, ok := strings.Cut(store, "/")
+	if !ok || name == "" || !slices.ContainsFunc(winStoreLocations, func(l string) bool { return strings.EqualFold(l, location) }) {
+		return "", "", fmt.Errorf("store %q must be LocalMachine/<name> or CurrentUser/<name>", store)
+	}
+	return location, name, nil
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// windowsStoreCerts returns the DER of every certificate in the store called name in location.
+// There are no Windows certificate stores on this platform.
+func windowsStoreCerts(location, name string) ([][]byte, error) {
+	return nil, fmt.Errorf("Windows certificate stores can only be checked on Windows")
+}
+
+// windowsStoreClientCert returns the client certificate with thumbprint in the store called name
+// in location. There are no Windows certificate stores on this platform.
+func windowsStoreClientCert(location, name string, thumbprint []byte) (*tls.Certificate, error) {
+	return nil, fmt.Errorf("Windows certificate stores can only be used on Windows")
+}
@@ -0,0 +1,203 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// windowsStoreCerts returns the DER of every certificate in the store called name in location,
+// LocalMachine or CurrentUser.
+func windowsStoreCerts(location, name string) ([][]byte, error) {
+	store, err := openWinStore(location, name)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CertCloseStore(store, 0)
+
+	var ders [][]byte
+	var ctx *windows.CertContext
+	for {
+		ctx, err = windows.CertEnumCertificatesInStore(store, ctx)
+		if err != nil {
+			// The store ran out of certificates, which CertEnumCertificatesInStore frees the
+			// last context for.
+			if errors.Is(err, windows.Errno(windows.CRYPT_E_NOT_FOUND)) {
+				return ders, nil
+			}
+			return nil, err
+		}
+		// The context is reused by the next call, so the certificate has to be copied.
+		ders = append(ders, append([]byte(nil), unsafe.Slice(ctx.EncodedCert, ctx.Length)...))
+	}
+}
+
+// openWinStore opens the store called name in location, LocalMachine or CurrentUser, read only.
+// The store must already exist.
+func openWinStore(location, name string) (windows.Handle, error) {
+	flags := uint32(windows.CERT_STORE_READONLY_FLAG | windows.CERT_STORE_OPEN_EXISTING_FLAG)
+	if strings.EqualFold(location, "LocalMachine") {
+		flags |= windows.CERT_SYSTEM_STORE_LOCAL_MACHINE
+	} else {
+		flags |= windows.CERT_SYSTEM_STORE_CURRENT_USER
+	}
+	n, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	return windows.CertOpenStore(windows.CERT_STORE_PROV_SYSTEM, 0, 0, flags, uintptr(unsafe.Pointer(n)))
+}
+
+// windowsStoreClientCert returns the client certificate with thumbprint in the store called name
+// in location. Its private key is a handle to the key in CNG, which signs with it wherever it is
+// kept, like a TPM or a smart card, without it ever leaving there.
+func windowsStoreClientCert(location, name string, thumbprint []byte) (*tls.Certificate, error) {
+	store, err := openWinStore(location, name)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CertCloseStore(store, 0)
+
+	var ctx *windows.CertContext
+	for {
+		ctx, err = windows.CertEnumCertificatesInStore(store, ctx)
+		if err != nil {
+			if errors.Is(err, windows.Errno(windows.CRYPT_E_NOT_FOUND)) {
+				return nil, fmt.Errorf("%s/%s has no certificate with thumbprint %X", location, name, thumbprint)
+			}
+			return nil, err
+		}
+		der := unsafe.Slice(ctx.EncodedCert, ctx.Length)
+		if sum := sha1.Sum(der); bytes.Equal(sum[:], thumbprint) {
+			break
+		}
+	}
+	// We are done enumerating, so the context is ours to free.
+	defer windows.CertFreeCertificateContext(ctx)
+
+	leaf, err := x509.ParseCertificate(append([]byte(nil), unsafe.Slice(ctx.EncodedCert, ctx.Length)...))
+	if err != nil {
+		return nil, err
+	}
+	var key windows.Handle
+	var keySpec uint32
+	var mustFree bool
+	err = windows.CryptAcquireCertificatePrivateKey(ctx, windows.CRYPT_ACQUIRE_ONLY_NCRYPT_KEY_FLAG|windows.CRYPT_ACQUIRE_SILENT_FLAG, nil, &key, &keySpec, &mustFree)
+	if err != nil {
+		return nil, fmt.Errorf("the certificate with thumbprint %X has no private key we can use: %s", thumbprint, err)
+	}
+	// Without CRYPT_ACQUIRE_CACHE_FLAG the key handle is ours, and it outlives the context. We
+	// keep it for as long as we run.
+	return &tls.Certificate{Certificate: [][]byte{leaf.Raw}, Leaf: leaf, PrivateKey: &ncryptSigner{key: key, pub: leaf.PublicKey}}, nil
+}
+
+var procNCryptSignHash = windows.NewLazySystemDLL("ncrypt.dll").NewProc("NCryptSignHash")
+
+// The padding schemes of NCryptSignHash.
+const (
+	bcryptPadPKCS1 = 0x2
+	bcryptPadPSS   = 0x8
+)
+
+// bcryptPKCS1PaddingInfo is BCRYPT_PKCS1_PADDING_INFO.
+type bcryptPKCS1PaddingInfo struct {
+	algID *uint16
+}
+
+// bcryptPSSPaddingInfo is BCRYPT_PSS_PADDING_INFO.
+type bcryptPSSPaddingInfo struct {
+	algID *uint16
+	salt  uint32
+}
+
+// ncryptSigner is a crypto.Signer for a CNG key.
+type ncryptSigner struct {
+	key windows.Handle
+	pub crypto.PublicKey
+}
+
+// Public implements crypto.Signer.Public().
+func (s *ncryptSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign implements crypto.Signer.Sign(). RSA keys sign with PKCS #1 v1.5 or, if opts are
+// *rsa.PSSOptions, PSS. ECDSA keys sign with the ASN.1 signatures crypto/tls expects.
+func (s *ncryptSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var algName string
+	switch opts.HashFunc() {
+	case crypto.SHA1:
+		algName = "SHA1"
+	case crypto.SHA256:
+		algName = "SHA256"
+	case crypto.SHA384:
+		algName = "SHA384"
+	case crypto.SHA512:
+		algName = "SHA512"
+	default:
+		return nil, fmt.Errorf("CNG keys can't sign %s digests", opts.HashFunc())
+	}
+	alg, err := windows.UTF16PtrFromString(algName)
+	if err != nil {
+		return nil, err
+	}
+
+	var padding unsafe.Pointer
+	var flags uint32
+	switch s.pub.(type) {
+	case *rsa.PublicKey:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			salt := pss.SaltLength
+			if salt == rsa.PSSSaltLengthEqualsHash || salt == rsa.PSSSaltLengthAuto {
+				salt = opts.HashFunc().Size()
+			}
+			padding, flags = unsafe.Pointer(&bcryptPSSPaddingInfo{algID: alg, salt: uint32(salt)}), bcryptPadPSS
+		} else {
+			padding, flags = unsafe.Pointer(&bcryptPKCS1PaddingInfo{algID: alg}), bcryptPadPKCS1
+		}
+	case *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("CNG keys of type %T aren't supported", s.pub)
+	}
+
+	sig, err := s.signHash(padding, digest, flags)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.pub.(*ecdsa.PublicKey); ok {
+		// CNG returns r and s one after the other, crypto/tls wants them in ASN.1.
+		half := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(sig[:half]), new(big.Int).SetBytes(sig[half:])})
+	}
+	return sig, nil
+}
+
+// signHash calls NCryptSignHash, first to find out how big the signature is and then to make it.
+func (s *ncryptSigner) signHash(padding unsafe.Pointer, digest []byte, flags uint32) ([]byte, error) {
+	var size uint32
+	r, _, _ := procNCryptSignHash.Call(uintptr(s.key), uintptr(padding), uintptr(unsafe.Pointer(&digest[0])), uintptr(len(digest)), 0, 0, uintptr(unsafe.Pointer(&size)), uintptr(flags))
+	if r != 0 {
+		return nil, fmt.Errorf("NCryptSignHash: %w", windows.Errno(r))
+	}
+	sig := make([]byte, size)
+	r, _, _ = procNCryptSignHash.Call(uintptr(s.key), uintptr(padding), uintptr(unsafe.Pointer(&digest[0])), uintptr(len(digest)), uintptr(unsafe.Pointer(&sig[0])), uintptr(size), uintptr(unsafe.Pointer(&size)), uintptr(flags))
+	if r != 0 {
+		return nil, fmt.Errorf("NCryptSignHash: %w", windows.Errno(r))
+	}
+	return sig[:size], nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var zonesFile = flag.String("zones", "", "The path to a json file that partitions hosts into zones by hostname pattern or CIDR, each with its own concurrency and rate limit")
+
+// defaultConcurrency is how many hosts we check at a time in a zone that doesn't say otherwise.
+const defaultConcurrency = 100
+
+// resolveWorkers is how many hosts a scan finds the zone of at a time.
+const resolveWorkers = 16
+
+// zone is a group of hosts that share concurrency and rate limits. This lets a single run go as
+// fast as it can against a lab network while being gentle with a fragile DMZ.
+type zone struct {
+	// Name is the name of the zone, which is recorded in each result.
+	Name string `json:"name"`
+	// Hosts are path.Match() patterns for hostnames in this zone, like "*.lab.example.com".
+	Hosts []string `json:"hosts,omitempty"`
+	// CIDRs are the networks in this zone, like "10.1.0.0/16". Hostnames are resolved to see if
+	// they are in one of these.
+	CIDRs []string `json:"cidrs,omitempty"`
+	// Concurrency is the most hosts in this zone we will check at one time. Defaults to 100.
+	Concurrency int `json:"concurrency,omitempty"`
+	// Rate is the most new connections per second we will make to this zone. 0 is unlimited.
+	Rate float64 `json:"rate,omitempty"`
+	// Agent is the name of the -agent-of agent that checks the hosts in this zone, from where it
+	// runs, instead of us. It needs -agent-listen. Concurrency is how many hosts the agent is
+	// asked to check at a time, the rest of the zone's settings are up to the agent. The agent
+	// resolves the hosts itself, and checks each of their addresses if it runs with -all-ips.
+	Agent string `json:"agent,omitempty"`
+	// routing is how we reach hosts in this zone, through a proxy, a jump host or from a
+	// particular interface. By default we connect directly.
+	routing
+
+	nets    []*net.IPNet
+	limiter *rate.Limiter
+	dialer  contextDialer
+}
+
+// init validates the zone and sets up the things we use at runtime.
+func (z *zone) init() error {
+	if z.Concurrency < 0 || z.Rate < 0 {
+		return fmt.Errorf("zone %q can't have a negative concurrency or rate", z.Name)
+	}
+	if z.Agent != "" && *agentListen == "" {
+		return fmt.Errorf("zone %q has agent %s, which needs -agent-listen", z.Name, z.Agent)
+	}
+	if z.Concurrency == 0 {
+		z.Concurrency = defaultConcurrency
+	}
+	if z.Rate > 0 {
+		z.limiter = rate.NewLimiter(rate.Limit(z.Rate), 1)
+	}
+	for _, c := range z.CIDRs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return fmt.Errorf("zone %q has bad CIDR %q: %s", z.Name, c, err)
+		}
+		z.nets = append(z.nets, n)
+	}
+	for _, h := range z.Hosts {
+		if _, err := path.Match(h, ""); err != nil {
+			return fmt.Errorf("zone %q has bad host pattern %q: %s", z.Name, h, err)
+		}
+	}
+	if z.Proxy == "" {
+		z.Proxy = *proxyFlag
+	}
+	if z.SourceInterface == "" && z.SourceIP == "" {
+		z.SourceInterface, z.SourceIP = *interfaceFlag, *sourceIP
+	}
+	d, err := z.routing.dialer()
+	if err != nil {
+		return fmt.Errorf("zone %q: %s", z.Name, err)
+	}
+	z.dialer = d
+	return nil
+}
+
+// wait blocks until the zone's rate limit lets us make another connection, or ctx is done.
+func (z *zone) wait(ctx context.Context) {
+	if z.limiter != nil {
+		z.limiter.Wait(ctx)
+	}
+}
+
+// zoneConfig is the format of the -zones file.
+type zoneConfig struct {
+	// Zones are checked in order and the first zone a host matches is the one it is in.
+	Zones []*zone `json:"zones"`
+	// Default is the zone for hosts that aren't in any of Zones. Its name is always "default".
+	Default zone `json:"default"`
+}
+
+// loadZones reads -zones. If it isn't set, every host is in a default zone that behaves like
+// we always have.
+func loadZones() (*zoneConfig, error) {
+	zc := &zoneConfig{}
+	if *zonesFile != "" {
+		b, err := os.ReadFile(*zonesFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, zc); err != nil {
+			return nil, fmt.Errorf("-zones=%s is not valid: %s", *zonesFile, err)
+		}
+	}
+	zc.Default.Name = "default"
+
+	seen := map[string]bool{"default": true}
+	for _, z := range zc.Zones {
+		if z.Name == "" || seen[z.Name] {
+			return nil, fmt.Errorf("-zones=%s: every zone must have a unique name that isn't \"default\", had %q", *zonesFile, z.Name)
+		}
+		seen[z.Name] = true
+		if err := z.init(); err != nil {
+			return nil, err
+		}
+	}
+	if err := zc.Default.init(); err != nil {
+		return nil, err
+	}
+	return zc, nil
+}
+
+// hasCIDRs reports if any zone is defined by CIDR, which means we need IP addresses to decide.
+func (zc *zoneConfig) hasCIDRs() bool {
+	for _, z := range zc.Zones {
+		if len(z.nets) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneFor returns the zone that hostPort is in. If finding it takes a DNS lookup, it gives up on
+// the lookup once ctx is done.
+func (zc *zoneConfig) zoneFor(ctx context.Context, hostPort string) *zone {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return &zc.Default
+	}
+	host = strings.ToLower(host)
+
+	var ips []net.IP
+	if zc.hasCIDRs() {
+		if ip := net.ParseIP(host); ip != nil {
+			ips = []net.IP{ip}
+		} else {
+			// If this fails, the host can only match a hostname pattern. The check will
+			// report the lookup failure.
+			ips, _ = dnsResolver().LookupIP(ctx, "ip", host)
+		}
+	}
+
+	for _, z := range zc.Zones {
+		for _, pattern := range z.Hosts {
+			if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+				return z
+			}
+		}
+		for _, n := range z.nets {
+			for _, ip := range ips {
+				if n.Contains(ip) {
+					return z
+				}
+			}
+		}
+	}
+	return &zc.Default
+}
+
+// zoneWork is a host waiting to be checked in a zone.
+type zoneWork struct {
+	hostPort string
+	order    int
+	// addr is the IP address to connect to. Empty means resolve the host when we connect.
+	addr string
+	// addrs is how many addresses of hostPort are being checked.
+	addrs int
+}
+
+// zoneWorkers are the goroutines that check the hosts in a single zone.
+type zoneWorkers struct {
+	zone *zone
+	// in is where the hosts to check in the zone are sent, see feed().
+	in chan zoneWork
+	// queue is where the workers get the hosts from.
+	queue chan zoneWork
+	// prog is told about the checks an agent did beyond the one we queued, for the addresses of
+	// a host it checked with -all-ips.
+	prog *progress
+	// labels are the labels the providers of this scan's targets gave them.
+	labels *targetLabels
+}
+
+// start starts z.Concurrency goroutines that check hosts sent to z.queue and send the results
+// to out, recording what they did in s. wg is Done() as each goroutine exits, which happens after
+// z.queue is closed. Hosts the scan we are resuming already checked aren't
+// checked again.
+func (z *zoneWorkers) start(ctx context.Context, wg *sync.WaitGroup, s *stage, out chan<- result) {
+	for i := 0; i < z.zone.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				w, ok := recv(s, z.queue)
+				if !ok {
+					return
+				}
+				// Once we are interrupted we only empty the queue, so the stages before us finish.
+				if ctx.Err() != nil {
+					continue
+				}
+				start := time.Now()
+				v, ok := scanCheckpoint.lookup(w.hostPort, w.addr)
+				results := []result{v}
+				if !ok {
+					if z.zone.Agent != "" {
+						// The agent keeps to its own limits.
+						results = agents.check(ctx, z.zone.Agent, w.hostPort)
+						for i := range results {
+							results[i] = assess(ctx, results[i], z.labels.get(w.hostPort))
+						}
+					} else {
+						z.zone.wait(ctx)
+						results[0] = check(ctx, z.zone.dialer, w.hostPort, w.addr, z.labels.get(w.hostPort))
+					}
+					// A check we cut short says nothing about the host, so it isn't a result.
+					if ctx.Err() != nil {
+						continue
+					}
+					for _, v := range results {
+						scanCheckpoint.record(v)
+					}
+				}
+				if len(results) > 1 {
+					z.prog.queue(len(results) - 1)
+					w.addrs = len(results)
+				}
+				s.worked(start)
+				for _, v := range results {
+					v.order = w.order
+					v.addrs = w.addrs
+					v.Zone = z.zone.Name
+					send(s, out, v)
+				}
+			}
+		}()
+	}
+}
+
+// feed hands the hosts sent to z.in to the workers on z.queue, waiting for them when they are
+// busy, recording what it did in s. With -all-ips, each address of a host is sent to be checked
+// on its own, except in zones with an agent, which resolves the host from where it runs. z.queue
+// is closed once z.in is closed and every host has been handed over.
+func (z *zoneWorkers) feed(ctx context.Context, s *stage) {
+	defer close(z.queue)
+	for {
+		w, ok := recv(s, z.in)
+		if !ok {
+			return
+		}
+		start := time.Now()
+		var addrs []string
+		if *allIPs && z.zone.Agent == "" && ctx.Err() == nil {
+			// If this fails, we check the host without an address so the failure is reported.
+			addrs, _ = resolveAddrs(ctx, w.hostPort)
+		}
+		s.worked(start)
+		if len(addrs) == 0 {
+			z.prog.queue(1)
+			send(s, z.queue, w)
+			continue
+		}
+		z.prog.queue(len(addrs))
+		w.addrs = len(addrs)
+		for _, addr := range addrs {
+			w.addr = addr
+			send(s, z.queue, w)
+		}
+	}
+}